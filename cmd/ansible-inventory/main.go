@@ -0,0 +1,236 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/work-obs/ansible-go/pkg/config"
+	inventoryPkg "github.com/work-obs/ansible-go/pkg/inventory"
+)
+
+const (
+	version = "2.19.0-go"
+)
+
+var (
+	inventories []string
+	limit       string
+	flushCache  bool
+	listMode    bool
+	hostName    string
+	graphMode   bool
+	validate    bool
+	showVars    bool
+	export      bool
+	yamlOutput  bool
+	tomlOutput  bool
+	outputFile  string
+)
+
+// rootCmd represents the ansible-inventory command
+var rootCmd = &cobra.Command{
+	Use:   "ansible-inventory [group]",
+	Short: "Show Ansible inventory information",
+	Long: `Show the inventory as Ansible sees it, after every source is parsed and
+group_vars and host_vars are applied.
+
+Examples:
+  # Dump the inventory as dynamic inventory JSON
+  ansible-inventory -i inventory.ini --list
+
+  # Dump it as YAML, keeping variables on their groups
+  ansible-inventory -i inventory.ini --list --yaml --export
+
+  # Show the variables of one host
+  ansible-inventory -i inventory.ini --host web1
+
+  # Draw the webservers group with its variables
+  ansible-inventory -i inventory.ini --graph webservers --vars
+
+  # Check the inventory for cycles, empty groups and bad names
+  ansible-inventory -i inventory.ini --validate`,
+	Version:      version,
+	Args:         cobra.MaximumNArgs(1),
+	SilenceUsage: true,
+	RunE:         runInventory,
+}
+
+func main() {
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func init() {
+	rootCmd.Flags().StringArrayVarP(&inventories, "inventory", "i", nil, "specify inventory host path or comma separated host list (may be repeated)")
+	rootCmd.Flags().StringVarP(&limit, "limit", "l", "", "further limit selected hosts to an additional pattern")
+	rootCmd.Flags().BoolVar(&flushCache, "flush-cache", false, "clear the inventory cache")
+
+	// Actions
+	rootCmd.Flags().BoolVar(&listMode, "list", false, "output all hosts info")
+	rootCmd.Flags().StringVar(&hostName, "host", "", "output specific host info")
+	rootCmd.Flags().BoolVar(&graphMode, "graph", false, "create inventory graph, optionally for the given group")
+	rootCmd.Flags().BoolVar(&validate, "validate", false, "report inventory problems and fail if there are any")
+
+	// Output options
+	rootCmd.Flags().BoolVar(&showVars, "vars", false, "add vars to graph display")
+	rootCmd.Flags().BoolVar(&export, "export", false, "keep variables on their groups instead of merging them into hosts")
+	rootCmd.Flags().BoolVarP(&yamlOutput, "yaml", "y", false, "use YAML format instead of default JSON")
+	rootCmd.Flags().BoolVar(&tomlOutput, "toml", false, "use TOML format instead of default JSON")
+	rootCmd.Flags().StringVar(&outputFile, "output", "", "write the output to a file instead of stdout")
+}
+
+// runInventory loads the inventory and prints the requested view of it
+func runInventory(cmd *cobra.Command, args []string) error {
+	actions := 0
+	for _, set := range []bool{listMode, hostName != "", graphMode, validate} {
+		if set {
+			actions++
+		}
+	}
+	if actions != 1 {
+		return fmt.Errorf("exactly one of --list, --host, --graph or --validate is required")
+	}
+	if showVars && !graphMode {
+		return fmt.Errorf("the --vars option can only be used with --graph")
+	}
+	if yamlOutput && tomlOutput {
+		return fmt.Errorf("--yaml and --toml are mutually exclusive")
+	}
+	if len(args) > 0 && !graphMode {
+		return fmt.Errorf("a group may only be given with --graph")
+	}
+
+	fs := afero.NewOsFs()
+	configManager := config.NewManager(fs)
+	if err := configManager.LoadConfig(); err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	ansibleConfig := configManager.GetConfig()
+	if flushCache {
+		ansibleConfig.FlushCache = true
+	}
+
+	invManager := inventoryPkg.NewManager(fs)
+	invManager.SetConfig(ansibleConfig)
+	if validate {
+		// Report every problem below rather than failing the load
+		invManager.SetValidationMode(inventoryPkg.ValidationIgnore)
+	}
+
+	sources := inventories
+	if len(sources) == 0 {
+		sources = []string{ansibleConfig.InventoryFile}
+	}
+	if err := invManager.LoadSources(sources...); err != nil {
+		return fmt.Errorf("failed to load inventory: %w", err)
+	}
+	if err := invManager.Subset(limit); err != nil {
+		return fmt.Errorf("invalid limit: %w", err)
+	}
+
+	if validate {
+		problems := invManager.Validate()
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("inventory validation found %d problem(s)", len(problems))
+		}
+		fmt.Println("inventory is valid")
+		return nil
+	}
+	for _, warning := range invManager.Warnings() {
+		fmt.Fprintf(os.Stderr, "[WARNING]: %s\n", warning)
+	}
+
+	var output string
+	if graphMode {
+		group := ""
+		if len(args) > 0 {
+			group = args[0]
+		}
+		graph, err := invManager.GraphInventory(group, showVars, export)
+		if err != nil {
+			return err
+		}
+		output = graph + "\n"
+	} else {
+		var data map[string]interface{}
+		var err error
+		switch {
+		case hostName != "":
+			data, err = invManager.HostInventory(hostName, export)
+		case yamlOutput:
+			data, err = invManager.YAMLInventory(export)
+		case tomlOutput:
+			data, err = invManager.TOMLInventory(export)
+		default:
+			data, err = invManager.ListInventory(export)
+		}
+		if err != nil {
+			return err
+		}
+		if output, err = formatOutput(data); err != nil {
+			return err
+		}
+	}
+
+	if outputFile != "" {
+		if err := os.WriteFile(outputFile, []byte(output), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", outputFile, err)
+		}
+		return nil
+	}
+	fmt.Print(output)
+	return nil
+}
+
+// formatOutput encodes data as JSON, YAML or TOML
+func formatOutput(data map[string]interface{}) (string, error) {
+	switch {
+	case yamlOutput:
+		var out strings.Builder
+		encoder := yaml.NewEncoder(&out)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(data); err != nil {
+			return "", fmt.Errorf("failed to encode YAML: %w", err)
+		}
+		return out.String(), nil
+	case tomlOutput:
+		out, err := toml.Marshal(data)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode TOML: %w", err)
+		}
+		return string(out), nil
+	default:
+		out, err := json.MarshalIndent(data, "", "    ")
+		if err != nil {
+			return "", fmt.Errorf("failed to encode JSON: %w", err)
+		}
+		return string(out) + "\n", nil
+	}
+}
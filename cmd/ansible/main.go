@@ -46,7 +46,7 @@ var (
 	// Global flags
 	cfgFile     string
 	verbose     int
-	inventory   string
+	inventory   []string
 	limit       string
 	moduleArgs  string
 	extraVars   map[string]string
@@ -59,6 +59,7 @@ var (
 	askPass     bool
 	check       bool
 	diff        bool
+	flushCache  bool
 
 	// Server mode flags
 	serverMode   bool
@@ -109,7 +110,7 @@ func init() {
 	// Global flags
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is ansible.cfg)")
 	rootCmd.PersistentFlags().CountVarP(&verbose, "verbose", "v", "verbose mode (-v, -vv, -vvv, or -vvvv)")
-	rootCmd.PersistentFlags().StringVarP(&inventory, "inventory", "i", "", "specify inventory host path or comma separated host list")
+	rootCmd.PersistentFlags().StringArrayVarP(&inventory, "inventory", "i", nil, "specify inventory host path or comma separated host list (may be repeated)")
 	rootCmd.PersistentFlags().StringVar(&limit, "limit", "", "further limit selected hosts to an additional pattern")
 	rootCmd.PersistentFlags().StringToStringVarP(&extraVars, "extra-vars", "e", nil, "set additional variables as key=value")
 	rootCmd.PersistentFlags().IntVarP(&forks, "forks", "f", 5, "specify number of parallel processes to use")
@@ -121,6 +122,7 @@ func init() {
 	rootCmd.PersistentFlags().BoolVarP(&askPass, "ask-pass", "k", false, "ask for connection password")
 	rootCmd.PersistentFlags().BoolVarP(&check, "check", "C", false, "don't make any changes")
 	rootCmd.PersistentFlags().BoolVarP(&diff, "diff", "D", false, "when changing files, show the differences")
+	rootCmd.PersistentFlags().BoolVar(&flushCache, "flush-cache", false, "clear the fact cache and the inventory cache")
 
 	// Ad-hoc execution flags
 	rootCmd.Flags().StringVarP(&moduleArgs, "args", "a", "", "module arguments")
@@ -173,6 +175,9 @@ func runAnsible(cmd *cobra.Command, args []string) error {
 	}
 
 	ansibleConfig := configManager.GetConfig()
+	if flushCache {
+		ansibleConfig.FlushCache = true
+	}
 
 	// Server mode
 	if serverMode {
@@ -269,18 +274,26 @@ func runAdHoc(hostPattern, moduleName string, config *config.Config) error {
 	fmt.Printf("Running module '%s' on hosts matching '%s'\n", moduleName, hostPattern)
 
 	// Create inventory manager
-	inventoryPath := inventory
-	if inventoryPath == "" {
-		inventoryPath = config.InventoryFile
+	inventorySources := inventory
+	if len(inventorySources) == 0 {
+		inventorySources = []string{config.InventoryFile}
 	}
 
 	fs := afero.NewOsFs()
 	invManager := inventoryPkg.NewManager(fs)
+	invManager.SetConfig(config)
 
-	// Load inventory from file
-	if err := invManager.LoadFromFile(inventoryPath); err != nil {
+	// Load and merge every inventory source
+	if err := invManager.LoadSources(inventorySources...); err != nil {
 		return fmt.Errorf("failed to load inventory: %w", err)
 	}
+	for _, warning := range invManager.Warnings() {
+		fmt.Fprintf(os.Stderr, "[WARNING]: %s\n", warning)
+	}
+
+	if err := invManager.Subset(limit); err != nil {
+		return fmt.Errorf("invalid limit: %w", err)
+	}
 
 	// Load inventory
 	hosts, err := invManager.GetHosts(hostPattern)
@@ -316,6 +329,10 @@ func runAdHoc(hostPattern, moduleName string, config *config.Config) error {
 	if err != nil {
 		return fmt.Errorf("failed to create task executor: %w", err)
 	}
+	if err := taskExecutor.SetInventory(invManager.GetInventory()); err != nil {
+		return err
+	}
+	defer taskExecutor.Close()
 
 	// Parse module arguments
 	moduleArguments := make(map[string]interface{})
@@ -101,6 +101,7 @@ type Config struct {
 	HashBehaviour      string `mapstructure:"hash_behaviour"`
 	HostPatternMismatch string `mapstructure:"host_pattern_mismatch"`
 	JinjaExtensions    []string `mapstructure:"jinja2_extensions"`
+	JinjaNative        bool   `mapstructure:"jinja2_native"`
 	Retry              bool   `mapstructure:"retry_files_enabled"`
 	RetryFilesSavePath string `mapstructure:"retry_files_save_path"`
 	LogPath            string `mapstructure:"log_path"`
@@ -112,6 +113,11 @@ type Config struct {
 	InventoryIgnoreRegex []string `mapstructure:"inventory_ignore_extensions"`
 	InventoryEnabled     []string `mapstructure:"inventory_enabled"`
 	HostnamePattern      string   `mapstructure:"hostname_pattern"`
+	InventoryCache           bool          `mapstructure:"inventory_cache"`
+	InventoryCachePlugin     string        `mapstructure:"inventory_cache_plugin"`
+	InventoryCacheConnection string        `mapstructure:"inventory_cache_connection"`
+	InventoryCachePrefix     string        `mapstructure:"inventory_cache_prefix"`
+	InventoryCacheTimeout    time.Duration `mapstructure:"inventory_cache_timeout"`
 
 	// Galaxy
 	GalaxyServerList   []string `mapstructure:"galaxy_server_list"`
@@ -235,6 +241,7 @@ func (m *Manager) setDefaults() {
 	// Advanced
 	m.viper.SetDefault("hash_behaviour", "replace")
 	m.viper.SetDefault("host_pattern_mismatch", "warning")
+	m.viper.SetDefault("jinja2_native", false)
 	m.viper.SetDefault("retry_files_enabled", false)
 	m.viper.SetDefault("retry_files_save_path", "~/.ansible-retry")
 	m.viper.SetDefault("vault_id_match", false)
@@ -244,8 +251,13 @@ func (m *Manager) setDefaults() {
 		"~", ".orig", ".bak", ".ini", ".cfg", ".retry", ".pyc", ".pyo",
 	})
 	m.viper.SetDefault("inventory_enabled", []string{
-		"host_list", "script", "auto", "yaml", "ini", "toml",
+		"host_list", "advanced_host_list", "script", "auto", "yaml", "terraform_state", "ini", "toml",
 	})
+	m.viper.SetDefault("inventory_cache", false)
+	m.viper.SetDefault("inventory_cache_plugin", "memory")
+	m.viper.SetDefault("inventory_cache_connection", "~/.ansible/tmp/inventory_cache")
+	m.viper.SetDefault("inventory_cache_prefix", "ansible_inventory_")
+	m.viper.SetDefault("inventory_cache_timeout", "3600s")
 
 	// Galaxy
 	m.viper.SetDefault("galaxy_ignore_certs", false)
@@ -297,6 +309,7 @@ func (m *Manager) processConfig() {
 	m.config.RetryFilesSavePath = expandPath(m.config.RetryFilesSavePath)
 	m.config.ControlPath = expandPath(m.config.ControlPath)
 	m.config.ControlPathDir = expandPath(m.config.ControlPathDir)
+	m.config.InventoryCacheConnection = expandPath(m.config.InventoryCacheConnection)
 
 	// Expand path lists
 	m.config.RolesPath = expandPaths(m.config.RolesPath)
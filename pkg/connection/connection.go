@@ -96,6 +96,12 @@ type ConnectionConfig struct {
 	PrivateKey      string            `json:"private_key,omitempty"`
 	PrivateKeyFile  string            `json:"private_key_file,omitempty"`
 	HostKeyChecking bool              `json:"host_key_checking"`
+	// StrictHostKeyChecking is yes, no or accept-new; when empty,
+	// HostKeyChecking selects yes or no
+	StrictHostKeyChecking string     `json:"strict_host_key_checking,omitempty"`
+	// KnownHostsFiles replaces ~/.ssh/known_hosts and
+	// /etc/ssh/ssh_known_hosts; accept-new writes to the first
+	KnownHostsFiles []string          `json:"known_hosts_files,omitempty"`
 	ProxyCommand    string            `json:"proxy_command,omitempty"`
 
 	// Become settings
@@ -0,0 +1,511 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package connection
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/work-obs/ansible-go/pkg/config"
+)
+
+// Host key checking modes, named after OpenSSH's StrictHostKeyChecking
+const (
+	// HostKeyCheckingYes rejects hosts whose key is unknown or has changed
+	HostKeyCheckingYes = "yes"
+	// HostKeyCheckingAcceptNew adds the keys of unknown hosts to
+	// known_hosts and rejects hosts whose key has changed
+	HostKeyCheckingAcceptNew = "accept-new"
+	// HostKeyCheckingNo skips host key verification
+	HostKeyCheckingNo = "no"
+)
+
+const (
+	// knownHostsLockTimeout is how long to wait for another process to
+	// finish writing known_hosts
+	knownHostsLockTimeout = 10 * time.Second
+
+	// knownHostsStaleLock is the age after which a lock file is assumed to
+	// be left behind by a process that died
+	knownHostsStaleLock = 30 * time.Second
+)
+
+// knownHostsMutex serializes writes to known_hosts within the process;
+// the lock file serializes them between processes
+var knownHostsMutex sync.Mutex
+
+// DefaultKnownHostsFiles returns the files host keys are verified against
+// when the configuration names none: the user's and the system's
+func DefaultKnownHostsFiles() []string {
+	files := make([]string, 0, 2)
+	if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, filepath.Join(home, ".ssh", "known_hosts"))
+	}
+	return append(files, "/etc/ssh/ssh_known_hosts")
+}
+
+// ApplyHostKeySettings sets the host key checking settings of connCfg the
+// way Ansible's ssh connection hands them to OpenSSH. host_key_checking
+// comes from cfg, or from the ansible_host_key_checking variable; when it
+// is off, host keys are not checked. Otherwise the StrictHostKeyChecking
+// and UserKnownHostsFile options of ssh_args and of the
+// ansible_ssh_common_args and ansible_ssh_extra_args variables apply,
+// the first one given winning as in OpenSSH. cfg may be nil.
+func ApplyHostKeySettings(connCfg *ConnectionConfig, cfg *config.Config, variables map[string]interface{}) error {
+	checking := true
+	args := []string{}
+	if cfg != nil {
+		checking = cfg.HostKeyChecking
+		args = append(args, cfg.SSHArgs)
+	}
+	for _, name := range []string{"ansible_host_key_checking", "ansible_ssh_host_key_checking"} {
+		if value, ok := variables[name]; ok {
+			enabled, err := variableBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", name, err)
+			}
+			checking = enabled
+			break
+		}
+	}
+	for _, name := range []string{"ansible_ssh_common_args", "ansible_ssh_extra_args"} {
+		if value, ok := variables[name]; ok && value != nil {
+			args = append(args, fmt.Sprintf("%v", value))
+		}
+	}
+
+	connCfg.HostKeyChecking = checking
+	if !checking {
+		connCfg.StrictHostKeyChecking = HostKeyCheckingNo
+		return nil
+	}
+
+	options := map[string]string{}
+	for _, arg := range args {
+		if err := sshOptions(arg, options); err != nil {
+			return err
+		}
+	}
+	if value, ok := options["stricthostkeychecking"]; ok {
+		switch strings.ToLower(value) {
+		case "yes", "ask":
+			connCfg.StrictHostKeyChecking = HostKeyCheckingYes
+		case "accept-new":
+			connCfg.StrictHostKeyChecking = HostKeyCheckingAcceptNew
+		case "no", "off":
+			connCfg.StrictHostKeyChecking = HostKeyCheckingNo
+		default:
+			return fmt.Errorf("invalid StrictHostKeyChecking option '%s'", value)
+		}
+	}
+	if value, ok := options["userknownhostsfile"]; ok {
+		connCfg.KnownHostsFiles = nil
+		for _, file := range strings.Fields(value) {
+			connCfg.KnownHostsFiles = append(connCfg.KnownHostsFiles, expandHome(file))
+		}
+	}
+	return nil
+}
+
+// sshOptions adds the -o options of an ssh argument string to options,
+// keyed by lower-case name. Options already set are kept, as OpenSSH uses
+// the first value it is given.
+func sshOptions(args string, options map[string]string) error {
+	fields, err := splitArgs(args)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(fields); i++ {
+		option := ""
+		switch {
+		case fields[i] == "-o" && i+1 < len(fields):
+			i++
+			option = fields[i]
+		case strings.HasPrefix(fields[i], "-o"):
+			option = fields[i][2:]
+		default:
+			continue
+		}
+
+		name, value, found := strings.Cut(option, "=")
+		if !found {
+			name, value, _ = strings.Cut(option, " ")
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, exists := options[name]; !exists {
+			options[name] = strings.TrimSpace(value)
+		}
+	}
+	return nil
+}
+
+// splitArgs splits an argument string like a POSIX shell, honouring
+// single and double quotes and backslash escapes
+func splitArgs(s string) ([]string, error) {
+	var (
+		fields  []string
+		current strings.Builder
+		inField bool
+		quote   rune
+	)
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\\' && quote != '\'' && i+1 < len(runes):
+			i++
+			current.WriteRune(runes[i])
+			inField = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inField = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inField {
+				fields = append(fields, current.String())
+				current.Reset()
+				inField = false
+			}
+		default:
+			current.WriteRune(r)
+			inField = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in ssh arguments %q", s)
+	}
+	if inField {
+		fields = append(fields, current.String())
+	}
+	return fields, nil
+}
+
+// variableBool reads a boolean variable, accepting the strings Ansible
+// takes for true and false
+func variableBool(value interface{}) (bool, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case int:
+		return v != 0, nil
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "yes", "on", "true", "1", "y", "t":
+			return true, nil
+		case "no", "off", "false", "0", "n", "f", "":
+			return false, nil
+		}
+	}
+	return false, fmt.Errorf("'%v' is not a boolean", value)
+}
+
+// expandHome replaces a leading ~ with the user's home directory
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
+
+// hostKeyVerifier checks host keys against known_hosts files. Entries may
+// be hashed, carry a non-standard port as [host]:port, or be
+// @cert-authority and @revoked markers.
+type hostKeyVerifier struct {
+	mode  string
+	files []string
+}
+
+// hostKeyMode returns the host key checking mode of the connection
+func (c *SSHConnection) hostKeyMode() (string, error) {
+	config := c.GetConfig()
+
+	switch config.StrictHostKeyChecking {
+	case "":
+		if config.HostKeyChecking {
+			return HostKeyCheckingYes, nil
+		}
+		return HostKeyCheckingNo, nil
+	case HostKeyCheckingYes, HostKeyCheckingAcceptNew, HostKeyCheckingNo:
+		return config.StrictHostKeyChecking, nil
+	}
+	return "", fmt.Errorf("invalid host key checking mode '%s', expected yes, no or accept-new", config.StrictHostKeyChecking)
+}
+
+// newHostKeyVerifier returns a verifier for the connection, or nil when
+// host keys are not checked
+func (c *SSHConnection) newHostKeyVerifier() (*hostKeyVerifier, error) {
+	mode, err := c.hostKeyMode()
+	if err != nil || mode == HostKeyCheckingNo {
+		return nil, err
+	}
+
+	files := c.GetConfig().KnownHostsFiles
+	if len(files) == 0 {
+		files = DefaultKnownHostsFiles()
+	}
+	return &hostKeyVerifier{mode: mode, files: files}, nil
+}
+
+// database reads the known_hosts files that exist
+func (v *hostKeyVerifier) database(files ...string) (ssh.HostKeyCallback, error) {
+	var existing []string
+	for _, file := range files {
+		if _, err := os.Stat(file); err == nil {
+			existing = append(existing, file)
+		}
+	}
+	if len(existing) == 0 {
+		return func(string, net.Addr, ssh.PublicKey) error {
+			return &knownhosts.KeyError{}
+		}, nil
+	}
+
+	callback, err := knownhosts.New(existing...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known_hosts: %w", err)
+	}
+	return callback, nil
+}
+
+// check verifies the key a host presented. The files are read on every
+// check, so keys added by other connections are seen.
+func (v *hostKeyVerifier) check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	callback, err := v.database(v.files...)
+	if err != nil {
+		return err
+	}
+
+	err = callback(hostname, remote, key)
+	var keyErr *knownhosts.KeyError
+	if errors.As(err, &keyErr) && len(keyErr.Want) == 0 && v.mode == HostKeyCheckingAcceptNew {
+		return v.addKey(hostname, remote, key)
+	}
+	return describeHostKeyError(hostname, key, err, v.files)
+}
+
+// addKey writes the key of a new host to the first known_hosts file,
+// unless another connection added one while the lock was awaited
+func (v *hostKeyVerifier) addKey(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	file := v.files[0]
+
+	knownHostsMutex.Lock()
+	defer knownHostsMutex.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return fmt.Errorf("failed to create known_hosts directory: %w", err)
+	}
+	unlock, err := lockKnownHosts(file)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	callback, err := v.database(file)
+	if err != nil {
+		return err
+	}
+	err = callback(hostname, remote, key)
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) || len(keyErr.Want) != 0 {
+		return describeHostKeyError(hostname, key, err, []string{file})
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read known_hosts: %w", err)
+	}
+	line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key) + "\n"
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		line = "\n" + line
+	}
+
+	out, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open known_hosts: %w", err)
+	}
+	if _, err := out.WriteString(line); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to write known_hosts: %w", err)
+	}
+	return out.Close()
+}
+
+// lockKnownHosts takes the lock file of a known_hosts file and returns
+// the function that releases it
+func lockKnownHosts(file string) (func(), error) {
+	lockFile := file + ".lock"
+	deadline := time.Now().Add(knownHostsLockTimeout)
+
+	for {
+		lock, err := os.OpenFile(lockFile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			lock.Close()
+			return func() { os.Remove(lockFile) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to lock known_hosts: %w", err)
+		}
+
+		if info, err := os.Stat(lockFile); err == nil && time.Since(info.ModTime()) > knownHostsStaleLock {
+			os.Remove(lockFile)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for the known_hosts lock %s", lockFile)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// describeHostKeyError turns a known_hosts error into one that names the
+// fingerprint of the key the host presented
+func describeHostKeyError(hostname string, key ssh.PublicKey, err error, files []string) error {
+	if err == nil {
+		return nil
+	}
+	fingerprint := ssh.FingerprintSHA256(key)
+
+	var revokedErr *knownhosts.RevokedError
+	if errors.As(err, &revokedErr) {
+		return fmt.Errorf("host key verification failed for %s: %s key %s is revoked (%s:%d)",
+			hostname, key.Type(), fingerprint, revokedErr.Revoked.Filename, revokedErr.Revoked.Line)
+	}
+
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) {
+		return fmt.Errorf("host key verification failed for %s: %s key %s: %v", hostname, key.Type(), fingerprint, err)
+	}
+	if len(keyErr.Want) == 0 {
+		return fmt.Errorf("host key verification failed for %s: %s key %s is not in %s",
+			hostname, key.Type(), fingerprint, strings.Join(files, " or "))
+	}
+
+	known := make([]string, len(keyErr.Want))
+	for i, want := range keyErr.Want {
+		known[i] = fmt.Sprintf("%s %s (%s:%d)", want.Key.Type(), ssh.FingerprintSHA256(want.Key), want.Filename, want.Line)
+	}
+	return fmt.Errorf("host key verification failed for %s: REMOTE HOST IDENTIFICATION HAS CHANGED, got %s key %s, known_hosts has %s",
+		hostname, key.Type(), fingerprint, strings.Join(known, ", "))
+}
+
+// probeKey is presented to known_hosts to find which keys it holds for a
+// host
+var (
+	probeKey     ssh.PublicKey
+	probeKeyOnce sync.Once
+)
+
+// certAlgorithms maps key types to the algorithms of host certificates
+// signed by keys of that type
+var certAlgorithms = map[string][]string{
+	ssh.KeyAlgoED25519:    {ssh.CertAlgoED25519v01},
+	ssh.KeyAlgoSKED25519:  {ssh.CertAlgoSKED25519v01},
+	ssh.KeyAlgoECDSA256:   {ssh.CertAlgoECDSA256v01},
+	ssh.KeyAlgoSKECDSA256: {ssh.CertAlgoSKECDSA256v01},
+	ssh.KeyAlgoECDSA384:   {ssh.CertAlgoECDSA384v01},
+	ssh.KeyAlgoECDSA521:   {ssh.CertAlgoECDSA521v01},
+	ssh.KeyAlgoRSA:        {ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01, ssh.CertAlgoRSAv01},
+}
+
+// hostKeyAlgorithms returns the algorithms of the keys known_hosts holds
+// for address, so the server is asked for a key that can be verified:
+// certificates for @cert-authority entries and plain keys otherwise. It
+// returns nil, keeping the defaults, when nothing is known.
+func (v *hostKeyVerifier) hostKeyAlgorithms(address string) []string {
+	probeKeyOnce.Do(func() {
+		public, _, err := ed25519.GenerateKey(rand.Reader)
+		if err == nil {
+			probeKey, _ = ssh.NewPublicKey(public)
+		}
+	})
+	if probeKey == nil {
+		return nil
+	}
+
+	callback, err := v.database(v.files...)
+	if err != nil {
+		return nil
+	}
+	var keyErr *knownhosts.KeyError
+	if !errors.As(callback(address, &net.TCPAddr{}, probeKey), &keyErr) {
+		return nil
+	}
+
+	var algorithms []string
+	seen := make(map[string]bool)
+	add := func(names ...string) {
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				algorithms = append(algorithms, name)
+			}
+		}
+	}
+
+	lines := make(map[string][]string)
+	for _, want := range keyErr.Want {
+		keyType := want.Key.Type()
+		if isAuthorityLine(lines, want.Filename, want.Line) {
+			add(certAlgorithms[keyType]...)
+			continue
+		}
+		if keyType == ssh.KeyAlgoRSA {
+			add(ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+		}
+		add(keyType)
+	}
+	return algorithms
+}
+
+// isAuthorityLine reports whether a known_hosts line is a
+// @cert-authority entry. lines caches the files read.
+func isAuthorityLine(lines map[string][]string, filename string, line int) bool {
+	if _, read := lines[filename]; !read {
+		data, _ := os.ReadFile(filename)
+		lines[filename] = strings.Split(string(data), "\n")
+	}
+	if line < 1 || line > len(lines[filename]) {
+		return false
+	}
+	return strings.HasPrefix(strings.TrimSpace(lines[filename][line-1]), "@cert-authority")
+}
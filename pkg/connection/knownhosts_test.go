@@ -0,0 +1,290 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package connection
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/work-obs/ansible-go/pkg/config"
+)
+
+// newTestSigner returns a fresh ed25519 signer
+func newTestSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	return signer
+}
+
+// startSSHServer runs an SSH server presenting hostKey that accepts any
+// password, and returns its port
+func startSSHServer(t *testing.T, hostKey ssh.Signer) int {
+	t.Helper()
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for newChannel := range chans {
+					newChannel.Reject(ssh.Prohibited, "no channels")
+				}
+			}()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// connectSSH connects to the test server with the given mode and
+// known_hosts files
+func connectSSH(t *testing.T, port int, mode string, files ...string) error {
+	t.Helper()
+	conn, err := NewSSHConnection(&ConnectionConfig{
+		Host:                  "127.0.0.1",
+		Port:                  port,
+		User:                  "test",
+		Password:              "secret",
+		StrictHostKeyChecking: mode,
+		KnownHostsFiles:       files,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create SSH connection: %v", err)
+	}
+	err = conn.Connect(context.Background())
+	conn.Close()
+	return err
+}
+
+func TestSSHConnection_HostKeyChecking(t *testing.T) {
+	hostKey := newTestSigner(t)
+	port := startSSHServer(t, hostKey)
+	fingerprint := ssh.FingerprintSHA256(hostKey.PublicKey())
+	knownHosts := filepath.Join(t.TempDir(), "ssh", "known_hosts")
+
+	// An unknown host is rejected, naming the key it offered
+	err := connectSSH(t, port, HostKeyCheckingYes, knownHosts)
+	if err == nil || !strings.Contains(err.Error(), fingerprint) {
+		t.Fatalf("Expected an unknown host to be rejected with its fingerprint, got: %v", err)
+	}
+	if err := connectSSH(t, port, HostKeyCheckingNo, knownHosts); err != nil {
+		t.Errorf("Expected no checking to accept any key, got: %v", err)
+	}
+
+	// accept-new records the key under the non-standard port
+	if err := connectSSH(t, port, HostKeyCheckingAcceptNew, knownHosts); err != nil {
+		t.Fatalf("Expected accept-new to accept a new host, got: %v", err)
+	}
+	data, err := os.ReadFile(knownHosts)
+	if err != nil {
+		t.Fatalf("Expected known_hosts to be written: %v", err)
+	}
+	if !strings.HasPrefix(string(data), knownhosts.Normalize(net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))+" ssh-ed25519 ") {
+		t.Errorf("Unexpected known_hosts entry: %s", data)
+	}
+	if _, err := os.Stat(knownHosts + ".lock"); !os.IsNotExist(err) {
+		t.Error("Expected the known_hosts lock to be released")
+	}
+	if err := connectSSH(t, port, HostKeyCheckingYes, knownHosts); err != nil {
+		t.Errorf("Expected the recorded key to be accepted, got: %v", err)
+	}
+
+	// A changed key is rejected in every checking mode
+	changed := startSSHServer(t, newTestSigner(t))
+	line := knownhosts.Line([]string{knownhosts.Normalize(net.JoinHostPort("127.0.0.1", strconv.Itoa(changed)))}, hostKey.PublicKey())
+	os.WriteFile(knownHosts, []byte(line+"\n"), 0600)
+	for _, mode := range []string{HostKeyCheckingYes, HostKeyCheckingAcceptNew} {
+		err := connectSSH(t, changed, mode, knownHosts)
+		if err == nil || !strings.Contains(err.Error(), "HAS CHANGED") || !strings.Contains(err.Error(), fingerprint) {
+			t.Errorf("Expected %s to reject a changed key, got: %v", mode, err)
+		}
+	}
+
+	if err := connectSSH(t, port, "sometimes", knownHosts); err == nil || !strings.Contains(err.Error(), "invalid host key checking mode") {
+		t.Errorf("Expected an invalid mode to be rejected, got: %v", err)
+	}
+}
+
+func TestSSHConnection_KnownHostsEntries(t *testing.T) {
+	hostKey := newTestSigner(t)
+	port := startSSHServer(t, hostKey)
+	address := knownhosts.Normalize(net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	dir := t.TempDir()
+	userFile := filepath.Join(dir, "known_hosts")
+	systemFile := filepath.Join(dir, "ssh_known_hosts")
+
+	// Hashed entries in the system file are found
+	os.WriteFile(systemFile, []byte("# system keys\n"+knownhosts.Line([]string{knownhosts.HashHostname(address)}, hostKey.PublicKey())+"\n"), 0644)
+	if err := connectSSH(t, port, HostKeyCheckingYes, userFile, systemFile); err != nil {
+		t.Errorf("Expected a hashed system entry to be accepted, got: %v", err)
+	}
+
+	// Host certificates signed by a @cert-authority are accepted
+	authority := newTestSigner(t)
+	cert := &ssh.Certificate{
+		Key:             hostKey.PublicKey(),
+		CertType:        ssh.HostCert,
+		ValidPrincipals: []string{"127.0.0.1"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, authority); err != nil {
+		t.Fatalf("Failed to sign host certificate: %v", err)
+	}
+	certSigner, err := ssh.NewCertSigner(cert, hostKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate signer: %v", err)
+	}
+	certPort := startSSHServer(t, certSigner)
+	certAddress := knownhosts.Normalize(net.JoinHostPort("127.0.0.1", strconv.Itoa(certPort)))
+	caLine := "@cert-authority " + certAddress + " " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(authority.PublicKey())))
+	os.WriteFile(systemFile, []byte(caLine+"\n"), 0644)
+	if err := connectSSH(t, certPort, HostKeyCheckingYes, userFile, systemFile); err != nil {
+		t.Errorf("Expected a certificate from a known authority to be accepted, got: %v", err)
+	}
+
+	// Revoked keys are rejected
+	revoked := "@revoked * " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(hostKey.PublicKey())))
+	os.WriteFile(systemFile, []byte(revoked+"\n"+knownhosts.Line([]string{address}, hostKey.PublicKey())+"\n"), 0644)
+	if err := connectSSH(t, port, HostKeyCheckingYes, userFile, systemFile); err == nil || !strings.Contains(err.Error(), "revoked") {
+		t.Errorf("Expected a revoked key to be rejected, got: %v", err)
+	}
+}
+
+func TestSSHConnection_AcceptNewConcurrent(t *testing.T) {
+	port := startSSHServer(t, newTestSigner(t))
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	os.WriteFile(knownHosts, []byte("# no trailing newline"), 0600)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- connectSSH(t, port, HostKeyCheckingAcceptNew, knownHosts)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Expected concurrent accept-new connections to succeed, got: %v", err)
+		}
+	}
+
+	data, _ := os.ReadFile(knownHosts)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || lines[0] != "# no trailing newline" {
+		t.Errorf("Expected the key to be written once on its own line, got:\n%s", data)
+	}
+}
+
+func TestApplyHostKeySettings(t *testing.T) {
+	home, _ := os.UserHomeDir()
+	defaults := &config.Config{HostKeyChecking: true, SSHArgs: "-C -o ControlMaster=auto -o ControlPersist=60s"}
+
+	tests := []struct {
+		name      string
+		cfg       *config.Config
+		variables map[string]interface{}
+		checking  bool
+		strict    string
+		files     []string
+	}{
+		{"defaults", defaults, nil, true, "", nil},
+		{"no config", nil, nil, true, "", nil},
+		{"host_key_checking off", &config.Config{HostKeyChecking: false}, nil, false, HostKeyCheckingNo, nil},
+		{"variable turns checking off", defaults, map[string]interface{}{"ansible_host_key_checking": "False"}, false, HostKeyCheckingNo, nil},
+		{"off wins over common args", &config.Config{HostKeyChecking: false}, map[string]interface{}{
+			"ansible_ssh_common_args": "-o StrictHostKeyChecking=yes",
+		}, false, HostKeyCheckingNo, nil},
+		{"common args", defaults, map[string]interface{}{
+			"ansible_ssh_common_args": "-o StrictHostKeyChecking=accept-new -o 'UserKnownHostsFile=/etc/ansible/known_hosts ~/.ssh/extra_hosts'",
+		}, true, HostKeyCheckingAcceptNew, []string{"/etc/ansible/known_hosts", filepath.Join(home, ".ssh/extra_hosts")}},
+		{"first option wins", &config.Config{HostKeyChecking: true, SSHArgs: "-oStrictHostKeyChecking=no"}, map[string]interface{}{
+			"ansible_ssh_extra_args": "-o StrictHostKeyChecking=yes",
+		}, true, HostKeyCheckingNo, nil},
+		{"space separated option", defaults, map[string]interface{}{
+			"ansible_ssh_extra_args": `-o "stricthostkeychecking ask" -o UserKnownHostsFile=/dev/null`,
+		}, true, HostKeyCheckingYes, []string{"/dev/null"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			connCfg := &ConnectionConfig{Host: "192.0.2.1"}
+			if err := ApplyHostKeySettings(connCfg, test.cfg, test.variables); err != nil {
+				t.Fatalf("ApplyHostKeySettings() error = %v", err)
+			}
+			if connCfg.HostKeyChecking != test.checking {
+				t.Errorf("expected HostKeyChecking %v, got %v", test.checking, connCfg.HostKeyChecking)
+			}
+			if connCfg.StrictHostKeyChecking != test.strict {
+				t.Errorf("expected StrictHostKeyChecking '%s', got '%s'", test.strict, connCfg.StrictHostKeyChecking)
+			}
+			if strings.Join(connCfg.KnownHostsFiles, ",") != strings.Join(test.files, ",") {
+				t.Errorf("expected KnownHostsFiles %v, got %v", test.files, connCfg.KnownHostsFiles)
+			}
+		})
+	}
+
+	for _, variables := range []map[string]interface{}{
+		{"ansible_host_key_checking": "maybe"},
+		{"ansible_ssh_common_args": "-o StrictHostKeyChecking=sometimes"},
+		{"ansible_ssh_common_args": "-o 'UserKnownHostsFile=/tmp"},
+	} {
+		if err := ApplyHostKeySettings(&ConnectionConfig{}, defaults, variables); err == nil {
+			t.Errorf("expected an error for %v", variables)
+		}
+	}
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package connection
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -111,42 +112,20 @@ func (c *LocalConnection) Execute(ctx context.Context, cmd string, stdin io.Read
 
 // runCommand executes a command and returns stdout, stderr, exit code, and error
 func (c *LocalConnection) runCommand(cmd *exec.Cmd) (string, string, int, error) {
-	// Capture stdout and stderr separately
-	stdoutPipe, err := cmd.StdoutPipe()
-	if err != nil {
-		return "", "", -1, fmt.Errorf("failed to create stdout pipe: %w", err)
-	}
-
-	stderrPipe, err := cmd.StderrPipe()
-	if err != nil {
-		return "", "", -1, fmt.Errorf("failed to create stderr pipe: %w", err)
-	}
+	// Capture stdout and stderr separately. Wait returns once both are
+	// copied into the buffers; reading pipes after Wait loses output
+	// because Wait closes them.
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
 
 	// Start the command
 	if err := cmd.Start(); err != nil {
 		return "", "", -1, fmt.Errorf("failed to start command: %w", err)
 	}
 
-	// Read stdout and stderr concurrently
-	stdoutChan := make(chan string, 1)
-	stderrChan := make(chan string, 1)
-
-	go func() {
-		stdout, _ := io.ReadAll(stdoutPipe)
-		stdoutChan <- string(stdout)
-	}()
-
-	go func() {
-		stderr, _ := io.ReadAll(stderrPipe)
-		stderrChan <- string(stderr)
-	}()
-
 	// Wait for command to complete
-	err = cmd.Wait()
-
-	// Get output
-	stdout := <-stdoutChan
-	stderr := <-stderrChan
+	err := cmd.Wait()
 
 	// Determine exit code
 	exitCode := 0
@@ -158,7 +137,7 @@ func (c *LocalConnection) runCommand(cmd *exec.Cmd) (string, string, int, error)
 		}
 	}
 
-	return stdout, stderr, exitCode, err
+	return stdout.String(), stderr.String(), exitCode, err
 }
 
 // PutFile copies a file from local to local (essentially a copy operation)
@@ -390,10 +390,19 @@ func (c *SSHConnection) GetFileInfo(ctx context.Context, path string) (*FileInfo
 func (c *SSHConnection) buildSSHConfig() (*ssh.ClientConfig, error) {
 	config := c.GetConfig()
 
+	verifier, err := c.newHostKeyVerifier()
+	if err != nil {
+		return nil, err
+	}
+
 	sshConfig := &ssh.ClientConfig{
 		User:            config.User,
 		Timeout:         config.ConnectTimeout,
-		HostKeyCallback: c.buildHostKeyCallback(),
+		HostKeyCallback: c.buildHostKeyCallback(verifier),
+	}
+	if verifier != nil {
+		address := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+		sshConfig.HostKeyAlgorithms = verifier.hostKeyAlgorithms(address)
 	}
 
 	// Set up authentication methods
@@ -407,17 +416,13 @@ func (c *SSHConnection) buildSSHConfig() (*ssh.ClientConfig, error) {
 	return sshConfig, nil
 }
 
-// buildHostKeyCallback builds the host key callback
-func (c *SSHConnection) buildHostKeyCallback() ssh.HostKeyCallback {
-	config := c.GetConfig()
-
-	if !config.HostKeyChecking {
+// buildHostKeyCallback builds the host key callback, which checks host
+// keys against known_hosts unless host key checking is off
+func (c *SSHConnection) buildHostKeyCallback(verifier *hostKeyVerifier) ssh.HostKeyCallback {
+	if verifier == nil {
 		return ssh.InsecureIgnoreHostKey()
 	}
-
-	// In a real implementation, this would check against known_hosts
-	// For now, we'll use the insecure callback
-	return ssh.InsecureIgnoreHostKey()
+	return verifier.check
 }
 
 // buildAuthMethods builds the authentication methods
@@ -22,9 +22,13 @@ import (
 	"sync"
 	"time"
 
+	"github.com/spf13/afero"
 	"github.com/work-obs/ansible-go/internal/router"
 	"github.com/work-obs/ansible-go/pkg/config"
+	"github.com/work-obs/ansible-go/pkg/inventory"
 	"github.com/work-obs/ansible-go/pkg/plugins"
+	"github.com/work-obs/ansible-go/pkg/plugins/action"
+	"github.com/work-obs/ansible-go/pkg/vars"
 )
 
 // TaskStatus represents the status of a task execution
@@ -86,6 +90,10 @@ type ExecutionContext struct {
 	ExtraVars      map[string]interface{}
 	Inventory      map[string]interface{}
 	ConnectionInfo map[string]interface{}
+
+	// Vars is the variable manager holding the host contexts templates
+	// are rendered with, if any
+	Vars *vars.Manager
 }
 
 // Executor manages task execution
@@ -93,6 +101,7 @@ type Executor struct {
 	config     *config.Config
 	router     *router.Router
 	pluginMgr  plugins.Manager
+	vars       *vars.Manager
 	results    map[string]*TaskResult
 	mutex      sync.RWMutex
 	maxWorkers int
@@ -165,6 +174,11 @@ func (e *Executor) ExecuteTask(task *Task, execCtx *ExecutionContext) (*TaskResu
 	e.results[task.ID] = result
 	e.mutex.Unlock()
 
+	// Values memoised while templating earlier tasks may be out of date
+	if execCtx.Vars != nil {
+		execCtx.Vars.StartTask(task.Host, task.ID)
+	}
+
 	// Resolve module name through router
 	resolvedModule, err := e.router.ResolveModule(task.Module)
 	if err != nil {
@@ -375,6 +389,12 @@ func (e *Executor) evaluateCondition(condition string, execCtx *ExecutionContext
 	return true, nil
 }
 
+// SetVariableManager sets the variable manager whose host contexts the
+// tasks queued to the workers are templated with
+func (e *Executor) SetVariableManager(vm *vars.Manager) {
+	e.vars = vm
+}
+
 // SetMaxWorkers sets the maximum number of worker goroutines
 func (e *Executor) SetMaxWorkers(workers int) {
 	e.maxWorkers = workers
@@ -414,6 +434,7 @@ func (w *TaskWorker) processTask(task *Task) (*TaskResult, error) {
 		Config:    w.Executor.config,
 		Variables: make(map[string]interface{}),
 		Facts:     make(map[string]interface{}),
+		Vars:      w.Executor.vars,
 	}
 
 	// Merge task variables
@@ -446,6 +467,8 @@ type TaskExecutor struct {
 	config        *Config
 	ansibleConfig *config.Config
 	executor      *Executor
+	vars          *vars.Manager
+	loader        *plugins.Loader
 }
 
 // NewTaskExecutor creates a new task executor (legacy compatibility)
@@ -456,10 +479,58 @@ func NewTaskExecutor(config *Config, ansibleConfig *config.Config) (*TaskExecuto
 	}, nil
 }
 
+// SetInventory builds the variable manager for inv, configured like the
+// executor and with the external filter, lookup and test plugins of its
+// plugin paths, and hands both to the action plugins, so that add_host
+// and group_by change the inventory the hosts were selected from
+func (e *TaskExecutor) SetInventory(inv *inventory.Inventory) error {
+	vm := vars.NewManager(inv)
+	if e.ansibleConfig != nil {
+		vm.SetConfig(e.ansibleConfig)
+		loader := plugins.NewLoader(e.ansibleConfig, afero.NewOsFs())
+		if err := vm.LoadExternalPlugins(loader); err != nil {
+			loader.Close()
+			return fmt.Errorf("failed to load external plugins: %w", err)
+		}
+		e.Close()
+		e.loader = loader
+	}
+	e.vars = vm
+	action.SetActionInventory(inv, vm)
+	return nil
+}
+
+// Close stops the long-lived external plugin processes started for the
+// variable manager
+func (e *TaskExecutor) Close() error {
+	if e.loader == nil {
+		return nil
+	}
+	err := e.loader.Close()
+	e.loader = nil
+	return err
+}
+
+// VariableManager returns the variable manager built by SetInventory, or
+// nil before it is called
+func (e *TaskExecutor) VariableManager() *vars.Manager {
+	return e.vars
+}
+
 // ExecuteModule executes a module on the specified hosts (legacy compatibility)
 func (e *TaskExecutor) ExecuteModule(ctx context.Context, hosts []string, moduleName string, args map[string]interface{}) (map[string]*TaskResult, error) {
 	results := make(map[string]*TaskResult)
 
+	if e.vars != nil {
+		for _, host := range hosts {
+			if _, err := e.vars.CreateHostContext(host); err != nil {
+				return nil, fmt.Errorf("failed to create variables for host %s: %w", host, err)
+			}
+		}
+		// Modules such as add_host and group_by may change the inventory
+		defer e.vars.RefreshHostContexts()
+	}
+
 	// TODO: Implement actual module execution using new executor
 	for _, host := range hosts {
 		results[host] = &TaskResult{
@@ -18,11 +18,16 @@ package executor
 
 import (
 	"context"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/work-obs/ansible-go/pkg/config"
+	"github.com/work-obs/ansible-go/pkg/inventory"
 	"github.com/work-obs/ansible-go/pkg/plugins"
+	"github.com/work-obs/ansible-go/pkg/plugins/action"
+	"github.com/work-obs/ansible-go/pkg/vars"
 	"github.com/work-obs/ansible-go/internal/router"
 	"github.com/spf13/afero"
 )
@@ -216,6 +221,56 @@ func TestExecutor_ExecuteTask_Success(t *testing.T) {
 	}
 }
 
+func TestExecutor_ExecuteTask_StartsTask(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	configMgr := config.NewManager(fs)
+	if err := configMgr.LoadConfig(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	pluginMgr := NewMockPluginManager()
+	pluginMgr.AddPlugin("test_module", &MockPlugin{name: "test_module"})
+	executor := NewExecutor(configMgr.GetConfig(), router.NewRouter(), pluginMgr)
+
+	varsMgr := vars.NewManager(nil)
+	hostCtx, err := varsMgr.CreateHostContext("test-host")
+	if err != nil {
+		t.Fatalf("Failed to create host context: %v", err)
+	}
+	hostCtx.SetVariable("greeting", "hello {{ name }}", vars.PrecedencePlayVars, "play")
+	hostCtx.SetVariable("name", "alice", vars.PrecedenceTaskVars, "test")
+
+	execCtx := &ExecutionContext{
+		Config:    configMgr.GetConfig(),
+		Variables: make(map[string]interface{}),
+		Facts:     make(map[string]interface{}),
+		Vars:      varsMgr,
+	}
+	for _, test := range []struct {
+		taskID   string
+		expected string
+	}{
+		{"task-1", "hello alice"},
+		{"task-2", "hello bob"},
+	} {
+		task := &Task{ID: test.taskID, Module: "test_module", Host: "test-host"}
+		if _, err := executor.ExecuteTask(task, execCtx); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		result, err := varsMgr.TemplateString("{{ greeting }}", hostCtx)
+		if err != nil {
+			t.Fatalf("Template failed: %v", err)
+		}
+		if result != test.expected {
+			t.Errorf("Task %s: expected '%s', got '%s'", test.taskID, test.expected, result)
+		}
+
+		// Changed in place, as a registered result can be, the value is
+		// seen from the next task on
+		hostCtx.Variables["name"].Value = "bob"
+	}
+}
+
 func TestExecutor_ExecuteTask_Failure(t *testing.T) {
 	fs := afero.NewMemMapFs()
 	configMgr := config.NewManager(fs)
@@ -529,6 +584,57 @@ func TestTaskWorker_processTask(t *testing.T) {
 	}
 }
 
+func TestTaskWorker_processTask_StartsTask(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	configMgr := config.NewManager(fs)
+	if err := configMgr.LoadConfig(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	pluginMgr := NewMockPluginManager()
+	pluginMgr.AddPlugin("test_module", &MockPlugin{name: "test_module"})
+	executor := NewExecutor(configMgr.GetConfig(), router.NewRouter(), pluginMgr)
+
+	varsMgr := vars.NewManager(nil)
+	executor.SetVariableManager(varsMgr)
+	hostCtx, err := varsMgr.CreateHostContext("test-host")
+	if err != nil {
+		t.Fatalf("Failed to create host context: %v", err)
+	}
+	hostCtx.SetVariable("greeting", "hello {{ name }}", vars.PrecedencePlayVars, "play")
+	hostCtx.SetVariable("name", "alice", vars.PrecedenceTaskVars, "test")
+
+	worker := &TaskWorker{
+		ID:         0,
+		TaskChan:   make(chan *Task),
+		ResultChan: make(chan *TaskResult),
+		WorkerPool: make(chan chan *Task),
+		Executor:   executor,
+		ctx:        executor.ctx,
+	}
+
+	for _, test := range []struct {
+		taskID   string
+		expected string
+	}{
+		{"task-1", "hello alice"},
+		{"task-2", "hello bob"},
+	} {
+		task := &Task{ID: test.taskID, Module: "test_module", Host: "test-host"}
+		if _, err := worker.processTask(task); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		result, err := varsMgr.TemplateString("{{ greeting }}", hostCtx)
+		if err != nil {
+			t.Fatalf("Template failed: %v", err)
+		}
+		if result != test.expected {
+			t.Errorf("Task %s: expected '%s', got '%s'", test.taskID, test.expected, result)
+		}
+		hostCtx.Variables["name"].Value = "bob"
+	}
+}
+
 // Legacy compatibility tests
 
 func TestNewTaskExecutor(t *testing.T) {
@@ -611,4 +717,114 @@ func TestTaskExecutor_ExecuteModule(t *testing.T) {
 			t.Errorf("Expected status %s for host %s, got %s", TaskStatusCompleted, host, result.Status)
 		}
 	}
-}
\ No newline at end of file
+}
+func TestTaskExecutor_SetInventory(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	configMgr := config.NewManager(fs)
+	if err := configMgr.LoadConfig(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	executor, err := NewTaskExecutor(&Config{Forks: 5}, configMgr.GetConfig())
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	inv := inventory.NewInventory(afero.NewMemMapFs())
+	inv.AddHost("localhost", []string{"local"}, nil)
+	if err := executor.SetInventory(inv); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer action.SetActionInventory(nil, nil)
+
+	if _, err := executor.ExecuteModule(context.Background(), []string{"localhost"}, "ping", nil); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	hostCtx, err := executor.VariableManager().CreateHostContext("localhost")
+	if err != nil {
+		t.Fatalf("Failed to create host context: %v", err)
+	}
+
+	// add_host from the default registry adds to the runner's inventory
+	// and refreshes the host contexts of its variable manager
+	plugin, err := action.GetActionPlugin("add_host")
+	if err != nil {
+		t.Fatalf("Failed to get add_host: %v", err)
+	}
+	actionCtx := &plugins.ActionContext{}
+	actionCtx.Args = map[string]interface{}{"name": "192.0.2.20", "groups": "added"}
+	result, err := plugin.Run(context.Background(), actionCtx)
+	if err != nil || result.Failed {
+		t.Fatalf("add_host failed: %v %s", err, result.Message)
+	}
+	if hosts := inv.GroupHosts()["added"]; len(hosts) != 1 || hosts[0] != "192.0.2.20" {
+		t.Errorf("Expected the host to be added to the runner's inventory, got %v", hosts)
+	}
+	if hosts := hostCtx.Groups["added"]; len(hosts) != 1 || hosts[0] != "192.0.2.20" {
+		t.Errorf("Expected host contexts to see the added group, got %v", hosts)
+	}
+}
+
+func TestTaskExecutor_SetInventory_Config(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	configMgr := config.NewManager(fs)
+	if err := configMgr.LoadConfig(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	cfg := configMgr.GetConfig()
+	cfg.JinjaNative = true
+	cfg.RemoteUser = "deploy"
+
+	executor, err := NewTaskExecutor(&Config{Forks: 5}, cfg)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if err := executor.SetInventory(inventory.NewInventory(afero.NewMemMapFs())); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer action.SetActionInventory(nil, nil)
+
+	varsMgr := executor.VariableManager()
+	if !varsMgr.NativeTypes() {
+		t.Error("Expected jinja2_native from the configuration")
+	}
+	result, err := varsMgr.TemplateString("{{ lookup('config', 'DEFAULT_REMOTE_USER') }}", vars.NewContext())
+	if err != nil || result != "deploy" {
+		t.Errorf("Expected the config lookup to read the configuration, got '%s' (%v)", result, err)
+	}
+}
+
+func TestTaskExecutor_SetInventory_ExternalPlugins(t *testing.T) {
+	dir := t.TempDir()
+	script := "#!/bin/sh\n" +
+		"if [ \"$1\" = describe ]; then echo '{\"name\": \"greet\", \"filters\": [\"greet\"]}'; exit 0; fi\n" +
+		"cat >/dev/null\n" +
+		"echo '{\"result\": \"hello\"}'\n"
+	if err := os.WriteFile(filepath.Join(dir, "greet"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := afero.NewMemMapFs()
+	configMgr := config.NewManager(fs)
+	if err := configMgr.LoadConfig(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	cfg := configMgr.GetConfig()
+	cfg.FilterPluginPath = []string{dir}
+
+	executor, err := NewTaskExecutor(&Config{Forks: 5}, cfg)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if err := executor.SetInventory(inventory.NewInventory(afero.NewMemMapFs())); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer action.SetActionInventory(nil, nil)
+	defer executor.Close()
+
+	result, err := executor.VariableManager().TemplateString("{{ 'world' | greet }}", vars.NewContext())
+	if err != nil || result != "hello" {
+		t.Errorf("Expected the external filter from the filter plugin path, got '%s' (%v)", result, err)
+	}
+}
@@ -0,0 +1,538 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/work-obs/ansible-go/pkg/config"
+	"github.com/work-obs/ansible-go/pkg/plugins/cache"
+	"gopkg.in/yaml.v3"
+)
+
+// memoryCache is shared by every manager in the process, so a source
+// loaded once is reused by later loads of the same source
+var memoryCache = cache.NewMemoryCachePlugin()
+
+// defaultCacheConnection is the directory of the jsonfile cache plugin
+// when neither the configuration nor the source names one
+const defaultCacheConnection = "~/.ansible/tmp/inventory_cache"
+
+// cachedInventory is the form a source's hosts and groups are cached in.
+// Hosts and groups are listed in the order they were added; slices and
+// maps keep the difference between empty and unset.
+type cachedInventory struct {
+	Hosts  []cachedHost  `json:"hosts"`
+	Groups []cachedGroup `json:"groups"`
+}
+
+type cachedHost struct {
+	Name      string                 `json:"name"`
+	Address   string                 `json:"address"`
+	Port      int                    `json:"port"`
+	User      string                 `json:"user"`
+	Variables map[string]interface{} `json:"vars"`
+	Groups    []string               `json:"groups"`
+}
+
+type cachedGroup struct {
+	Name      string                 `json:"name"`
+	Hosts     []string               `json:"hosts"`
+	Children  []string               `json:"children"`
+	Variables map[string]interface{} `json:"vars"`
+}
+
+// cacheOptions are the cache settings of a source. The inventory cache
+// settings of the configuration are the defaults, which a plugin
+// configuration file overrides with its cache, cache_plugin,
+// cache_connection, cache_prefix and cache_timeout keys.
+type cacheOptions struct {
+	enabled    bool
+	plugin     cache.CachePlugin
+	pluginName string
+	connection string
+	prefix     string
+	timeout    time.Duration
+}
+
+// sourceCacheConfig holds the cache keys of a plugin configuration file
+type sourceCacheConfig struct {
+	Cache           *bool       `yaml:"cache"`
+	CachePlugin     *string     `yaml:"cache_plugin"`
+	CacheConnection *string     `yaml:"cache_connection"`
+	CachePrefix     *string     `yaml:"cache_prefix"`
+	CacheTimeout    interface{} `yaml:"cache_timeout"`
+}
+
+// SetCache caches every file source in plugin, with entries named with
+// prefix that expire after timeout, unless a source's configuration says
+// otherwise. A zero timeout never expires. A nil plugin returns to the
+// configured settings.
+func (m *Manager) SetCache(plugin cache.CachePlugin, prefix string, timeout time.Duration) {
+	m.cacheDefaults.enabled = plugin != nil
+	m.cacheDefaults.plugin = plugin
+	m.cacheDefaults.prefix = prefix
+	m.cacheDefaults.timeout = timeout
+}
+
+// SetFlushCache makes sources ignore their cache entries, loading the
+// sources again and replacing the entries
+func (m *Manager) SetFlushCache(flush bool) {
+	m.flushCache = flush
+}
+
+// setCacheConfig takes the default cache settings from cfg
+func (m *Manager) setCacheConfig(cfg *config.Config) {
+	m.cacheDefaults = cacheOptions{
+		enabled:    cfg.InventoryCache,
+		pluginName: cfg.InventoryCachePlugin,
+		connection: cfg.InventoryCacheConnection,
+		prefix:     cfg.InventoryCachePrefix,
+		timeout:    cfg.InventoryCacheTimeout,
+	}
+	m.flushCache = cfg.FlushCache
+}
+
+// sourceCacheOptions returns the cache settings of a source. Only files
+// are cached, and never constructed configurations, which build on the
+// hosts of the other sources.
+func (m *Manager) sourceCacheOptions(source string) (cacheOptions, error) {
+	options := m.cacheDefaults
+	if !m.isFile(source) {
+		options.enabled = false
+		return options, nil
+	}
+
+	ext := strings.ToLower(filepath.Ext(source))
+	if ext != ".yml" && ext != ".yaml" {
+		return options, nil
+	}
+	// Files that are not plugin configurations keep the defaults; their
+	// plugin reports any syntax errors
+	name, err := pluginConfigName(m, source)
+	if err != nil || name == "" {
+		return options, nil
+	}
+	if strings.TrimPrefix(name, "ansible.builtin.") == "constructed" {
+		options.enabled = false
+		return options, nil
+	}
+
+	data, err := afero.ReadFile(m.fs, source)
+	if err != nil {
+		return options, fmt.Errorf("failed to read inventory file %s: %w", source, err)
+	}
+	var sourceConfig sourceCacheConfig
+	if err := yaml.Unmarshal(data, &sourceConfig); err != nil {
+		return options, fmt.Errorf("invalid cache settings in %s: %w", source, err)
+	}
+	if err := sourceConfig.apply(&options); err != nil {
+		return options, fmt.Errorf("invalid cache settings in %s: %w", source, err)
+	}
+	return options, nil
+}
+
+// apply overrides options with the keys the configuration file sets
+func (c *sourceCacheConfig) apply(options *cacheOptions) error {
+	if c.Cache != nil {
+		options.enabled = *c.Cache
+	}
+	if c.CachePlugin != nil {
+		options.plugin = nil
+		options.pluginName = *c.CachePlugin
+	}
+	if c.CacheConnection != nil {
+		options.connection = *c.CacheConnection
+	}
+	if c.CachePrefix != nil {
+		options.prefix = *c.CachePrefix
+	}
+
+	// Like Ansible, a bare number is a number of seconds
+	switch timeout := c.CacheTimeout.(type) {
+	case nil:
+	case int:
+		options.timeout = time.Duration(timeout) * time.Second
+	case string:
+		if seconds, err := strconv.Atoi(timeout); err == nil {
+			options.timeout = time.Duration(seconds) * time.Second
+			break
+		}
+		duration, err := time.ParseDuration(timeout)
+		if err != nil {
+			return fmt.Errorf("cache_timeout must be a number of seconds or a duration, got '%s'", timeout)
+		}
+		options.timeout = duration
+	default:
+		return fmt.Errorf("cache_timeout must be a number of seconds or a duration, got %v", timeout)
+	}
+	return nil
+}
+
+// cachePlugin returns the cache plugin the options name
+func (o cacheOptions) cachePlugin() (cache.CachePlugin, error) {
+	if o.plugin != nil {
+		return o.plugin, nil
+	}
+	switch o.pluginName {
+	case "", "memory":
+		return memoryCache, nil
+	case "jsonfile":
+		connection := o.connection
+		if connection == "" {
+			connection = defaultCacheConnection
+		}
+		return cache.NewJsonFileCachePlugin(expandHome(connection)), nil
+	}
+	return cache.NewCachePluginRegistry().Get(o.pluginName)
+}
+
+// expandHome expands a leading ~/ to the home directory
+func expandHome(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[2:])
+		}
+	}
+	return path
+}
+
+// cacheKey names the cache entry of a source
+func (m *Manager) cacheKey(prefix, source string) string {
+	if abs, err := filepath.Abs(source); err == nil {
+		source = abs
+	}
+	hash := sha256.Sum256([]byte(source))
+	return prefix + hex.EncodeToString(hash[:])[:32]
+}
+
+// parseCachedSource loads a source whose cache settings turn caching on.
+// The source is parsed on its own, so that its entry holds only what it
+// adds, and the cached or parsed result is merged into the inventory.
+func (m *Manager) parseCachedSource(source string, options cacheOptions) error {
+	plugin, err := options.cachePlugin()
+	if err != nil {
+		return fmt.Errorf("invalid inventory cache settings for %s: %v", source, err)
+	}
+	key := m.cacheKey(options.prefix, source)
+
+	if !m.flushCache {
+		if cached, ok := loadCachedInventory(plugin, key); ok {
+			m.mergeCachedInventory(cached)
+			return nil
+		}
+	}
+
+	scratch := m.sourceManager()
+	if err := scratch.parseFile(source); err != nil {
+		return err
+	}
+	cached := scratch.inventory.snapshot()
+	data, err := encodeCachedInventory(cached)
+	if err == nil {
+		err = plugin.Set(key, data, options.timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to cache inventory source %s: %w", source, err)
+	}
+	m.mergeCachedInventory(cached)
+	return nil
+}
+
+// sourceManager returns a manager with the settings of m and an empty
+// inventory, to parse a single source into
+func (m *Manager) sourceManager() *Manager {
+	scratch := NewManager(m.fs)
+	scratch.plugins = m.plugins
+	scratch.enabledPlugins = m.enabledPlugins
+	scratch.ignoreExtensions = m.ignoreExtensions
+	scratch.scriptTimeout = m.scriptTimeout
+	scratch.validationMode = m.validationMode
+	scratch.currentSource = m.currentSource
+	return scratch
+}
+
+// loadCachedInventory reads a cache entry. It reports false when there is
+// no usable entry.
+func loadCachedInventory(plugin cache.CachePlugin, key string) (*cachedInventory, bool) {
+	value, err := plugin.Get(key)
+	if err != nil {
+		return nil, false
+	}
+	data, ok := value.(string)
+	if !ok {
+		return nil, false
+	}
+
+	cached, err := decodeCachedInventory(data)
+	if err != nil {
+		// A corrupt entry is a miss; loading the source replaces it
+		return nil, false
+	}
+	return cached, true
+}
+
+// mergeCachedInventory adds the hosts, groups and variables of a source
+// to the inventory, as parsing the source into it would
+func (m *Manager) mergeCachedInventory(cached *cachedInventory) {
+	for _, host := range cached.Hosts {
+		m.recordHostDefinition(host.Name, host.Variables)
+	}
+
+	inv := m.inventory
+	inv.mutex.Lock()
+	defer inv.mutex.Unlock()
+
+	for _, cachedHost := range cached.Hosts {
+		_, existed := inv.Hosts[cachedHost.Name]
+		host := inv.getOrCreateHost(cachedHost.Name, cachedHost.Address)
+		if !existed {
+			host.Port = cachedHost.Port
+			host.User = cachedHost.User
+		}
+		setHostVariables(host, cachedHost.Variables)
+	}
+
+	for _, cachedGroup := range cached.Groups {
+		group := inv.getOrCreateGroup(cachedGroup.Name)
+		if len(cachedGroup.Variables) > 0 && group.Variables == nil {
+			group.Variables = make(map[string]interface{}, len(cachedGroup.Variables))
+		}
+		for key, value := range cachedGroup.Variables {
+			group.Variables[key] = value
+		}
+		// The members of all and ungrouped are worked out from the others
+		if group != inv.AllGroup && group != inv.UngroupedGroup {
+			for _, hostName := range cachedGroup.Hosts {
+				group.AddHost(hostName)
+			}
+		}
+		for _, childName := range cachedGroup.Children {
+			group.AddChild(childName)
+		}
+	}
+	inv.updateAllGroup()
+}
+
+// snapshot copies the inventory into its cached form
+func (inv *Inventory) snapshot() *cachedInventory {
+	inv.mutex.RLock()
+	defer inv.mutex.RUnlock()
+
+	cached := &cachedInventory{}
+	for _, name := range inv.orderedHostNames() {
+		host := inv.Hosts[name]
+		cached.Hosts = append(cached.Hosts, cachedHost{
+			Name:      host.Name,
+			Address:   host.Address,
+			Port:      host.Port,
+			User:      host.User,
+			Variables: host.Variables,
+			Groups:    host.Groups,
+		})
+	}
+	for _, name := range inv.orderedGroupNames() {
+		group := inv.Groups[name]
+		cached.Groups = append(cached.Groups, cachedGroup{
+			Name:      group.Name,
+			Hosts:     group.Hosts,
+			Children:  group.Children,
+			Variables: group.Variables,
+		})
+	}
+	return cached
+}
+
+// encodeCachedInventory encodes a cached source as JSON. Floats are always
+// written with a decimal point or exponent so that decoding can tell them
+// from integers.
+func encodeCachedInventory(cached *cachedInventory) (string, error) {
+	encoded := &cachedInventory{
+		Hosts:  make([]cachedHost, len(cached.Hosts)),
+		Groups: make([]cachedGroup, len(cached.Groups)),
+	}
+	for i, host := range cached.Hosts {
+		variables, err := encodeCacheVariables(host.Variables)
+		if err != nil {
+			return "", fmt.Errorf("host %s: %v", host.Name, err)
+		}
+		host.Variables = variables
+		encoded.Hosts[i] = host
+	}
+	for i, group := range cached.Groups {
+		variables, err := encodeCacheVariables(group.Variables)
+		if err != nil {
+			return "", fmt.Errorf("group %s: %v", group.Name, err)
+		}
+		group.Variables = variables
+		encoded.Groups[i] = group
+	}
+
+	data, err := json.Marshal(encoded)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// decodeCachedInventory decodes an inventory encoded by
+// encodeCachedInventory
+func decodeCachedInventory(data string) (*cachedInventory, error) {
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.UseNumber()
+
+	cached := &cachedInventory{}
+	if err := decoder.Decode(cached); err != nil {
+		return nil, err
+	}
+
+	builtin := 0
+	for _, group := range cached.Groups {
+		if group.Name == "all" || group.Name == "ungrouped" {
+			builtin++
+		}
+	}
+	if builtin != 2 {
+		return nil, fmt.Errorf("cached inventory lacks the all and ungrouped groups")
+	}
+	for i := range cached.Hosts {
+		if err := decodeCacheVariables(cached.Hosts[i].Variables); err != nil {
+			return nil, err
+		}
+	}
+	for i := range cached.Groups {
+		if err := decodeCacheVariables(cached.Groups[i].Variables); err != nil {
+			return nil, err
+		}
+	}
+	return cached, nil
+}
+
+func encodeCacheVariables(variables map[string]interface{}) (map[string]interface{}, error) {
+	if variables == nil {
+		return nil, nil
+	}
+	encoded, err := encodeCacheValue(variables)
+	if err != nil {
+		return nil, err
+	}
+	return encoded.(map[string]interface{}), nil
+}
+
+// encodeCacheValue copies a variable value, replacing floats with their
+// JSON text. Only the types inventory sources produce are accepted, as
+// those are the types decoding gives back.
+func encodeCacheValue(value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case float64:
+		return encodeCacheFloat(value)
+	case uint64:
+		if value > math.MaxInt64 {
+			return value, nil
+		}
+	case map[string]interface{}:
+		if value == nil {
+			return value, nil
+		}
+		encoded := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			item, err := encodeCacheValue(item)
+			if err != nil {
+				return nil, err
+			}
+			encoded[key] = item
+		}
+		return encoded, nil
+	case []interface{}:
+		if value == nil {
+			return value, nil
+		}
+		encoded := make([]interface{}, len(value))
+		for i, item := range value {
+			item, err := encodeCacheValue(item)
+			if err != nil {
+				return nil, err
+			}
+			encoded[i] = item
+		}
+		return encoded, nil
+	case nil, string, bool, int:
+		return value, nil
+	}
+	return nil, fmt.Errorf("cannot cache a value of type %T", value)
+}
+
+func encodeCacheFloat(value float64) (json.RawMessage, error) {
+	if math.IsInf(value, 0) || math.IsNaN(value) {
+		return nil, fmt.Errorf("cannot cache the float %v", value)
+	}
+	text := strconv.FormatFloat(value, 'g', -1, 64)
+	if !strings.ContainsAny(text, ".e") {
+		text += ".0"
+	}
+	return json.RawMessage(text), nil
+}
+
+// decodeCacheVariables turns the json.Numbers of decoded variables back
+// into ints and floats, in place
+func decodeCacheVariables(variables map[string]interface{}) error {
+	for key, value := range variables {
+		decoded, err := decodeCacheValue(value)
+		if err != nil {
+			return err
+		}
+		variables[key] = decoded
+	}
+	return nil
+}
+
+func decodeCacheValue(value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case json.Number:
+		text := value.String()
+		if strings.ContainsAny(text, ".eE") {
+			return value.Float64()
+		}
+		if n, err := strconv.ParseInt(text, 10, 0); err == nil {
+			return int(n), nil
+		}
+		// YAML decodes integers too large for int as uint64
+		return strconv.ParseUint(text, 10, 64)
+	case map[string]interface{}:
+		return value, decodeCacheVariables(value)
+	case []interface{}:
+		for i, item := range value {
+			decoded, err := decodeCacheValue(item)
+			if err != nil {
+				return nil, err
+			}
+			value[i] = decoded
+		}
+		return value, nil
+	}
+	return value, nil
+}
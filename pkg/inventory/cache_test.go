@@ -0,0 +1,270 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/work-obs/ansible-go/pkg/config"
+	"github.com/work-obs/ansible-go/pkg/plugins/cache"
+)
+
+const cachedInventoryYAML = `all:
+  vars:
+    ratio: 1.0
+    big: 18446744073709551615
+    nothing: null
+  children:
+    web:
+      hosts:
+        192.0.2.20:
+          ansible_port: 2222
+          weights: [1, 2.5, "3"]
+          nested: {list: [], map: {}, flag: true}
+        192.0.2.10:
+      children:
+        web_eu:
+          hosts:
+            192.0.2.30:
+    empty:
+`
+
+func writeCachedInventory(fs afero.Fs) {
+	afero.WriteFile(fs, "/inv/hosts.yml", []byte(cachedInventoryYAML), 0644)
+	afero.WriteFile(fs, "/inv/extra.ini", []byte("[db]\nlocalhost ansible_connection=local threshold=0.5\n"), 0644)
+}
+
+// assertSameInventory compares everything the cache is meant to keep
+func assertSameInventory(t *testing.T, want, got *Inventory) {
+	t.Helper()
+	if !reflect.DeepEqual(want.Hosts, got.Hosts) {
+		t.Errorf("Hosts differ:\nwant %#v\ngot  %#v", want.Hosts, got.Hosts)
+	}
+	if !reflect.DeepEqual(want.Groups, got.Groups) {
+		t.Errorf("Groups differ:\nwant %#v\ngot  %#v", want.Groups, got.Groups)
+	}
+	if !reflect.DeepEqual(want.orderedHostNames(), got.orderedHostNames()) {
+		t.Errorf("Host order differs: %v != %v", want.orderedHostNames(), got.orderedHostNames())
+	}
+	if !reflect.DeepEqual(want.orderedGroupNames(), got.orderedGroupNames()) {
+		t.Errorf("Group order differs: %v != %v", want.orderedGroupNames(), got.orderedGroupNames())
+	}
+	if got.AllGroup != got.Groups["all"] || got.UngroupedGroup != got.Groups["ungrouped"] {
+		t.Error("Expected all and ungrouped to point at the restored groups")
+	}
+}
+
+func TestInventoryCache_RoundTrip(t *testing.T) {
+	for _, plugin := range []cache.CachePlugin{
+		cache.NewMemoryCachePlugin(),
+		cache.NewJsonFileCachePlugin(t.TempDir()),
+	} {
+		t.Run(plugin.Name(), func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			writeCachedInventory(fs)
+
+			loaded := NewManager(fs)
+			loaded.SetCache(plugin, "test_", 0)
+			if err := loaded.LoadSources("/inv/hosts.yml", "/inv/extra.ini", "192.0.2.40:2200,"); err != nil {
+				t.Fatalf("Failed to load inventory: %v", err)
+			}
+
+			// The files no longer parse; only the cache can supply them now
+			afero.WriteFile(fs, "/inv/hosts.yml", []byte("all: [unclosed\n"), 0644)
+			afero.WriteFile(fs, "/inv/extra.ini", []byte("[db\n"), 0644)
+			cached := NewManager(fs)
+			cached.SetCache(plugin, "test_", 0)
+			if err := cached.LoadSources("/inv/hosts.yml", "/inv/extra.ini", "192.0.2.40:2200,"); err != nil {
+				t.Fatalf("Failed to load the cached inventory: %v", err)
+			}
+			assertSameInventory(t, loaded.GetInventory(), cached.GetInventory())
+
+			vars := cached.GetInventory().GetHostVars("192.0.2.20")
+			if vars["ratio"] != 1.0 || vars["ansible_port"] != 2222 || vars["big"] != uint64(18446744073709551615) {
+				t.Errorf("Expected number types to survive the cache, got %#v", vars)
+			}
+		})
+	}
+}
+
+func TestInventoryCache_PerSource(t *testing.T) {
+	dir := t.TempDir()
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/infra/terraform.tfstate", []byte(terraformStateFixture), 0644)
+	afero.WriteFile(fs, "/inv/a_hosts", []byte("[web]\n192.0.2.10\n"), 0644)
+	afero.WriteFile(fs, "/inv/b_terraform.yml", []byte(`plugin: terraform_state
+state_files: [/infra/terraform.tfstate]
+cache: true
+cache_plugin: jsonfile
+cache_connection: `+dir+`
+cache_prefix: tf_
+cache_timeout: 3600
+`), 0644)
+
+	load := func() *Inventory {
+		t.Helper()
+		manager := NewManager(fs)
+		if err := manager.LoadFromFile("/inv"); err != nil {
+			t.Fatalf("Failed to load inventory: %v", err)
+		}
+		return manager.GetInventory()
+	}
+
+	load()
+	afero.WriteFile(fs, "/infra/terraform.tfstate", []byte(`{"version": 4, "resources": []}`), 0644)
+	afero.WriteFile(fs, "/inv/a_hosts", []byte("[web]\n192.0.2.10\n192.0.2.11\n"), 0644)
+
+	// Only the source that opted in is cached, and its cached hosts join
+	// those of the sources loaded before it
+	inv := load()
+	expected := []string{"192.0.2.10", "192.0.2.11", "web-1", "i-02", "db-1"}
+	if hosts := inv.ListHosts(); !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("Expected hosts %v, got %v", expected, hosts)
+	}
+	if members := groupMembers(inv, "web"); !reflect.DeepEqual(members, []string{"192.0.2.10", "192.0.2.11"}) {
+		t.Errorf("Expected the uncached source's group, got %v", members)
+	}
+	if members := groupMembers(inv, "tag_role_db"); !reflect.DeepEqual(members, []string{"db-1"}) {
+		t.Errorf("Expected the cached source's group, got %v", members)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 1 || !strings.HasPrefix(filepath.Base(files[0]), "tf_") {
+		t.Errorf("Expected one cache file for the opted-in source, got %v", files)
+	}
+
+	// cache: false in a configuration file overrides the defaults
+	afero.WriteFile(fs, "/inv/b_terraform.yml", []byte("plugin: terraform_state\nstate_files: [/infra/terraform.tfstate]\ncache: false\n"), 0644)
+	manager := NewManager(fs)
+	manager.SetCache(cache.NewMemoryCachePlugin(), "test_", 0)
+	if err := manager.LoadFromFile("/inv/b_terraform.yml"); err != nil {
+		t.Fatalf("Failed to load inventory: %v", err)
+	}
+	if hosts := manager.GetInventory().ListHosts(); len(hosts) != 0 {
+		t.Errorf("Expected the source to be loaded without the cache, got %v", hosts)
+	}
+}
+
+func TestInventoryCache_DefaultConnection(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	cacheDir := filepath.Join(home, ".ansible", "tmp", "inventory_cache")
+
+	configManager := config.NewManager(afero.NewMemMapFs())
+	if err := configManager.LoadConfig(); err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+	if connection := configManager.GetConfig().InventoryCacheConnection; connection != cacheDir {
+		t.Errorf("Expected the cache connection to default to %s, got %s", cacheDir, connection)
+	}
+
+	// A source naming jsonfile without a connection uses the same directory
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/infra/terraform.tfstate", []byte(terraformStateFixture), 0644)
+	afero.WriteFile(fs, "/inv/terraform.yml", []byte("plugin: terraform_state\nstate_files: [/infra/terraform.tfstate]\ncache: true\ncache_plugin: jsonfile\n"), 0644)
+	manager := NewManager(fs)
+	if err := manager.LoadFromFile("/inv/terraform.yml"); err != nil {
+		t.Fatalf("Failed to load inventory: %v", err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(cacheDir, "*.json")); len(files) != 1 {
+		t.Errorf("Expected one cache file in %s, got %v", cacheDir, files)
+	}
+}
+
+func TestInventoryCache_FlushAndTimeout(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/inv/hosts.ini", []byte("[web]\n192.0.2.10\n"), 0644)
+	plugin := cache.NewMemoryCachePlugin()
+
+	load := func(flush bool) []string {
+		t.Helper()
+		manager := NewManager(fs)
+		manager.SetCache(plugin, "test_", time.Hour)
+		manager.SetFlushCache(flush)
+		if err := manager.LoadFromFile("/inv/hosts.ini"); err != nil {
+			t.Fatalf("Failed to load inventory: %v", err)
+		}
+		return manager.GetInventory().ListHosts()
+	}
+
+	load(false)
+	afero.WriteFile(fs, "/inv/hosts.ini", []byte("[web]\n192.0.2.10\n192.0.2.11\n"), 0644)
+	if hosts := load(false); len(hosts) != 1 {
+		t.Errorf("Expected the cached inventory, got %v", hosts)
+	}
+	if hosts := load(true); len(hosts) != 2 {
+		t.Errorf("Expected flushing to reload the sources, got %v", hosts)
+	}
+	if hosts := load(false); len(hosts) != 2 {
+		t.Errorf("Expected the flushed load to refresh the cache, got %v", hosts)
+	}
+
+	keys, _ := plugin.Keys()
+	if len(keys) != 1 || !strings.HasPrefix(keys[0], "test_") {
+		t.Errorf("Expected one prefixed cache entry, got %v", keys)
+	}
+
+	// Expired entries are not used
+	manager := NewManager(fs)
+	manager.SetCache(plugin, "short_", time.Nanosecond)
+	manager.LoadFromFile("/inv/hosts.ini")
+	afero.WriteFile(fs, "/inv/hosts.ini", []byte("[web]\n192.0.2.12\n"), 0644)
+	manager = NewManager(fs)
+	manager.SetCache(plugin, "short_", time.Nanosecond)
+	manager.LoadFromFile("/inv/hosts.ini")
+	if hosts := manager.GetInventory().ListHosts(); !reflect.DeepEqual(hosts, []string{"192.0.2.12"}) {
+		t.Errorf("Expected an expired entry to be reloaded, got %v", hosts)
+	}
+}
+
+func TestInventoryCache_Config(t *testing.T) {
+	dir := t.TempDir()
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/inv/hosts.ini", []byte("[web]\n192.0.2.10\n"), 0644)
+
+	manager := NewManager(fs)
+	manager.SetConfig(&config.Config{
+		InventoryCache:           true,
+		InventoryCachePlugin:     "jsonfile",
+		InventoryCacheConnection: dir,
+		InventoryCachePrefix:     "inv_",
+		InventoryCacheTimeout:    time.Hour,
+	})
+	if err := manager.LoadFromFile("/inv/hosts.ini"); err != nil {
+		t.Fatalf("Failed to load inventory: %v", err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "inv_*.json"))
+	if len(files) != 1 {
+		t.Errorf("Expected one cache file, got %v", files)
+	}
+	if data, _ := os.ReadFile(files[0]); !strings.Contains(string(data), "192.0.2.10") {
+		t.Errorf("Expected the inventory in the cache file, got %s", data)
+	}
+
+	// The configuration's settings are the defaults of every source
+	manager = NewManager(fs)
+	manager.SetConfig(&config.Config{InventoryCache: true, InventoryCachePlugin: "redis"})
+	if err := manager.LoadFromFile("/inv/hosts.ini"); err == nil || !strings.Contains(err.Error(), "cache plugin 'redis' not found") {
+		t.Errorf("Expected an unknown cache plugin to fail the load, got: %v", err)
+	}
+}
@@ -0,0 +1,374 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/afero"
+	"github.com/work-obs/ansible-go/pkg/template"
+	"gopkg.in/yaml.v3"
+)
+
+// invalidGroupChars matches the characters replaced in constructed group
+// names
+var invalidGroupChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// constructedConfig is the configuration file of the constructed plugin
+type constructedConfig struct {
+	Plugin           string       `yaml:"plugin"`
+	Strict           bool         `yaml:"strict"`
+	Compose          yaml.Node    `yaml:"compose"`
+	Groups           yaml.Node    `yaml:"groups"`
+	KeyedGroups      []keyedGroup `yaml:"keyed_groups"`
+	LeadingSeparator *bool        `yaml:"leading_separator"`
+	compose          []constructedRule
+	groups           []constructedRule
+}
+
+// keyedGroup creates groups named after the value of Key
+type keyedGroup struct {
+	Key               string  `yaml:"key"`
+	Prefix            string  `yaml:"prefix"`
+	Separator         *string `yaml:"separator"`
+	DefaultValue      *string `yaml:"default_value"`
+	TrailingSeparator *bool   `yaml:"trailing_separator"`
+	ParentGroup       string  `yaml:"parent_group"`
+}
+
+// constructedRule is a compose variable or a conditional group, kept in
+// the order of the configuration file
+type constructedRule struct {
+	name       string
+	expression string
+}
+
+// autoPlugin loads YAML plugin configuration files, handing them to the
+// plugin their plugin key names
+type autoPlugin struct{}
+
+func (p *autoPlugin) Name() string { return "auto" }
+
+func (p *autoPlugin) VerifyFile(m *Manager, source string) bool {
+	ext := strings.ToLower(filepath.Ext(source))
+	return m.isFile(source) && (ext == ".yml" || ext == ".yaml")
+}
+
+func (p *autoPlugin) Parse(m *Manager, source string) error {
+	name, err := pluginConfigName(m, source)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return fmt.Errorf("no root 'plugin' key found, '%s' is not a valid YAML inventory plugin config file", source)
+	}
+
+	plugin, exists := m.plugins[strings.TrimPrefix(name, "ansible.builtin.")]
+	if !exists || plugin == Plugin(p) {
+		return fmt.Errorf("inventory config '%s' specifies unknown plugin '%s'", source, name)
+	}
+	if !plugin.VerifyFile(m, source) {
+		return fmt.Errorf("inventory source '%s' could not be verified by inventory plugin '%s'", source, name)
+	}
+	return plugin.Parse(m, source)
+}
+
+// pluginConfigName returns the plugin key of a YAML configuration file
+func pluginConfigName(m *Manager, source string) (string, error) {
+	data, err := afero.ReadFile(m.fs, source)
+	if err != nil {
+		return "", fmt.Errorf("failed to read inventory file %s: %w", source, err)
+	}
+
+	var header struct {
+		Plugin string `yaml:"plugin"`
+	}
+	if err := yaml.Unmarshal(data, &header); err != nil {
+		return "", fmt.Errorf("failed to parse %s: %w", source, err)
+	}
+	return header.Plugin, nil
+}
+
+// constructedPlugin builds variables and groups from the variables of the
+// hosts already in the inventory. It runs once every other source is
+// loaded.
+type constructedPlugin struct {
+	engine *template.Engine
+}
+
+func (p *constructedPlugin) Name() string { return "constructed" }
+
+func (p *constructedPlugin) VerifyFile(m *Manager, source string) bool {
+	switch strings.ToLower(filepath.Ext(source)) {
+	case "", ".config", ".yaml", ".yml", ".json":
+		return m.isFile(source)
+	}
+	return false
+}
+
+func (p *constructedPlugin) Parse(m *Manager, source string) error {
+	data, err := afero.ReadFile(m.fs, source)
+	if err != nil {
+		return fmt.Errorf("failed to read inventory file %s: %w", source, err)
+	}
+
+	config, err := parseConstructedConfig(data)
+	if err != nil {
+		return fmt.Errorf("invalid constructed configuration %s: %w", source, err)
+	}
+	if name := strings.TrimPrefix(config.Plugin, "ansible.builtin."); name != p.Name() {
+		return fmt.Errorf("incorrect plugin name in %s: expected constructed, got '%s'", source, config.Plugin)
+	}
+
+	m.pending = append(m.pending, func() error {
+		if err := p.construct(m.inventory, config); err != nil {
+			return fmt.Errorf("failed to construct inventory from %s: %w", source, err)
+		}
+		return nil
+	})
+	return nil
+}
+
+// parseConstructedConfig parses and checks a constructed configuration
+func parseConstructedConfig(data []byte) (*constructedConfig, error) {
+	config := &constructedConfig{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if config.compose, err = orderedRules(&config.Compose, "compose"); err != nil {
+		return nil, err
+	}
+	if config.groups, err = orderedRules(&config.Groups, "groups"); err != nil {
+		return nil, err
+	}
+	for _, keyed := range config.KeyedGroups {
+		if keyed.Key == "" {
+			return nil, fmt.Errorf("keyed_groups entries require a key")
+		}
+		if keyed.DefaultValue != nil && keyed.TrailingSeparator != nil {
+			return nil, fmt.Errorf("parameters are mutually exclusive for keyed groups: default_value|trailing_separator")
+		}
+	}
+	return config, nil
+}
+
+// orderedRules reads a mapping of names to expressions in file order
+func orderedRules(node *yaml.Node, option string) ([]constructedRule, error) {
+	if node.Kind == 0 {
+		return nil, nil
+	}
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s must be a mapping of names to expressions", option)
+	}
+
+	rules := make([]constructedRule, 0, len(node.Content)/2)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if value.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("%s.%s must be an expression", option, key.Value)
+		}
+		rules = append(rules, constructedRule{name: key.Value, expression: value.Value})
+	}
+	return rules, nil
+}
+
+// construct applies a constructed configuration to every host of inv:
+// compose sets variables, groups adds hosts whose condition holds and
+// keyed_groups adds hosts to groups named after a variable. Expressions
+// that fail are skipped unless the configuration is strict.
+func (p *constructedPlugin) construct(inv *Inventory, config *constructedConfig) error {
+	if p.engine == nil {
+		p.engine = template.NewEngine()
+	}
+
+	for _, hostName := range inv.orderedHostNames() {
+		host := inv.Hosts[hostName]
+
+		variables := constructedVariables(inv, host)
+		for _, rule := range config.compose {
+			value, err := p.engine.RenderNative("{{ "+rule.expression+" }}", &template.Context{Variables: variables})
+			if err != nil {
+				if config.Strict {
+					return fmt.Errorf("could not set %s for host %s: %v", rule.name, hostName, err)
+				}
+				continue
+			}
+			setHostVariables(host, map[string]interface{}{rule.name: value})
+		}
+
+		// Groups see the variables compose just set
+		variables = constructedVariables(inv, host)
+		ctx := &template.Context{Variables: variables}
+
+		for _, rule := range config.groups {
+			member, err := p.engine.RenderBool("{% if "+rule.expression+" %}True{% else %}False{% endif %}", ctx)
+			if err != nil {
+				if config.Strict {
+					return fmt.Errorf("could not add host %s to group %s: %v", hostName, rule.name, err)
+				}
+				continue
+			}
+			if member {
+				inv.GetOrCreateGroup(sanitizeGroupName(rule.name)).AddHost(hostName)
+			}
+		}
+
+		for _, keyed := range config.KeyedGroups {
+			if err := p.addKeyedGroups(inv, config, keyed, hostName, ctx); err != nil {
+				return err
+			}
+		}
+	}
+
+	inv.UpdateAllGroup()
+	return nil
+}
+
+// addKeyedGroups adds a host to the groups named after the value of a
+// keyed group's key: one group for a string, one per item of a list and
+// one per key/value pair of a dict
+func (p *constructedPlugin) addKeyedGroups(inv *Inventory, config *constructedConfig, keyed keyedGroup, hostName string, ctx *template.Context) error {
+	key, err := p.engine.RenderNative("{{ "+keyed.Key+" }}", ctx)
+	if err != nil {
+		if config.Strict {
+			return fmt.Errorf("could not generate group for host %s from %s entry: %v", hostName, keyed.Key, err)
+		}
+		return nil
+	}
+
+	separator := "_"
+	if keyed.Separator != nil {
+		separator = *keyed.Separator
+	}
+
+	nameOf := func(value interface{}) string {
+		name := fmt.Sprintf("%v", value)
+		if name == "" && keyed.DefaultValue != nil {
+			return *keyed.DefaultValue
+		}
+		return name
+	}
+
+	var names []string
+	switch value := key.(type) {
+	case string:
+		if value != "" || keyed.DefaultValue != nil {
+			names = append(names, nameOf(value))
+		}
+	case []interface{}:
+		for _, item := range value {
+			names = append(names, nameOf(item))
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for name := range value {
+			keys = append(keys, name)
+		}
+		sort.Strings(keys)
+		for _, name := range keys {
+			item := fmt.Sprintf("%v", value[name])
+			switch {
+			case item != "":
+				names = append(names, name+separator+item)
+			case keyed.DefaultValue != nil:
+				names = append(names, name+separator+*keyed.DefaultValue)
+			case keyed.TrailingSeparator != nil && !*keyed.TrailingSeparator:
+				names = append(names, name)
+			default:
+				names = append(names, name+separator)
+			}
+		}
+	case nil, bool:
+		// None and booleans name no group; true is as unusable as false
+	case int, int64, float64:
+		if fmt.Sprintf("%v", value) != "0" {
+			names = append(names, nameOf(value))
+		}
+	default:
+		return fmt.Errorf("invalid group name format for %s, expected a string, a list or a dict, got %T", keyed.Key, key)
+	}
+
+	if len(names) == 0 {
+		// Empty lists and dicts are valid keys that simply name no group
+		_, isList := key.([]interface{})
+		_, isDict := key.(map[string]interface{})
+		if config.Strict && !isList && !isDict {
+			return fmt.Errorf("no key or key resulted empty for %s in host %s, invalid entry", keyed.Key, hostName)
+		}
+		return nil
+	}
+
+	var parent string
+	if keyed.ParentGroup != "" {
+		rendered, err := p.engine.Render(keyed.ParentGroup, ctx)
+		if err != nil {
+			if config.Strict {
+				return fmt.Errorf("could not render parent group %s for host %s: %v", keyed.ParentGroup, hostName, err)
+			}
+			return nil
+		}
+		parent = sanitizeGroupName(rendered)
+	}
+
+	prefixSeparator := separator
+	if keyed.Prefix == "" && config.LeadingSeparator != nil && !*config.LeadingSeparator {
+		prefixSeparator = ""
+	}
+	for _, name := range names {
+		groupName := sanitizeGroupName(keyed.Prefix + prefixSeparator + name)
+		inv.GetOrCreateGroup(groupName).AddHost(hostName)
+		if parent != "" {
+			inv.GetOrCreateGroup(parent).AddChild(groupName)
+		}
+	}
+	return nil
+}
+
+// constructedVariables returns the variables expressions see for a host:
+// its group and host variables and the inventory magic variables
+func constructedVariables(inv *Inventory, host *Host) map[string]interface{} {
+	variables := inv.GetHostVars(host.Name)
+	variables["inventory_hostname"] = host.Name
+	variables["inventory_hostname_short"] = strings.SplitN(host.Name, ".", 2)[0]
+
+	groupNames := make([]string, 0)
+	for _, groupName := range inv.orderedGroupNames() {
+		if groupName == "all" || groupName == "ungrouped" {
+			continue
+		}
+		for _, member := range inv.Groups[groupName].Hosts {
+			if member == host.Name {
+				groupNames = append(groupNames, groupName)
+				break
+			}
+		}
+	}
+	sort.Strings(groupNames)
+	variables["group_names"] = groupNames
+	return variables
+}
+
+// sanitizeGroupName replaces characters that are not valid in group names
+func sanitizeGroupName(name string) string {
+	return invalidGroupChars.ReplaceAllString(name, "_")
+}
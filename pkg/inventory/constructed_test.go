@@ -0,0 +1,169 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+const constructedHosts = `
+servers:
+  hosts:
+    web1:
+      os_family: RedHat
+      private_ip: 10.0.0.1
+      tags: {env: prod, role: web}
+      roles: [web, cache]
+    web2:
+      os_family: Debian
+      private_ip: 10.0.0.2
+      tags: {env: ""}
+    db1:
+      os_family: RedHat
+      tags: {env: staging}
+`
+
+func loadConstructed(t *testing.T, config string) (*Manager, error) {
+	t.Helper()
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/inv/hosts.yml", []byte(constructedHosts), 0644)
+	// Sorted before hosts.yml, but constructed runs once every source is in
+	afero.WriteFile(fs, "/inv/constructed.yml", []byte(config), 0644)
+
+	manager := NewManager(fs)
+	return manager, manager.LoadFromFile("/inv")
+}
+
+func groupMembers(inv *Inventory, name string) []string {
+	group, exists := inv.Groups[name]
+	if !exists {
+		return nil
+	}
+	hosts := append([]string{}, group.Hosts...)
+	sort.Strings(hosts)
+	return hosts
+}
+
+func TestConstructedPlugin(t *testing.T) {
+	manager, err := loadConstructed(t, `
+plugin: ansible.builtin.constructed
+compose:
+  ansible_host: private_ip
+  env: tags.env | default('none')
+groups:
+  redhat: os_family == 'RedHat'
+  has_ip: private_ip is defined
+  broken: undefined_var.attr == 1
+keyed_groups:
+  - key: os_family
+    prefix: os
+  - key: tags.env
+    prefix: env
+    default_value: unknown
+    parent_group: environments
+  - key: roles | default([])
+    prefix: role
+  - key: tags
+    separator: "-"
+    trailing_separator: false
+`)
+	if err != nil {
+		t.Fatalf("LoadFromFile() error = %v", err)
+	}
+	inv := manager.GetInventory()
+
+	web1 := inv.Hosts["web1"]
+	if web1.Address != "10.0.0.1" || web1.Variables["env"] != "prod" {
+		t.Errorf("web1 = %+v", web1)
+	}
+	if _, exists := inv.Hosts["db1"].Variables["ansible_host"]; exists {
+		t.Error("compose must skip expressions that fail when not strict")
+	}
+
+	expected := map[string][]string{
+		"redhat":           {"db1", "web1"},
+		"has_ip":           {"web1", "web2"},
+		"os_RedHat":        {"db1", "web1"},
+		"os_Debian":        {"web2"},
+		"env_prod":         {"web1"},
+		"env_staging":      {"db1"},
+		"env_unknown":      {"web2"},
+		"role_web":         {"web1"},
+		"role_cache":       {"web1"},
+		"_env_prod":        {"web1"},
+		"_env":             {"web2"},
+		"_role_web":        {"web1"},
+		"broken":           nil,
+		"os_family_RedHat": nil,
+	}
+	for group, hosts := range expected {
+		if got := groupMembers(inv, group); !reflect.DeepEqual(got, hosts) {
+			t.Errorf("group %s = %v, want %v", group, got, hosts)
+		}
+	}
+
+	environments := inv.Groups["environments"]
+	if environments == nil {
+		t.Fatal("expected the environments parent group")
+	}
+	children := append([]string{}, environments.Children...)
+	sort.Strings(children)
+	if !reflect.DeepEqual(children, []string{"env_prod", "env_staging", "env_unknown"}) {
+		t.Errorf("environments children = %v", children)
+	}
+
+	hosts, _ := manager.GetHosts("environments:&os_RedHat")
+	names := hostNames(hosts)
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"db1", "web1"}) {
+		t.Errorf("environments:&os_RedHat = %v", names)
+	}
+}
+
+func TestConstructedPlugin_Strict(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		errMsg string
+	}{
+		{"compose", "compose:\n  ip: private_ip.split('.')\n", "could not set ip for host db1"},
+		{"groups", "groups:\n  broken: undefined_var.attr == 1\n", "to group broken"},
+		{"keyed groups", "keyed_groups:\n  - key: private_ip\n", "from private_ip entry"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := loadConstructed(t, "plugin: constructed\nstrict: true\n"+test.config)
+			if err == nil || !strings.Contains(err.Error(), test.errMsg) {
+				t.Errorf("expected error containing '%s', got %v", test.errMsg, err)
+			}
+		})
+	}
+
+	_, err := loadConstructed(t, "plugin: constructed\nkeyed_groups:\n  - key: os_family\n    default_value: x\n    trailing_separator: true\n")
+	if err == nil || !strings.Contains(err.Error(), "mutually exclusive") {
+		t.Errorf("expected a mutually exclusive options error, got %v", err)
+	}
+	_, err = loadConstructed(t, "plugin: nonesuch\n")
+	if err == nil || !strings.Contains(err.Error(), "unknown plugin 'nonesuch'") {
+		t.Errorf("expected an unknown plugin error, got %v", err)
+	}
+}
@@ -0,0 +1,348 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The dump functions below produce what ansible-inventory prints. Unless
+// export is set, host variables are flattened: each host carries the merged
+// variables of its groups and group variables are left out. With export,
+// hosts carry only their own variables and groups keep theirs. Only hosts
+// within the manager's subset are included.
+
+// ListInventory returns the inventory in the JSON format dynamic inventory
+// scripts print for --list: a group's hosts and children by name and the
+// host variables under _meta.hostvars
+func (m *Manager) ListInventory(export bool) (map[string]interface{}, error) {
+	available, err := m.availableHosts()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]interface{})
+	seen := make(map[string]bool)
+
+	var format func(group *Group)
+	format = func(group *Group) {
+		entry := make(map[string]interface{})
+		if group != m.inventory.AllGroup {
+			if hosts := filterHosts(group.Hosts, available); len(hosts) > 0 {
+				entry["hosts"] = hosts
+			}
+		}
+
+		children := make([]string, 0)
+		for _, childName := range m.inventory.childGroupNames(group) {
+			children = append(children, childName)
+			if !seen[childName] {
+				seen[childName] = true
+				format(m.inventory.Groups[childName])
+			}
+		}
+		if len(children) > 0 {
+			entry["children"] = children
+		}
+
+		if export && len(group.Variables) > 0 {
+			entry["vars"] = copyVariables(group.Variables)
+		}
+		if len(entry) > 0 {
+			result[group.Name] = entry
+		}
+	}
+	seen["all"] = true
+	format(m.inventory.AllGroup)
+
+	hostvars := make(map[string]interface{})
+	for _, hostName := range m.inventory.orderedHostNames() {
+		if !available[hostName] {
+			continue
+		}
+		if vars := m.hostVariables(hostName, export); len(vars) > 0 {
+			hostvars[hostName] = vars
+		}
+	}
+	result["_meta"] = map[string]interface{}{"hostvars": hostvars}
+
+	return result, nil
+}
+
+// YAMLInventory returns the inventory in the YAML inventory format: all at
+// the top with groups nested under children. A host's variables are given
+// the first time it appears.
+func (m *Manager) YAMLInventory(export bool) (map[string]interface{}, error) {
+	available, err := m.availableHosts()
+	if err != nil {
+		return nil, err
+	}
+
+	seenGroups := map[string]bool{"all": true}
+	seenHosts := make(map[string]bool)
+
+	var format func(group *Group) map[string]interface{}
+	format = func(group *Group) map[string]interface{} {
+		entry := make(map[string]interface{})
+
+		children := make(map[string]interface{})
+		for _, childName := range m.inventory.childGroupNames(group) {
+			if seenGroups[childName] {
+				children[childName] = map[string]interface{}{}
+				continue
+			}
+			seenGroups[childName] = true
+			children[childName] = format(m.inventory.Groups[childName])
+		}
+		if len(children) > 0 {
+			entry["children"] = children
+		}
+
+		if group != m.inventory.AllGroup {
+			hosts := make(map[string]interface{})
+			for _, hostName := range filterHosts(group.Hosts, available) {
+				hostVars := make(map[string]interface{})
+				if !seenHosts[hostName] {
+					seenHosts[hostName] = true
+					hostVars = m.hostVariables(hostName, export)
+				}
+				hosts[hostName] = hostVars
+			}
+			if len(hosts) > 0 {
+				entry["hosts"] = hosts
+			}
+		}
+
+		if export && len(group.Variables) > 0 {
+			entry["vars"] = copyVariables(group.Variables)
+		}
+		return entry
+	}
+
+	return map[string]interface{}{"all": format(m.inventory.AllGroup)}, nil
+}
+
+// TOMLInventory returns the inventory in the TOML inventory format: one
+// table per group holding its children, hosts and variables
+func (m *Manager) TOMLInventory(export bool) (map[string]interface{}, error) {
+	available, err := m.availableHosts()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]interface{})
+	seenHosts := make(map[string]bool)
+
+	var format func(group *Group)
+	format = func(group *Group) {
+		if _, done := result[group.Name]; done {
+			return
+		}
+		entry := make(map[string]interface{})
+		result[group.Name] = entry
+
+		children := make([]string, 0)
+		for _, childName := range m.inventory.childGroupNames(group) {
+			child := m.inventory.Groups[childName]
+			if child == m.inventory.UngroupedGroup && len(filterHosts(child.Hosts, available)) == 0 {
+				continue
+			}
+			children = append(children, childName)
+			format(child)
+		}
+
+		if group != m.inventory.AllGroup {
+			if len(children) > 0 {
+				entry["children"] = children
+			}
+			hosts := make(map[string]interface{})
+			for _, hostName := range filterHosts(group.Hosts, available) {
+				hostVars := make(map[string]interface{})
+				if !seenHosts[hostName] {
+					seenHosts[hostName] = true
+					hostVars = m.hostVariables(hostName, export)
+				}
+				hosts[hostName] = hostVars
+			}
+			if len(hosts) > 0 {
+				entry["hosts"] = hosts
+			}
+		}
+
+		if export && len(group.Variables) > 0 {
+			entry["vars"] = copyVariables(group.Variables)
+		}
+	}
+	format(m.inventory.AllGroup)
+
+	for name, entry := range result {
+		if len(entry.(map[string]interface{})) == 0 {
+			delete(result, name)
+		}
+	}
+	return result, nil
+}
+
+// HostInventory returns the variables of a host, as --host prints them
+func (m *Manager) HostInventory(hostName string, export bool) (map[string]interface{}, error) {
+	if _, exists := m.inventory.Hosts[hostName]; !exists {
+		return nil, fmt.Errorf("you must pass a single valid host to --host parameter: %s", hostName)
+	}
+	return m.hostVariables(hostName, export), nil
+}
+
+// GraphInventory draws the tree of a group's children and hosts, as
+// --graph does. With showVars, host and group variables are drawn too.
+func (m *Manager) GraphInventory(groupName string, showVars, export bool) (string, error) {
+	if groupName == "" {
+		groupName = "all"
+	}
+	top, exists := m.inventory.Groups[groupName]
+	if !exists {
+		return "", fmt.Errorf("pattern must be a valid group name when using --graph: %s", groupName)
+	}
+
+	available, err := m.availableHosts()
+	if err != nil {
+		return "", err
+	}
+
+	var lines []string
+	graphName := func(name string, depth int) {
+		if depth > 0 {
+			name = strings.Repeat("  |", depth) + "--" + name
+		}
+		lines = append(lines, name)
+	}
+	graphVars := func(vars map[string]interface{}, depth int) {
+		names := make([]string, 0, len(vars))
+		for name := range vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			graphName(fmt.Sprintf("{%s = %v}", name, vars[name]), depth)
+		}
+	}
+
+	var graph func(group *Group, depth int, path map[string]bool)
+	graph = func(group *Group, depth int, path map[string]bool) {
+		graphName("@"+group.Name+":", depth)
+		path[group.Name] = true
+		defer delete(path, group.Name)
+
+		children := append([]string{}, m.inventory.childGroupNames(group)...)
+		sort.Strings(children)
+		for _, childName := range children {
+			// A cycle would draw forever
+			if !path[childName] {
+				graph(m.inventory.Groups[childName], depth+1, path)
+			}
+		}
+
+		if group != m.inventory.AllGroup {
+			hosts := filterHosts(group.Hosts, available)
+			sort.Strings(hosts)
+			for _, hostName := range hosts {
+				graphName(hostName, depth+1)
+				if showVars {
+					graphVars(m.hostVariables(hostName, export), depth+2)
+				}
+			}
+		}
+		if showVars {
+			graphVars(group.Variables, depth+1)
+		}
+	}
+	graph(top, 0, make(map[string]bool))
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// availableHosts returns the set of hosts within the subset
+func (m *Manager) availableHosts() (map[string]bool, error) {
+	hosts, err := m.GetHosts("all")
+	if err != nil {
+		return nil, err
+	}
+	available := make(map[string]bool, len(hosts))
+	for _, host := range hosts {
+		available[host.Name] = true
+	}
+	return available, nil
+}
+
+// hostVariables returns the variables dumped for a host
+func (m *Manager) hostVariables(hostName string, export bool) map[string]interface{} {
+	if export {
+		return copyVariables(m.inventory.Hosts[hostName].Variables)
+	}
+	return m.inventory.GetHostVars(hostName)
+}
+
+// childGroupNames returns the child groups of a group. The children of all
+// are the groups no other group claims, ungrouped among them.
+func (inv *Inventory) childGroupNames(group *Group) []string {
+	if group != inv.AllGroup {
+		var children []string
+		for _, childName := range group.Children {
+			if _, exists := inv.Groups[childName]; exists {
+				children = append(children, childName)
+			}
+		}
+		return children
+	}
+
+	claimed := make(map[string]bool)
+	for _, other := range inv.Groups {
+		if other == inv.AllGroup {
+			continue
+		}
+		for _, childName := range other.Children {
+			claimed[childName] = true
+		}
+	}
+
+	var children []string
+	for _, groupName := range inv.orderedGroupNames() {
+		if groupName != "all" && (!claimed[groupName] || containsString(group.Children, groupName)) {
+			children = append(children, groupName)
+		}
+	}
+	return children
+}
+
+// filterHosts returns the hosts that are available, in order
+func filterHosts(hostNames []string, available map[string]bool) []string {
+	hosts := make([]string, 0, len(hostNames))
+	for _, hostName := range hostNames {
+		if available[hostName] {
+			hosts = append(hosts, hostName)
+		}
+	}
+	return hosts
+}
+
+func copyVariables(vars map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(vars))
+	for k, v := range vars {
+		result[k] = v
+	}
+	return result
+}
@@ -0,0 +1,230 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// loadDumpInventory loads an INI inventory with group_vars and host_vars
+func loadDumpInventory(t *testing.T) *Manager {
+	t.Helper()
+
+	fs := afero.NewMemMapFs()
+	files := map[string]string{
+		"/inv/hosts": `192.0.2.1 a=1
+[web]
+192.0.2.10 http_port=8080
+[db]
+192.0.2.20
+[prod:children]
+web
+db
+[prod:vars]
+env=prod
+`,
+		"/inv/group_vars/all.yml":          "region: eu\n",
+		"/inv/group_vars/web/main.yml":     "role: frontend\nenv: web\n",
+		"/inv/group_vars/web/.hidden.yml":  "hidden: true\n",
+		"/inv/group_vars/db.json":          `{"role": "database"}`,
+		"/inv/host_vars/192.0.2.20.yaml":   "special: true\n",
+		"/inv/host_vars/192.0.2.10/a.yml":  "order: a\n",
+		"/inv/host_vars/192.0.2.10/b.yaml": "order: b\n",
+	}
+	for path, content := range files {
+		if err := afero.WriteFile(fs, path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+
+	manager := NewManager(fs)
+	if err := manager.LoadFromFile("/inv/hosts"); err != nil {
+		t.Fatalf("Failed to load inventory: %v", err)
+	}
+	return manager
+}
+
+func TestManager_LoadVarsFiles(t *testing.T) {
+	manager := loadDumpInventory(t)
+	inv := manager.GetInventory()
+
+	if inv.AllGroup.Variables["region"] != "eu" {
+		t.Errorf("Expected group_vars/all.yml to set region, got %v", inv.AllGroup.Variables)
+	}
+	if _, exists := inv.Groups["web"].Variables["hidden"]; exists {
+		t.Error("Expected hidden vars files to be skipped")
+	}
+	if inv.Groups["db"].Variables["role"] != "database" {
+		t.Errorf("Expected group_vars/db.json to set role, got %v", inv.Groups["db"].Variables)
+	}
+	if inv.Hosts["192.0.2.10"].Variables["order"] != "b" {
+		t.Errorf("Expected host_vars directory files in name order, got %v", inv.Hosts["192.0.2.10"].Variables["order"])
+	}
+
+	// Deeper groups override shallower ones and hosts override groups
+	vars := inv.GetHostVars("192.0.2.10")
+	expected := map[string]interface{}{
+		"region":    "eu",
+		"env":       "web",
+		"role":      "frontend",
+		"http_port": 8080,
+		"order":     "b",
+	}
+	if !reflect.DeepEqual(vars, expected) {
+		t.Errorf("Expected merged variables %v, got %v", expected, vars)
+	}
+}
+
+func TestManager_ListInventory(t *testing.T) {
+	manager := loadDumpInventory(t)
+
+	result, err := manager.ListInventory(false)
+	if err != nil {
+		t.Fatalf("ListInventory failed: %v", err)
+	}
+
+	if children := result["all"].(map[string]interface{})["children"]; !reflect.DeepEqual(children, []string{"ungrouped", "prod"}) {
+		t.Errorf("Expected all children [ungrouped prod], got %v", children)
+	}
+	if children := result["prod"].(map[string]interface{})["children"]; !reflect.DeepEqual(children, []string{"web", "db"}) {
+		t.Errorf("Expected prod children [web db], got %v", children)
+	}
+	if hosts := result["web"].(map[string]interface{})["hosts"]; !reflect.DeepEqual(hosts, []string{"192.0.2.10"}) {
+		t.Errorf("Expected web hosts [192.0.2.10], got %v", hosts)
+	}
+	if _, exists := result["prod"].(map[string]interface{})["vars"]; exists {
+		t.Error("Expected group vars to be flattened into hosts without export")
+	}
+
+	hostvars := result["_meta"].(map[string]interface{})["hostvars"].(map[string]interface{})
+	dbVars := hostvars["192.0.2.20"].(map[string]interface{})
+	if dbVars["env"] != "prod" || dbVars["role"] != "database" || dbVars["special"] != true {
+		t.Errorf("Unexpected flattened host vars: %v", dbVars)
+	}
+
+	exported, err := manager.ListInventory(true)
+	if err != nil {
+		t.Fatalf("ListInventory with export failed: %v", err)
+	}
+	if vars := exported["prod"].(map[string]interface{})["vars"]; !reflect.DeepEqual(vars, map[string]interface{}{"env": "prod"}) {
+		t.Errorf("Expected exported prod vars, got %v", vars)
+	}
+	exportedHostvars := exported["_meta"].(map[string]interface{})["hostvars"].(map[string]interface{})
+	if vars := exportedHostvars["192.0.2.20"]; !reflect.DeepEqual(vars, map[string]interface{}{"special": true}) {
+		t.Errorf("Expected exported host vars to be the host's own, got %v", vars)
+	}
+}
+
+func TestManager_YAMLAndTOMLInventory(t *testing.T) {
+	manager := loadDumpInventory(t)
+
+	yamlResult, err := manager.YAMLInventory(true)
+	if err != nil {
+		t.Fatalf("YAMLInventory failed: %v", err)
+	}
+	all := yamlResult["all"].(map[string]interface{})
+	prod := all["children"].(map[string]interface{})["prod"].(map[string]interface{})
+	web := prod["children"].(map[string]interface{})["web"].(map[string]interface{})
+	if _, exists := web["hosts"].(map[string]interface{})["192.0.2.10"]; !exists {
+		t.Errorf("Expected web hosts to be nested under all and prod, got %v", yamlResult)
+	}
+	if all["vars"].(map[string]interface{})["region"] != "eu" {
+		t.Errorf("Expected exported all vars, got %v", all["vars"])
+	}
+
+	tomlResult, err := manager.TOMLInventory(false)
+	if err != nil {
+		t.Fatalf("TOMLInventory failed: %v", err)
+	}
+	if _, exists := tomlResult["all"]; exists {
+		t.Error("Expected the empty all table to be omitted")
+	}
+	if children := tomlResult["prod"].(map[string]interface{})["children"]; !reflect.DeepEqual(children, []string{"web", "db"}) {
+		t.Errorf("Expected prod children [web db], got %v", children)
+	}
+}
+
+func TestManager_GraphInventory(t *testing.T) {
+	manager := loadDumpInventory(t)
+
+	graph, err := manager.GraphInventory("", false, false)
+	if err != nil {
+		t.Fatalf("GraphInventory failed: %v", err)
+	}
+	expected := strings.Join([]string{
+		"@all:",
+		"  |--@prod:",
+		"  |  |--@db:",
+		"  |  |  |--192.0.2.20",
+		"  |  |--@web:",
+		"  |  |  |--192.0.2.10",
+		"  |--@ungrouped:",
+		"  |  |--192.0.2.1",
+	}, "\n")
+	if graph != expected {
+		t.Errorf("Expected graph:\n%s\ngot:\n%s", expected, graph)
+	}
+
+	graph, err = manager.GraphInventory("db", true, true)
+	if err != nil {
+		t.Fatalf("GraphInventory with vars failed: %v", err)
+	}
+	expected = strings.Join([]string{
+		"@db:",
+		"  |--192.0.2.20",
+		"  |  |--{special = true}",
+		"  |--{role = database}",
+	}, "\n")
+	if graph != expected {
+		t.Errorf("Expected graph:\n%s\ngot:\n%s", expected, graph)
+	}
+
+	if _, err := manager.GraphInventory("missing", false, false); err == nil {
+		t.Error("Expected an error graphing a missing group")
+	}
+}
+
+func TestManager_HostInventory(t *testing.T) {
+	manager := loadDumpInventory(t)
+	if err := manager.Subset("web"); err != nil {
+		t.Fatalf("Subset failed: %v", err)
+	}
+
+	vars, err := manager.HostInventory("192.0.2.10", true)
+	if err != nil {
+		t.Fatalf("HostInventory failed: %v", err)
+	}
+	if !reflect.DeepEqual(vars, map[string]interface{}{"http_port": 8080, "order": "b"}) {
+		t.Errorf("Unexpected host variables: %v", vars)
+	}
+	if _, err := manager.HostInventory("missing", false); err == nil {
+		t.Error("Expected an error for a missing host")
+	}
+
+	// The subset limits the hosts listed
+	result, err := manager.ListInventory(false)
+	if err != nil {
+		t.Fatalf("ListInventory failed: %v", err)
+	}
+	if _, exists := result["db"]; exists {
+		t.Errorf("Expected db to be left out of the limited inventory, got %v", result["db"])
+	}
+}
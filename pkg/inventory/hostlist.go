@@ -0,0 +1,109 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// hostListPlugin parses an inline, comma separated list of hosts such as
+// "web1,web2:2222,". Each entry may carry a port; ranges are left to
+// advanced_host_list.
+type hostListPlugin struct{}
+
+func (p *hostListPlugin) Name() string { return "host_list" }
+
+func (p *hostListPlugin) VerifyFile(m *Manager, source string) bool {
+	return isHostList(m, source)
+}
+
+func (p *hostListPlugin) Parse(m *Manager, source string) error {
+	return m.parseHostList(source, false)
+}
+
+// advancedHostListPlugin parses an inline host list whose entries may be
+// ranges, such as "web[1:5],db1"
+type advancedHostListPlugin struct{}
+
+func (p *advancedHostListPlugin) Name() string { return "advanced_host_list" }
+
+func (p *advancedHostListPlugin) VerifyFile(m *Manager, source string) bool {
+	return isHostList(m, source)
+}
+
+func (p *advancedHostListPlugin) Parse(m *Manager, source string) error {
+	return m.parseHostList(source, true)
+}
+
+// isHostList reports whether source is an inline host list: it holds a
+// comma and names no existing path
+func isHostList(m *Manager, source string) bool {
+	if !strings.Contains(source, ",") {
+		return false
+	}
+	exists, _ := afero.Exists(m.fs, source)
+	return !exists
+}
+
+// parseHostList adds the hosts of an inline host list to ungrouped
+func (m *Manager) parseHostList(source string, allowRanges bool) error {
+	type entry struct {
+		names []string
+		port  int
+	}
+
+	// Parse every entry before adding any, so a failed list adds nothing
+	var entries []entry
+	for _, item := range strings.Split(source, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		var names []string
+		var port int
+		var err error
+		if allowRanges {
+			names, port, err = ExpandHostPattern(item)
+		} else {
+			var name string
+			name, port, err = splitHostPort(item)
+			if err == nil && strings.ContainsAny(name, "[]") {
+				err = fmt.Errorf("host '%s' is a range, which requires the advanced_host_list plugin", item)
+			}
+			names = []string{name}
+		}
+		if err != nil {
+			return fmt.Errorf("invalid data from string, could not parse: %v", err)
+		}
+		entries = append(entries, entry{names: names, port: port})
+	}
+
+	for _, e := range entries {
+		hostVars := make(map[string]interface{})
+		if e.port != 0 {
+			hostVars["ansible_port"] = e.port
+		}
+		m.addHosts(e.names, hostVars, "")
+	}
+
+	m.inventory.UpdateAllGroup()
+	return nil
+}
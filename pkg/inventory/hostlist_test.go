@@ -0,0 +1,96 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestManager_HostList(t *testing.T) {
+	manager := NewManager(afero.NewMemMapFs())
+	if err := manager.LoadFromFile("192.0.2.1, 192.0.2.2:2222,[2001:db8::1]:2200,2001:db8::2,"); err != nil {
+		t.Fatalf("Failed to load host list: %v", err)
+	}
+	inv := manager.GetInventory()
+
+	expected := []string{"192.0.2.1", "192.0.2.2", "2001:db8::1", "2001:db8::2"}
+	if hosts := inv.ListHosts(); !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("Expected hosts %v, got %v", expected, hosts)
+	}
+	if !reflect.DeepEqual(inv.UngroupedGroup.Hosts, expected) {
+		t.Errorf("Expected every host to be ungrouped, got %v", inv.UngroupedGroup.Hosts)
+	}
+	if inv.Hosts["192.0.2.2"].Port != 2222 || inv.Hosts["2001:db8::1"].Port != 2200 {
+		t.Error("Expected ports to be taken from the host list")
+	}
+}
+
+func TestManager_AdvancedHostList(t *testing.T) {
+	manager := NewManager(afero.NewMemMapFs())
+	if err := manager.LoadFromFile("192.0.2.[1:3]:2222,"); err != nil {
+		t.Fatalf("Failed to load host list: %v", err)
+	}
+	inv := manager.GetInventory()
+
+	expected := []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}
+	if hosts := inv.ListHosts(); !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("Expected hosts %v, got %v", expected, hosts)
+	}
+	if inv.Hosts["192.0.2.3"].Port != 2222 {
+		t.Errorf("Expected port 2222, got %d", inv.Hosts["192.0.2.3"].Port)
+	}
+
+	// Without advanced_host_list, ranges are rejected
+	manager = NewManager(afero.NewMemMapFs())
+	manager.enabledPlugins = []string{"host_list"}
+	err := manager.LoadFromFile("192.0.2.[1:3],")
+	if err == nil || !strings.Contains(err.Error(), "advanced_host_list") {
+		t.Errorf("Expected host_list to reject ranges, got: %v", err)
+	}
+}
+
+func TestManager_LoadSources(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/inv/hosts.ini", []byte("[web]\n192.0.2.10\n"), 0644)
+	afero.WriteFile(fs, "/inv/more/db.yml", []byte("db:\n  hosts:\n    192.0.2.20:\n"), 0644)
+	afero.WriteFile(fs, "/inv/groups.yml", []byte(`plugin: constructed
+groups:
+  everything: true
+`), 0644)
+
+	manager := NewManager(fs)
+	if err := manager.LoadSources("/inv/hosts.ini", "/inv/more", "192.0.2.30,", "/inv/groups.yml"); err != nil {
+		t.Fatalf("Failed to load sources: %v", err)
+	}
+	inv := manager.GetInventory()
+
+	expected := []string{"192.0.2.10", "192.0.2.20", "192.0.2.30"}
+	if hosts := inv.ListHosts(); !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("Expected hosts %v, got %v", expected, hosts)
+	}
+	if !reflect.DeepEqual(inv.Groups["everything"].Hosts, expected) {
+		t.Errorf("Expected constructed groups to see every source, got %v", inv.Groups["everything"].Hosts)
+	}
+
+	if err := manager.LoadSources("/inv/missing"); err == nil {
+		t.Error("Expected an error for a missing source")
+	}
+}
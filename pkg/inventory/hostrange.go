@@ -0,0 +1,202 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// rangeLetters is the alphabet of alphabetic ranges, in Ansible's order
+const rangeLetters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// ExpandHostPattern expands the ranges of an inventory host pattern into
+// host names. A range is [begin:end] or [begin:end:stride] over numbers,
+// which keep the zero padding of begin, or single letters. A pattern may
+// hold several ranges, as in rack[1:2]-node[01:04], and may end in a
+// :port, which is returned separately (0 when absent).
+func ExpandHostPattern(pattern string) ([]string, int, error) {
+	name, port, err := splitHostPort(pattern)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !strings.Contains(name, "[") {
+		if strings.Contains(name, "]") {
+			return nil, 0, fmt.Errorf("invalid host pattern '%s': unmatched ']'", pattern)
+		}
+		return []string{name}, port, nil
+	}
+
+	hosts, err := expandHostnameRange(name)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid host range in '%s': %v", pattern, err)
+	}
+	return hosts, port, nil
+}
+
+// splitHostPort splits a trailing :port from a host pattern. Colons inside
+// ranges belong to the range, and IPv6 addresses carry no port unless
+// bracketed, as in [fe80::1]:2222.
+func splitHostPort(pattern string) (string, int, error) {
+	if strings.HasPrefix(pattern, "[") {
+		if end := strings.Index(pattern, "]"); end > 0 && strings.Count(pattern[1:end], ":") > 1 {
+			address, rest := pattern[1:end], pattern[end+1:]
+			if rest == "" {
+				return address, 0, nil
+			}
+			if !strings.HasPrefix(rest, ":") {
+				return "", 0, fmt.Errorf("invalid host pattern '%s'", pattern)
+			}
+			port, err := parsePort(rest[1:], pattern)
+			return address, port, err
+		}
+	}
+
+	// Find the colons outside brackets
+	depth := 0
+	var colons []int
+	for i, ch := range pattern {
+		switch ch {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ':':
+			if depth == 0 {
+				colons = append(colons, i)
+			}
+		}
+	}
+	if len(colons) != 1 {
+		// No port, or a bare IPv6 address
+		return pattern, 0, nil
+	}
+
+	port, err := parsePort(pattern[colons[0]+1:], pattern)
+	return pattern[:colons[0]], port, err
+}
+
+func parsePort(value, pattern string) (int, error) {
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 0 || port > 65535 {
+		return 0, fmt.Errorf("invalid port '%s' in host pattern '%s'", value, pattern)
+	}
+	return port, nil
+}
+
+// expandHostnameRange expands the first range of name and recurses into
+// the names it produces for the remaining ranges
+func expandHostnameRange(name string) ([]string, error) {
+	open := strings.Index(name, "[")
+	if open < 0 {
+		if strings.Contains(name, "]") {
+			return nil, fmt.Errorf("unmatched ']'")
+		}
+		return []string{name}, nil
+	}
+	end := strings.Index(name[open:], "]")
+	if end < 0 {
+		return nil, fmt.Errorf("unterminated range '%s'", name[open:])
+	}
+	end += open
+	head, bounds, tail := name[:open], name[open+1:end], name[end+1:]
+
+	values, err := rangeValues(bounds)
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []string
+	for _, value := range values {
+		expanded, err := expandHostnameRange(head + value + tail)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, expanded...)
+	}
+	return hosts, nil
+}
+
+// rangeValues returns the values of the range begin:end[:stride]
+func rangeValues(bounds string) ([]string, error) {
+	parts := strings.Split(bounds, ":")
+	if len(parts) != 2 && len(parts) != 3 {
+		return nil, fmt.Errorf("range [%s] must be begin:end or begin:end:stride", bounds)
+	}
+
+	begin, end := parts[0], parts[1]
+	if begin == "" {
+		begin = "0"
+	}
+	if end == "" {
+		return nil, fmt.Errorf("range [%s] must specify an end value", bounds)
+	}
+
+	stride := 1
+	if len(parts) == 3 {
+		var err error
+		if stride, err = strconv.Atoi(parts[2]); err != nil || stride < 1 {
+			return nil, fmt.Errorf("range [%s] must have a positive integer stride", bounds)
+		}
+	}
+
+	// Alphabetic ranges
+	if len(begin) == 1 && len(end) == 1 {
+		first, last := strings.Index(rangeLetters, begin), strings.Index(rangeLetters, end)
+		if first >= 0 || last >= 0 {
+			if first < 0 || last < 0 {
+				return nil, fmt.Errorf("range [%s] mixes letters and numbers", bounds)
+			}
+			if first > last {
+				return nil, fmt.Errorf("range [%s] must have begin <= end", bounds)
+			}
+			var values []string
+			for i := first; i <= last; i += stride {
+				values = append(values, rangeLetters[i:i+1])
+			}
+			return values, nil
+		}
+	}
+
+	first, err := strconv.Atoi(begin)
+	if err != nil || first < 0 {
+		return nil, fmt.Errorf("range [%s] has an invalid begin value '%s'", bounds, begin)
+	}
+	last, err := strconv.Atoi(end)
+	if err != nil || last < 0 {
+		return nil, fmt.Errorf("range [%s] has an invalid end value '%s'", bounds, end)
+	}
+	if first > last {
+		return nil, fmt.Errorf("range [%s] must have begin <= end", bounds)
+	}
+
+	// A zero-padded begin sets the width of every value
+	format := "%d"
+	if len(begin) > 1 && begin[0] == '0' {
+		if len(begin) != len(end) {
+			return nil, fmt.Errorf("range [%s] must specify equal-length begin and end formats", bounds)
+		}
+		format = fmt.Sprintf("%%0%dd", len(begin))
+	}
+
+	var values []string
+	for i := first; i <= last; i += stride {
+		values = append(values, fmt.Sprintf(format, i))
+	}
+	return values, nil
+}
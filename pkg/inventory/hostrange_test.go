@@ -0,0 +1,125 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestExpandHostPattern(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		expected []string
+		port     int
+	}{
+		{"web1", []string{"web1"}, 0},
+		{"web1:2222", []string{"web1"}, 2222},
+		{"web[1:3]", []string{"web1", "web2", "web3"}, 0},
+		{"web[08:11].example.com", []string{"web08.example.com", "web09.example.com", "web10.example.com", "web11.example.com"}, 0},
+		{"node[01:07:3]", []string{"node01", "node04", "node07"}, 0},
+		{"db-[a:c]", []string{"db-a", "db-b", "db-c"}, 0},
+		{"db-[y:B]", []string{"db-y", "db-z", "db-A", "db-B"}, 0},
+		{"db-[a:e:2]", []string{"db-a", "db-c", "db-e"}, 0},
+		{"web[:2]", []string{"web0", "web1", "web2"}, 0},
+		{"rack[1:2]-node[01:02]:22", []string{"rack1-node01", "rack1-node02", "rack2-node01", "rack2-node02"}, 22},
+		{"fe80::1", []string{"fe80::1"}, 0},
+		{"[fe80::1]:2222", []string{"fe80::1"}, 2222},
+	}
+	for _, test := range tests {
+		hosts, port, err := ExpandHostPattern(test.pattern)
+		if err != nil {
+			t.Errorf("ExpandHostPattern(%q) error = %v", test.pattern, err)
+			continue
+		}
+		if !reflect.DeepEqual(hosts, test.expected) || port != test.port {
+			t.Errorf("ExpandHostPattern(%q) = %v, %d; want %v, %d", test.pattern, hosts, port, test.expected, test.port)
+		}
+	}
+
+	invalid := map[string]string{
+		"web[1:3":       "unterminated range",
+		"web1]":         "unmatched ']'",
+		"web[3:1]":      "begin <= end",
+		"web[1:]":       "must specify an end value",
+		"web[1:2:3:4]":  "begin:end or begin:end:stride",
+		"web[01:100]":   "equal-length",
+		"web[1:5:0]":    "positive integer stride",
+		"web[a:5]":      "mixes letters and numbers",
+		"web[x1:x2]":    "invalid begin value",
+		"web[1:3]:port": "invalid port",
+		"web:99999":     "invalid port",
+		"web[c:a]":      "begin <= end",
+		"web[1:2]-[a:b": "unterminated range",
+	}
+	for pattern, errMsg := range invalid {
+		if _, _, err := ExpandHostPattern(pattern); err == nil || !strings.Contains(err.Error(), errMsg) {
+			t.Errorf("ExpandHostPattern(%q): expected error containing '%s', got %v", pattern, errMsg, err)
+		}
+	}
+}
+
+func TestManager_HostRanges(t *testing.T) {
+	manager := NewManager(afero.NewMemMapFs())
+	err := manager.LoadFromString(`
+storage:
+  hosts:
+    san-[a:b]:
+      tier: fast
+    backup[01:03:2]:2222:
+`, "yaml")
+	if err != nil {
+		t.Fatalf("LoadFromString() error = %v", err)
+	}
+
+	hosts, _ := manager.GetHosts("storage")
+	if got := hostNames(hosts); !reflect.DeepEqual(got, []string{"san-a", "san-b", "backup01", "backup03"}) {
+		t.Errorf("storage = %v", got)
+	}
+	inv := manager.GetInventory()
+	if inv.Hosts["san-b"].Variables["tier"] != "fast" || inv.Hosts["backup03"].Port != 2222 {
+		t.Errorf("san-b = %+v, backup03 = %+v", inv.Hosts["san-b"], inv.Hosts["backup03"])
+	}
+
+	// Hosts from one range do not share their variables
+	inv.Hosts["san-a"].Variables["tier"] = "slow"
+	if inv.Hosts["san-b"].Variables["tier"] != "fast" {
+		t.Error("hosts expanded from one range must not share their variables")
+	}
+}
+
+func TestManager_HostRanges_LineNumbers(t *testing.T) {
+	tests := []struct {
+		format string
+		data   string
+		errMsg string
+	}{
+		{"ini", "[web]\nweb1\n\nweb[3:1] http_port=80\n", "line 4:"},
+		{"yaml", "web:\n  hosts:\n    web1:\n    web[a:3]:\n", "line 4:"},
+		{"yaml", "web:\n  hosts: [web1]\n", "line 2: hosts of group 'web' must be a mapping"},
+	}
+	for _, test := range tests {
+		manager := NewManager(afero.NewMemMapFs())
+		err := manager.LoadFromString(test.data, test.format)
+		if err == nil || !strings.Contains(err.Error(), test.errMsg) {
+			t.Errorf("%s %q: expected error containing '%s', got %v", test.format, test.data, test.errMsg, err)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestShlexSplit(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected []string
+	}{
+		{`web1 a=1 b=2`, []string{"web1", "a=1", "b=2"}},
+		{`web1 msg="hello world"`, []string{"web1", "msg=hello world"}},
+		{`web1 msg='it''s'`, []string{"web1", "msg=its"}},
+		{`web1 msg="say \"hi\"" path=C:\\dir`, []string{"web1", `msg=say "hi"`, `path=C:\dir`}},
+		{`web1 msg="a\b"`, []string{"web1", `msg=a\b`}},
+		{`web1 a=1 # a comment`, []string{"web1", "a=1"}},
+		{`web1 a=1# a comment`, []string{"web1", "a=1"}},
+		{`web1 tag="#1"`, []string{"web1", "tag=#1"}},
+		{`web1 empty=""`, []string{"web1", "empty="}},
+	}
+
+	for _, test := range tests {
+		result, err := shlexSplit(test.line)
+		if err != nil {
+			t.Errorf("shlexSplit(%q) returned error: %v", test.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("shlexSplit(%q) = %q, expected %q", test.line, result, test.expected)
+		}
+	}
+
+	for _, line := range []string{`web1 msg="unterminated`, `web1 msg=trailing\`} {
+		if _, err := shlexSplit(line); err == nil {
+			t.Errorf("shlexSplit(%q) should fail", line)
+		}
+	}
+}
+
+func TestParseINIValue(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected interface{}
+	}{
+		{"22", 22},
+		{"-5", -5},
+		{"1_000", 1000},
+		{"0x1F", 31},
+		{"010", "010"},
+		{"0", 0},
+		{"1.5", 1.5},
+		{"True", true},
+		{"False", false},
+		{"None", nil},
+		{"true", "true"},
+		{"yes", "yes"},
+		{"'quoted'", "quoted"},
+		{`"a\tb"`, "a\tb"},
+		{"[1, 'two', [3]]", []interface{}{1, "two", []interface{}{3}}},
+		{"(1, 2,)", []interface{}{1, 2}},
+		{"(1)", 1},
+		{"{'a': 1, 'b': [True]}", map[string]interface{}{"a": 1, "b": []interface{}{true}}},
+		{"{}", map[string]interface{}{}},
+		{"{1, 2}", []interface{}{1, 2}},
+		{"[1, 2", "[1, 2"},
+		{"web1.example.com", "web1.example.com"},
+		{"1.2.3.4", "1.2.3.4"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		result := parseINIValue(test.value)
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("parseINIValue(%q) = %#v, expected %#v", test.value, result, test.expected)
+		}
+	}
+}
+
+func TestManager_LoadFromINI_Ansible(t *testing.T) {
+	inventoryContent := `# leading comment
+192.0.2.1 ansible_port=2222 motd="hello world" # trailing comment
+
+[web]
+192.0.2.10 http_port=8080 enabled=True tags="['a', 'b']" opts="{'x': 1}"
+192.0.2.11 name='web two'
+
+[db]
+192.0.2.20
+
+[prod:children]
+web  # inline comment
+db
+
+[prod:vars]
+http_port=80
+debug=False
+; comment
+greeting="hi there"
+`
+
+	manager := NewManager(afero.NewMemMapFs())
+	if err := manager.LoadFromString(inventoryContent, "ini"); err != nil {
+		t.Fatalf("Failed to load INI inventory: %v", err)
+	}
+	inv := manager.GetInventory()
+
+	ungrouped := inv.Hosts["192.0.2.1"]
+	if ungrouped.Port != 2222 || ungrouped.Variables["motd"] != "hello world" {
+		t.Errorf("Unexpected ungrouped host: %+v", ungrouped)
+	}
+
+	web := inv.Hosts["192.0.2.10"]
+	expectedVars := map[string]interface{}{
+		"http_port": 8080,
+		"enabled":   true,
+		"tags":      []interface{}{"a", "b"},
+		"opts":      map[string]interface{}{"x": 1},
+	}
+	if !reflect.DeepEqual(web.Variables, expectedVars) {
+		t.Errorf("Expected host variables %#v, got %#v", expectedVars, web.Variables)
+	}
+
+	if name := inv.Hosts["192.0.2.11"].Variables["name"]; name != "web two" {
+		t.Errorf("Expected quoted name 'web two', got %v", name)
+	}
+
+	prod := inv.Groups["prod"]
+	if !reflect.DeepEqual(prod.Children, []string{"web", "db"}) {
+		t.Errorf("Expected prod children [web db], got %v", prod.Children)
+	}
+	expectedGroupVars := map[string]interface{}{
+		"http_port": "80",
+		"debug":     "False",
+		"greeting":  "hi there",
+	}
+	if !reflect.DeepEqual(prod.Variables, expectedGroupVars) {
+		t.Errorf("Expected group variables %#v, got %#v", expectedGroupVars, prod.Variables)
+	}
+
+	expectedGroups := map[string][]string{
+		"192.0.2.1":  {"all", "ungrouped"},
+		"192.0.2.10": {"all", "web", "prod"},
+		"192.0.2.20": {"all", "db", "prod"},
+	}
+	for hostName, expected := range expectedGroups {
+		if groups := inv.Hosts[hostName].Groups; !reflect.DeepEqual(groups, expected) {
+			t.Errorf("Expected %s to be in groups %v, got %v", hostName, expected, groups)
+		}
+	}
+}
+
+func TestManager_LoadFromINI_Errors(t *testing.T) {
+	tests := []struct {
+		content string
+		message string
+	}{
+		{"[web]\n192.0.2.1 novalue\n", "line 2: failed to parse host entry: expected key=value"},
+		{"[web]\n192.0.2.1 msg=\"open\n", "no closing quotation"},
+		{"[web:vars]\nnovalue\n", "line 2: failed to parse group variable: expected key=value"},
+		{"[web:other]\n", "unknown type: other"},
+		{"[web servers]\n", "invalid section entry"},
+		{"[web:children]\nbad group\n", "not a valid group name"},
+	}
+
+	for _, test := range tests {
+		manager := NewManager(afero.NewMemMapFs())
+		err := manager.LoadFromString(test.content, "ini")
+		if err == nil {
+			t.Errorf("Expected an error loading %q", test.content)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.message) {
+			t.Errorf("Expected error containing %q, got: %v", test.message, err)
+		}
+	}
+}
@@ -19,10 +19,12 @@ package inventory
 import (
 	"fmt"
 	"net"
-	"os"
+	"reflect"
 	"regexp"
-	"strconv"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/spf13/afero"
 	"gopkg.in/yaml.v3"
@@ -53,6 +55,15 @@ type Inventory struct {
 	fs             afero.Fs
 	AllGroup       *Group `json:"-" yaml:"-"`
 	UngroupedGroup *Group `json:"-" yaml:"-"`
+
+	// hostOrder and groupOrder record the order hosts and groups were
+	// added in, which is the order patterns return them in
+	hostOrder  []string
+	groupOrder []string
+
+	// mutex guards changes made while tasks run, such as by add_host and
+	// group_by, against concurrent readers
+	mutex sync.RWMutex
 }
 
 // Manager handles inventory loading and management
@@ -60,15 +71,42 @@ type Manager struct {
 	inventory *Inventory
 	fs        afero.Fs
 	sources   []string
+	subset    []string
+
+	plugins          map[string]Plugin
+	enabledPlugins   []string
+	ignoreExtensions []string
+	scriptTimeout    time.Duration
+	pending          []func() error
+
+	// Validation state: the source being loaded, which source set each
+	// host variable, and what validation found
+	validationMode  string
+	currentSource   string
+	hostDefinitions map[string]map[string]hostDefinition
+	conflicts       []Problem
+	warnings        []string
+
+	// Inventory caching: the cache settings sources inherit, and whether
+	// to ignore cache entries
+	cacheDefaults cacheOptions
+	flushCache    bool
 }
 
 // NewManager creates a new inventory manager
 func NewManager(fs afero.Fs) *Manager {
-	return &Manager{
-		inventory: NewInventory(fs),
-		fs:        fs,
-		sources:   make([]string, 0),
+	m := &Manager{
+		inventory:        NewInventory(fs),
+		fs:               fs,
+		sources:          make([]string, 0),
+		plugins:          make(map[string]Plugin),
+		enabledPlugins:   append([]string{}, DefaultEnabledPlugins...),
+		ignoreExtensions: append([]string{}, DefaultIgnoreExtensions...),
+		validationMode:   ValidationWarning,
+		hostDefinitions:  make(map[string]map[string]hostDefinition),
 	}
+	m.registerBuiltinPlugins()
+	return m
 }
 
 // NewInventory creates a new empty inventory
@@ -94,37 +132,46 @@ func NewInventory(fs afero.Fs) *Inventory {
 		Variables: make(map[string]interface{}),
 	}
 	inv.Groups["ungrouped"] = inv.UngroupedGroup
+	inv.groupOrder = []string{"all", "ungrouped"}
 
 	return inv
 }
 
-// LoadFromFile loads inventory from a file
+// LoadFromFile loads inventory from a file or directory using the enabled
+// inventory plugins
 func (m *Manager) LoadFromFile(filename string) error {
-	m.sources = append(m.sources, filename)
-
-	data, err := afero.ReadFile(m.fs, filename)
-	if err != nil {
-		return fmt.Errorf("failed to read inventory file %s: %w", filename, err)
+	return m.LoadSources(filename)
+}
+
+// LoadSources merges several inventory sources into the inventory, in
+// order. A source may be a file, a directory, a script or an inline host
+// list such as "web1,web2:2222,". Plugins that build on other sources, like
+// constructed, run once every source is loaded, and the result is then
+// validated. Files whose cache settings turn caching on are read from the
+// cache when it holds them.
+func (m *Manager) LoadSources(sources ...string) error {
+	defer func() { m.currentSource = "" }()
+	for _, source := range sources {
+		m.sources = append(m.sources, source)
+		m.currentSource = source
+		if err := m.parseSource(source); err != nil {
+			return err
+		}
+		if err := m.loadVarsFiles(source); err != nil {
+			return err
+		}
 	}
-
-	// Determine file format based on extension
-	if strings.HasSuffix(filename, ".yml") || strings.HasSuffix(filename, ".yaml") {
-		return m.loadFromYAML(data)
-	} else if strings.HasSuffix(filename, ".json") {
-		return m.loadFromJSON(data)
-	} else {
-		// Default to INI format
-		return m.loadFromINI(data)
+	if err := m.runPending(); err != nil {
+		return err
 	}
+	return m.validateOnLoad()
 }
 
 // LoadFromString loads inventory from a string
 func (m *Manager) LoadFromString(data, format string) error {
 	switch strings.ToLower(format) {
-	case "yaml", "yml":
+	case "yaml", "yml", "json":
 		return m.loadFromYAML([]byte(data))
-	case "json":
-		return m.loadFromJSON([]byte(data))
 	case "ini":
 		return m.loadFromINI([]byte(data))
 	default:
@@ -134,28 +181,33 @@ func (m *Manager) LoadFromString(data, format string) error {
 
 // loadFromYAML loads inventory from YAML format
 func (m *Manager) loadFromYAML(data []byte) error {
-	var yamlInventory map[string]interface{}
-	if err := yaml.Unmarshal(data, &yamlInventory); err != nil {
-		return fmt.Errorf("failed to parse YAML inventory: %w", err)
+	root, err := yamlInventoryRoot(data)
+	if err != nil {
+		return err
+	}
+	if root == nil {
+		return nil
 	}
 
-	return m.parseYAMLInventory(yamlInventory)
+	return m.parseYAMLInventory(root)
 }
 
-// loadFromJSON loads inventory from JSON format
-func (m *Manager) loadFromJSON(data []byte) error {
-	// JSON inventory parsing would be implemented here
-	// For now, return an error indicating it's not yet implemented
-	return fmt.Errorf("JSON inventory format not yet implemented")
-}
+// iniSectionHeader matches an INI section header such as [web] or
+// [web:vars], optionally followed by a comment
+var iniSectionHeader = regexp.MustCompile(`^\[([^:\]\s]+)(?::(\w+))?\]\s*(?:[#;].*)?$`)
+
+// iniGroupName matches a group name on a line of a :children section
+var iniGroupName = regexp.MustCompile(`^([^:\]\s]+)\s*(?:[#;].*)?$`)
 
-// loadFromINI loads inventory from INI format
+// loadFromINI loads inventory from INI format. Hosts before the first
+// section are ungrouped; [group:children] lists child groups and
+// [group:vars] sets group variables.
 func (m *Manager) loadFromINI(data []byte) error {
 	lines := strings.Split(string(data), "\n")
 	currentGroup := ""
-	inVarsSection := false
+	state := "hosts"
 
-	for _, line := range lines {
+	for index, line := range lines {
 		line = strings.TrimSpace(line)
 
 		// Skip empty lines and comments
@@ -163,250 +215,225 @@ func (m *Manager) loadFromINI(data []byte) error {
 			continue
 		}
 
-		// Check for group headers
-		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
-			groupName := line[1 : len(line)-1]
-
-			// Handle group variables sections
-			if strings.HasSuffix(groupName, ":vars") {
-				currentGroup = strings.TrimSuffix(groupName, ":vars")
-				inVarsSection = true
-				continue
+		// Check for section headers
+		if strings.HasPrefix(line, "[") {
+			match := iniSectionHeader.FindStringSubmatch(line)
+			if match == nil {
+				return fmt.Errorf("line %d: invalid section entry: '%s'. Please make sure that there are no spaces in the section entry, and that there are no other invalid characters", index+1, line)
 			}
 
-			// Handle group children sections
-			if strings.HasSuffix(groupName, ":children") {
-				currentGroup = strings.TrimSuffix(groupName, ":children")
-				inVarsSection = false
-				// TODO: Handle children parsing
-				continue
+			currentGroup, state = match[1], match[2]
+			if state == "" {
+				state = "hosts"
+			}
+			if state != "hosts" && state != "children" && state != "vars" {
+				return fmt.Errorf("line %d: section [%s:%s] has unknown type: %s", index+1, currentGroup, state, state)
 			}
-
-			// Regular group
-			currentGroup = groupName
-			inVarsSection = false
 			m.inventory.GetOrCreateGroup(currentGroup)
 			continue
 		}
 
-		if inVarsSection {
-			// Parse group variables
-			if err := m.parseVariable(line, currentGroup); err != nil {
-				return fmt.Errorf("failed to parse group variable: %w", err)
-			}
-		} else {
-			// Parse host entry
+		switch state {
+		case "hosts":
 			if err := m.parseHostEntry(line, currentGroup); err != nil {
-				return fmt.Errorf("failed to parse host entry: %w", err)
+				return fmt.Errorf("line %d: failed to parse host entry: %w", index+1, err)
 			}
-		}
-	}
-
-	m.inventory.UpdateAllGroup()
-	return nil
-}
-
-// parseYAMLInventory parses YAML inventory structure
-func (m *Manager) parseYAMLInventory(yamlInventory map[string]interface{}) error {
-	// First pass: handle the 'all' group specially and process other groups
-	for groupName, groupData := range yamlInventory {
-		if groupName == "all" {
-			// Handle special 'all' group
-			if allData, ok := groupData.(map[string]interface{}); ok {
-				if vars, ok := allData["vars"].(map[string]interface{}); ok {
-					m.inventory.AllGroup.Variables = vars
-				}
-				if children, ok := allData["children"].(map[string]interface{}); ok {
-					// Process children groups
-					for childName, childData := range children {
-						m.inventory.AllGroup.Children = append(m.inventory.AllGroup.Children, childName)
-						// Parse the child group data
-						m.parseGroupData(childName, childData)
-					}
-				}
+		case "children":
+			match := iniGroupName.FindStringSubmatch(line)
+			if match == nil {
+				return fmt.Errorf("line %d: '%s' is not a valid group name", index+1, line)
+			}
+			m.inventory.GetOrCreateGroup(match[1])
+			m.inventory.GetOrCreateGroup(currentGroup).AddChild(match[1])
+		case "vars":
+			if err := m.parseVariable(line, currentGroup); err != nil {
+				return fmt.Errorf("line %d: failed to parse group variable: %w", index+1, err)
 			}
-			continue
 		}
-
-		// Parse regular groups
-		m.parseGroupData(groupName, groupData)
 	}
 
 	m.inventory.UpdateAllGroup()
 	return nil
 }
 
-// parseGroupData parses a single group's data
-func (m *Manager) parseGroupData(groupName string, groupData interface{}) {
-	group := m.inventory.GetOrCreateGroup(groupName)
-	groupMap, ok := groupData.(map[string]interface{})
-	if !ok {
-		return
-	}
-
-	// Parse hosts
-	if hosts, ok := groupMap["hosts"].(map[string]interface{}); ok {
-		for hostName, hostData := range hosts {
-			host := m.inventory.GetOrCreateHost(hostName)
-			if hostVars, ok := hostData.(map[string]interface{}); ok {
-				for varName, varValue := range hostVars {
-					if host.Variables == nil {
-						host.Variables = make(map[string]interface{})
-					}
-					host.Variables[varName] = varValue
-				}
-			}
-			group.AddHost(hostName)
-		}
+// yamlInventoryRoot parses a YAML inventory document and returns its
+// top-level mapping, or nil for an empty document
+func yamlInventoryRoot(data []byte) (*yaml.Node, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("failed to parse YAML inventory: %w", err)
 	}
-
-	// Parse group variables
-	if vars, ok := groupMap["vars"].(map[string]interface{}); ok {
-		group.Variables = vars
+	if len(document.Content) == 0 || isNullNode(document.Content[0]) {
+		return nil, nil
 	}
 
-	// Parse children
-	if children, ok := groupMap["children"].(map[string]interface{}); ok {
-		for childName, childData := range children {
-			group.AddChild(childName)
-			// Recursively parse child groups
-			m.parseGroupData(childName, childData)
-		}
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: YAML inventory must be a mapping of groups", root.Line)
 	}
+	return root, nil
 }
 
-// parseHostEntry parses a single host entry from INI format
-func (m *Manager) parseHostEntry(line, groupName string) error {
-	// Handle host ranges like web[1:3].example.com
-	if strings.Contains(line, "[") && strings.Contains(line, ":") && strings.Contains(line, "]") {
-		return m.parseHostRange(line, groupName)
+// parseYAMLInventory parses YAML inventory structure. Groups, hosts and
+// children are added in the order the file lists them.
+func (m *Manager) parseYAMLInventory(root *yaml.Node) error {
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if err := m.parseGroupData(root.Content[i].Value, root.Content[i+1]); err != nil {
+			return err
+		}
 	}
 
-	// Parse single host entry
-	parts := strings.Fields(line)
-	if len(parts) == 0 {
+	m.inventory.UpdateAllGroup()
+	return nil
+}
+
+// parseGroupData parses a single group's data. Host keys may be patterns
+// with ranges, which add every host they expand to.
+func (m *Manager) parseGroupData(groupName string, groupData *yaml.Node) error {
+	group := m.inventory.GetOrCreateGroup(groupName)
+	if isNullNode(groupData) {
 		return nil
 	}
+	if groupData.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: group '%s' must be a mapping of hosts, vars and children", groupData.Line, groupName)
+	}
 
-	hostName := parts[0]
-	host := m.inventory.GetOrCreateHost(hostName)
+	for i := 0; i+1 < len(groupData.Content); i += 2 {
+		key, value := groupData.Content[i], groupData.Content[i+1]
+		switch key.Value {
+		case "hosts":
+			if isNullNode(value) {
+				continue
+			}
+			if value.Kind != yaml.MappingNode {
+				return fmt.Errorf("line %d: hosts of group '%s' must be a mapping", value.Line, groupName)
+			}
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				hostKey, hostData := value.Content[j], value.Content[j+1]
+				hostNames, port, err := ExpandHostPattern(hostKey.Value)
+				if err != nil {
+					return fmt.Errorf("line %d: %v", hostKey.Line, err)
+				}
 
-	// Parse host variables
-	for _, part := range parts[1:] {
-		if strings.Contains(part, "=") {
-			keyValue := strings.SplitN(part, "=", 2)
-			if len(keyValue) == 2 {
-				if host.Variables == nil {
-					host.Variables = make(map[string]interface{})
+				hostVars, err := yamlMapping(hostData, "variables of host "+hostKey.Value)
+				if err != nil {
+					return err
 				}
-				host.Variables[keyValue[0]] = keyValue[1]
-
-				// Handle special variables
-				switch keyValue[0] {
-				case "ansible_host":
-					host.Address = keyValue[1]
-				case "ansible_port":
-					if port, err := strconv.Atoi(keyValue[1]); err == nil {
-						host.Port = port
-					}
-				case "ansible_user":
-					host.User = keyValue[1]
+				if port != 0 {
+					hostVars["ansible_port"] = port
 				}
+				m.addHosts(hostNames, hostVars, groupName)
+			}
+
+		case "vars":
+			vars, err := yamlMapping(value, "vars of group "+groupName)
+			if err != nil {
+				return err
+			}
+			for k, v := range vars {
+				group.Variables[k] = v
 			}
-		}
-	}
 
-	// Add host to group
-	if groupName != "" {
-		group := m.inventory.GetOrCreateGroup(groupName)
-		group.AddHost(hostName)
+		case "children":
+			if isNullNode(value) {
+				continue
+			}
+			if value.Kind != yaml.MappingNode {
+				return fmt.Errorf("line %d: children of group '%s' must be a mapping", value.Line, groupName)
+			}
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				childName := value.Content[j].Value
+				group.AddChild(childName)
+				// Recursively parse child groups
+				if err := m.parseGroupData(childName, value.Content[j+1]); err != nil {
+					return err
+				}
+			}
+		}
 	}
 
 	return nil
 }
 
-// parseHostRange parses host ranges like web[1:3].example.com
-func (m *Manager) parseHostRange(line, groupName string) error {
-	// Regular expression to match ranges like web[1:3].example.com
-	rangeRegex := regexp.MustCompile(`^([^[]*)\[(\d+):(\d+)](.*)$`)
-	matches := rangeRegex.FindStringSubmatch(strings.Fields(line)[0])
-
-	if len(matches) != 5 {
-		return fmt.Errorf("invalid host range format: %s", line)
+// yamlMapping decodes a mapping node, treating null as empty
+func yamlMapping(node *yaml.Node, what string) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	if isNullNode(node) {
+		return result, nil
 	}
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: %s must be a mapping", node.Line, what)
+	}
+	if err := node.Decode(&result); err != nil {
+		return nil, fmt.Errorf("line %d: invalid %s: %v", node.Line, what, err)
+	}
+	return result, nil
+}
 
-	prefix := matches[1]
-	startStr := matches[2]
-	endStr := matches[3]
-	suffix := matches[4]
+func isNullNode(node *yaml.Node) bool {
+	return node == nil || (node.Kind == yaml.ScalarNode && node.Tag == "!!null")
+}
 
-	start, err := strconv.Atoi(startStr)
+// parseHostEntry parses a single host entry from INI format. The host may
+// be a pattern with ranges, like web[01:10].example.com, and a :port. The
+// line is split with shell-style quoting and host variable values are
+// evaluated as literals, so port=22 is an int and on=True a bool.
+func (m *Manager) parseHostEntry(line, groupName string) error {
+	parts, err := shlexSplit(line)
 	if err != nil {
-		return fmt.Errorf("invalid range start: %s", startStr)
+		return fmt.Errorf("error parsing host definition '%s': %v", line, err)
+	}
+	if len(parts) == 0 {
+		return nil
 	}
 
-	end, err := strconv.Atoi(endStr)
+	hostNames, port, err := ExpandHostPattern(parts[0])
 	if err != nil {
-		return fmt.Errorf("invalid range end: %s", endStr)
+		return err
 	}
 
-	// Parse additional variables from the line
+	// Parse host variables
 	hostVars := make(map[string]interface{})
-	parts := strings.Fields(line)
 	for _, part := range parts[1:] {
-		if strings.Contains(part, "=") {
-			keyValue := strings.SplitN(part, "=", 2)
-			if len(keyValue) == 2 {
-				hostVars[keyValue[0]] = keyValue[1]
-			}
+		keyValue := strings.SplitN(part, "=", 2)
+		if len(keyValue) != 2 {
+			return fmt.Errorf("expected key=value host variable assignment, got: %s", part)
 		}
+		hostVars[keyValue[0]] = parseINIValue(keyValue[1])
 	}
+	if port != 0 {
+		hostVars["ansible_port"] = port
+	}
+
+	m.addHosts(hostNames, hostVars, groupName)
+	return nil
+}
 
-	// Create hosts for the range
-	for i := start; i <= end; i++ {
-		hostName := fmt.Sprintf("%s%d%s", prefix, i, suffix)
+// addHosts adds hosts with their variables to a group
+func (m *Manager) addHosts(hostNames []string, hostVars map[string]interface{}, groupName string) {
+	for _, hostName := range hostNames {
 		host := m.inventory.GetOrCreateHost(hostName)
 
-		// Copy variables to each host
-		if host.Variables == nil {
-			host.Variables = make(map[string]interface{})
-		}
+		// Each host gets its own copy of the variables
+		vars := make(map[string]interface{}, len(hostVars))
 		for k, v := range hostVars {
-			host.Variables[k] = v
-		}
-
-		// Handle special variables
-		if address, ok := hostVars["ansible_host"].(string); ok {
-			host.Address = address
-		}
-		if portStr, ok := hostVars["ansible_port"].(string); ok {
-			if port, err := strconv.Atoi(portStr); err == nil {
-				host.Port = port
-			}
-		}
-		if user, ok := hostVars["ansible_user"].(string); ok {
-			host.User = user
+			vars[k] = v
 		}
+		m.recordHostDefinition(hostName, vars)
+		setHostVariables(host, vars)
 
-		// Add to group
 		if groupName != "" {
-			group := m.inventory.GetOrCreateGroup(groupName)
-			group.AddHost(hostName)
+			m.inventory.GetOrCreateGroup(groupName).AddHost(hostName)
 		}
 	}
-
-	return nil
 }
 
-// parseVariable parses a variable assignment
+// parseVariable parses a variable assignment of a :vars section. Unlike
+// host variables, the value is always a string.
 func (m *Manager) parseVariable(line, groupName string) error {
-	if !strings.Contains(line, "=") {
-		return nil
-	}
-
 	keyValue := strings.SplitN(line, "=", 2)
 	if len(keyValue) != 2 {
-		return nil
+		return fmt.Errorf("expected key=value, got: %s", line)
 	}
 
 	key := strings.TrimSpace(keyValue[0])
@@ -432,98 +459,60 @@ func (m *Manager) GetInventory() *Inventory {
 	return m.inventory
 }
 
-// GetHosts returns all hosts matching the given pattern
-func (m *Manager) GetHosts(pattern string) ([]*Host, error) {
-	if pattern == "all" {
-		var hosts []*Host
-		for _, host := range m.inventory.Hosts {
-			hosts = append(hosts, host)
-		}
-		return hosts, nil
-	}
-
-	// Check if pattern is a group name
-	if group, exists := m.inventory.Groups[pattern]; exists {
-		return m.getHostsFromGroup(group), nil
-	}
-
-	// Check if pattern is a host name
-	if host, exists := m.inventory.Hosts[pattern]; exists {
-		return []*Host{host}, nil
-	}
-
-	// Handle pattern matching (simplified)
-	return m.getHostsByPattern(pattern)
-}
-
-// getHostsFromGroup returns all hosts from a group (including children)
-func (m *Manager) getHostsFromGroup(group *Group) []*Host {
-	hostsMap := make(map[string]*Host)
-
-	// Add direct hosts
-	for _, hostName := range group.Hosts {
-		if host, exists := m.inventory.Hosts[hostName]; exists {
-			hostsMap[hostName] = host
-		}
-	}
-
-	// Add hosts from child groups
-	for _, childName := range group.Children {
-		if childGroup, exists := m.inventory.Groups[childName]; exists {
-			childHosts := m.getHostsFromGroup(childGroup)
-			for _, host := range childHosts {
-				hostsMap[host.Name] = host
-			}
-		}
-	}
-
-	// Convert map to slice
-	var hosts []*Host
-	for _, host := range hostsMap {
-		hosts = append(hosts, host)
+// GetOrCreateHost gets an existing host or creates a new one
+func (inv *Inventory) GetOrCreateHost(name string) *Host {
+	inv.mutex.RLock()
+	host, exists := inv.Hosts[name]
+	inv.mutex.RUnlock()
+	if exists {
+		return host
 	}
 
-	return hosts
-}
+	// Resolve the name without holding the lock
+	address := resolveHostAddress(name)
 
-// getHostsByPattern returns hosts matching a pattern
-func (m *Manager) getHostsByPattern(pattern string) ([]*Host, error) {
-	// Simplified pattern matching - could be extended with regex support
-	var hosts []*Host
-	for _, host := range m.inventory.Hosts {
-		// Simple wildcard matching
-		if matched, err := matchPattern(host.Name, pattern); err == nil && matched {
-			hosts = append(hosts, host)
-		}
-	}
-	return hosts, nil
+	inv.mutex.Lock()
+	defer inv.mutex.Unlock()
+	return inv.getOrCreateHost(name, address)
 }
 
-// GetOrCreateHost gets an existing host or creates a new one
-func (inv *Inventory) GetOrCreateHost(name string) *Host {
+// getOrCreateHost gets or creates a host with the lock held
+func (inv *Inventory) getOrCreateHost(name, address string) *Host {
 	if host, exists := inv.Hosts[name]; exists {
 		return host
 	}
 
 	host := &Host{
 		Name:      name,
+		Address:   address,
 		Variables: make(map[string]interface{}),
 		Groups:    make([]string, 0),
 	}
 
-	// Try to resolve hostname to IP
+	inv.Hosts[name] = host
+	inv.hostOrder = append(inv.hostOrder, name)
+	return host
+}
+
+// resolveHostAddress tries to resolve a host name to an IP address
+func resolveHostAddress(name string) string {
 	if name != "localhost" {
 		if ips, err := net.LookupIP(name); err == nil && len(ips) > 0 {
-			host.Address = ips[0].String()
+			return ips[0].String()
 		}
 	}
-
-	inv.Hosts[name] = host
-	return host
+	return ""
 }
 
 // GetOrCreateGroup gets an existing group or creates a new one
 func (inv *Inventory) GetOrCreateGroup(name string) *Group {
+	inv.mutex.Lock()
+	defer inv.mutex.Unlock()
+	return inv.getOrCreateGroup(name)
+}
+
+// getOrCreateGroup gets or creates a group with the lock held
+func (inv *Inventory) getOrCreateGroup(name string) *Group {
 	if group, exists := inv.Groups[name]; exists {
 		return group
 	}
@@ -536,9 +525,74 @@ func (inv *Inventory) GetOrCreateGroup(name string) *Group {
 	}
 
 	inv.Groups[name] = group
+	inv.groupOrder = append(inv.groupOrder, name)
 	return group
 }
 
+// AddHost adds a host with variables to groups while tasks run, as
+// add_host does, creating the host and groups as needed. It reports
+// whether the inventory changed.
+func (inv *Inventory) AddHost(name string, groupNames []string, vars map[string]interface{}) bool {
+	inv.mutex.RLock()
+	_, exists := inv.Hosts[name]
+	inv.mutex.RUnlock()
+	address := ""
+	if !exists {
+		address = resolveHostAddress(name)
+	}
+
+	inv.mutex.Lock()
+	defer inv.mutex.Unlock()
+
+	_, existed := inv.Hosts[name]
+	host := inv.getOrCreateHost(name, address)
+	changed := !existed
+
+	for _, groupName := range groupNames {
+		group := inv.getOrCreateGroup(groupName)
+		if group != inv.AllGroup && !containsString(group.Hosts, name) {
+			group.AddHost(name)
+			changed = true
+		}
+	}
+
+	for key, value := range vars {
+		if current, exists := host.Variables[key]; !exists || !reflect.DeepEqual(current, value) {
+			changed = true
+		}
+	}
+	setHostVariables(host, vars)
+
+	inv.updateAllGroup()
+	return changed
+}
+
+// AddHostToGroup adds a host to a group while tasks run, as group_by does.
+// The group is created as needed and made a child of each parent group.
+// It reports whether the host was not already in the group.
+func (inv *Inventory) AddHostToGroup(hostName, groupName string, parents []string) (bool, error) {
+	inv.mutex.Lock()
+	defer inv.mutex.Unlock()
+
+	if _, exists := inv.Hosts[hostName]; !exists {
+		return false, fmt.Errorf("host '%s' is not in the inventory", hostName)
+	}
+
+	group := inv.getOrCreateGroup(groupName)
+	for _, parentName := range parents {
+		if parentName != "all" && parentName != groupName {
+			inv.getOrCreateGroup(parentName).AddChild(groupName)
+		}
+	}
+
+	if group == inv.AllGroup || containsString(group.Hosts, hostName) {
+		return false, nil
+	}
+	group.AddHost(hostName)
+	inv.updateAllGroup()
+	return true, nil
+}
+
 // AddHost adds a host to the group
 func (g *Group) AddHost(hostName string) {
 	// Check if host already exists in group
@@ -563,15 +617,23 @@ func (g *Group) AddChild(childName string) {
 
 // UpdateAllGroup updates the 'all' group to include all hosts
 func (inv *Inventory) UpdateAllGroup() {
+	inv.mutex.Lock()
+	defer inv.mutex.Unlock()
+	inv.updateAllGroup()
+}
+
+// updateAllGroup updates all, ungrouped and host memberships with the lock
+// held
+func (inv *Inventory) updateAllGroup() {
 	inv.AllGroup.Hosts = make([]string, 0)
 
 	// Add all hosts to the 'all' group
-	for hostName := range inv.Hosts {
-		inv.AllGroup.Hosts = append(inv.AllGroup.Hosts, hostName)
-	}
+	inv.AllGroup.Hosts = append(inv.AllGroup.Hosts, inv.orderedHostNames()...)
 
 	// Update ungrouped group
 	inv.updateUngroupedGroup()
+
+	inv.updateHostGroups()
 }
 
 // updateUngroupedGroup updates the 'ungrouped' group with hosts not in any other group
@@ -590,15 +652,61 @@ func (inv *Inventory) updateUngroupedGroup() {
 
 	// Add ungrouped hosts
 	inv.UngroupedGroup.Hosts = make([]string, 0)
-	for hostName := range inv.Hosts {
+	for _, hostName := range inv.orderedHostNames() {
 		if !groupedHosts[hostName] {
 			inv.UngroupedGroup.Hosts = append(inv.UngroupedGroup.Hosts, hostName)
 		}
 	}
 }
 
-// GetHostVars returns all variables for a host (including group variables)
+// updateHostGroups sets each host's Groups to every group it belongs to,
+// directly or through a child group, in group order
+func (inv *Inventory) updateHostGroups() {
+	for _, host := range inv.Hosts {
+		host.Groups = make([]string, 0)
+	}
+
+	for _, groupName := range inv.orderedGroupNames() {
+		seenGroups := make(map[string]bool)
+		var walk func(group *Group)
+		walk = func(group *Group) {
+			if seenGroups[group.Name] {
+				return
+			}
+			seenGroups[group.Name] = true
+
+			for _, hostName := range group.Hosts {
+				if host, exists := inv.Hosts[hostName]; exists && !containsString(host.Groups, groupName) {
+					host.Groups = append(host.Groups, groupName)
+				}
+			}
+			for _, childName := range group.Children {
+				if child, exists := inv.Groups[childName]; exists {
+					walk(child)
+				}
+			}
+		}
+		walk(inv.Groups[groupName])
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// GetHostVars returns all variables for a host (including group variables).
+// Like Ansible, variables of the groups the host belongs to, directly or
+// through a child group, are merged from the shallowest group to the
+// deepest, groups at the same depth in name order, and host variables win.
 func (inv *Inventory) GetHostVars(hostName string) map[string]interface{} {
+	inv.mutex.RLock()
+	defer inv.mutex.RUnlock()
+
 	vars := make(map[string]interface{})
 
 	// Start with 'all' group variables
@@ -607,14 +715,9 @@ func (inv *Inventory) GetHostVars(hostName string) map[string]interface{} {
 	}
 
 	// Add group variables for groups containing this host
-	for _, group := range inv.Groups {
-		for _, groupHostName := range group.Hosts {
-			if groupHostName == hostName {
-				for k, v := range group.Variables {
-					vars[k] = v
-				}
-				break
-			}
+	for _, groupName := range inv.hostGroupsByDepth(hostName) {
+		for k, v := range inv.Groups[groupName].Variables {
+			vars[k] = v
 		}
 	}
 
@@ -628,8 +731,84 @@ func (inv *Inventory) GetHostVars(hostName string) map[string]interface{} {
 	return vars
 }
 
+// hostGroupsByDepth returns the groups other than all that contain a host,
+// directly or through a child group, ordered by depth and then name
+func (inv *Inventory) hostGroupsByDepth(hostName string) []string {
+	depths := inv.groupDepths()
+
+	var groupNames []string
+	for groupName, group := range inv.Groups {
+		if group == inv.AllGroup {
+			continue
+		}
+		if inv.groupContainsHost(group, hostName, make(map[string]bool)) {
+			groupNames = append(groupNames, groupName)
+		}
+	}
+
+	sort.Slice(groupNames, func(i, j int) bool {
+		if depths[groupNames[i]] != depths[groupNames[j]] {
+			return depths[groupNames[i]] < depths[groupNames[j]]
+		}
+		return groupNames[i] < groupNames[j]
+	})
+	return groupNames
+}
+
+// groupContainsHost reports whether a host is in a group or its descendants
+func (inv *Inventory) groupContainsHost(group *Group, hostName string, seen map[string]bool) bool {
+	if seen[group.Name] {
+		return false
+	}
+	seen[group.Name] = true
+
+	if containsString(group.Hosts, hostName) {
+		return true
+	}
+	for _, childName := range group.Children {
+		if child, exists := inv.Groups[childName]; exists && inv.groupContainsHost(child, hostName, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// groupDepths returns the depth of every group: 0 for all, 1 for top-level
+// groups and one more than the deepest parent for child groups
+func (inv *Inventory) groupDepths() map[string]int {
+	depths := make(map[string]int, len(inv.Groups))
+	for groupName := range inv.Groups {
+		if groupName != "all" {
+			depths[groupName] = 1
+		}
+	}
+
+	// Relax parent-child edges; bounding the passes keeps cycles finite
+	for pass := 0; pass < len(inv.Groups); pass++ {
+		changed := false
+		for groupName, group := range inv.Groups {
+			if groupName == "all" {
+				continue
+			}
+			for _, childName := range group.Children {
+				if _, exists := depths[childName]; exists && depths[childName] < depths[groupName]+1 {
+					depths[childName] = depths[groupName] + 1
+					changed = true
+				}
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+	return depths
+}
+
 // GetGroupVars returns all variables for a group
 func (inv *Inventory) GetGroupVars(groupName string) map[string]interface{} {
+	inv.mutex.RLock()
+	defer inv.mutex.RUnlock()
+
 	if group, exists := inv.Groups[groupName]; exists {
 		// Return a copy to prevent modification
 		vars := make(map[string]interface{})
@@ -641,35 +820,38 @@ func (inv *Inventory) GetGroupVars(groupName string) map[string]interface{} {
 	return make(map[string]interface{})
 }
 
-// ListHosts returns a list of all host names
+// ListHosts returns a list of all host names in inventory order
 func (inv *Inventory) ListHosts() []string {
-	var hosts []string
-	for hostName := range inv.Hosts {
-		hosts = append(hosts, hostName)
-	}
-	return hosts
+	inv.mutex.RLock()
+	defer inv.mutex.RUnlock()
+	return inv.orderedHostNames()
 }
 
-// ListGroups returns a list of all group names
+// ListGroups returns a list of all group names in inventory order
 func (inv *Inventory) ListGroups() []string {
-	var groups []string
-	for groupName := range inv.Groups {
-		groups = append(groups, groupName)
+	inv.mutex.RLock()
+	defer inv.mutex.RUnlock()
+	return inv.orderedGroupNames()
+}
+
+// GroupHosts returns the hosts of every group, keyed by group name
+func (inv *Inventory) GroupHosts() map[string][]string {
+	inv.mutex.RLock()
+	defer inv.mutex.RUnlock()
+
+	groups := make(map[string][]string, len(inv.Groups))
+	for groupName, group := range inv.Groups {
+		groups[groupName] = append([]string{}, group.Hosts...)
 	}
 	return groups
 }
 
-// matchPattern performs simple wildcard pattern matching
+// matchPattern performs shell-style wildcard pattern matching
 func matchPattern(text, pattern string) (bool, error) {
-	// Convert glob pattern to regex
-	regexPattern := strings.ReplaceAll(pattern, "*", ".*")
-	regexPattern = "^" + regexPattern + "$"
-
-	matched, err := regexp.MatchString(regexPattern, text)
-	return matched, err
+	return regexp.MatchString(globToRegexp(pattern), text)
 }
 
-// LoadFromDirectory loads inventory from multiple files in a directory
+// LoadFromDirectory loads inventory from the files in a directory
 func (m *Manager) LoadFromDirectory(dirPath string) error {
 	// Check if directory exists
 	exists, err := afero.DirExists(m.fs, dirPath)
@@ -680,31 +862,12 @@ func (m *Manager) LoadFromDirectory(dirPath string) error {
 		return fmt.Errorf("directory %s does not exist", dirPath)
 	}
 
-	// Read directory contents
-	files, err := afero.ReadDir(m.fs, dirPath)
-	if err != nil {
-		return fmt.Errorf("failed to read directory %s: %w", dirPath, err)
+	m.sources = append(m.sources, dirPath)
+	if err := m.parseDirectory(dirPath); err != nil {
+		return err
 	}
-
-	// Load each inventory file
-	for _, file := range files {
-		if file.IsDir() {
-			continue
-		}
-
-		fileName := file.Name()
-		// Skip backup and temporary files
-		if strings.HasSuffix(fileName, "~") ||
-			strings.HasSuffix(fileName, ".bak") ||
-			strings.HasPrefix(fileName, ".") {
-			continue
-		}
-
-		filePath := dirPath + string(os.PathSeparator) + fileName
-		if err := m.LoadFromFile(filePath); err != nil {
-			return fmt.Errorf("failed to load inventory file %s: %w", filePath, err)
-		}
+	if err := m.loadVarsFiles(dirPath); err != nil {
+		return err
 	}
-
-	return nil
+	return m.runPending()
 }
@@ -518,7 +518,7 @@ func TestParseHostRange(t *testing.T) {
 	manager := NewManager(fs)
 
 	// Test parsing host range
-	err := manager.parseHostRange("web[1:3].example.com ansible_user=admin", "webservers")
+	err := manager.parseHostEntry("web[1:3].example.com ansible_user=admin", "webservers")
 	if err != nil {
 		t.Fatalf("Failed to parse host range: %v", err)
 	}
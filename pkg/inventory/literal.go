@@ -0,0 +1,339 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// shlexSplit splits an INI host line into tokens the way Python's
+// shlex.split(line, comments=True) does: whitespace separates tokens,
+// quotes group and are removed, backslashes escape outside single quotes
+// and an unquoted # ends the line
+func shlexSplit(line string) ([]string, error) {
+	var (
+		tokens  []string
+		current strings.Builder
+		quote   rune
+		inToken bool
+		escaped bool
+	)
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			// Inside double quotes only quotes and backslashes are escaped
+			if quote == '"' && r != '"' && r != '\\' {
+				current.WriteRune('\\')
+			}
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inToken = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inToken = true
+		case r == '#':
+			if inToken {
+				tokens = append(tokens, current.String())
+			}
+			return tokens, nil
+		case unicode.IsSpace(r):
+			if inToken {
+				tokens = append(tokens, current.String())
+				current.Reset()
+				inToken = false
+			}
+		default:
+			current.WriteRune(r)
+			inToken = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("no closing quotation")
+	}
+	if escaped {
+		return nil, fmt.Errorf("no escaped character")
+	}
+	if inToken {
+		tokens = append(tokens, current.String())
+	}
+	return tokens, nil
+}
+
+// parseINIValue interprets a host variable value as a Python literal, as
+// Ansible's ast.literal_eval does: numbers, quoted strings, True, False,
+// None, lists, tuples, dicts and sets. Anything else stays a string.
+func parseINIValue(value string) interface{} {
+	parser := &literalParser{src: value}
+	result, err := parser.parse()
+	if err != nil {
+		return value
+	}
+	return result
+}
+
+// literalParser parses the subset of Python expressions literal_eval
+// accepts
+type literalParser struct {
+	src string
+	pos int
+}
+
+func (p *literalParser) parse() (interface{}, error) {
+	value, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos != len(p.src) {
+		return nil, fmt.Errorf("unexpected %q", p.src[p.pos:])
+	}
+	return value, nil
+}
+
+func (p *literalParser) skipSpace() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *literalParser) value() (interface{}, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return nil, fmt.Errorf("unexpected end of literal")
+	}
+
+	switch ch := p.src[p.pos]; {
+	case ch == '[':
+		p.pos++
+		return p.sequence(']')
+	case ch == '(':
+		p.pos++
+		return p.tuple()
+	case ch == '{':
+		p.pos++
+		return p.mapping()
+	case ch == '\'' || ch == '"':
+		return p.str()
+	case ch == '-' || ch == '+' || ch == '.' || (ch >= '0' && ch <= '9'):
+		return p.number()
+	default:
+		start := p.pos
+		for p.pos < len(p.src) && (isLiteralNameChar(p.src[p.pos])) {
+			p.pos++
+		}
+		switch p.src[start:p.pos] {
+		case "True":
+			return true, nil
+		case "False":
+			return false, nil
+		case "None":
+			return nil, nil
+		}
+		return nil, fmt.Errorf("not a literal: %q", p.src[start:])
+	}
+}
+
+// sequence parses list items up to the closing bracket
+func (p *literalParser) sequence(closing byte) ([]interface{}, error) {
+	items := make([]interface{}, 0)
+	for {
+		p.skipSpace()
+		if p.pos < len(p.src) && p.src[p.pos] == closing {
+			p.pos++
+			return items, nil
+		}
+
+		item, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			return nil, fmt.Errorf("unterminated sequence")
+		}
+		switch p.src[p.pos] {
+		case ',':
+			p.pos++
+		case closing:
+		default:
+			return nil, fmt.Errorf("expected ',' or %q", closing)
+		}
+	}
+}
+
+// tuple parses a parenthesised expression, which is a tuple only when it
+// is empty or holds a comma
+func (p *literalParser) tuple() (interface{}, error) {
+	start := p.pos
+	items, err := p.sequence(')')
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 1 && !strings.Contains(strings.TrimSpace(p.src[start:p.pos-1]), ",") {
+		return items[0], nil
+	}
+	return items, nil
+}
+
+// mapping parses a dict or, when the items have no keys, a set
+func (p *literalParser) mapping() (interface{}, error) {
+	dict := make(map[string]interface{})
+	var set []interface{}
+
+	for {
+		p.skipSpace()
+		if p.pos < len(p.src) && p.src[p.pos] == '}' {
+			p.pos++
+			if set != nil {
+				return set, nil
+			}
+			return dict, nil
+		}
+
+		key, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+
+		if p.pos < len(p.src) && p.src[p.pos] == ':' && set == nil {
+			p.pos++
+			value, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			dict[fmt.Sprintf("%v", key)] = value
+		} else if len(dict) == 0 {
+			set = append(set, key)
+		} else {
+			return nil, fmt.Errorf("expected ':' in dict")
+		}
+
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			return nil, fmt.Errorf("unterminated dict")
+		}
+		switch p.src[p.pos] {
+		case ',':
+			p.pos++
+		case '}':
+		default:
+			return nil, fmt.Errorf("expected ',' or '}'")
+		}
+	}
+}
+
+// str parses a single or double quoted string with Python escapes.
+// Adjacent strings are concatenated, as in Python.
+func (p *literalParser) str() (string, error) {
+	var result strings.Builder
+	for p.pos < len(p.src) && (p.src[p.pos] == '\'' || p.src[p.pos] == '"') {
+		quote := p.src[p.pos]
+		end := p.pos + 1
+		for end < len(p.src) && p.src[end] != quote {
+			if p.src[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(p.src) {
+			return "", fmt.Errorf("unterminated string")
+		}
+
+		body := p.src[p.pos+1 : end]
+		if quote == '\'' {
+			// strconv unquotes Go syntax, where single quotes are runes
+			body = strings.ReplaceAll(strings.ReplaceAll(body, `\'`, `'`), `"`, `\"`)
+		}
+		text, err := strconv.Unquote(`"` + body + `"`)
+		if err != nil {
+			return "", err
+		}
+		result.WriteString(text)
+
+		p.pos = end + 1
+		p.skipSpace()
+	}
+	return result.String(), nil
+}
+
+// number parses an int, which may be signed, use underscores or a 0x, 0o
+// or 0b prefix, or a float. Ints with leading zeros are not literals.
+func (p *literalParser) number() (interface{}, error) {
+	start := p.pos
+	if p.src[p.pos] == '-' || p.src[p.pos] == '+' {
+		p.pos++
+		p.skipSpace()
+	}
+	digits := p.pos
+	for p.pos < len(p.src) && (isLiteralNameChar(p.src[p.pos]) || p.src[p.pos] == '.' ||
+		((p.src[p.pos] == '-' || p.src[p.pos] == '+') && (p.src[p.pos-1] == 'e' || p.src[p.pos-1] == 'E'))) {
+		p.pos++
+	}
+
+	sign := strings.TrimSpace(p.src[start:digits])
+	text := p.src[digits:p.pos]
+	if text == "" || strings.HasPrefix(text, "_") || strings.HasSuffix(text, "_") || strings.Contains(text, "__") {
+		return nil, fmt.Errorf("invalid number %q", p.src[start:p.pos])
+	}
+	clean := strings.ReplaceAll(text, "_", "")
+
+	lower := strings.ToLower(clean)
+	isFloat := !strings.HasPrefix(lower, "0x") && strings.ContainsAny(lower, ".e")
+	if isFloat {
+		value, err := strconv.ParseFloat(sign+clean, 64)
+		if err != nil {
+			return nil, err
+		}
+		return value, nil
+	}
+
+	if len(clean) > 1 && clean[0] == '0' && (lower[1] >= '0' && lower[1] <= '9') {
+		if strings.Trim(clean, "0") != "" {
+			return nil, fmt.Errorf("leading zeros in %q", text)
+		}
+	}
+	value, ok := new(big.Int).SetString(sign+clean, 0)
+	if !ok {
+		return nil, fmt.Errorf("invalid number %q", p.src[start:p.pos])
+	}
+	if value.IsInt64() {
+		return int(value.Int64()), nil
+	}
+	// Too big for an int: keep the digits
+	return value.String(), nil
+}
+
+func isLiteralNameChar(ch byte) bool {
+	return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9')
+}
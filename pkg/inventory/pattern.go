@@ -0,0 +1,482 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+var (
+	// patternSeparator splits colon separated patterns, keeping bracketed
+	// subscripts such as web[1:3] together
+	patternSeparator = regexp.MustCompile(`(?:[^\s:\[\]]|\[[^\]]*\])+`)
+
+	// patternWithSubscript matches web[0], web[-1], web[1:3] and web[1:]
+	patternWithSubscript = regexp.MustCompile(`^(.+)\[(?:(-?[0-9]+)|([0-9]+)([:-])([0-9]+)?)\]$`)
+)
+
+// subscript selects hosts by position from the hosts a pattern matched.
+// Slices include their end, as in Ansible.
+type subscript struct {
+	start    int
+	end      int
+	hasEnd   bool
+	isSlice  bool
+	toTheEnd bool
+}
+
+// SplitHostPattern splits a host pattern into its terms. Terms are
+// separated by commas or, when there are none, by colons. Intersections
+// and exclusions keep their & and ! prefixes.
+func SplitHostPattern(pattern string) []string {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		return nil
+	}
+
+	var terms []string
+	switch {
+	case strings.Contains(pattern, ","):
+		terms = strings.Split(pattern, ",")
+	case net.ParseIP(pattern) != nil:
+		// An IPv6 address is a single term, not a list of hex numbers
+		terms = []string{pattern}
+	default:
+		terms = patternSeparator.FindAllString(pattern, -1)
+	}
+
+	result := make([]string, 0, len(terms))
+	for _, term := range terms {
+		if term = strings.TrimSpace(term); term != "" {
+			result = append(result, term)
+		}
+	}
+	return result
+}
+
+// Subset limits the hosts returned by GetHosts to those also matching
+// limit, as --limit does. Terms of the form @file read further terms from
+// file, one per line. An empty limit removes the subset.
+func (m *Manager) Subset(limit string) error {
+	if strings.TrimSpace(limit) == "" {
+		m.subset = nil
+		return nil
+	}
+
+	var subset []string
+	for _, term := range SplitHostPattern(limit) {
+		if !strings.HasPrefix(term, "@") {
+			subset = append(subset, term)
+			continue
+		}
+
+		filename := term[1:]
+		data, err := afero.ReadFile(m.fs, filename)
+		if err != nil {
+			return fmt.Errorf("unable to read limit file %s: %w", filename, err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				subset = append(subset, line)
+			}
+		}
+	}
+	m.subset = subset
+	return nil
+}
+
+// GetHosts returns the hosts matching a host pattern, restricted to the
+// subset when one is set. Patterns may combine unions (a:b or a,b),
+// intersections (a:&b), exclusions (a:!b), regular expressions (~web\d+),
+// wildcards (web*) and subscripts (web[0], web[-1], web[1:3]). Hosts are
+// returned in the order the pattern selects them, which follows the order
+// of the inventory.
+func (m *Manager) GetHosts(pattern string) ([]*Host, error) {
+	// add_host and group_by may change the inventory while patterns are
+	// evaluated; the helpers below expect the read lock to be held
+	m.inventory.mutex.RLock()
+	defer m.inventory.mutex.RUnlock()
+
+	hosts, err := m.evaluatePatterns(SplitHostPattern(pattern))
+	if err != nil {
+		return nil, err
+	}
+
+	if m.subset != nil {
+		allowed, err := m.evaluatePatterns(m.subset)
+		if err != nil {
+			return nil, err
+		}
+		hosts = intersectHosts(hosts, allowed)
+	}
+
+	return hosts, nil
+}
+
+// evaluatePatterns evaluates unions first, then intersections, then
+// exclusions. With only intersections and exclusions, they apply to all.
+// The caller holds the inventory's read lock.
+func (m *Manager) evaluatePatterns(patterns []string) ([]*Host, error) {
+	var regular, intersect, exclude []string
+	for _, pattern := range patterns {
+		switch pattern[0] {
+		case '&':
+			intersect = append(intersect, pattern)
+		case '!':
+			exclude = append(exclude, pattern)
+		default:
+			regular = append(regular, pattern)
+		}
+	}
+	if len(regular) == 0 {
+		regular = []string{"all"}
+	}
+
+	var hosts []*Host
+	seen := make(map[string]bool)
+	for _, pattern := range append(append(regular, intersect...), exclude...) {
+		var matched []*Host
+		if host, exists := m.inventory.Hosts[pattern]; exists {
+			matched = []*Host{host}
+		} else {
+			var err error
+			if matched, err = m.matchOnePattern(pattern); err != nil {
+				return nil, err
+			}
+		}
+
+		switch pattern[0] {
+		case '&':
+			hosts = intersectHosts(hosts, matched)
+		case '!':
+			hosts = excludeHosts(hosts, matched)
+		default:
+			for _, host := range matched {
+				if !seen[host.Name] {
+					seen[host.Name] = true
+					hosts = append(hosts, host)
+				}
+			}
+			continue
+		}
+
+		seen = make(map[string]bool, len(hosts))
+		for _, host := range hosts {
+			seen[host.Name] = true
+		}
+	}
+	return hosts, nil
+}
+
+// matchOnePattern matches a single term, without its & or ! prefix, and
+// applies its subscript
+func (m *Manager) matchOnePattern(pattern string) ([]*Host, error) {
+	pattern = strings.TrimLeft(pattern, "&!")
+	if pattern == "" {
+		return nil, nil
+	}
+
+	pattern, sub, err := splitSubscript(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts, err := m.enumerateMatches(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return applySubscript(hosts, sub), nil
+}
+
+// enumerateMatches returns the hosts of the groups matching pattern and,
+// when no group matched or the pattern is a wildcard or regex, the hosts
+// whose names match it
+func (m *Manager) enumerateMatches(pattern string) ([]*Host, error) {
+	matcher, err := compileHostPattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []*Host
+	seen := make(map[string]bool)
+	add := func(host *Host) {
+		if !seen[host.Name] {
+			seen[host.Name] = true
+			hosts = append(hosts, host)
+		}
+	}
+
+	matchedGroup := false
+	for _, groupName := range m.inventory.orderedGroupNames() {
+		if !matcher.MatchString(groupName) {
+			continue
+		}
+		matchedGroup = true
+		for _, host := range m.groupHosts(m.inventory.Groups[groupName]) {
+			add(host)
+		}
+	}
+
+	if !matchedGroup || pattern[0] == '~' || strings.ContainsAny(pattern, ".?*[") {
+		for _, hostName := range m.inventory.orderedHostNames() {
+			if matcher.MatchString(hostName) {
+				add(m.inventory.Hosts[hostName])
+			}
+		}
+	}
+
+	return hosts, nil
+}
+
+// groupHosts returns the hosts of a group and its descendants, the group's
+// own hosts first
+func (m *Manager) groupHosts(group *Group) []*Host {
+	if group == m.inventory.AllGroup {
+		hosts := make([]*Host, 0, len(m.inventory.Hosts))
+		for _, hostName := range m.inventory.orderedHostNames() {
+			hosts = append(hosts, m.inventory.Hosts[hostName])
+		}
+		return hosts
+	}
+
+	var hosts []*Host
+	seenHosts := make(map[string]bool)
+	seenGroups := make(map[string]bool)
+
+	var walk func(group *Group)
+	walk = func(group *Group) {
+		if seenGroups[group.Name] {
+			return
+		}
+		seenGroups[group.Name] = true
+
+		for _, hostName := range group.Hosts {
+			if host, exists := m.inventory.Hosts[hostName]; exists && !seenHosts[hostName] {
+				seenHosts[hostName] = true
+				hosts = append(hosts, host)
+			}
+		}
+		for _, childName := range group.Children {
+			if child, exists := m.inventory.Groups[childName]; exists {
+				walk(child)
+			}
+		}
+	}
+	walk(group)
+
+	return hosts
+}
+
+// compileHostPattern compiles a ~regex, which matches from the start of a
+// name, or a shell-style wildcard, which matches whole names
+func compileHostPattern(pattern string) (*regexp.Regexp, error) {
+	if strings.HasPrefix(pattern, "~") {
+		re, err := regexp.Compile("^(?:" + pattern[1:] + ")")
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression in host pattern '%s': %v", pattern, err)
+		}
+		return re, nil
+	}
+	return regexp.Compile(globToRegexp(pattern))
+}
+
+// globToRegexp translates a shell-style wildcard into an anchored regular
+// expression, supporting *, ? and [seq] / [!seq]
+func globToRegexp(pattern string) string {
+	runes := []rune(pattern)
+
+	var b strings.Builder
+	b.WriteString("^(?s:")
+	for i := 0; i < len(runes); i++ {
+		switch c := runes[i]; c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '[':
+			j := i + 1
+			if j < len(runes) && runes[j] == '!' {
+				j++
+			}
+			if j < len(runes) && runes[j] == ']' {
+				j++
+			}
+			for j < len(runes) && runes[j] != ']' {
+				j++
+			}
+			if j >= len(runes) {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := strings.ReplaceAll(string(runes[i+1:j]), `\`, `\\`)
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i = j
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString(")$")
+	return b.String()
+}
+
+// splitSubscript splits web[1:3] into web and its subscript
+func splitSubscript(pattern string) (string, *subscript, error) {
+	if strings.HasPrefix(pattern, "~") {
+		return pattern, nil, nil
+	}
+
+	match := patternWithSubscript.FindStringSubmatch(pattern)
+	if match == nil {
+		return pattern, nil, nil
+	}
+
+	if match[2] != "" {
+		index, err := strconv.Atoi(match[2])
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid subscript in host pattern '%s': %v", pattern, err)
+		}
+		return match[1], &subscript{start: index}, nil
+	}
+
+	start, err := strconv.Atoi(match[3])
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid subscript in host pattern '%s': %v", pattern, err)
+	}
+	sub := &subscript{start: start, isSlice: true}
+	if match[5] == "" {
+		sub.toTheEnd = true
+	} else if sub.end, err = strconv.Atoi(match[5]); err != nil {
+		return "", nil, fmt.Errorf("invalid subscript in host pattern '%s': %v", pattern, err)
+	}
+	return match[1], sub, nil
+}
+
+// applySubscript selects hosts by index or by an inclusive slice.
+// Negative indexes count from the end; out of range selections are empty.
+func applySubscript(hosts []*Host, sub *subscript) []*Host {
+	if sub == nil {
+		return hosts
+	}
+
+	if !sub.isSlice {
+		index := sub.start
+		if index < 0 {
+			index += len(hosts)
+		}
+		if index < 0 || index >= len(hosts) {
+			return nil
+		}
+		return hosts[index : index+1]
+	}
+
+	end := sub.end + 1
+	if sub.toTheEnd || end > len(hosts) {
+		end = len(hosts)
+	}
+	if sub.start >= end {
+		return nil
+	}
+	return hosts[sub.start:end]
+}
+
+// intersectHosts keeps the hosts that are also in other
+func intersectHosts(hosts, other []*Host) []*Host {
+	keep := make(map[string]bool, len(other))
+	for _, host := range other {
+		keep[host.Name] = true
+	}
+
+	result := make([]*Host, 0, len(hosts))
+	for _, host := range hosts {
+		if keep[host.Name] {
+			result = append(result, host)
+		}
+	}
+	return result
+}
+
+// excludeHosts drops the hosts that are in other
+func excludeHosts(hosts, other []*Host) []*Host {
+	drop := make(map[string]bool, len(other))
+	for _, host := range other {
+		drop[host.Name] = true
+	}
+
+	result := make([]*Host, 0, len(hosts))
+	for _, host := range hosts {
+		if !drop[host.Name] {
+			result = append(result, host)
+		}
+	}
+	return result
+}
+
+// orderedHostNames returns the host names in the order they were added.
+// Hosts added to the map directly follow, sorted by name.
+func (inv *Inventory) orderedHostNames() []string {
+	return orderedNames(inv.hostOrder, len(inv.Hosts), func(name string) bool {
+		_, exists := inv.Hosts[name]
+		return exists
+	}, func(visit func(string)) {
+		for name := range inv.Hosts {
+			visit(name)
+		}
+	})
+}
+
+// orderedGroupNames returns the group names in the order they were added.
+// Groups added to the map directly follow, sorted by name.
+func (inv *Inventory) orderedGroupNames() []string {
+	return orderedNames(inv.groupOrder, len(inv.Groups), func(name string) bool {
+		_, exists := inv.Groups[name]
+		return exists
+	}, func(visit func(string)) {
+		for name := range inv.Groups {
+			visit(name)
+		}
+	})
+}
+
+func orderedNames(order []string, size int, exists func(string) bool, each func(func(string))) []string {
+	names := make([]string, 0, size)
+	seen := make(map[string]bool, size)
+	for _, name := range order {
+		if !seen[name] && exists(name) {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	var rest []string
+	each(func(name string) {
+		if !seen[name] {
+			rest = append(rest, name)
+		}
+	})
+	sort.Strings(rest)
+	return append(names, rest...)
+}
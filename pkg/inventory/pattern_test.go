@@ -0,0 +1,198 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// newPatternManager builds the inventory
+//
+//	lb1
+//	[web]     web3 web1 web2
+//	[db]      db2 db1
+//	[canary]  web1 db1
+//	[prod]    children web, db
+//
+// without resolving the host names
+func newPatternManager(t *testing.T) *Manager {
+	t.Helper()
+	manager := NewManager(afero.NewMemMapFs())
+	inv := manager.GetInventory()
+
+	addHosts := func(groupName string, hostNames ...string) {
+		group := inv.GetOrCreateGroup(groupName)
+		for _, name := range hostNames {
+			if _, exists := inv.Hosts[name]; !exists {
+				inv.Hosts[name] = &Host{Name: name, Variables: map[string]interface{}{}}
+				inv.hostOrder = append(inv.hostOrder, name)
+			}
+			if groupName != "all" {
+				group.AddHost(name)
+			}
+		}
+	}
+	addHosts("all", "lb1")
+	addHosts("web", "web3", "web1", "web2")
+	addHosts("db", "db2", "db1")
+	addHosts("canary", "web1", "db1")
+	prod := inv.GetOrCreateGroup("prod")
+	prod.AddChild("web")
+	prod.AddChild("db")
+	inv.UpdateAllGroup()
+
+	return manager
+}
+
+func hostNames(hosts []*Host) []string {
+	names := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		names = append(names, host.Name)
+	}
+	return names
+}
+
+func TestSplitHostPattern(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		expected []string
+	}{
+		{"all", []string{"all"}},
+		{"web:db", []string{"web", "db"}},
+		{"web, db ,", []string{"web", "db"}},
+		{"web:&canary:!db1", []string{"web", "&canary", "!db1"}},
+		{"web[1:3]:db[0]", []string{"web[1:3]", "db[0]"}},
+		{"fe80::1", []string{"fe80::1"}},
+		{"", nil},
+	}
+	for _, test := range tests {
+		if got := SplitHostPattern(test.pattern); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("SplitHostPattern(%q) = %v, want %v", test.pattern, got, test.expected)
+		}
+	}
+}
+
+func TestManager_GetHosts_Patterns(t *testing.T) {
+	manager := newPatternManager(t)
+
+	tests := []struct {
+		pattern  string
+		expected []string
+	}{
+		{"all", []string{"lb1", "web3", "web1", "web2", "db2", "db1"}},
+		{"*", []string{"lb1", "web3", "web1", "web2", "db2", "db1"}},
+		{"web", []string{"web3", "web1", "web2"}},
+		{"prod", []string{"web3", "web1", "web2", "db2", "db1"}},
+		{"ungrouped", []string{"lb1"}},
+		{"db:web", []string{"db2", "db1", "web3", "web1", "web2"}},
+		{"db1,lb1", []string{"db1", "lb1"}},
+		{"prod:&canary", []string{"web1", "db1"}},
+		{"prod:!canary", []string{"web3", "web2", "db2"}},
+		{"!web", []string{"lb1", "db2", "db1"}},
+		{"&canary", []string{"web1", "db1"}},
+		{"web*", []string{"web3", "web1", "web2"}},
+		{"db?", []string{"db2", "db1"}},
+		{"~(web|db)[12]", []string{"web1", "web2", "db2", "db1"}},
+		{"web[0]", []string{"web3"}},
+		{"web[-1]", []string{"web2"}},
+		{"web[1:2]", []string{"web1", "web2"}},
+		{"prod[3:]", []string{"db2", "db1"}},
+		{"web[7]", []string{}},
+		{"nonexistent", []string{}},
+	}
+	for _, test := range tests {
+		hosts, err := manager.GetHosts(test.pattern)
+		if err != nil {
+			t.Errorf("GetHosts(%q) error = %v", test.pattern, err)
+			continue
+		}
+		if got := hostNames(hosts); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("GetHosts(%q) = %v, want %v", test.pattern, got, test.expected)
+		}
+	}
+
+	if _, err := manager.GetHosts("~web("); err == nil {
+		t.Error("expected an error for an invalid regular expression")
+	}
+}
+
+func TestManager_Subset(t *testing.T) {
+	manager := newPatternManager(t)
+	afero.WriteFile(manager.fs, "/tmp/retry", []byte("web2\n\ndb1\n"), 0644)
+
+	tests := []struct {
+		limit    string
+		pattern  string
+		expected []string
+	}{
+		{"canary", "all", []string{"web1", "db1"}},
+		{"web:!web1", "prod", []string{"web3", "web2"}},
+		{"@/tmp/retry", "all", []string{"web2", "db1"}},
+		{"@/tmp/retry,lb1", "~.*1", []string{"lb1", "db1"}},
+		{"", "web", []string{"web3", "web1", "web2"}},
+	}
+	for _, test := range tests {
+		if err := manager.Subset(test.limit); err != nil {
+			t.Fatalf("Subset(%q) error = %v", test.limit, err)
+		}
+		hosts, err := manager.GetHosts(test.pattern)
+		if err != nil {
+			t.Fatalf("GetHosts(%q) error = %v", test.pattern, err)
+		}
+		if got := hostNames(hosts); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("GetHosts(%q) with limit %q = %v, want %v", test.pattern, test.limit, got, test.expected)
+		}
+	}
+
+	if err := manager.Subset("@/tmp/missing"); err == nil {
+		t.Error("expected an error for a missing limit file")
+	}
+}
+
+func TestManager_GetHosts_ConcurrentAddHost(t *testing.T) {
+	manager := newPatternManager(t)
+	inv := manager.GetInventory()
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 20; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			inv.AddHost(fmt.Sprintf("192.0.2.%d", i), []string{fmt.Sprintf("added_%d", i%3)}, nil)
+		}(i)
+		go func() {
+			defer wg.Done()
+			if _, err := manager.GetHosts("added_*:prod:!canary"); err != nil {
+				t.Errorf("GetHosts error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	hosts, err := manager.GetHosts("added_*")
+	if err != nil {
+		t.Fatalf("GetHosts error = %v", err)
+	}
+	if len(hosts) != 20 {
+		t.Errorf("Expected 20 added hosts, got %v", hostNames(hosts))
+	}
+}
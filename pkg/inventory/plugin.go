@@ -0,0 +1,277 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+	"github.com/work-obs/ansible-go/pkg/config"
+)
+
+// DefaultEnabledPlugins are the inventory plugins tried on each source
+// when the configuration does not set inventory_enabled. advanced_host_list
+// follows host_list so inline lists with ranges work out of the box, and
+// terraform_state precedes ini so state files are not read as INI.
+var DefaultEnabledPlugins = []string{"host_list", "advanced_host_list", "script", "auto", "yaml", "terraform_state", "ini", "toml"}
+
+// DefaultIgnoreExtensions are the file suffixes skipped when loading an
+// inventory directory
+var DefaultIgnoreExtensions = []string{"~", ".orig", ".bak", ".ini", ".cfg", ".retry", ".pyc", ".pyo"}
+
+// yamlExtensions are the extensions the yaml plugin accepts, besides none
+var yamlExtensions = []string{".yaml", ".yml", ".json"}
+
+// Plugin is an inventory plugin. VerifyFile cheaply checks whether the
+// plugin can handle a source; Parse adds the source's hosts and groups to
+// the manager's inventory.
+type Plugin interface {
+	Name() string
+	VerifyFile(m *Manager, source string) bool
+	Parse(m *Manager, source string) error
+}
+
+// RegisterPlugin makes an inventory plugin available by name. Only
+// plugins named in inventory_enabled are tried.
+func (m *Manager) RegisterPlugin(plugin Plugin) {
+	m.plugins[plugin.Name()] = plugin
+}
+
+// SetConfig applies the inventory settings of cfg
+func (m *Manager) SetConfig(cfg *config.Config) {
+	if len(cfg.InventoryEnabled) > 0 {
+		m.enabledPlugins = append([]string{}, cfg.InventoryEnabled...)
+	}
+	if cfg.InventoryIgnoreRegex != nil {
+		m.ignoreExtensions = append([]string{}, cfg.InventoryIgnoreRegex...)
+	}
+	if cfg.HostPatternMismatch != "" {
+		m.validationMode = cfg.HostPatternMismatch
+	}
+	m.setCacheConfig(cfg)
+}
+
+// registerBuiltinPlugins registers the inventory plugins shipped with the
+// package
+func (m *Manager) registerBuiltinPlugins() {
+	m.RegisterPlugin(&hostListPlugin{})
+	m.RegisterPlugin(&advancedHostListPlugin{})
+	m.RegisterPlugin(&scriptPlugin{})
+	m.RegisterPlugin(&autoPlugin{})
+	m.RegisterPlugin(&constructedPlugin{})
+	m.RegisterPlugin(&terraformPlugin{})
+	m.RegisterPlugin(&yamlPlugin{})
+	m.RegisterPlugin(&iniPlugin{})
+}
+
+// runPending runs the work plugins deferred until every source is loaded,
+// such as the constructed plugin's
+func (m *Manager) runPending() error {
+	pending := m.pending
+	m.pending = nil
+	for _, run := range pending {
+		if err := run(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseSource loads a source, recursing into directories and going
+// through the inventory cache for sources that use it
+func (m *Manager) parseSource(source string) error {
+	if info, err := m.fs.Stat(source); err == nil && info.IsDir() {
+		return m.parseDirectory(source)
+	}
+
+	options, err := m.sourceCacheOptions(source)
+	if err != nil {
+		return err
+	}
+	if options.enabled {
+		return m.parseCachedSource(source, options)
+	}
+	return m.parseFile(source)
+}
+
+// parseFile loads a source other than a directory. It is offered to the
+// enabled plugins in order until one parses it; when none does, the error
+// of every plugin that tried is reported.
+func (m *Manager) parseFile(source string) error {
+	var failures []string
+	verified := false
+	for _, name := range m.enabledPlugins {
+		plugin, exists := m.plugins[strings.TrimPrefix(name, "ansible.builtin.")]
+		if !exists || !plugin.VerifyFile(m, source) {
+			continue
+		}
+		verified = true
+
+		err := plugin.Parse(m, source)
+		if err == nil {
+			return nil
+		}
+		failures = append(failures, fmt.Sprintf("%s: %v", plugin.Name(), err))
+	}
+
+	if !verified {
+		if exists, _ := afero.Exists(m.fs, source); !exists {
+			return fmt.Errorf("inventory source %s does not exist", source)
+		}
+		return fmt.Errorf("no enabled inventory plugin accepts %s (enabled: %s)", source, strings.Join(m.enabledPlugins, ", "))
+	}
+	return fmt.Errorf("failed to parse inventory source %s:\n  %s", source, strings.Join(failures, "\n  "))
+}
+
+// parseDirectory loads every source in a directory in name order, skipping
+// hidden files, ignored extensions and group_vars and host_vars
+func (m *Manager) parseDirectory(dirPath string) error {
+	entries, err := afero.ReadDir(m.fs, dirPath)
+	if err != nil {
+		return fmt.Errorf("failed to read directory %s: %w", dirPath, err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") || m.ignoredFile(name) {
+			continue
+		}
+		if entry.IsDir() && (name == "group_vars" || name == "host_vars") {
+			continue
+		}
+
+		path := filepath.Join(dirPath, name)
+		if err := m.parseSource(path); err != nil {
+			return fmt.Errorf("failed to load inventory file %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// ignoredFile reports whether a file in an inventory directory is skipped
+func (m *Manager) ignoredFile(name string) bool {
+	for _, suffix := range m.ignoreExtensions {
+		if suffix != "" && strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isFile reports whether source is an existing regular file
+func (m *Manager) isFile(source string) bool {
+	info, err := m.fs.Stat(source)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// scriptPlugin runs executable dynamic inventory scripts
+type scriptPlugin struct{}
+
+func (p *scriptPlugin) Name() string { return "script" }
+
+func (p *scriptPlugin) VerifyFile(m *Manager, source string) bool {
+	return m.isInventoryScript(source)
+}
+
+func (p *scriptPlugin) Parse(m *Manager, source string) error {
+	return m.loadFromScript(source)
+}
+
+// yamlPlugin parses YAML and JSON inventory files
+type yamlPlugin struct{}
+
+func (p *yamlPlugin) Name() string { return "yaml" }
+
+func (p *yamlPlugin) VerifyFile(m *Manager, source string) bool {
+	if !m.isFile(source) {
+		return false
+	}
+	ext := strings.ToLower(filepath.Ext(source))
+	if ext == "" {
+		return true
+	}
+	for _, valid := range yamlExtensions {
+		if ext == valid {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *yamlPlugin) Parse(m *Manager, source string) error {
+	data, err := afero.ReadFile(m.fs, source)
+	if err != nil {
+		return fmt.Errorf("failed to read inventory file %s: %w", source, err)
+	}
+
+	root, err := yamlInventoryRoot(data)
+	if err != nil {
+		return err
+	}
+	if root == nil {
+		return fmt.Errorf("parsed an empty YAML file")
+	}
+	for i := 0; i < len(root.Content); i += 2 {
+		if root.Content[i].Value == "plugin" {
+			return fmt.Errorf("plugin configuration YAML file, not YAML inventory")
+		}
+	}
+	return m.parseYAMLInventory(root)
+}
+
+// iniPlugin parses INI inventory files
+type iniPlugin struct{}
+
+func (p *iniPlugin) Name() string { return "ini" }
+
+func (p *iniPlugin) VerifyFile(m *Manager, source string) bool {
+	if !m.isFile(source) {
+		return false
+	}
+	// YAML and JSON files the yaml plugin rejected are not INI files
+	// either, and neither are Terraform state files, which are JSON
+	ext := strings.ToLower(filepath.Ext(source))
+	if ext == ".tfstate" {
+		return false
+	}
+	for _, yamlExt := range yamlExtensions {
+		if ext == yamlExt {
+			return false
+		}
+	}
+
+	// Nor are scripts the script plugin failed to run
+	file, err := m.fs.Open(source)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	shebang := make([]byte, 2)
+	n, _ := io.ReadFull(file, shebang)
+	return string(shebang[:n]) != "#!"
+}
+
+func (p *iniPlugin) Parse(m *Manager, source string) error {
+	data, err := afero.ReadFile(m.fs, source)
+	if err != nil {
+		return fmt.Errorf("failed to read inventory file %s: %w", source, err)
+	}
+	return m.loadFromINI(data)
+}
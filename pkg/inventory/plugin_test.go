@@ -0,0 +1,135 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/work-obs/ansible-go/pkg/config"
+)
+
+// recordingPlugin accepts sources with its prefix and fails or adds a host
+type recordingPlugin struct {
+	name   string
+	prefix string
+	fail   bool
+	parsed []string
+}
+
+func (p *recordingPlugin) Name() string { return p.name }
+
+func (p *recordingPlugin) VerifyFile(m *Manager, source string) bool {
+	return strings.HasPrefix(source, p.prefix)
+}
+
+func (p *recordingPlugin) Parse(m *Manager, source string) error {
+	p.parsed = append(p.parsed, source)
+	if p.fail {
+		return fmt.Errorf("%s cannot read %s", p.name, source)
+	}
+	m.GetInventory().Hosts[p.name] = &Host{Name: p.name}
+	return nil
+}
+
+func TestManager_InventoryPlugins(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/inv/hosts", []byte("[web]\nweb1\n"), 0644)
+	afero.WriteFile(fs, "/inv/cloud.yml", []byte("cloud:\n  hosts:\n    db1:\n"), 0644)
+	afero.WriteFile(fs, "/inv/old.bak", []byte("[old]\nold1\n"), 0644)
+	afero.WriteFile(fs, "/inv/group_vars/all.yml", []byte("ntp: pool\n"), 0644)
+	afero.WriteFile(fs, "/inv/nested/static", []byte("cache1\n"), 0644)
+
+	manager := NewManager(fs)
+	if err := manager.LoadFromFile("/inv"); err != nil {
+		t.Fatalf("LoadFromFile() error = %v", err)
+	}
+
+	hosts := manager.GetInventory().ListHosts()
+	sort.Strings(hosts)
+	if !reflect.DeepEqual(hosts, []string{"cache1", "db1", "web1"}) {
+		t.Errorf("hosts = %v", hosts)
+	}
+	if _, exists := manager.GetInventory().Groups["old"]; exists {
+		t.Error("files with ignored extensions must be skipped")
+	}
+}
+
+func TestManager_InventoryPlugins_Order(t *testing.T) {
+	first := &recordingPlugin{name: "first", prefix: "/src", fail: true}
+	second := &recordingPlugin{name: "second", prefix: "/src"}
+	unused := &recordingPlugin{name: "unused", prefix: "/src"}
+
+	manager := NewManager(afero.NewMemMapFs())
+	manager.RegisterPlugin(first)
+	manager.RegisterPlugin(second)
+	manager.RegisterPlugin(unused)
+	manager.SetConfig(&config.Config{InventoryEnabled: []string{"first", "second", "unused"}})
+
+	if err := manager.LoadFromFile("/src/a"); err != nil {
+		t.Fatalf("LoadFromFile() error = %v", err)
+	}
+	if len(first.parsed) != 1 || len(second.parsed) != 1 || len(unused.parsed) != 0 {
+		t.Errorf("parsed: first=%v second=%v unused=%v", first.parsed, second.parsed, unused.parsed)
+	}
+	if _, exists := manager.GetInventory().Hosts["second"]; !exists {
+		t.Error("expected the second plugin's host")
+	}
+
+	// Only enabled plugins are tried
+	manager.SetConfig(&config.Config{InventoryEnabled: []string{"ansible.builtin.unused"}})
+	if err := manager.LoadFromFile("/src/b"); err != nil || len(unused.parsed) != 1 {
+		t.Errorf("expected the unused plugin to parse /src/b: %v, %v", unused.parsed, err)
+	}
+}
+
+func TestManager_InventoryPlugins_Errors(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/broken", []byte("[web]\nweb1\n"), 0644)
+	afero.WriteFile(fs, "/broken.yml", []byte("all: [unclosed\n"), 0644)
+
+	manager := NewManager(fs)
+	manager.RegisterPlugin(&recordingPlugin{name: "custom", prefix: "/broken", fail: true})
+	manager.SetConfig(&config.Config{InventoryEnabled: []string{"custom", "yaml", "ini"}})
+
+	// Every plugin that tried reports its error
+	err := manager.LoadFromFile("/broken.yml")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, expected := range []string{"custom: custom cannot read /broken.yml", "yaml: failed to parse YAML inventory"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in %v", expected, err)
+		}
+	}
+	if strings.Contains(err.Error(), "ini:") {
+		t.Errorf("the ini plugin must not try YAML files: %v", err)
+	}
+
+	// Extensionless files fall through to the ini plugin
+	if err := manager.LoadFromFile("/broken"); err != nil {
+		t.Errorf("expected the ini plugin to parse /broken, got %v", err)
+	}
+
+	if err := manager.LoadFromFile("/missing"); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("expected a missing source error, got %v", err)
+	}
+}
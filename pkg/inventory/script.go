@@ -0,0 +1,356 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// DefaultScriptTimeout bounds each run of a dynamic inventory script
+const DefaultScriptTimeout = 30 * time.Second
+
+// scriptGroup is a group as printed by an inventory script's --list
+type scriptGroup struct {
+	Hosts    []string
+	Vars     map[string]interface{}
+	Children []string
+}
+
+// SetScriptTimeout sets how long a dynamic inventory script may run
+func (m *Manager) SetScriptTimeout(timeout time.Duration) {
+	m.scriptTimeout = timeout
+}
+
+// isInventoryScript reports whether filename is an executable to run as a
+// dynamic inventory script rather than a file to parse
+func (m *Manager) isInventoryScript(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yml", ".yaml", ".json", ".ini", ".cfg":
+		return false
+	}
+
+	info, err := m.fs.Stat(filename)
+	if err != nil {
+		return false
+	}
+	return info.Mode().IsRegular() && info.Mode().Perm()&0111 != 0
+}
+
+// loadFromScript runs a dynamic inventory script with --list. Host
+// variables come from _meta.hostvars or, when the script does not print
+// _meta, from running it with --host for every host.
+func (m *Manager) loadFromScript(path string) error {
+	if _, ok := m.fs.(*afero.OsFs); !ok {
+		return fmt.Errorf("inventory script %s can only be run from the local filesystem", path)
+	}
+
+	output, err := m.runScript(path, "--list")
+	if err != nil {
+		return err
+	}
+
+	names, groups, meta, err := parseScriptList(output)
+	if err != nil {
+		return fmt.Errorf("inventory script %s returned invalid output for --list: %w", path, err)
+	}
+
+	var hostOrder []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		data := groups[name]
+		group := m.inventory.GetOrCreateGroup(name)
+		for key, value := range data.Vars {
+			group.Variables[key] = value
+		}
+		for _, hostName := range data.Hosts {
+			m.inventory.GetOrCreateHost(hostName)
+			if group != m.inventory.AllGroup {
+				group.AddHost(hostName)
+			}
+			if !seen[hostName] {
+				seen[hostName] = true
+				hostOrder = append(hostOrder, hostName)
+			}
+		}
+		for _, childName := range data.Children {
+			m.inventory.GetOrCreateGroup(childName)
+			group.AddChild(childName)
+		}
+	}
+
+	for _, hostName := range hostOrder {
+		var hostVars map[string]interface{}
+		if meta != nil {
+			hostVars = meta[hostName]
+		} else {
+			output, err := m.runScript(path, "--host", hostName)
+			if err != nil {
+				return err
+			}
+			if hostVars, err = decodeScriptVars(output); err != nil {
+				return fmt.Errorf("inventory script %s returned invalid output for --host %s: %w", path, hostName, err)
+			}
+		}
+		m.recordHostDefinition(hostName, hostVars)
+		setHostVariables(m.inventory.Hosts[hostName], hostVars)
+	}
+
+	m.inventory.UpdateAllGroup()
+	return nil
+}
+
+// runScript runs an inventory script and returns what it printed, turning
+// failures and timeouts into errors that name the script and its stderr
+func (m *Manager) runScript(path string, args ...string) ([]byte, error) {
+	timeout := m.scriptTimeout
+	if timeout <= 0 {
+		timeout = DefaultScriptTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// Do not wait on children that keep the output pipes open
+	cmd.WaitDelay = time.Second
+
+	command := path + " " + strings.Join(args, " ")
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("inventory script %s timed out after %s", command, timeout)
+		}
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("inventory script %s failed: %v: %s", command, err, message)
+		}
+		return nil, fmt.Errorf("inventory script %s failed: %v", command, err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// parseScriptList parses the JSON printed by --list, returning the group
+// names in the order the script printed them and the _meta host variables,
+// which are nil when the script printed no _meta
+func parseScriptList(output []byte) ([]string, map[string]*scriptGroup, map[string]map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(output))
+	decoder.UseNumber()
+
+	if token, err := decoder.Token(); err != nil {
+		return nil, nil, nil, err
+	} else if token != json.Delim('{') {
+		return nil, nil, nil, fmt.Errorf("expected a JSON object of groups")
+	}
+
+	var names []string
+	groups := make(map[string]*scriptGroup)
+	var meta map[string]map[string]interface{}
+
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		name := token.(string)
+
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil {
+			return nil, nil, nil, err
+		}
+		value = convertJSONNumbers(value)
+
+		if name == "_meta" {
+			if meta, err = parseScriptMeta(value); err != nil {
+				return nil, nil, nil, err
+			}
+			continue
+		}
+
+		group, err := parseScriptGroup(name, value)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if _, exists := groups[name]; !exists {
+			names = append(names, name)
+		}
+		groups[name] = group
+	}
+
+	if _, err := decoder.Token(); err != nil {
+		return nil, nil, nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, nil, nil, fmt.Errorf("unexpected data after the JSON object")
+	}
+	return names, groups, meta, nil
+}
+
+// parseScriptGroup reads a group, given either as a list of hosts or as an
+// object with hosts, vars and children
+func parseScriptGroup(name string, value interface{}) (*scriptGroup, error) {
+	group := &scriptGroup{}
+
+	switch data := value.(type) {
+	case []interface{}:
+		hosts, err := stringList(data)
+		if err != nil {
+			return nil, fmt.Errorf("group '%s' has an invalid host list: %v", name, err)
+		}
+		group.Hosts = hosts
+	case map[string]interface{}:
+		for key, field := range data {
+			switch key {
+			case "hosts":
+				list, ok := field.([]interface{})
+				hosts, err := stringList(list)
+				if !ok || err != nil {
+					return nil, fmt.Errorf("group '%s' has an invalid host list", name)
+				}
+				group.Hosts = hosts
+			case "children":
+				list, ok := field.([]interface{})
+				children, err := stringList(list)
+				if !ok || err != nil {
+					return nil, fmt.Errorf("group '%s' has an invalid children list", name)
+				}
+				group.Children = children
+			case "vars":
+				vars, ok := field.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("group '%s' has vars that are not an object", name)
+				}
+				group.Vars = vars
+			}
+		}
+	default:
+		return nil, fmt.Errorf("group '%s' must be a list of hosts or an object, got %T", name, value)
+	}
+
+	return group, nil
+}
+
+// parseScriptMeta reads _meta.hostvars
+func parseScriptMeta(value interface{}) (map[string]map[string]interface{}, error) {
+	data, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("_meta must be an object")
+	}
+
+	meta := make(map[string]map[string]interface{})
+	hostvars, ok := data["hostvars"].(map[string]interface{})
+	if !ok {
+		if data["hostvars"] != nil {
+			return nil, fmt.Errorf("_meta.hostvars must be an object")
+		}
+		return meta, nil
+	}
+	for hostName, vars := range hostvars {
+		hostVars, ok := vars.(map[string]interface{})
+		if !ok && vars != nil {
+			return nil, fmt.Errorf("_meta.hostvars for host '%s' must be an object", hostName)
+		}
+		meta[hostName] = hostVars
+	}
+	return meta, nil
+}
+
+// decodeScriptVars parses the JSON object printed by --host
+func decodeScriptVars(output []byte) (map[string]interface{}, error) {
+	if len(bytes.TrimSpace(output)) == 0 {
+		return nil, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(output))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	vars, ok := convertJSONNumbers(value).(map[string]interface{})
+	if !ok && value != nil {
+		return nil, fmt.Errorf("expected a JSON object of variables")
+	}
+	return vars, nil
+}
+
+// setHostVariables sets host variables, filling in the connection fields
+// they name
+func setHostVariables(host *Host, vars map[string]interface{}) {
+	if host.Variables == nil {
+		host.Variables = make(map[string]interface{})
+	}
+	for key, value := range vars {
+		host.Variables[key] = value
+
+		switch key {
+		case "ansible_host":
+			host.Address = fmt.Sprintf("%v", value)
+		case "ansible_port":
+			if port, err := strconv.Atoi(fmt.Sprintf("%v", value)); err == nil {
+				host.Port = port
+			}
+		case "ansible_user":
+			host.User = fmt.Sprintf("%v", value)
+		}
+	}
+}
+
+// convertJSONNumbers turns json.Number values into ints where they are
+// whole numbers and float64 otherwise
+func convertJSONNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := strconv.Atoi(v.String()); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = convertJSONNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = convertJSONNumbers(item)
+		}
+	}
+	return value
+}
+
+func stringList(values []interface{}) ([]string, error) {
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a string, got %T", value)
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
@@ -0,0 +1,129 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func writeScript(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "inventory.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestManager_LoadFromScript_Meta(t *testing.T) {
+	script := writeScript(t, `
+if [ "$1" != "--list" ]; then
+  echo "unexpected $*" >&2
+  exit 1
+fi
+cat <<'JSON'
+{
+  "web": {"hosts": ["web2", "web1"], "vars": {"http_port": 8080}},
+  "db": ["db1"],
+  "prod": {"children": ["web", "db"]},
+  "all": {"vars": {"env": "prod"}},
+  "_meta": {"hostvars": {
+    "web1": {"ansible_host": "10.0.0.1", "ansible_port": 2222, "weight": 1.5},
+    "db1": {"ansible_user": "postgres"}
+  }}
+}
+JSON
+`)
+
+	manager := NewManager(afero.NewOsFs())
+	if err := manager.LoadFromFile(script); err != nil {
+		t.Fatalf("LoadFromFile() error = %v", err)
+	}
+	inv := manager.GetInventory()
+
+	hosts, _ := manager.GetHosts("prod")
+	if got := hostNames(hosts); !reflect.DeepEqual(got, []string{"web2", "web1", "db1"}) {
+		t.Errorf("prod hosts = %v", got)
+	}
+
+	web1 := inv.Hosts["web1"]
+	if web1.Address != "10.0.0.1" || web1.Port != 2222 || web1.Variables["weight"] != 1.5 {
+		t.Errorf("web1 = %+v", web1)
+	}
+	if inv.Hosts["db1"].User != "postgres" {
+		t.Errorf("db1 user = %q", inv.Hosts["db1"].User)
+	}
+	if inv.Groups["web"].Variables["http_port"] != 8080 {
+		t.Errorf("web vars = %v", inv.Groups["web"].Variables)
+	}
+	if inv.AllGroup.Variables["env"] != "prod" {
+		t.Errorf("all vars = %v", inv.AllGroup.Variables)
+	}
+}
+
+func TestManager_LoadFromScript_HostFallback(t *testing.T) {
+	script := writeScript(t, `
+case "$1" in
+  --list) echo '{"web": ["web1", "web2"]}' ;;
+  --host) echo "{\"host_name\": \"$2\"}" ;;
+esac
+`)
+
+	manager := NewManager(afero.NewOsFs())
+	if err := manager.LoadFromFile(script); err != nil {
+		t.Fatalf("LoadFromFile() error = %v", err)
+	}
+	for _, name := range []string{"web1", "web2"} {
+		if got := manager.GetInventory().Hosts[name].Variables["host_name"]; got != name {
+			t.Errorf("%s host_name = %v", name, got)
+		}
+	}
+}
+
+func TestManager_LoadFromScript_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		errMsg string
+	}{
+		{"failure", "echo 'cmdb unreachable' >&2; exit 3", "cmdb unreachable"},
+		{"malformed", "echo '{\"web\": [\"web1\"'", "invalid output for --list"},
+		{"not an object", "echo '[\"web1\"]'", "JSON object of groups"},
+		{"bad group", "echo '{\"web\": 1}'", "group 'web'"},
+		{"bad hostvars", "echo '{\"web\": [\"web1\"], \"_meta\": {\"hostvars\": []}}'", "_meta.hostvars"},
+		{"bad --host", `[ "$1" = "--list" ] && echo '{"web": ["web1"]}' || echo nope`, "invalid output for --host web1"},
+		{"timeout", "sleep 5", "timed out"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			manager := NewManager(afero.NewOsFs())
+			manager.SetScriptTimeout(500 * time.Millisecond)
+			err := manager.LoadFromFile(writeScript(t, test.body))
+			if err == nil || !strings.Contains(err.Error(), test.errMsg) {
+				t.Errorf("expected error containing '%s', got %v", test.errMsg, err)
+			}
+		})
+	}
+}
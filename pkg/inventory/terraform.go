@@ -0,0 +1,336 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/afero"
+	"gopkg.in/yaml.v3"
+)
+
+// terraformStateVersion is the only state file format the terraform_state
+// plugin reads
+const terraformStateVersion = 4
+
+// terraformConfig is the configuration file of the terraform_state plugin
+type terraformConfig struct {
+	Plugin       string              `yaml:"plugin"`
+	StateFiles   []string            `yaml:"state_files"`
+	Resources    []terraformResource `yaml:"resources"`
+	TagPrefix    *string             `yaml:"tag_prefix"`
+	TagGroups    *bool               `yaml:"tag_groups"`
+	ModuleGroups *bool               `yaml:"module_groups"`
+	TypeGroups   *bool               `yaml:"type_groups"`
+}
+
+// terraformResource maps a resource type to hosts. Name and Address list
+// attribute paths, such as tags.Name or network_interface.0.network_ip,
+// tried in order; Tags is the path of a map or list of tags.
+type terraformResource struct {
+	Type    string   `yaml:"type"`
+	Name    []string `yaml:"name"`
+	Address []string `yaml:"address"`
+	Tags    string   `yaml:"tags"`
+}
+
+// defaultTerraformResources are the compute resource types mapped when
+// the configuration lists none
+var defaultTerraformResources = []terraformResource{
+	{Type: "aws_instance", Name: []string{"tags.Name", "id"}, Address: []string{"public_ip", "private_ip"}, Tags: "tags"},
+	{Type: "google_compute_instance", Name: []string{"name"}, Address: []string{"network_interface.0.access_config.0.nat_ip", "network_interface.0.network_ip"}, Tags: "labels"},
+	{Type: "azurerm_linux_virtual_machine", Name: []string{"name"}, Address: []string{"public_ip_address", "private_ip_address"}, Tags: "tags"},
+	{Type: "azurerm_windows_virtual_machine", Name: []string{"name"}, Address: []string{"public_ip_address", "private_ip_address"}, Tags: "tags"},
+	{Type: "digitalocean_droplet", Name: []string{"name"}, Address: []string{"ipv4_address", "ipv4_address_private"}, Tags: "tags"},
+	{Type: "hcloud_server", Name: []string{"name"}, Address: []string{"ipv4_address", "ipv6_address"}, Tags: "labels"},
+	{Type: "openstack_compute_instance_v2", Name: []string{"name"}, Address: []string{"access_ip_v4", "access_ip_v6"}, Tags: "metadata"},
+	{Type: "vsphere_virtual_machine", Name: []string{"name"}, Address: []string{"default_ip_address"}, Tags: "tags"},
+}
+
+// terraformState is the part of a v4 state file the plugin reads
+type terraformState struct {
+	Version   int `json:"version"`
+	Resources []struct {
+		Module    string `json:"module"`
+		Mode      string `json:"mode"`
+		Type      string `json:"type"`
+		Name      string `json:"name"`
+		Provider  string `json:"provider"`
+		Instances []struct {
+			IndexKey   interface{}            `json:"index_key"`
+			Attributes map[string]interface{} `json:"attributes"`
+		} `json:"instances"`
+	} `json:"resources"`
+}
+
+// terraformPlugin adds the compute instances recorded in Terraform state
+// files as hosts. It reads the files from disk and never runs terraform or
+// contacts a backend.
+type terraformPlugin struct{}
+
+func (p *terraformPlugin) Name() string { return "terraform_state" }
+
+// VerifyFile accepts state files and YAML configuration files
+func (p *terraformPlugin) VerifyFile(m *Manager, source string) bool {
+	switch strings.ToLower(filepath.Ext(source)) {
+	case ".tfstate", ".yml", ".yaml":
+		return m.isFile(source)
+	}
+	return false
+}
+
+func (p *terraformPlugin) Parse(m *Manager, source string) error {
+	config := &terraformConfig{}
+	var stateFiles []string
+
+	if strings.ToLower(filepath.Ext(source)) == ".tfstate" {
+		stateFiles = []string{source}
+	} else {
+		data, err := afero.ReadFile(m.fs, source)
+		if err != nil {
+			return fmt.Errorf("failed to read inventory file %s: %w", source, err)
+		}
+		if err := yaml.Unmarshal(data, config); err != nil {
+			return fmt.Errorf("invalid terraform_state configuration %s: %w", source, err)
+		}
+		if name := strings.TrimPrefix(config.Plugin, "ansible.builtin."); name != p.Name() {
+			return fmt.Errorf("incorrect plugin name in %s: expected terraform_state, got '%s'", source, config.Plugin)
+		}
+
+		// State files are relative to the configuration file
+		stateFiles = config.StateFiles
+		if len(stateFiles) == 0 {
+			stateFiles = []string{"terraform.tfstate"}
+		}
+		for i, stateFile := range stateFiles {
+			if !filepath.IsAbs(stateFile) {
+				stateFiles[i] = filepath.Join(filepath.Dir(source), stateFile)
+			}
+		}
+	}
+
+	resources := config.Resources
+	if len(resources) == 0 {
+		resources = defaultTerraformResources
+	}
+	mappings := make(map[string]terraformResource, len(resources))
+	for _, resource := range resources {
+		if resource.Type == "" {
+			return fmt.Errorf("terraform_state resources entries require a type")
+		}
+		mappings[resource.Type] = resource
+	}
+
+	for _, stateFile := range stateFiles {
+		if err := p.parseState(m, config, mappings, stateFile); err != nil {
+			return err
+		}
+	}
+
+	m.inventory.UpdateAllGroup()
+	return nil
+}
+
+// parseState adds the hosts of one state file
+func (p *terraformPlugin) parseState(m *Manager, config *terraformConfig, mappings map[string]terraformResource, stateFile string) error {
+	data, err := afero.ReadFile(m.fs, stateFile)
+	if err != nil {
+		return fmt.Errorf("failed to read Terraform state %s: %w", stateFile, err)
+	}
+
+	var state terraformState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to parse Terraform state %s: %w", stateFile, err)
+	}
+	if state.Version != terraformStateVersion {
+		return fmt.Errorf("unsupported Terraform state version %d in %s, expected %d", state.Version, stateFile, terraformStateVersion)
+	}
+
+	tagPrefix := "tag"
+	if config.TagPrefix != nil {
+		tagPrefix = *config.TagPrefix
+	}
+
+	for _, resource := range state.Resources {
+		mapping, exists := mappings[resource.Type]
+		if !exists || resource.Mode == "data" {
+			continue
+		}
+
+		for _, instance := range resource.Instances {
+			address := resourceAddress(resource.Module, resource.Type, resource.Name, instance.IndexKey)
+
+			hostName := firstTerraformAttribute(instance.Attributes, mapping.Name)
+			hostAddress := firstTerraformAttribute(instance.Attributes, mapping.Address)
+			if hostName == "" {
+				hostName = hostAddress
+			}
+			if hostName == "" {
+				hostName = address
+			}
+
+			// Attributes become host variables, so constructed can use them
+			hostVars := make(map[string]interface{}, len(instance.Attributes)+2)
+			for name, value := range instance.Attributes {
+				hostVars[name] = value
+			}
+			if hostAddress != "" {
+				hostVars["ansible_host"] = hostAddress
+			}
+			hostVars["terraform_address"] = address
+			m.addHosts([]string{hostName}, hostVars, "")
+
+			if config.TypeGroups == nil || *config.TypeGroups {
+				m.inventory.GetOrCreateGroup(sanitizeGroupName(resource.Type)).AddHost(hostName)
+			}
+			if config.ModuleGroups == nil || *config.ModuleGroups {
+				addModuleGroups(m.inventory, resource.Module, hostName)
+			}
+			if config.TagGroups == nil || *config.TagGroups {
+				for _, groupName := range tagGroupNames(tagPrefix, terraformAttribute(instance.Attributes, mapping.Tags)) {
+					m.inventory.GetOrCreateGroup(groupName).AddHost(hostName)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// resourceAddress returns the Terraform address of a resource instance,
+// such as module.app.aws_instance.web[0]
+func resourceAddress(module, resourceType, name string, indexKey interface{}) string {
+	address := resourceType + "." + name
+	if module != "" {
+		address = module + "." + address
+	}
+	switch key := indexKey.(type) {
+	case string:
+		address += fmt.Sprintf("[%q]", key)
+	case float64:
+		address += fmt.Sprintf("[%d]", int(key))
+	}
+	return address
+}
+
+// addModuleGroups adds a host to a group per module of its module path,
+// each a child of its parent module's group: module.app.module.db adds
+// the host to module_app_db, a child of module_app
+func addModuleGroups(inv *Inventory, module, hostName string) {
+	if module == "" {
+		return
+	}
+
+	var parent, name string
+	parts := strings.Split(module, ".")
+	for i := 0; i+1 < len(parts); i += 2 {
+		if name == "" {
+			name = "module_" + parts[i+1]
+		} else {
+			name += "_" + parts[i+1]
+		}
+		groupName := sanitizeGroupName(name)
+		inv.GetOrCreateGroup(groupName)
+		if parent != "" {
+			inv.GetOrCreateGroup(parent).AddChild(groupName)
+		}
+		parent = groupName
+	}
+	if parent != "" {
+		inv.GetOrCreateGroup(parent).AddHost(hostName)
+	}
+}
+
+// tagGroupNames returns the groups named after tags: prefix_key_value for
+// each entry of a map and prefix_value for each item of a list
+func tagGroupNames(prefix string, tags interface{}) []string {
+	join := func(parts ...string) string {
+		if prefix != "" {
+			parts = append([]string{prefix}, parts...)
+		}
+		return sanitizeGroupName(strings.Join(parts, "_"))
+	}
+
+	var names []string
+	switch tags := tags.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(tags))
+		for key := range tags {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if value := fmt.Sprintf("%v", tags[key]); value != "" && tags[key] != nil {
+				names = append(names, join(key, value))
+			} else {
+				names = append(names, join(key))
+			}
+		}
+	case []interface{}:
+		for _, tag := range tags {
+			if value := fmt.Sprintf("%v", tag); value != "" && tag != nil {
+				names = append(names, join(value))
+			}
+		}
+	}
+	return names
+}
+
+// firstTerraformAttribute returns the first of paths set to a non-empty
+// scalar
+func firstTerraformAttribute(attributes map[string]interface{}, paths []string) string {
+	for _, path := range paths {
+		switch value := terraformAttribute(attributes, path).(type) {
+		case string:
+			if value != "" {
+				return value
+			}
+		case float64, bool:
+			return fmt.Sprintf("%v", value)
+		}
+	}
+	return ""
+}
+
+// terraformAttribute looks up a dotted attribute path, in which numbers
+// index lists
+func terraformAttribute(attributes map[string]interface{}, path string) interface{} {
+	if path == "" {
+		return nil
+	}
+
+	var current interface{} = attributes
+	for _, part := range strings.Split(path, ".") {
+		switch value := current.(type) {
+		case map[string]interface{}:
+			current = value[part]
+		case []interface{}:
+			index, err := strconv.Atoi(part)
+			if err != nil || index < 0 || index >= len(value) {
+				return nil
+			}
+			current = value[index]
+		default:
+			return nil
+		}
+	}
+	return current
+}
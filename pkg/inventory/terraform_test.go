@@ -0,0 +1,198 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/work-obs/ansible-go/pkg/config"
+)
+
+const terraformStateFixture = `{
+  "version": 4,
+  "terraform_version": "1.6.0",
+  "serial": 3,
+  "lineage": "b6a3c4b2-0000-0000-0000-000000000000",
+  "outputs": {},
+  "resources": [
+    {
+      "mode": "managed",
+      "type": "aws_instance",
+      "name": "web",
+      "provider": "provider[\"registry.terraform.io/hashicorp/aws\"]",
+      "instances": [
+        {"index_key": 0, "schema_version": 1, "attributes": {"id": "i-01", "public_ip": "192.0.2.10", "private_ip": "10.0.0.10", "tags": {"Name": "web-1", "env": "prod"}}},
+        {"index_key": 1, "schema_version": 1, "attributes": {"id": "i-02", "public_ip": "", "private_ip": "10.0.0.11", "tags": {"env": "prod"}}}
+      ]
+    },
+    {
+      "module": "module.data.module.db",
+      "mode": "managed",
+      "type": "google_compute_instance",
+      "name": "db",
+      "provider": "provider[\"registry.terraform.io/hashicorp/google\"]",
+      "instances": [
+        {"schema_version": 6, "attributes": {"name": "db-1", "labels": {"role": "db"}, "network_interface": [{"network_ip": "10.1.0.5", "access_config": []}]}}
+      ]
+    },
+    {
+      "mode": "data",
+      "type": "aws_instance",
+      "name": "lookup",
+      "instances": [{"attributes": {"id": "i-99", "private_ip": "10.0.0.99"}}]
+    },
+    {
+      "mode": "managed",
+      "type": "aws_security_group",
+      "name": "web",
+      "instances": [{"attributes": {"id": "sg-1"}}]
+    }
+  ]
+}`
+
+func TestTerraformPlugin(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/infra/terraform.tfstate", []byte(terraformStateFixture), 0644)
+	afero.WriteFile(fs, "/inv/terraform.yml", []byte(`plugin: terraform_state
+state_files:
+  - /infra/terraform.tfstate
+`), 0644)
+
+	manager := NewManager(fs)
+	if err := manager.LoadFromFile("/inv/terraform.yml"); err != nil {
+		t.Fatalf("Failed to load Terraform state: %v", err)
+	}
+	inv := manager.GetInventory()
+
+	expected := []string{"web-1", "i-02", "db-1"}
+	if hosts := inv.ListHosts(); !reflect.DeepEqual(hosts, expected) {
+		t.Fatalf("Expected hosts %v, got %v", expected, hosts)
+	}
+
+	vars := inv.GetHostVars("web-1")
+	if vars["ansible_host"] != "192.0.2.10" || vars["terraform_address"] != "aws_instance.web[0]" || vars["id"] != "i-01" {
+		t.Errorf("Unexpected host variables for web-1: %v", vars)
+	}
+	if vars := inv.GetHostVars("i-02"); vars["ansible_host"] != "10.0.0.11" {
+		t.Errorf("Expected the private address as a fallback, got %v", vars["ansible_host"])
+	}
+	if vars := inv.GetHostVars("db-1"); vars["ansible_host"] != "10.1.0.5" || vars["terraform_address"] != "module.data.module.db.google_compute_instance.db" {
+		t.Errorf("Unexpected host variables for db-1: %v", vars)
+	}
+
+	groups := map[string][]string{
+		"aws_instance":            {"i-02", "web-1"},
+		"google_compute_instance": {"db-1"},
+		"tag_env_prod":            {"i-02", "web-1"},
+		"tag_Name_web_1":          {"web-1"},
+		"tag_role_db":             {"db-1"},
+		"module_data_db":          {"db-1"},
+	}
+	for name, hosts := range groups {
+		if members := groupMembers(inv, name); !reflect.DeepEqual(members, hosts) {
+			t.Errorf("Expected group %s to hold %v, got %v", name, hosts, members)
+		}
+	}
+	if children := inv.Groups["module_data"].Children; !reflect.DeepEqual(children, []string{"module_data_db"}) {
+		t.Errorf("Expected nested module groups, got %v", children)
+	}
+}
+
+func TestTerraformPlugin_Config(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/inv/terraform.tfstate", []byte(terraformStateFixture), 0644)
+	afero.WriteFile(fs, "/inv/terraform.yaml", []byte(`plugin: terraform_state
+resources:
+  - type: aws_instance
+    name: [id]
+    address: [private_ip]
+    tags: tags
+tag_prefix: ""
+module_groups: false
+type_groups: false
+`), 0644)
+
+	manager := NewManager(fs)
+	if err := manager.LoadFromFile("/inv/terraform.yaml"); err != nil {
+		t.Fatalf("Failed to load Terraform state: %v", err)
+	}
+	inv := manager.GetInventory()
+
+	expected := []string{"i-01", "i-02"}
+	if hosts := inv.ListHosts(); !reflect.DeepEqual(hosts, expected) {
+		t.Fatalf("Expected hosts %v, got %v", expected, hosts)
+	}
+	if vars := inv.GetHostVars("i-01"); vars["ansible_host"] != "10.0.0.10" {
+		t.Errorf("Expected the configured address, got %v", vars["ansible_host"])
+	}
+	if members := groupMembers(inv, "env_prod"); !reflect.DeepEqual(members, expected) {
+		t.Errorf("Expected unprefixed tag groups, got %v", members)
+	}
+	if _, exists := inv.Groups["aws_instance"]; exists {
+		t.Error("Expected no type groups")
+	}
+}
+
+func TestTerraformPlugin_StateFile(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/inv/terraform.tfstate", []byte(terraformStateFixture), 0644)
+	afero.WriteFile(fs, "/inv/old.tfstate", []byte(`{"version": 3, "modules": []}`), 0644)
+
+	manager := NewManager(fs)
+	manager.enabledPlugins = []string{"terraform_state"}
+	if err := manager.LoadFromFile("/inv/terraform.tfstate"); err != nil {
+		t.Fatalf("Failed to load Terraform state: %v", err)
+	}
+	if hosts := manager.GetInventory().ListHosts(); len(hosts) != 3 {
+		t.Errorf("Expected 3 hosts from the default mappings, got %v", hosts)
+	}
+
+	manager = NewManager(fs)
+	manager.enabledPlugins = []string{"terraform_state"}
+	err := manager.LoadFromFile("/inv/old.tfstate")
+	if err == nil || !strings.Contains(err.Error(), "unsupported Terraform state version 3") {
+		t.Errorf("Expected old state files to be rejected, got: %v", err)
+	}
+}
+
+func TestTerraformPlugin_EnabledByDefault(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/inv/terraform.tfstate", []byte(terraformStateFixture), 0644)
+
+	configManager := config.NewManager(fs)
+	if err := configManager.LoadConfig(); err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Both the built-in plugin list and the configuration default read
+	// state files with the terraform_state plugin rather than as INI
+	for _, cfg := range []*config.Config{nil, configManager.GetConfig()} {
+		manager := NewManager(fs)
+		if cfg != nil {
+			manager.SetConfig(cfg)
+		}
+		if err := manager.LoadFromFile("/inv/terraform.tfstate"); err != nil {
+			t.Fatalf("Failed to load Terraform state: %v", err)
+		}
+		if hosts := manager.GetInventory().ListHosts(); len(hosts) != 3 {
+			t.Errorf("Expected 3 hosts, got %v", hosts)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Kinds of problems inventory validation reports
+const (
+	ProblemCycle           = "cycle"
+	ProblemEmptyGroup      = "empty_group"
+	ProblemConflict        = "conflict"
+	ProblemInvalidVariable = "invalid_variable"
+	ProblemReservedName    = "reserved_name"
+)
+
+// Validation modes, the values of host_pattern_mismatch
+const (
+	ValidationWarning = "warning"
+	ValidationError   = "error"
+	ValidationIgnore  = "ignore"
+)
+
+// validVariableName matches the names Ansible accepts for variables
+var validVariableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// pythonKeywords are not valid variable names even though they match
+// validVariableName
+var pythonKeywords = map[string]bool{
+	"False": true, "None": true, "True": true, "and": true, "as": true,
+	"assert": true, "async": true, "await": true, "break": true, "class": true,
+	"continue": true, "def": true, "del": true, "elif": true, "else": true,
+	"except": true, "finally": true, "for": true, "from": true, "global": true,
+	"if": true, "import": true, "in": true, "is": true, "lambda": true,
+	"nonlocal": true, "not": true, "or": true, "pass": true, "raise": true,
+	"return": true, "try": true, "while": true, "with": true, "yield": true,
+}
+
+// reservedVariables are magic variables Ansible sets itself, which
+// inventory variables cannot usefully override
+var reservedVariables = map[string]bool{
+	"ansible_check_mode": true, "ansible_play_batch": true, "ansible_play_hosts": true,
+	"ansible_version": true, "group_names": true, "groups": true, "hostvars": true,
+	"inventory_dir": true, "inventory_file": true, "inventory_hostname": true,
+	"inventory_hostname_short": true, "omit": true, "play_hosts": true,
+	"playbook_dir": true, "role_name": true, "role_path": true,
+}
+
+// Problem is something wrong with the inventory found by validation
+type Problem struct {
+	Kind    string
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("[%s] %s", p.Kind, p.Message)
+}
+
+// hostDefinition records which source set a host variable, and to what
+type hostDefinition struct {
+	source string
+	value  interface{}
+}
+
+// SetValidationMode sets what loading does with validation problems:
+// warning records them as warnings, error fails the load and ignore skips
+// validation
+func (m *Manager) SetValidationMode(mode string) {
+	m.validationMode = mode
+}
+
+// Warnings returns the validation problems found while loading, when the
+// validation mode is warning
+func (m *Manager) Warnings() []string {
+	return append([]string{}, m.warnings...)
+}
+
+// validateOnLoad validates the inventory after its sources are loaded,
+// following the validation mode
+func (m *Manager) validateOnLoad() error {
+	if m.validationMode == ValidationIgnore {
+		return nil
+	}
+
+	problems := m.Validate()
+	if len(problems) == 0 {
+		return nil
+	}
+	if m.validationMode == ValidationError {
+		messages := make([]string, len(problems))
+		for i, problem := range problems {
+			messages[i] = problem.String()
+		}
+		return fmt.Errorf("inventory validation failed:\n  %s", strings.Join(messages, "\n  "))
+	}
+
+	m.warnings = m.warnings[:0]
+	for _, problem := range problems {
+		m.warnings = append(m.warnings, problem.String())
+	}
+	return nil
+}
+
+// Validate checks the inventory for child-group cycles, groups without
+// hosts, hosts defined differently by different sources, invalid variable
+// names and reserved names
+func (m *Manager) Validate() []Problem {
+	var problems []Problem
+	problems = append(problems, m.inventory.findCycles()...)
+	problems = append(problems, m.inventory.findEmptyGroups()...)
+	problems = append(problems, m.conflicts...)
+	problems = append(problems, m.inventory.findNameProblems()...)
+	return problems
+}
+
+// findCycles reports every child-group cycle once
+func (inv *Inventory) findCycles() []Problem {
+	var problems []Problem
+	reported := make(map[string]bool)
+	done := make(map[string]bool)
+
+	var path []string
+	onPath := make(map[string]int)
+
+	var visit func(groupName string)
+	visit = func(groupName string) {
+		if index, exists := onPath[groupName]; exists {
+			cycle := append(append([]string{}, path[index:]...), groupName)
+			key := cycleKey(cycle[:len(cycle)-1])
+			if !reported[key] {
+				reported[key] = true
+				problems = append(problems, Problem{ProblemCycle, fmt.Sprintf("group '%s' is its own descendant: %s", groupName, strings.Join(cycle, " -> "))})
+			}
+			return
+		}
+		if done[groupName] {
+			return
+		}
+
+		onPath[groupName] = len(path)
+		path = append(path, groupName)
+		for _, childName := range inv.Groups[groupName].Children {
+			if _, exists := inv.Groups[childName]; exists {
+				visit(childName)
+			}
+		}
+		path = path[:len(path)-1]
+		delete(onPath, groupName)
+		done[groupName] = true
+	}
+
+	for _, groupName := range inv.orderedGroupNames() {
+		visit(groupName)
+	}
+	return problems
+}
+
+// cycleKey identifies a cycle regardless of the group it was entered from
+func cycleKey(cycle []string) string {
+	members := append([]string{}, cycle...)
+	sort.Strings(members)
+	return strings.Join(members, ",")
+}
+
+// findEmptyGroups reports groups with no hosts, directly or through
+// children. all and ungrouped may be empty.
+func (inv *Inventory) findEmptyGroups() []Problem {
+	var problems []Problem
+	for _, groupName := range inv.orderedGroupNames() {
+		group := inv.Groups[groupName]
+		if group == inv.AllGroup || group == inv.UngroupedGroup {
+			continue
+		}
+		if !inv.groupHasHosts(group, make(map[string]bool)) {
+			problems = append(problems, Problem{ProblemEmptyGroup, fmt.Sprintf("group '%s' has no hosts", groupName)})
+		}
+	}
+	return problems
+}
+
+func (inv *Inventory) groupHasHosts(group *Group, seen map[string]bool) bool {
+	if seen[group.Name] {
+		return false
+	}
+	seen[group.Name] = true
+
+	for _, hostName := range group.Hosts {
+		if _, exists := inv.Hosts[hostName]; exists {
+			return true
+		}
+	}
+	for _, childName := range group.Children {
+		if child, exists := inv.Groups[childName]; exists && inv.groupHasHosts(child, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// findNameProblems reports invalid and reserved variable names, reserved
+// groups used as children and names shared by a host and a group
+func (inv *Inventory) findNameProblems() []Problem {
+	var problems []Problem
+
+	for _, groupName := range inv.orderedGroupNames() {
+		group := inv.Groups[groupName]
+		problems = append(problems, variableNameProblems(group.Variables, "group '"+groupName+"'")...)
+
+		for _, childName := range group.Children {
+			if childName == "all" || childName == "ungrouped" {
+				problems = append(problems, Problem{ProblemReservedName, fmt.Sprintf("group '%s' lists the reserved group '%s' as a child", groupName, childName)})
+			}
+		}
+		if _, exists := inv.Hosts[groupName]; exists {
+			problems = append(problems, Problem{ProblemConflict, fmt.Sprintf("'%s' names both a group and a host", groupName)})
+		}
+	}
+
+	for _, hostName := range inv.orderedHostNames() {
+		problems = append(problems, variableNameProblems(inv.Hosts[hostName].Variables, "host '"+hostName+"'")...)
+	}
+	return problems
+}
+
+// variableNameProblems reports the invalid and reserved names among vars
+func variableNameProblems(vars map[string]interface{}, owner string) []Problem {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []Problem
+	for _, name := range names {
+		switch {
+		case !validVariableName.MatchString(name) || pythonKeywords[name]:
+			problems = append(problems, Problem{ProblemInvalidVariable, fmt.Sprintf("%s sets '%s', which is not a valid variable name", owner, name)})
+		case reservedVariables[name]:
+			problems = append(problems, Problem{ProblemReservedName, fmt.Sprintf("%s sets '%s', which is a reserved variable name", owner, name)})
+		}
+	}
+	return problems
+}
+
+// recordHostDefinition remembers the source that set each variable of a
+// host and reports a conflict when another source sets it differently
+func (m *Manager) recordHostDefinition(hostName string, vars map[string]interface{}) {
+	source := m.currentSource
+	if source == "" {
+		source = "<string>"
+	}
+
+	definitions, exists := m.hostDefinitions[hostName]
+	if !exists {
+		definitions = make(map[string]hostDefinition)
+		m.hostDefinitions[hostName] = definitions
+	}
+
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := vars[name]
+		if previous, exists := definitions[name]; exists && previous.source != source && !reflect.DeepEqual(previous.value, value) {
+			m.conflicts = append(m.conflicts, Problem{ProblemConflict, fmt.Sprintf("host '%s' has %s=%v in %s but %s=%v in %s",
+				hostName, name, previous.value, previous.source, name, value, source)})
+		}
+		definitions[name] = hostDefinition{source: source, value: value}
+	}
+}
@@ -0,0 +1,137 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+const invalidInventory = `[a:children]
+b
+[b:children]
+c
+[c:children]
+a
+[empty]
+[web]
+192.0.2.10 bad-name=1 hostvars=2 class=3
+[web:children]
+all
+`
+
+// problemKinds returns the kind of each problem
+func problemKinds(problems []Problem) []string {
+	kinds := make([]string, len(problems))
+	for i, problem := range problems {
+		kinds[i] = problem.Kind
+	}
+	return kinds
+}
+
+func TestManager_Validate(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/inv/hosts.ini", []byte(invalidInventory), 0644)
+
+	manager := NewManager(fs)
+	manager.SetValidationMode(ValidationIgnore)
+	if err := manager.LoadFromFile("/inv/hosts.ini"); err != nil {
+		t.Fatalf("Failed to load inventory: %v", err)
+	}
+	if warnings := manager.Warnings(); len(warnings) != 0 {
+		t.Errorf("Expected no warnings when validation is ignored, got %v", warnings)
+	}
+
+	problems := manager.Validate()
+	expected := []string{
+		ProblemCycle,
+		ProblemEmptyGroup, ProblemEmptyGroup, ProblemEmptyGroup, ProblemEmptyGroup,
+		ProblemReservedName,
+		ProblemInvalidVariable, ProblemInvalidVariable, ProblemReservedName,
+	}
+	if kinds := problemKinds(problems); !reflect.DeepEqual(kinds, expected) {
+		t.Fatalf("Expected problems %v, got %v", expected, problems)
+	}
+	if !strings.Contains(problems[0].Message, "a -> b -> c -> a") {
+		t.Errorf("Expected the cycle path, got %q", problems[0].Message)
+	}
+	if !strings.Contains(problems[4].Message, "'empty'") {
+		t.Errorf("Expected the empty group to be named, got %q", problems[4].Message)
+	}
+}
+
+func TestManager_Validate_Conflicts(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/inv/one.ini", []byte("[web]\n192.0.2.10 http_port=80 role=web\nlocalhost\n"), 0644)
+	afero.WriteFile(fs, "/inv/two.yml", []byte("localhost:\n  hosts:\n    192.0.2.10:\n      http_port: 8080\n      role: web\n"), 0644)
+
+	manager := NewManager(fs)
+	manager.SetValidationMode(ValidationIgnore)
+	if err := manager.LoadSources("/inv/one.ini", "/inv/two.yml"); err != nil {
+		t.Fatalf("Failed to load inventory: %v", err)
+	}
+
+	var conflicts []string
+	for _, problem := range manager.Validate() {
+		if problem.Kind == ProblemConflict {
+			conflicts = append(conflicts, problem.Message)
+		}
+	}
+	if len(conflicts) != 2 {
+		t.Fatalf("Expected two conflicts, got %v", conflicts)
+	}
+	if !strings.Contains(conflicts[0], "http_port=80 in /inv/one.ini but http_port=8080 in /inv/two.yml") {
+		t.Errorf("Unexpected variable conflict: %s", conflicts[0])
+	}
+	if !strings.Contains(conflicts[1], "'localhost' names both a group and a host") {
+		t.Errorf("Unexpected name conflict: %s", conflicts[1])
+	}
+}
+
+func TestManager_ValidationModes(t *testing.T) {
+	load := func(mode string) (*Manager, error) {
+		fs := afero.NewMemMapFs()
+		afero.WriteFile(fs, "/inv/hosts.ini", []byte(invalidInventory), 0644)
+		manager := NewManager(fs)
+		if mode != "" {
+			manager.SetValidationMode(mode)
+		}
+		return manager, manager.LoadFromFile("/inv/hosts.ini")
+	}
+
+	// Warning is the default
+	manager, err := load("")
+	if err != nil {
+		t.Fatalf("Expected warnings not to fail the load: %v", err)
+	}
+	warnings := manager.Warnings()
+	if len(warnings) != 9 || !strings.HasPrefix(warnings[0], "[cycle] ") {
+		t.Errorf("Expected 9 warnings starting with the cycle, got %v", warnings)
+	}
+
+	if _, err := load(ValidationError); err == nil || !strings.Contains(err.Error(), "inventory validation failed") {
+		t.Errorf("Expected the load to fail in error mode, got: %v", err)
+	}
+
+	manager, err = load(ValidationIgnore)
+	if err != nil || len(manager.Warnings()) != 0 {
+		t.Errorf("Expected ignore mode to skip validation, got %v and %v", err, manager.Warnings())
+	}
+}
@@ -0,0 +1,129 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/afero"
+	"gopkg.in/yaml.v3"
+)
+
+// loadVarsFiles applies the group_vars and host_vars directories beside an
+// inventory source, as Ansible's host_group_vars plugin does. For a group
+// or host named web, group_vars/web, group_vars/web.yml, .yaml or .json
+// and every file under a group_vars/web directory are loaded, and their
+// variables override those set in the inventory source.
+func (m *Manager) loadVarsFiles(source string) error {
+	baseDir := source
+	if info, err := m.fs.Stat(source); err != nil {
+		return nil
+	} else if !info.IsDir() {
+		baseDir = filepath.Dir(source)
+	}
+
+	for _, groupName := range m.inventory.orderedGroupNames() {
+		vars, err := m.readVarsFiles(filepath.Join(baseDir, "group_vars"), groupName)
+		if err != nil {
+			return err
+		}
+		group := m.inventory.Groups[groupName]
+		for k, v := range vars {
+			group.Variables[k] = v
+		}
+	}
+
+	for _, hostName := range m.inventory.orderedHostNames() {
+		vars, err := m.readVarsFiles(filepath.Join(baseDir, "host_vars"), hostName)
+		if err != nil {
+			return err
+		}
+		setHostVariables(m.inventory.Hosts[hostName], vars)
+	}
+	return nil
+}
+
+// readVarsFiles merges the variable files for name found in dir
+func (m *Manager) readVarsFiles(dir, name string) (map[string]interface{}, error) {
+	if exists, _ := afero.DirExists(m.fs, dir); !exists {
+		return nil, nil
+	}
+
+	var files []string
+	base := filepath.Join(dir, name)
+	if info, err := m.fs.Stat(base); err == nil && info.IsDir() {
+		if err := afero.Walk(m.fs, base, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if strings.HasPrefix(info.Name(), ".") && path != base {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !info.IsDir() && isVarsFile(path) {
+				files = append(files, path)
+			}
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", base, err)
+		}
+		sort.Strings(files)
+	} else {
+		for _, ext := range append([]string{""}, yamlExtensions...) {
+			if m.isFile(base + ext) {
+				files = append(files, base+ext)
+			}
+		}
+	}
+
+	vars := make(map[string]interface{})
+	for _, path := range files {
+		data, err := afero.ReadFile(m.fs, path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read vars file %s: %w", path, err)
+		}
+		var fileVars map[string]interface{}
+		if err := yaml.Unmarshal(data, &fileVars); err != nil {
+			return nil, fmt.Errorf("failed to parse vars file %s: %w", path, err)
+		}
+		for k, v := range fileVars {
+			vars[k] = v
+		}
+	}
+	return vars, nil
+}
+
+// isVarsFile reports whether a file in a group_vars or host_vars directory
+// holds variables: it has a YAML or JSON extension, or none
+func isVarsFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == "" {
+		return true
+	}
+	for _, valid := range yamlExtensions {
+		if ext == valid {
+			return true
+		}
+	}
+	return false
+}
@@ -18,9 +18,17 @@ package action
 
 import (
 	"context"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/spf13/afero"
+	"github.com/work-obs/ansible-go/pkg/config"
+	"github.com/work-obs/ansible-go/pkg/connection"
+	"github.com/work-obs/ansible-go/pkg/inventory"
 	"github.com/work-obs/ansible-go/pkg/plugins"
+	"github.com/work-obs/ansible-go/pkg/vars"
 )
 
 // TestBasicActionPlugin tests basic action plugin functionality
@@ -89,4 +97,226 @@ func TestActionPluginExecution(t *testing.T) {
 	if result.Results == nil {
 		t.Error("Expected results to be returned")
 	}
-}
\ No newline at end of file
+}
+
+// TestTemplateActionPlugin tests rendering a template from the role search
+// path and transferring it through a local connection
+func TestTemplateActionPlugin(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/roles/web/templates/base.conf.j2", []byte("server {{ name }}\n{% block body %}{% endblock %}"), 0644)
+	afero.WriteFile(fs, "/roles/web/templates/site.conf.j2", []byte("{% extends 'base.conf.j2' %}{% block body %}port={{ port }}\n{% endblock %}"), 0644)
+
+	plugin := NewTemplateActionPlugin()
+	plugin.SetFilesystem(fs)
+
+	conn, err := connection.NewLocalConnection(&connection.ConnectionConfig{Host: "localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "site.conf")
+	run := func(args map[string]interface{}, port int, playCtx *plugins.PlayContext) *plugins.ActionResult {
+		t.Helper()
+		actionCtx := &plugins.ActionContext{
+			TaskVars: map[string]interface{}{
+				"name":      "web",
+				"port":      port,
+				"role_path": "/roles/web",
+			},
+			PlayContext: playCtx,
+			Connection:  conn,
+			TempDir:     dir,
+		}
+		actionCtx.Args = map[string]interface{}{"src": "site.conf.j2", "dest": dest}
+		for key, value := range args {
+			actionCtx.Args[key] = value
+		}
+		result, err := plugin.Run(context.Background(), actionCtx)
+		if err != nil {
+			t.Fatalf("Plugin execution failed: %v", err)
+		}
+		return result
+	}
+	readDest := func() string {
+		t.Helper()
+		content, err := os.ReadFile(dest)
+		if err != nil {
+			t.Fatalf("Expected dest to be written: %v", err)
+		}
+		return string(content)
+	}
+
+	result := run(map[string]interface{}{"mode": 0640}, 8080, nil)
+	if result.Failed || !result.Changed {
+		t.Fatalf("Expected a changed result, got %+v", result)
+	}
+	if content := readDest(); content != "server web\nport=8080\n" {
+		t.Errorf("Unexpected content %q", content)
+	}
+	if info, _ := os.Stat(dest); info.Mode().Perm() != 0640 {
+		t.Errorf("Expected mode 0640, got %o", info.Mode().Perm())
+	}
+
+	if result := run(map[string]interface{}{"mode": "0640"}, 8080, nil); result.Failed || result.Changed {
+		t.Errorf("Expected an unchanged result, got %+v", result)
+	}
+
+	result = run(nil, 9090, &plugins.PlayContext{CheckMode: true, DiffMode: true})
+	if result.Failed || !result.Changed {
+		t.Fatalf("Expected a changed result in check mode, got %+v", result)
+	}
+	diff, _ := result.Diff["prepared"].(string)
+	if !strings.Contains(diff, "-port=8080\n+port=9090\n") {
+		t.Errorf("Unexpected diff %q", diff)
+	}
+	if content := readDest(); content != "server web\nport=8080\n" {
+		t.Errorf("Check mode must not change dest, got %q", content)
+	}
+
+	if result := run(map[string]interface{}{"validate": "grep -q 8080 %s"}, 9090, nil); !result.Failed {
+		t.Error("Expected validation to fail")
+	}
+	if content := readDest(); content != "server web\nport=8080\n" {
+		t.Errorf("Failed validation must not change dest, got %q", content)
+	}
+
+	result = run(map[string]interface{}{"backup": true, "newline_sequence": `\r\n`}, 9090, nil)
+	if result.Failed || !result.Changed {
+		t.Fatalf("Expected a changed result, got %+v", result)
+	}
+	if content := readDest(); content != "server web\r\nport=9090\r\n" {
+		t.Errorf("Unexpected content %q", content)
+	}
+	backup, _ := result.Results["backup_file"].(string)
+	if content, err := os.ReadFile(backup); err != nil || string(content) != "server web\nport=8080\n" {
+		t.Errorf("Expected a backup of the previous content, got %q (%v)", content, err)
+	}
+	if info, _ := os.Stat(dest); info.Mode().Perm() != 0640 {
+		t.Errorf("Expected the mode to be kept, got %o", info.Mode().Perm())
+	}
+
+	if result := run(map[string]interface{}{"src": "missing.j2"}, 8080, nil); !result.Failed {
+		t.Error("Expected a missing template to fail")
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	before := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n"
+	after := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\n"
+	expected := "--- old\n+++ new\n" +
+		"@@ -1,5 +1,5 @@\n a\n-b\n+B\n c\n d\n e\n" +
+		"@@ -8,3 +8,4 @@\n h\n i\n j\n+k\n"
+	if diff := UnifiedDiff(before, after, "old", "new"); diff != expected {
+		t.Errorf("Unexpected diff:\n%s", diff)
+	}
+	if diff := UnifiedDiff(before, before, "old", "new"); diff != "" {
+		t.Errorf("Expected no diff for equal texts, got %q", diff)
+	}
+}
+
+// TestTemplateActionPlugin_Engine tests that templates render with the
+// filters and lookups of the registry's variable manager
+func TestTemplateActionPlugin_Engine(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/templates/hosts.j2", []byte("{{ cidr | ipaddr('address') }} {{ lookup('vars', 'name') }}"), 0644)
+
+	registry := NewActionPluginRegistry()
+	registry.SetInventory(inventory.NewInventory(afero.NewMemMapFs()), vars.NewManager(nil))
+	plugin, err := registry.Get("template")
+	if err != nil {
+		t.Fatalf("Failed to get template: %v", err)
+	}
+	templatePlugin := plugin.(*TemplateActionPlugin)
+	templatePlugin.SetFilesystem(fs)
+
+	taskVars := map[string]interface{}{"cidr": "192.0.2.10/24", "name": "web", "playbook_dir": "/"}
+	content, err := templatePlugin.render("hosts.j2", taskVars, nil)
+	if err != nil {
+		t.Fatalf("Failed to render: %v", err)
+	}
+	if content != "192.0.2.10 web" {
+		t.Errorf("Unexpected content %q", content)
+	}
+
+	// Without a variable manager the same filters and lookups are available
+	standalone := NewTemplateActionPlugin()
+	standalone.SetFilesystem(fs)
+	content, err = standalone.render("hosts.j2", taskVars, nil)
+	if err != nil || content != "192.0.2.10 web" {
+		t.Errorf("Unexpected content without a variable manager %q: %v", content, err)
+	}
+
+	// and the config lookup reads the task's configuration
+	afero.WriteFile(fs, "/templates/user.j2", []byte("{{ lookup('config', 'DEFAULT_REMOTE_USER') }}"), 0644)
+	content, err = standalone.render("user.j2", taskVars, &config.Config{RemoteUser: "deploy"})
+	if err != nil || content != "deploy" {
+		t.Errorf("Expected the configured remote user, got %q: %v", content, err)
+	}
+}
+
+// TestTemplateActionPlugin_KeepsOwnership tests that replacing a file owned
+// by another user keeps its owner and group unless new ones are given
+func TestTemplateActionPlugin_KeepsOwnership(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing file ownership requires root")
+	}
+
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/templates/motd.j2", []byte("welcome to {{ name }}\n"), 0644)
+	plugin := NewTemplateActionPlugin()
+	plugin.SetFilesystem(fs)
+
+	conn, err := connection.NewLocalConnection(&connection.ConnectionConfig{Host: "localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "motd")
+	if err := os.WriteFile(dest, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chown(dest, 65534, 65534); err != nil {
+		t.Fatal(err)
+	}
+	before := &remoteFile{}
+	if err := statAttributes(context.Background(), conn, dest, before); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(name string, args map[string]interface{}) *remoteFile {
+		t.Helper()
+		actionCtx := &plugins.ActionContext{
+			TaskVars:   map[string]interface{}{"name": name, "playbook_dir": "/"},
+			Connection: conn,
+			TempDir:    dir,
+		}
+		actionCtx.Args = map[string]interface{}{"src": "motd.j2", "dest": dest}
+		for key, value := range args {
+			actionCtx.Args[key] = value
+		}
+		result, err := plugin.Run(context.Background(), actionCtx)
+		if err != nil {
+			t.Fatalf("Plugin execution failed: %v", err)
+		}
+		if result.Failed || !result.Changed {
+			t.Fatalf("Expected a changed result, got %+v", result)
+		}
+		after := &remoteFile{}
+		if err := statAttributes(context.Background(), conn, dest, after); err != nil {
+			t.Fatal(err)
+		}
+		return after
+	}
+
+	after := run("web", nil)
+	if after.owner != before.owner || after.group != before.group {
+		t.Errorf("Expected ownership %s:%s to be kept, got %s:%s", before.owner, before.group, after.owner, after.group)
+	}
+
+	after = run("db", map[string]interface{}{"owner": "root"})
+	if after.owner != "root" || after.group != before.group {
+		t.Errorf("Expected ownership root:%s, got %s:%s", before.group, after.owner, after.group)
+	}
+}
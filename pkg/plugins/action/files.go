@@ -19,18 +19,23 @@ package action
 import (
 	"context"
 	"fmt"
-	"io"
 	"os"
-	"path/filepath"
+	"path"
 	"strings"
 	"time"
 
+	"github.com/spf13/afero"
+	"github.com/work-obs/ansible-go/pkg/config"
 	"github.com/work-obs/ansible-go/pkg/plugins"
+	"github.com/work-obs/ansible-go/pkg/template"
+	"github.com/work-obs/ansible-go/pkg/vars"
 )
 
 // TemplateActionPlugin implements the template action plugin
 type TemplateActionPlugin struct {
 	*BaseActionPlugin
+	fs     afero.Fs
+	engine *template.Engine
 }
 
 func NewTemplateActionPlugin() *TemplateActionPlugin {
@@ -41,14 +46,30 @@ func NewTemplateActionPlugin() *TemplateActionPlugin {
 			"1.0.0",
 			"Ansible Project",
 		),
+		fs: afero.NewOsFs(),
 	}
 }
 
+// SetFilesystem sets the controller filesystem templates are read from
+func (a *TemplateActionPlugin) SetFilesystem(fs afero.Fs) {
+	a.fs = fs
+}
+
+// SetTemplateEngine sets the engine templates are rendered with, normally
+// the variable manager's, so templates see the same filters and lookups
+// as task arguments
+func (a *TemplateActionPlugin) SetTemplateEngine(engine *template.Engine) {
+	a.engine = engine
+}
+
 func (a *TemplateActionPlugin) Run(ctx context.Context, actionCtx *plugins.ActionContext) (*plugins.ActionResult, error) {
 	args := actionCtx.Args
 
 	src := GetArgString(args, "src", "")
 	dest := GetArgString(args, "dest", "")
+	validate := GetArgString(args, "validate", "")
+	backup := GetArgBool(args, "backup", false)
+	attrs := getFileAttributes(args)
 
 	if src == "" {
 		return &plugins.ActionResult{
@@ -64,25 +85,186 @@ func (a *TemplateActionPlugin) Run(ctx context.Context, actionCtx *plugins.Actio
 		}, nil
 	}
 
-	if IsCheckMode(actionCtx) {
+	if validate != "" && !strings.Contains(validate, "%s") {
 		return &plugins.ActionResult{
-			Changed: true,
-			Message: fmt.Sprintf("Would template %s to %s", src, dest),
+			Failed:  true,
+			Message: fmt.Sprintf("validate must contain %%s: %s", validate),
+		}, nil
+	}
+
+	newline, ok := newlineSequences[GetArgString(args, "newline_sequence", `\n`)]
+	if !ok {
+		return &plugins.ActionResult{
+			Failed:  true,
+			Message: fmt.Sprintf("invalid newline_sequence '%s'", GetArgString(args, "newline_sequence", "")),
+		}, nil
+	}
+
+	cfg, _ := actionCtx.Config.(*config.Config)
+	rendered, err := a.render(src, actionCtx.TaskVars, cfg)
+	if err != nil {
+		return &plugins.ActionResult{
+			Failed:  true,
+			Message: err.Error(),
+		}, nil
+	}
+	content := []byte(strings.ReplaceAll(strings.ReplaceAll(rendered, "\r\n", "\n"), "\n", newline))
+
+	conn, err := GetConnection(actionCtx)
+	if err != nil {
+		return &plugins.ActionResult{
+			Failed:  true,
+			Message: err.Error(),
 		}, nil
 	}
 
+	// A directory destination receives a file named after the template
+	current, err := statRemote(ctx, conn, dest)
+	if err == nil && current.isDir {
+		dest = path.Join(dest, path.Base(src))
+		current, err = statRemote(ctx, conn, dest)
+	}
+	if err != nil {
+		return &plugins.ActionResult{
+			Failed:  true,
+			Message: err.Error(),
+		}, nil
+	}
+
+	checksum := Checksum(content)
+	changed := !current.exists || current.checksum != checksum
+
 	result := &plugins.ActionResult{
-		Changed: true,
+		Changed: changed,
 		Results: make(map[string]interface{}),
 	}
-
 	result.Results["src"] = src
 	result.Results["dest"] = dest
-	result.Message = "Template rendered successfully"
+	result.Results["checksum"] = checksum
+	result.Results["size"] = len(content)
+
+	if changed && IsDiffMode(actionCtx) {
+		var before []byte
+		if current.exists {
+			before, err = fetchRemote(ctx, conn, dest, actionCtx.TempDir)
+			if err != nil {
+				return &plugins.ActionResult{
+					Failed:  true,
+					Message: fmt.Sprintf("failed to read %s: %v", dest, err),
+				}, nil
+			}
+		}
+		result.Diff = map[string]interface{}{
+			"before":        string(before),
+			"after":         string(content),
+			"before_header": dest,
+			"after_header":  src,
+			"prepared":      UnifiedDiff(string(before), string(content), dest, src),
+		}
+	}
+
+	if IsCheckMode(actionCtx) {
+		if changed {
+			result.Message = fmt.Sprintf("Would template %s to %s", src, dest)
+		}
+		return result, nil
+	}
+
+	if changed {
+		if backup && current.exists {
+			backupFile, err := backupRemote(ctx, conn, dest)
+			if err != nil {
+				return &plugins.ActionResult{
+					Failed:  true,
+					Message: err.Error(),
+				}, nil
+			}
+			result.Results["backup_file"] = backupFile
+		}
+
+		// A replaced file keeps its mode, owner and group unless new ones
+		// are given
+		mode := attrs.mode
+		var owner string
+		if current.exists {
+			if mode == "" {
+				mode = current.mode
+			}
+			if attrs.owner == "" {
+				owner = current.owner
+			}
+			if attrs.group == "" {
+				owner += ":" + current.group
+			}
+		}
+		if err := transferContent(ctx, conn, content, dest, actionCtx.TempDir, validate, mode, owner); err != nil {
+			return &plugins.ActionResult{
+				Failed:  true,
+				Message: err.Error(),
+			}, nil
+		}
 
+		current = &remoteFile{exists: true}
+		if err := statAttributes(ctx, conn, dest, current); err != nil {
+			return &plugins.ActionResult{
+				Failed:  true,
+				Message: err.Error(),
+			}, nil
+		}
+	}
+
+	attrsChanged, err := applyAttributes(ctx, conn, dest, attrs, current)
+	if err != nil {
+		return &plugins.ActionResult{
+			Failed:  true,
+			Message: err.Error(),
+		}, nil
+	}
+	result.Changed = changed || attrsChanged
+
+	if result.Changed {
+		result.Message = "Template rendered successfully"
+	}
 	return result, nil
 }
 
+// newlineSequences maps the newline_sequence choices to line endings
+var newlineSequences = map[string]string{
+	`\n`:   "\n",
+	`\r`:   "\r",
+	`\r\n`: "\r\n",
+	"\n":   "\n",
+	"\r":   "\r",
+	"\r\n": "\r\n",
+}
+
+// render renders the src template on the controller with the task
+// variables. Relative names are looked up in the templates directory of
+// the role and the playbook, and include, import and extends resolve
+// against the same search path. Without the engine of a variable manager,
+// one is built for cfg, which may be nil.
+func (a *TemplateActionPlugin) render(src string, taskVars map[string]interface{}, cfg *config.Config) (string, error) {
+	engine := a.engine
+	if engine == nil {
+		vm := vars.NewManager(nil)
+		if cfg != nil {
+			vm.SetConfig(cfg)
+		}
+		engine = vm.TemplateEngine()
+	}
+	engine = engine.WithFilesystem(a.fs)
+
+	ctx := &template.Context{Variables: taskVars}
+	if hostvars, ok := taskVars["hostvars"].(map[string]map[string]interface{}); ok {
+		ctx.Hostvars = hostvars
+	}
+	if groups, ok := taskVars["groups"].(map[string][]string); ok {
+		ctx.Groups = groups
+	}
+
+	return engine.RenderFile(src, ctx)
+}
+
 // LineinfileActionPlugin implements the lineinfile action plugin
 type LineinfileActionPlugin struct {
 	*BaseActionPlugin
@@ -269,10 +451,6 @@ func (a *FindActionPlugin) Run(ctx context.Context, actionCtx *plugins.ActionCon
 		}, nil
 	}
 
-	patterns := GetArgStringSlice(args, "patterns")
-	fileType := GetArgString(args, "file_type", "file")
-	recurse := GetArgBool(args, "recurse", false)
-
 	result := &plugins.ActionResult{
 		Changed: false,
 		Results: make(map[string]interface{}),
@@ -0,0 +1,246 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/work-obs/ansible-go/pkg/inventory"
+	"github.com/work-obs/ansible-go/pkg/plugins"
+	"github.com/work-obs/ansible-go/pkg/vars"
+)
+
+// addHostNameArgs and addHostGroupArgs are the aliases add_host accepts
+// for the host name and its groups; every other argument is a host variable
+var (
+	addHostNameArgs  = []string{"name", "host", "hostname"}
+	addHostGroupArgs = []string{"groups", "group", "groupname"}
+)
+
+// inventoryActionPlugin holds the in-memory inventory that add_host and
+// group_by change, and the variable manager whose host contexts they
+// refresh afterwards
+type inventoryActionPlugin struct {
+	*BaseActionPlugin
+	inventory *inventory.Inventory
+	vars      *vars.Manager
+}
+
+// SetInventory sets the inventory the plugin changes and the variable
+// manager to refresh; vm may be nil
+func (a *inventoryActionPlugin) SetInventory(inv *inventory.Inventory, vm *vars.Manager) {
+	a.inventory = inv
+	a.vars = vm
+}
+
+// GetRequiredConnection returns "local": these plugins only change the
+// controller's inventory
+func (a *inventoryActionPlugin) GetRequiredConnection() string {
+	return "local"
+}
+
+// refresh makes inventory changes visible to later tasks and plays
+func (a *inventoryActionPlugin) refresh() {
+	if a.vars != nil {
+		a.vars.RefreshHostContexts()
+	}
+}
+
+// AddHostActionPlugin implements the add_host action plugin
+type AddHostActionPlugin struct {
+	inventoryActionPlugin
+}
+
+func NewAddHostActionPlugin() *AddHostActionPlugin {
+	return &AddHostActionPlugin{
+		inventoryActionPlugin: inventoryActionPlugin{
+			BaseActionPlugin: NewBaseActionPlugin(
+				"add_host",
+				"Add a host and, optionally, its groups to the in-memory inventory",
+				"1.0.0",
+				"Ansible Project",
+			),
+		},
+	}
+}
+
+func (a *AddHostActionPlugin) Run(ctx context.Context, actionCtx *plugins.ActionContext) (*plugins.ActionResult, error) {
+	if a.inventory == nil {
+		return &plugins.ActionResult{
+			Failed:  true,
+			Message: "add_host requires an inventory",
+		}, nil
+	}
+	args := actionCtx.Args
+
+	name := firstArgString(args, addHostNameArgs)
+	if name == "" {
+		return &plugins.ActionResult{
+			Failed:  true,
+			Message: "name is required",
+		}, nil
+	}
+
+	// The name may carry a port, as in db1:2222, but not a range
+	hostNames, port, err := inventory.ExpandHostPattern(name)
+	if err != nil || len(hostNames) != 1 {
+		return &plugins.ActionResult{
+			Failed:  true,
+			Message: fmt.Sprintf("invalid host name '%s'", name),
+		}, nil
+	}
+	hostName := hostNames[0]
+
+	var groups []string
+	for _, key := range addHostGroupArgs {
+		if _, exists := args[key]; exists {
+			for _, group := range GetArgStringSlice(args, key) {
+				if group = strings.TrimSpace(group); group != "" {
+					groups = append(groups, group)
+				}
+			}
+			break
+		}
+	}
+
+	hostVars := make(map[string]interface{})
+	for key, value := range args {
+		if !containsArg(addHostNameArgs, key) && !containsArg(addHostGroupArgs, key) {
+			hostVars[key] = value
+		}
+	}
+	if port != 0 {
+		hostVars["ansible_port"] = port
+	}
+
+	changed := a.inventory.AddHost(hostName, groups, hostVars)
+	a.refresh()
+
+	if groups == nil {
+		groups = []string{}
+	}
+	return &plugins.ActionResult{
+		Changed: changed,
+		Results: map[string]interface{}{
+			"add_host": map[string]interface{}{
+				"host_name": hostName,
+				"groups":    groups,
+				"host_vars": hostVars,
+			},
+		},
+	}, nil
+}
+
+// GroupByActionPlugin implements the group_by action plugin
+type GroupByActionPlugin struct {
+	inventoryActionPlugin
+}
+
+func NewGroupByActionPlugin() *GroupByActionPlugin {
+	return &GroupByActionPlugin{
+		inventoryActionPlugin: inventoryActionPlugin{
+			BaseActionPlugin: NewBaseActionPlugin(
+				"group_by",
+				"Create groups based on facts and add the current host to them",
+				"1.0.0",
+				"Ansible Project",
+			),
+		},
+	}
+}
+
+func (a *GroupByActionPlugin) Run(ctx context.Context, actionCtx *plugins.ActionContext) (*plugins.ActionResult, error) {
+	if a.inventory == nil {
+		return &plugins.ActionResult{
+			Failed:  true,
+			Message: "group_by requires an inventory",
+		}, nil
+	}
+	args := actionCtx.Args
+
+	key := GetArgString(args, "key", "")
+	if key == "" {
+		return &plugins.ActionResult{
+			Failed:  true,
+			Message: "the 'key' param is required when using group_by",
+		}, nil
+	}
+	// Group names cannot contain spaces
+	groupName := strings.ReplaceAll(key, " ", "-")
+
+	parents := []string{"all"}
+	if _, exists := args["parents"]; exists {
+		parents = GetArgStringSlice(args, "parents")
+	}
+
+	hostName := currentHostName(actionCtx)
+	if hostName == "" {
+		return &plugins.ActionResult{
+			Failed:  true,
+			Message: "group_by could not determine the current host (inventory_hostname is not set)",
+		}, nil
+	}
+
+	changed, err := a.inventory.AddHostToGroup(hostName, groupName, parents)
+	if err != nil {
+		return &plugins.ActionResult{
+			Failed:  true,
+			Message: err.Error(),
+		}, nil
+	}
+	a.refresh()
+
+	return &plugins.ActionResult{
+		Changed: changed,
+		Results: map[string]interface{}{
+			"add_group":     groupName,
+			"parent_groups": parents,
+		},
+	}, nil
+}
+
+// currentHostName returns inventory_hostname from the task variables,
+// the module variables or the facts
+func currentHostName(actionCtx *plugins.ActionContext) string {
+	for _, source := range []map[string]interface{}{actionCtx.TaskVars, actionCtx.Variables, actionCtx.Facts} {
+		if name, ok := source["inventory_hostname"].(string); ok && name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// firstArgString returns the first of the aliases set in args
+func firstArgString(args map[string]interface{}, aliases []string) string {
+	for _, alias := range aliases {
+		if _, exists := args[alias]; exists {
+			return GetArgString(args, alias, "")
+		}
+	}
+	return ""
+}
+
+func containsArg(aliases []string, key string) bool {
+	for _, alias := range aliases {
+		if alias == key {
+			return true
+		}
+	}
+	return false
+}
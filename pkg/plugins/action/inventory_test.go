@@ -0,0 +1,191 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/work-obs/ansible-go/pkg/inventory"
+	"github.com/work-obs/ansible-go/pkg/plugins"
+	"github.com/work-obs/ansible-go/pkg/vars"
+)
+
+// inventoryActionContext builds an action context with args and task vars
+func inventoryActionContext(args, taskVars map[string]interface{}) *plugins.ActionContext {
+	actionCtx := &plugins.ActionContext{TaskVars: taskVars}
+	actionCtx.Args = args
+	return actionCtx
+}
+
+func TestAddHostActionPlugin(t *testing.T) {
+	inv := inventory.NewInventory(afero.NewMemMapFs())
+	vm := vars.NewManager(inv)
+	ctx, err := vm.CreateHostContext("localhost")
+	if err != nil {
+		t.Fatalf("Failed to create host context: %v", err)
+	}
+
+	plugin := NewAddHostActionPlugin()
+	result, _ := plugin.Run(context.Background(), inventoryActionContext(map[string]interface{}{"name": "192.0.2.10"}, nil))
+	if !result.Failed {
+		t.Error("Expected add_host to fail without an inventory")
+	}
+
+	plugin.SetInventory(inv, vm)
+	result, err = plugin.Run(context.Background(), inventoryActionContext(map[string]interface{}{
+		"name":   "192.0.2.10:2222",
+		"groups": "web,db",
+		"role":   "frontend",
+	}, nil))
+	if err != nil || result.Failed {
+		t.Fatalf("add_host failed: %v %s", err, result.Message)
+	}
+	if !result.Changed {
+		t.Error("Expected adding a new host to report changed")
+	}
+	added := result.Results["add_host"].(map[string]interface{})
+	if added["host_name"] != "192.0.2.10" || !reflect.DeepEqual(added["groups"], []string{"web", "db"}) {
+		t.Errorf("Unexpected add_host result: %v", added)
+	}
+
+	vars := inv.GetHostVars("192.0.2.10")
+	if vars["role"] != "frontend" || vars["ansible_port"] != 2222 {
+		t.Errorf("Expected host variables and port, got %v", vars)
+	}
+	if hosts := ctx.Groups["db"]; !reflect.DeepEqual(hosts, []string{"192.0.2.10"}) {
+		t.Errorf("Expected host contexts to see the new group, got %v", hosts)
+	}
+
+	// Adding the same host again changes nothing
+	result, _ = plugin.Run(context.Background(), inventoryActionContext(map[string]interface{}{
+		"hostname": "192.0.2.10",
+		"group":    "web",
+		"role":     "frontend",
+	}, nil))
+	if result.Failed || result.Changed {
+		t.Errorf("Expected an unchanged result, got %+v", result)
+	}
+
+	result, _ = plugin.Run(context.Background(), inventoryActionContext(map[string]interface{}{"name": "web[1:3]"}, nil))
+	if !result.Failed {
+		t.Error("Expected add_host to reject a range")
+	}
+}
+
+func TestGroupByActionPlugin(t *testing.T) {
+	inv := inventory.NewInventory(afero.NewMemMapFs())
+	inv.AddHost("localhost", nil, nil)
+
+	plugin := NewGroupByActionPlugin()
+	plugin.SetInventory(inv, nil)
+
+	result, err := plugin.Run(context.Background(), inventoryActionContext(map[string]interface{}{"key": "os Linux", "parents": "linux,servers"}, map[string]interface{}{"inventory_hostname": "localhost"}))
+	if err != nil || result.Failed {
+		t.Fatalf("group_by failed: %v %s", err, result.Message)
+	}
+	if !result.Changed || result.Results["add_group"] != "os-Linux" {
+		t.Errorf("Unexpected group_by result: %+v", result)
+	}
+	for _, parent := range []string{"linux", "servers"} {
+		if children := inv.Groups[parent].Children; !reflect.DeepEqual(children, []string{"os-Linux"}) {
+			t.Errorf("Expected %s to have child os-Linux, got %v", parent, children)
+		}
+	}
+	if hosts := inv.Groups["os-Linux"].Hosts; !reflect.DeepEqual(hosts, []string{"localhost"}) {
+		t.Errorf("Expected localhost in os-Linux, got %v", hosts)
+	}
+
+	result, _ = plugin.Run(context.Background(), inventoryActionContext(map[string]interface{}{"key": "missing"}, map[string]interface{}{"inventory_hostname": "192.0.2.99"}))
+	if !result.Failed {
+		t.Error("Expected group_by to fail for a host not in the inventory")
+	}
+}
+
+func TestInventoryActionPlugins_Concurrent(t *testing.T) {
+	inv := inventory.NewInventory(afero.NewMemMapFs())
+	vm := vars.NewManager(inv)
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 20; i++ {
+		host := fmt.Sprintf("192.0.2.%d", i)
+		if _, err := vm.CreateHostContext(host); err != nil {
+			t.Fatalf("Failed to create host context: %v", err)
+		}
+
+		wg.Add(1)
+		go func(host string, i int) {
+			defer wg.Done()
+			addHost := NewAddHostActionPlugin()
+			addHost.SetInventory(inv, vm)
+			addHost.Run(context.Background(), inventoryActionContext(map[string]interface{}{"name": host, "groups": "added"}, nil))
+
+			groupBy := NewGroupByActionPlugin()
+			groupBy.SetInventory(inv, vm)
+			groupBy.Run(context.Background(), inventoryActionContext(map[string]interface{}{"key": fmt.Sprintf("parity_%d", i%2)}, map[string]interface{}{"inventory_hostname": host}))
+		}(host, i)
+	}
+	wg.Wait()
+
+	if hosts := inv.ListHosts(); len(hosts) != 20 {
+		t.Errorf("Expected 20 hosts, got %d", len(hosts))
+	}
+	groups := inv.GroupHosts()
+	if len(groups["added"]) != 20 || len(groups["parity_0"]) != 10 || len(groups["parity_1"]) != 10 {
+		t.Errorf("Unexpected groups after concurrent runs: %v", groups)
+	}
+}
+
+func TestActionPluginRegistry_SetInventory(t *testing.T) {
+	inv := inventory.NewInventory(afero.NewMemMapFs())
+	vm := vars.NewManager(inv)
+	registry := NewActionPluginRegistry()
+	registry.SetInventory(inv, vm)
+
+	plugin, err := registry.Get("add_host")
+	if err != nil {
+		t.Fatalf("Failed to get add_host: %v", err)
+	}
+	result, err := plugin.Run(context.Background(), inventoryActionContext(map[string]interface{}{"name": "192.0.2.10", "groups": "web"}, nil))
+	if err != nil || result.Failed {
+		t.Fatalf("add_host from the registry failed: %v %s", err, result.Message)
+	}
+
+	plugin, err = registry.Get("group_by")
+	if err != nil {
+		t.Fatalf("Failed to get group_by: %v", err)
+	}
+	result, err = plugin.Run(context.Background(), inventoryActionContext(map[string]interface{}{"key": "tier_front"}, map[string]interface{}{"inventory_hostname": "192.0.2.10"}))
+	if err != nil || result.Failed {
+		t.Fatalf("group_by from the registry failed: %v %s", err, result.Message)
+	}
+
+	groups := inv.GroupHosts()
+	if !reflect.DeepEqual(groups["web"], []string{"192.0.2.10"}) || !reflect.DeepEqual(groups["tier_front"], []string{"192.0.2.10"}) {
+		t.Errorf("Unexpected groups after registry runs: %v", groups)
+	}
+
+	// Without an inventory the plugins fail instead of panicking
+	plugin, _ = NewActionPluginRegistry().Get("add_host")
+	if result, _ := plugin.Run(context.Background(), inventoryActionContext(map[string]interface{}{"name": "192.0.2.11"}, nil)); !result.Failed {
+		t.Error("Expected add_host without an inventory to fail")
+	}
+}
@@ -20,7 +20,10 @@ import (
 	"fmt"
 	"sync"
 
+	"github.com/work-obs/ansible-go/pkg/inventory"
 	"github.com/work-obs/ansible-go/pkg/plugins"
+	"github.com/work-obs/ansible-go/pkg/template"
+	"github.com/work-obs/ansible-go/pkg/vars"
 )
 
 // ActionPluginCreator is a function that creates an action plugin instance
@@ -28,8 +31,22 @@ type ActionPluginCreator func() plugins.ActionPlugin
 
 // ActionPluginRegistry manages action plugin registration and creation
 type ActionPluginRegistry struct {
-	plugins map[string]ActionPluginCreator
-	mutex   sync.RWMutex
+	plugins   map[string]ActionPluginCreator
+	inventory *inventory.Inventory
+	vars      *vars.Manager
+	mutex     sync.RWMutex
+}
+
+// inventoryPlugin is implemented by plugins that change the inventory,
+// such as add_host and group_by
+type inventoryPlugin interface {
+	SetInventory(inv *inventory.Inventory, vm *vars.Manager)
+}
+
+// templatePlugin is implemented by plugins that render templates on the
+// controller, such as template
+type templatePlugin interface {
+	SetTemplateEngine(engine *template.Engine)
 }
 
 // NewActionPluginRegistry creates a new action plugin registry
@@ -51,17 +68,35 @@ func (r *ActionPluginRegistry) Register(name string, creator ActionPluginCreator
 	r.plugins[name] = creator
 }
 
+// SetInventory sets the inventory given to the plugins that change it and
+// the variable manager whose template engine controller-side templates are
+// rendered with; vm may be nil
+func (r *ActionPluginRegistry) SetInventory(inv *inventory.Inventory, vm *vars.Manager) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.inventory = inv
+	r.vars = vm
+}
+
 // Get retrieves an action plugin by name
 func (r *ActionPluginRegistry) Get(name string) (plugins.ActionPlugin, error) {
 	r.mutex.RLock()
 	creator, exists := r.plugins[name]
+	inv, vm := r.inventory, r.vars
 	r.mutex.RUnlock()
 
 	if !exists {
 		return nil, fmt.Errorf("action plugin '%s' not found", name)
 	}
 
-	return creator(), nil
+	plugin := creator()
+	if aware, ok := plugin.(inventoryPlugin); ok && inv != nil {
+		aware.SetInventory(inv, vm)
+	}
+	if renderer, ok := plugin.(templatePlugin); ok && vm != nil {
+		renderer.SetTemplateEngine(vm.TemplateEngine())
+	}
+	return plugin, nil
 }
 
 // List returns all registered action plugin names
@@ -116,6 +151,20 @@ func (r *ActionPluginRegistry) registerBuiltinPlugins() {
 		return NewServiceActionPlugin()
 	})
 
+	// Register template action plugin; Get gives it the registry's
+	// template engine
+	r.Register("template", func() plugins.ActionPlugin {
+		return NewTemplateActionPlugin()
+	})
+
+	// Register inventory action plugins; Get gives them the registry's inventory
+	r.Register("add_host", func() plugins.ActionPlugin {
+		return NewAddHostActionPlugin()
+	})
+	r.Register("group_by", func() plugins.ActionPlugin {
+		return NewGroupByActionPlugin()
+	})
+
 	// Register normal action plugin (generic action for most modules)
 	r.Register("normal", func() plugins.ActionPlugin {
 		return NewNormalActionPlugin()
@@ -135,6 +184,12 @@ func GetActionPlugin(name string) (plugins.ActionPlugin, error) {
 	return DefaultRegistry.Get(name)
 }
 
+// SetActionInventory sets the inventory and variable manager of the
+// default registry
+func SetActionInventory(inv *inventory.Inventory, vm *vars.Manager) {
+	DefaultRegistry.SetInventory(inv, vm)
+}
+
 // ListActionPlugins returns all registered action plugin names
 func ListActionPlugins() []string {
 	return DefaultRegistry.List()
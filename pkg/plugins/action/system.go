@@ -20,9 +20,6 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"os/user"
-	"strconv"
-	"time"
 
 	"github.com/work-obs/ansible-go/pkg/plugins"
 )
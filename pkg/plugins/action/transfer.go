@@ -0,0 +1,284 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/work-obs/ansible-go/pkg/connection"
+	"github.com/work-obs/ansible-go/pkg/plugins"
+)
+
+// GetConnection returns the connection of an action context
+func GetConnection(actionCtx *plugins.ActionContext) (connection.Connection, error) {
+	conn, ok := actionCtx.Connection.(connection.Connection)
+	if !ok || conn == nil {
+		return nil, fmt.Errorf("no connection available for this action")
+	}
+	return conn, nil
+}
+
+// ShellQuote quotes a string for a POSIX shell
+func ShellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+// Checksum returns the SHA-1 checksum Ansible reports for file content
+func Checksum(data []byte) string {
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// runRemote runs a command on the remote host and fails on a non-zero exit
+func runRemote(ctx context.Context, conn connection.Connection, cmd string) (string, error) {
+	result, err := conn.Execute(ctx, cmd, nil)
+	if err != nil {
+		return "", err
+	}
+	if result.ExitCode != 0 {
+		msg := strings.TrimSpace(result.Stderr)
+		if msg == "" {
+			msg = strings.TrimSpace(result.Stdout)
+		}
+		return result.Stdout, fmt.Errorf("command failed with rc %d: %s", result.ExitCode, msg)
+	}
+	return result.Stdout, nil
+}
+
+// remoteFile describes a file on the remote host
+type remoteFile struct {
+	exists   bool
+	isDir    bool
+	checksum string
+	owner    string
+	group    string
+	mode     string
+}
+
+// statRemote reads the type, ownership, mode and checksum of a remote file
+func statRemote(ctx context.Context, conn connection.Connection, filePath string) (*remoteFile, error) {
+	exists, err := conn.FileExists(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return &remoteFile{}, nil
+	}
+
+	info, err := conn.GetFileInfo(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	file := &remoteFile{exists: true, isDir: info.IsDir}
+	if file.isDir {
+		return file, nil
+	}
+
+	if err := statAttributes(ctx, conn, filePath, file); err != nil {
+		return nil, err
+	}
+
+	quoted := ShellQuote(filePath)
+	out, err := runRemote(ctx, conn, fmt.Sprintf("sha1sum %s 2>/dev/null || shasum -a 1 %s", quoted, quoted))
+	if err != nil {
+		return nil, fmt.Errorf("failed to checksum %s: %w", filePath, err)
+	}
+	fields := strings.Fields(out)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("failed to checksum %s: no output", filePath)
+	}
+	file.checksum = fields[0]
+	return file, nil
+}
+
+// statAttributes reads the owner, group and octal mode of a remote file
+func statAttributes(ctx context.Context, conn connection.Connection, filePath string, file *remoteFile) error {
+	quoted := ShellQuote(filePath)
+	out, err := runRemote(ctx, conn, fmt.Sprintf("stat -c '%%U %%G %%a' %s 2>/dev/null || stat -f '%%Su %%Sg %%Lp' %s", quoted, quoted))
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", filePath, err)
+	}
+	fields := strings.Fields(out)
+	if len(fields) != 3 {
+		return fmt.Errorf("failed to stat %s: unexpected output %q", filePath, out)
+	}
+	file.owner, file.group, file.mode = fields[0], fields[1], fields[2]
+	return nil
+}
+
+// fetchRemote returns the content of a remote file
+func fetchRemote(ctx context.Context, conn connection.Connection, filePath, tempDir string) ([]byte, error) {
+	local, err := os.CreateTemp(tempDir, "ansible-fetch-")
+	if err != nil {
+		return nil, err
+	}
+	local.Close()
+	defer os.Remove(local.Name())
+
+	if err := conn.GetFile(ctx, filePath, local.Name()); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(local.Name())
+}
+
+// fileAttributes are the owner, group and mode arguments shared by the
+// file-producing actions
+type fileAttributes struct {
+	owner string
+	group string
+	mode  string
+}
+
+// getFileAttributes reads owner, group and mode from the arguments. YAML
+// turns an unquoted 0644 into the integer 420, which is taken as octal.
+func getFileAttributes(args map[string]interface{}) fileAttributes {
+	attrs := fileAttributes{
+		owner: GetArgString(args, "owner", ""),
+		group: GetArgString(args, "group", ""),
+	}
+	switch mode := args["mode"].(type) {
+	case int:
+		attrs.mode = fmt.Sprintf("%04o", mode)
+	case int64:
+		attrs.mode = fmt.Sprintf("%04o", mode)
+	case float64:
+		attrs.mode = fmt.Sprintf("%04o", int64(mode))
+	case string:
+		attrs.mode = mode
+	}
+	return attrs
+}
+
+// applyAttributes sets the owner, group and mode of a remote file and
+// reports whether anything changed
+func applyAttributes(ctx context.Context, conn connection.Connection, filePath string, attrs fileAttributes, current *remoteFile) (bool, error) {
+	quoted := ShellQuote(filePath)
+	changed := false
+
+	owner := attrs.owner
+	if attrs.group != "" {
+		owner += ":" + attrs.group
+	}
+	if owner != "" && ((attrs.owner != "" && attrs.owner != current.owner) || (attrs.group != "" && attrs.group != current.group)) {
+		if _, err := runRemote(ctx, conn, fmt.Sprintf("chown %s %s", ShellQuote(owner), quoted)); err != nil {
+			return false, fmt.Errorf("failed to set owner of %s: %w", filePath, err)
+		}
+		changed = true
+	}
+
+	if attrs.mode != "" && !sameMode(attrs.mode, current.mode) {
+		if _, err := runRemote(ctx, conn, fmt.Sprintf("chmod %s %s", ShellQuote(attrs.mode), quoted)); err != nil {
+			return false, fmt.Errorf("failed to set mode of %s: %w", filePath, err)
+		}
+
+		// Symbolic modes are only known to differ once applied
+		after := &remoteFile{}
+		if err := statAttributes(ctx, conn, filePath, after); err != nil {
+			return false, err
+		}
+		changed = changed || after.mode != current.mode
+	}
+
+	return changed, nil
+}
+
+// sameMode compares an octal mode argument with a mode read by stat
+func sameMode(mode, current string) bool {
+	want, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return false
+	}
+	have, err := strconv.ParseUint(current, 8, 32)
+	return err == nil && want == have
+}
+
+// transferContent writes content to a remote file atomically: it is
+// uploaded to a temporary file in the destination directory, optionally
+// validated, and renamed over the destination. Like Ansible's atomic_move,
+// the temporary file is first given owner (user:group, either part may be
+// empty) so that the file keeps its ownership; a chown the remote user is
+// not permitted to make is not an error.
+func transferContent(ctx context.Context, conn connection.Connection, content []byte, dest, tempDir, validate, mode, owner string) error {
+	local, err := os.CreateTemp(tempDir, "ansible-upload-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(local.Name())
+
+	if _, err := local.Write(content); err != nil {
+		local.Close()
+		return err
+	}
+	if err := local.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(local.Name(), 0644); err != nil {
+		return err
+	}
+
+	tmp := path.Join(path.Dir(dest), fmt.Sprintf(".ansible_tmp%d_%s", time.Now().UnixNano(), path.Base(dest)))
+	if err := conn.PutFile(ctx, local.Name(), tmp); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", dest, err)
+	}
+
+	cleanup := func() { _ = conn.RemoveFile(ctx, tmp) }
+
+	if owner != "" {
+		_, _ = runRemote(ctx, conn, fmt.Sprintf("chown %s %s", ShellQuote(owner), ShellQuote(tmp)))
+	}
+
+	if mode != "" {
+		if _, err := runRemote(ctx, conn, fmt.Sprintf("chmod %s %s", ShellQuote(mode), ShellQuote(tmp))); err != nil {
+			cleanup()
+			return fmt.Errorf("failed to set mode of %s: %w", dest, err)
+		}
+	}
+
+	if validate != "" {
+		if _, err := runRemote(ctx, conn, strings.ReplaceAll(validate, "%s", ShellQuote(tmp))); err != nil {
+			cleanup()
+			return fmt.Errorf("failed to validate: %w", err)
+		}
+	}
+
+	if _, err := runRemote(ctx, conn, fmt.Sprintf("mv -f %s %s", ShellQuote(tmp), ShellQuote(dest))); err != nil {
+		cleanup()
+		return fmt.Errorf("failed to move %s into place: %w", dest, err)
+	}
+	return nil
+}
+
+// backupRemote copies a remote file to a timestamped backup next to it and
+// returns the backup's name
+func backupRemote(ctx context.Context, conn connection.Connection, filePath string) (string, error) {
+	backup := fmt.Sprintf("%s.%d.%s~", filePath, os.Getpid(), time.Now().Format("2006-01-02@15:04:05"))
+	if _, err := runRemote(ctx, conn, fmt.Sprintf("cp -p %s %s", ShellQuote(filePath), ShellQuote(backup))); err != nil {
+		return "", fmt.Errorf("failed to back up %s: %w", filePath, err)
+	}
+	return backup, nil
+}
@@ -17,7 +17,6 @@ limitations under the License.
 package cache
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -86,6 +85,11 @@ type cacheEntry struct {
 	expiry time.Time
 }
 
+// expired reports whether the entry has expired; a zero expiry never does
+func (e *cacheEntry) expired(now time.Time) bool {
+	return !e.expiry.IsZero() && now.After(e.expiry)
+}
+
 func NewMemoryCachePlugin() *MemoryCachePlugin {
 	return &MemoryCachePlugin{
 		BaseCachePlugin: NewBaseCachePlugin(
@@ -99,15 +103,16 @@ func NewMemoryCachePlugin() *MemoryCachePlugin {
 }
 
 func (m *MemoryCachePlugin) Get(key string) (interface{}, error) {
-	m.mutex.RLock()
-	defer m.mutex.RUnlock()
+	// Expired entries are removed, so Get needs the write lock
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 
 	entry, exists := m.cache[key]
 	if !exists {
 		return nil, fmt.Errorf("key not found: %s", key)
 	}
 
-	if time.Now().After(entry.expiry) {
+	if entry.expired(time.Now()) {
 		delete(m.cache, key)
 		return nil, fmt.Errorf("key expired: %s", key)
 	}
@@ -141,15 +146,15 @@ func (m *MemoryCachePlugin) Delete(key string) error {
 }
 
 func (m *MemoryCachePlugin) Contains(key string) bool {
-	m.mutex.RLock()
-	defer m.mutex.RUnlock()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 
 	entry, exists := m.cache[key]
 	if !exists {
 		return false
 	}
 
-	if time.Now().After(entry.expiry) {
+	if entry.expired(time.Now()) {
 		delete(m.cache, key)
 		return false
 	}
@@ -165,7 +170,7 @@ func (m *MemoryCachePlugin) Keys() ([]string, error) {
 	now := time.Now()
 
 	for key, entry := range m.cache {
-		if now.After(entry.expiry) {
+		if entry.expired(now) {
 			continue
 		}
 		keys = append(keys, key)
@@ -213,6 +218,11 @@ func (j *JsonFileCachePlugin) Get(key string) (interface{}, error) {
 	j.mutex.RLock()
 	defer j.mutex.RUnlock()
 
+	return j.get(key)
+}
+
+// get reads a key; the caller holds the lock
+func (j *JsonFileCachePlugin) get(key string) (interface{}, error) {
 	filename := j.getCacheFile(key)
 	data, err := os.ReadFile(filename)
 	if err != nil {
@@ -283,13 +293,22 @@ func (j *JsonFileCachePlugin) Delete(key string) error {
 }
 
 func (j *JsonFileCachePlugin) Contains(key string) bool {
+	j.mutex.RLock()
+	defer j.mutex.RUnlock()
+
+	return j.contains(key)
+}
+
+// contains reports whether a key is set and unexpired; the caller holds
+// the lock
+func (j *JsonFileCachePlugin) contains(key string) bool {
 	filename := j.getCacheFile(key)
 	if _, err := os.Stat(filename); os.IsNotExist(err) {
 		return false
 	}
 
 	// Check if expired
-	_, err := j.Get(key)
+	_, err := j.get(key)
 	return err == nil
 }
 
@@ -314,7 +333,7 @@ func (j *JsonFileCachePlugin) Keys() ([]string, error) {
 		name := entry.Name()
 		if filepath.Ext(name) == ".json" {
 			key := name[:len(name)-5] // Remove .json extension
-			if j.Contains(key) {
+			if j.contains(key) {
 				keys = append(keys, key)
 			}
 		}
@@ -0,0 +1,52 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCachePlugins(t *testing.T) {
+	for _, plugin := range []CachePlugin{NewMemoryCachePlugin(), NewJsonFileCachePlugin(t.TempDir())} {
+		t.Run(plugin.Name(), func(t *testing.T) {
+			if err := plugin.Set("forever", "value", 0); err != nil {
+				t.Fatalf("Failed to set: %v", err)
+			}
+			if value, err := plugin.Get("forever"); err != nil || value != "value" {
+				t.Errorf("Expected a zero ttl never to expire, got %v, %v", value, err)
+			}
+
+			plugin.Set("brief", "value", time.Nanosecond)
+			time.Sleep(time.Millisecond)
+			if plugin.Contains("brief") {
+				t.Error("Expected the entry to have expired")
+			}
+			if _, err := plugin.Get("brief"); err == nil {
+				t.Error("Expected Get to fail for an expired entry")
+			}
+
+			if keys, _ := plugin.Keys(); len(keys) != 1 || keys[0] != "forever" {
+				t.Errorf("Expected only the unexpired key, got %v", keys)
+			}
+			plugin.Flush()
+			if plugin.Contains("forever") {
+				t.Error("Expected Flush to remove every entry")
+			}
+		})
+	}
+}
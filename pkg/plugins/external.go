@@ -0,0 +1,498 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugins
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// External plugins are executables, written in any language, that provide
+// filters, lookups and tests over JSON.
+//
+// At startup the loader runs "<plugin> describe", which prints a
+// description such as
+//
+//	{"name": "net", "protocol": "json", "filters": ["cidr"], "tests": [],
+//	 "lookups": [], "variables": false, "description": "...", "version": "1.0"}
+//
+// With the "json" protocol (the default) each call runs the executable
+// once with no arguments, writes one request to its stdin and reads one
+// response from its stdout:
+//
+//	{"method": "filter", "params": {"name": "cidr", "input": "10.0.0.1", "args": [], "kwargs": {}}}
+//	{"result": "10.0.0.0/8"}  or  {"error": "message"}
+//
+// With the "jsonrpc" protocol the loader starts "<plugin> serve" once per
+// run and exchanges line-delimited JSON-RPC 2.0 messages with it:
+//
+//	{"jsonrpc": "2.0", "id": 1, "method": "filter", "params": {...}}
+//	{"jsonrpc": "2.0", "id": 1, "result": "10.0.0.0/8"}
+//
+// The methods are "filter" (name, input, args, kwargs), "lookup" (name,
+// terms, options and, when the plugin asks for them, variables) and "test"
+// (name, input, args), which must return a boolean.
+//
+// A call that gets no response within the plugin's timeout fails; a
+// one-shot process or long-lived server that is still running is killed.
+
+const (
+	ExternalProtocolJSON    = "json"
+	ExternalProtocolJSONRPC = "jsonrpc"
+
+	// externalDescribeTimeout bounds the startup description
+	externalDescribeTimeout = 30 * time.Second
+
+	// externalCallTimeout bounds a call of a plugin without its own Timeout
+	externalCallTimeout = 60 * time.Second
+
+	// externalWaitDelay is how long the pipes of an exited or killed plugin
+	// process are kept open for processes it started
+	externalWaitDelay = time.Second
+)
+
+// ExternalDescription is what an external plugin reports about itself
+type ExternalDescription struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Version     string   `json:"version"`
+	Author      []string `json:"author"`
+	Protocol    string   `json:"protocol"`
+	Filters     []string `json:"filters"`
+	Lookups     []string `json:"lookups"`
+	Tests       []string `json:"tests"`
+	// Variables asks for the task variables to be sent with lookups
+	Variables bool `json:"variables"`
+}
+
+// ExternalPlugin runs an external plugin executable
+type ExternalPlugin struct {
+	Path string
+	ExternalDescription
+	// Timeout bounds each call; zero means externalCallTimeout
+	Timeout time.Duration
+
+	mutex   sync.Mutex
+	server  *exec.Cmd
+	stdin   io.WriteCloser
+	stdout  *bufio.Reader
+	stderr  *lockedBuffer
+	nextID  int
+	started bool
+}
+
+// externalRequest is a one-shot request
+type externalRequest struct {
+	Method string      `json:"method"`
+	Params interface{} `json:"params"`
+}
+
+// externalResponse is a one-shot or JSON-RPC response
+type externalResponse struct {
+	ID     *int            `json:"id,omitempty"`
+	Result json.RawMessage `json:"result"`
+	Error  json.RawMessage `json:"error"`
+}
+
+// serverReply is the outcome of a request to the long-lived process
+type serverReply struct {
+	response *externalResponse
+	err      error
+	// broken is set when the pipes to the process failed
+	broken bool
+}
+
+// rpcRequest is a JSON-RPC 2.0 request
+type rpcRequest struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      int         `json:"id"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+// DescribeExternalPlugin runs an executable's describe command
+func DescribeExternalPlugin(ctx context.Context, path string) (*ExternalPlugin, error) {
+	ctx, cancel := context.WithTimeout(ctx, externalDescribeTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, "describe")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to describe external plugin %s: %w%s", path, err, stderrSuffix(stderr.String()))
+	}
+
+	plugin := &ExternalPlugin{Path: path}
+	if err := json.Unmarshal(stdout.Bytes(), &plugin.ExternalDescription); err != nil {
+		return nil, fmt.Errorf("external plugin %s returned an invalid description: %w", path, err)
+	}
+
+	if plugin.Name == "" {
+		plugin.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	switch plugin.Protocol {
+	case "":
+		plugin.Protocol = ExternalProtocolJSON
+	case ExternalProtocolJSON, ExternalProtocolJSONRPC:
+	default:
+		return nil, fmt.Errorf("external plugin %s uses unknown protocol '%s'", path, plugin.Protocol)
+	}
+	return plugin, nil
+}
+
+// Filter applies one of the plugin's filters
+func (p *ExternalPlugin) Filter(ctx context.Context, name string, input interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	return p.Call(ctx, "filter", map[string]interface{}{
+		"name":   name,
+		"input":  input,
+		"args":   nonNilList(args),
+		"kwargs": nonNilMap(kwargs),
+	})
+}
+
+// Lookup runs one of the plugin's lookups
+func (p *ExternalPlugin) Lookup(ctx context.Context, name string, terms []string, variables, options map[string]interface{}) ([]interface{}, error) {
+	params := map[string]interface{}{
+		"name":    name,
+		"terms":   terms,
+		"options": nonNilMap(options),
+	}
+	if p.Variables {
+		params["variables"] = jsonSafe(variables)
+	}
+
+	result, err := p.Call(ctx, "lookup", params)
+	if err != nil {
+		return nil, err
+	}
+	switch v := result.(type) {
+	case nil:
+		return []interface{}{}, nil
+	case []interface{}:
+		return v, nil
+	default:
+		return []interface{}{v}, nil
+	}
+}
+
+// Test runs one of the plugin's tests
+func (p *ExternalPlugin) Test(ctx context.Context, name string, input interface{}, args []interface{}) (bool, error) {
+	result, err := p.Call(ctx, "test", map[string]interface{}{
+		"name":  name,
+		"input": input,
+		"args":  nonNilList(args),
+	})
+	if err != nil {
+		return false, err
+	}
+	value, ok := result.(bool)
+	if !ok {
+		return false, fmt.Errorf("external test '%s' returned %T instead of a boolean", name, result)
+	}
+	return value, nil
+}
+
+// Call sends a request to the plugin and returns its result
+func (p *ExternalPlugin) Call(ctx context.Context, method string, params interface{}) (interface{}, error) {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = externalCallTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var (
+		response *externalResponse
+		err      error
+	)
+	if p.Protocol == ExternalProtocolJSONRPC {
+		response, err = p.callServer(ctx, method, params)
+	} else {
+		response, err = p.callOnce(ctx, method, params)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response.Error) > 0 && string(response.Error) != "null" {
+		return nil, &PluginError{Message: fmt.Sprintf("%s plugin %s: %s", method, p.Name, errorMessage(response.Error))}
+	}
+	return decodeJSON(response.Result)
+}
+
+// callOnce runs the executable for a single request
+func (p *ExternalPlugin) callOnce(ctx context.Context, method string, params interface{}) (*externalResponse, error) {
+	request, err := json.Marshal(externalRequest{Method: method, Params: params})
+	if err != nil {
+		return nil, fmt.Errorf("cannot send %s request to external plugin %s: %w", method, p.Name, err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.Path)
+	cmd.WaitDelay = externalWaitDelay
+	cmd.Stdin = bytes.NewReader(request)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("external plugin %s did not answer the %s request: %w", p.Name, method, ctx.Err())
+		}
+		return nil, fmt.Errorf("external plugin %s failed: %w%s", p.Name, err, stderrSuffix(stderr.String()))
+	}
+
+	var response externalResponse
+	if err := json.Unmarshal(stdout.Bytes(), &response); err != nil {
+		return nil, fmt.Errorf("external plugin %s returned an invalid response: %w", p.Name, err)
+	}
+	return &response, nil
+}
+
+// callServer sends a JSON-RPC request to the long-lived process, starting
+// it on first use. Requests are serialized. The process is killed if it
+// does not answer before ctx is done.
+func (p *ExternalPlugin) callServer(ctx context.Context, method string, params interface{}) (*externalResponse, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.started {
+		if err := p.startServer(); err != nil {
+			return nil, err
+		}
+	}
+	if p.server == nil {
+		return nil, fmt.Errorf("external plugin %s is not running%s", p.Name, stderrSuffix(p.stderr.String()))
+	}
+
+	p.nextID++
+	id := p.nextID
+	request, err := json.Marshal(rpcRequest{JSONRPC: "2.0", ID: id, Method: method, Params: params})
+	if err != nil {
+		return nil, fmt.Errorf("cannot send %s request to external plugin %s: %w", method, p.Name, err)
+	}
+
+	replies := make(chan serverReply, 1)
+	go func() { replies <- p.exchange(id, request) }()
+
+	select {
+	case reply := <-replies:
+		if reply.broken {
+			p.stopServer()
+			return nil, fmt.Errorf("external plugin %s stopped: %w%s", p.Name, reply.err, stderrSuffix(p.stderr.String()))
+		}
+		return reply.response, reply.err
+	case <-ctx.Done():
+		// Killing the process ends the exchange, whose read then fails
+		p.server.Process.Kill()
+		p.server.Wait()
+		<-replies
+		p.server = nil
+		return nil, fmt.Errorf("external plugin %s did not answer the %s request: %w", p.Name, method, ctx.Err())
+	}
+}
+
+// exchange writes a request to the long-lived process and reads the
+// response with the same id
+func (p *ExternalPlugin) exchange(id int, request []byte) serverReply {
+	if _, err := p.stdin.Write(append(request, '\n')); err != nil {
+		return serverReply{err: err, broken: true}
+	}
+
+	for {
+		line, err := p.stdout.ReadBytes('\n')
+		if err != nil {
+			return serverReply{err: err, broken: true}
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var response externalResponse
+		if err := json.Unmarshal(line, &response); err != nil {
+			return serverReply{err: fmt.Errorf("external plugin %s returned an invalid response: %w", p.Name, err)}
+		}
+		// Notifications and stale responses are skipped
+		if response.ID == nil || *response.ID != id {
+			continue
+		}
+		return serverReply{response: &response}
+	}
+}
+
+func (p *ExternalPlugin) startServer() error {
+	p.started = true
+	p.stderr = &lockedBuffer{}
+
+	cmd := exec.Command(p.Path, "serve")
+	cmd.Stderr = p.stderr
+	cmd.WaitDelay = externalWaitDelay
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start external plugin %s: %w", p.Name, err)
+	}
+
+	p.server = cmd
+	p.stdin = stdin
+	p.stdout = bufio.NewReader(stdout)
+	return nil
+}
+
+func (p *ExternalPlugin) stopServer() {
+	if p.server == nil {
+		return
+	}
+	p.stdin.Close()
+	done := make(chan struct{})
+	go func() {
+		p.server.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		p.server.Process.Kill()
+		<-done
+	}
+	p.server = nil
+}
+
+// Close stops the long-lived process, if any. Closing its stdin asks it to
+// exit; it is killed if it does not.
+func (p *ExternalPlugin) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.stopServer()
+	return nil
+}
+
+// decodeJSON decodes a result, keeping integers as int
+func decodeJSON(data json.RawMessage) (interface{}, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return convertNumbers(value), nil
+}
+
+func convertNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return int(i)
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		for i, item := range v {
+			v[i] = convertNumbers(item)
+		}
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = convertNumbers(item)
+		}
+	}
+	return value
+}
+
+// jsonSafe drops the values of a map that cannot be encoded as JSON
+func jsonSafe(variables map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(variables))
+	for key, value := range variables {
+		if _, err := json.Marshal(value); err == nil {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// errorMessage extracts the message of a string or JSON-RPC error object
+func errorMessage(data json.RawMessage) string {
+	var message string
+	if json.Unmarshal(data, &message) == nil {
+		return message
+	}
+	var object struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(data, &object) == nil && object.Message != "" {
+		return object.Message
+	}
+	return string(data)
+}
+
+func stderrSuffix(stderr string) string {
+	if msg := strings.TrimSpace(stderr); msg != "" {
+		return ": " + msg
+	}
+	return ""
+}
+
+// lockedBuffer collects the stderr of a running process
+type lockedBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(data []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(data)
+}
+
+func (b *lockedBuffer) String() string {
+	if b == nil {
+		return ""
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.String()
+}
+
+func nonNilList(list []interface{}) []interface{} {
+	if list == nil {
+		return []interface{}{}
+	}
+	return list
+}
+
+func nonNilMap(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return map[string]interface{}{}
+	}
+	return m
+}
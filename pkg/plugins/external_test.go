@@ -0,0 +1,290 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugins
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/work-obs/ansible-go/pkg/config"
+)
+
+// writeHelperPlugin writes an executable that re-runs the test binary as
+// TestHelperProcess with the given protocol
+func writeHelperPlugin(t *testing.T, dir, name, protocol string) string {
+	t.Helper()
+	t.Setenv("GO_WANT_HELPER_PROCESS", "1")
+
+	path := filepath.Join(dir, name)
+	script := fmt.Sprintf("#!/bin/sh\nEXTERNAL_PROTOCOL=%s exec %q -test.run=TestHelperProcess -- \"$@\"\n", protocol, os.Args[0])
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestHelperProcess is the external plugin run by writeHelperPlugin
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	defer os.Exit(0)
+
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	command := ""
+	if len(args) > 1 {
+		command = args[1]
+	}
+
+	switch command {
+	case "describe":
+		json.NewEncoder(os.Stdout).Encode(map[string]interface{}{
+			"name":        "helper",
+			"description": "Test helper plugin",
+			"version":     "2.0",
+			"author":      []string{"Tester"},
+			"protocol":    os.Getenv("EXTERNAL_PROTOCOL"),
+			"filters":     []string{"shout", "suffix", "broken", "hang"},
+			"lookups":     []string{"echo"},
+			"tests":       []string{"even"},
+			"variables":   true,
+		})
+	case "serve":
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			var request struct {
+				ID     int                    `json:"id"`
+				Method string                 `json:"method"`
+				Params map[string]interface{} `json:"params"`
+			}
+			json.Unmarshal(scanner.Bytes(), &request)
+			// A notification without an id must be skipped by the client
+			fmt.Println(`{"jsonrpc": "2.0", "method": "log", "params": {}}`)
+			result, err := helperCall(request.Method, request.Params)
+			response := map[string]interface{}{"jsonrpc": "2.0", "id": request.ID, "result": result}
+			if err != nil {
+				response["error"] = map[string]interface{}{"code": -32000, "message": err.Error()}
+			}
+			json.NewEncoder(os.Stdout).Encode(response)
+		}
+	default:
+		var request struct {
+			Method string                 `json:"method"`
+			Params map[string]interface{} `json:"params"`
+		}
+		json.NewDecoder(os.Stdin).Decode(&request)
+		result, err := helperCall(request.Method, request.Params)
+		if err != nil {
+			json.NewEncoder(os.Stdout).Encode(map[string]interface{}{"error": err.Error()})
+			return
+		}
+		json.NewEncoder(os.Stdout).Encode(map[string]interface{}{"result": result})
+	}
+}
+
+// helperCall implements the helper plugin's filters, lookups and tests
+func helperCall(method string, params map[string]interface{}) (interface{}, error) {
+	name, _ := params["name"].(string)
+	switch method + ":" + name {
+	case "filter:shout":
+		return strings.ToUpper(fmt.Sprintf("%v", params["input"])) + "!", nil
+	case "filter:suffix":
+		suffix := params["kwargs"].(map[string]interface{})["suffix"]
+		if args := params["args"].([]interface{}); len(args) > 0 {
+			suffix = args[0]
+		}
+		return fmt.Sprintf("%v%v", params["input"], suffix), nil
+	case "filter:broken":
+		return nil, fmt.Errorf("broken on purpose")
+	case "filter:hang":
+		time.Sleep(time.Minute)
+		return nil, nil
+	case "lookup:echo":
+		result := params["terms"].([]interface{})
+		if variables, ok := params["variables"].(map[string]interface{}); ok && variables["greeting"] != nil {
+			result = append(result, variables["greeting"])
+		}
+		return result, nil
+	case "test:even":
+		number, _ := params["input"].(float64)
+		return int(number)%2 == 0, nil
+	}
+	return nil, fmt.Errorf("unknown %s '%s'", method, name)
+}
+
+func TestExternalPlugin_Protocols(t *testing.T) {
+	for _, protocol := range []string{ExternalProtocolJSON, ExternalProtocolJSONRPC} {
+		t.Run(protocol, func(t *testing.T) {
+			path := writeHelperPlugin(t, t.TempDir(), "helper.sh", protocol)
+			ctx := context.Background()
+
+			plugin, err := DescribeExternalPlugin(ctx, path)
+			if err != nil {
+				t.Fatalf("DescribeExternalPlugin() error = %v", err)
+			}
+			defer plugin.Close()
+
+			if plugin.Name != "helper" || plugin.Version != "2.0" || plugin.Protocol != protocol {
+				t.Errorf("description = %+v", plugin.ExternalDescription)
+			}
+			if !reflect.DeepEqual(plugin.Filters, []string{"shout", "suffix", "broken", "hang"}) {
+				t.Errorf("Filters = %v", plugin.Filters)
+			}
+
+			result, err := plugin.Filter(ctx, "shout", "hello", nil, nil)
+			if err != nil || result != "HELLO!" {
+				t.Errorf("Filter(shout) = %v, %v", result, err)
+			}
+			result, err = plugin.Filter(ctx, "suffix", "a", nil, map[string]interface{}{"suffix": "-b"})
+			if err != nil || result != "a-b" {
+				t.Errorf("Filter(suffix) = %v, %v", result, err)
+			}
+			result, err = plugin.Filter(ctx, "suffix", "a", []interface{}{1}, nil)
+			if err != nil || result != "a1" {
+				t.Errorf("Filter(suffix, 1) = %v, %v", result, err)
+			}
+
+			_, err = plugin.Filter(ctx, "broken", "x", nil, nil)
+			if err == nil || !strings.Contains(err.Error(), "broken on purpose") {
+				t.Errorf("Filter(broken) error = %v", err)
+			}
+
+			items, err := plugin.Lookup(ctx, "echo", []string{"a", "b"}, map[string]interface{}{"greeting": "hi", "unsafe": func() {}}, nil)
+			if err != nil || !reflect.DeepEqual(items, []interface{}{"a", "b", "hi"}) {
+				t.Errorf("Lookup(echo) = %v, %v", items, err)
+			}
+
+			even, err := plugin.Test(ctx, "even", 4, nil)
+			if err != nil || !even {
+				t.Errorf("Test(even, 4) = %v, %v", even, err)
+			}
+			even, err = plugin.Test(ctx, "even", 3, nil)
+			if err != nil || even {
+				t.Errorf("Test(even, 3) = %v, %v", even, err)
+			}
+		})
+	}
+}
+
+func TestExternalPlugin_Timeout(t *testing.T) {
+	for _, protocol := range []string{ExternalProtocolJSON, ExternalProtocolJSONRPC} {
+		t.Run(protocol, func(t *testing.T) {
+			path := writeHelperPlugin(t, t.TempDir(), "helper.sh", protocol)
+			ctx := context.Background()
+
+			plugin, err := DescribeExternalPlugin(ctx, path)
+			if err != nil {
+				t.Fatalf("DescribeExternalPlugin() error = %v", err)
+			}
+			defer plugin.Close()
+			plugin.Timeout = 200 * time.Millisecond
+
+			start := time.Now()
+			_, err = plugin.Filter(ctx, "hang", "x", nil, nil)
+			if err == nil || !strings.Contains(err.Error(), "did not answer") {
+				t.Errorf("Filter(hang) error = %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > 10*time.Second {
+				t.Errorf("Filter(hang) returned after %v", elapsed)
+			}
+
+			if protocol == ExternalProtocolJSONRPC {
+				if plugin.server != nil {
+					t.Error("expected the hung server to be killed")
+				}
+				if _, err := plugin.Filter(ctx, "shout", "x", nil, nil); err == nil || !strings.Contains(err.Error(), "not running") {
+					t.Errorf("Filter(shout) after the timeout error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestExternalPlugin_DescribeErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	failing := filepath.Join(dir, "failing")
+	os.WriteFile(failing, []byte("#!/bin/sh\necho 'cannot start' >&2\nexit 3\n"), 0755)
+	if _, err := DescribeExternalPlugin(context.Background(), failing); err == nil || !strings.Contains(err.Error(), "cannot start") {
+		t.Errorf("expected the plugin's stderr in the error, got %v", err)
+	}
+
+	garbage := filepath.Join(dir, "garbage")
+	os.WriteFile(garbage, []byte("#!/bin/sh\necho 'not json'\n"), 0755)
+	if _, err := DescribeExternalPlugin(context.Background(), garbage); err == nil || !strings.Contains(err.Error(), "invalid description") {
+		t.Errorf("expected an invalid description error, got %v", err)
+	}
+
+	protocol := filepath.Join(dir, "protocol")
+	os.WriteFile(protocol, []byte("#!/bin/sh\necho '{\"protocol\": \"grpc\"}'\n"), 0755)
+	if _, err := DescribeExternalPlugin(context.Background(), protocol); err == nil || !strings.Contains(err.Error(), "unknown protocol") {
+		t.Errorf("expected an unknown protocol error, got %v", err)
+	}
+
+	unnamed := filepath.Join(dir, "unnamed.py")
+	os.WriteFile(unnamed, []byte("#!/bin/sh\necho '{}'\n"), 0755)
+	plugin, err := DescribeExternalPlugin(context.Background(), unnamed)
+	if err != nil || plugin.Name != "unnamed" || plugin.Protocol != ExternalProtocolJSON {
+		t.Errorf("DescribeExternalPlugin(unnamed) = %+v, %v", plugin, err)
+	}
+}
+
+func TestLoader_ExternalPlugins(t *testing.T) {
+	dir := t.TempDir()
+	writeHelperPlugin(t, dir, "helper.sh", ExternalProtocolJSON)
+	// Not executable, so not an external plugin
+	os.WriteFile(filepath.Join(dir, "README.md"), []byte("docs"), 0644)
+
+	cfg := &config.Config{FilterPluginPath: []string{dir}}
+	loader := NewLoader(cfg, afero.NewOsFs())
+	defer loader.Close()
+
+	external, err := loader.ExternalPlugins(PluginTypeFilter)
+	if err != nil {
+		t.Fatalf("ExternalPlugins() error = %v", err)
+	}
+	if len(external) != 1 || external[0].Name != "helper" {
+		t.Fatalf("ExternalPlugins() = %v", external)
+	}
+
+	plugin, err := loader.LoadPlugin(PluginTypeFilter, "helper")
+	if err != nil {
+		t.Fatalf("LoadPlugin() error = %v", err)
+	}
+	if plugin.Instance != external[0] {
+		t.Error("expected the described plugin to be cached for the run")
+	}
+	if plugin.Version != "2.0" || !reflect.DeepEqual(plugin.Author, []string{"Tester"}) {
+		t.Errorf("plugin = %+v", plugin)
+	}
+
+	if external, _ := loader.ExternalPlugins(PluginTypeLookup); len(external) != 0 {
+		t.Errorf("expected no lookup plugins, got %v", external)
+	}
+}
@@ -0,0 +1,1119 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filter
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// This file implements the core filters that take more than a line:
+// Jinja2's list, math and string filters and Ansible's dictionary, YAML,
+// date and version filters. Arguments follow the Jinja2 and Ansible
+// signatures, positionally or by keyword.
+
+// regexReplaceFilter implements regex_replace(regex, replacement,
+// ignorecase=False, multiline=False, count=0). Replacements refer to
+// groups as \1 or \g<name>, as in Python.
+func regexReplaceFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, kw := SplitKeywords(args)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("regex_replace requires a regular expression")
+	}
+	re, err := compileRegex("regex_replace", args[0], isTrue(kw["ignorecase"]), isTrue(kw["multiline"]))
+	if err != nil {
+		return nil, err
+	}
+	replacement := pythonReplacement(optionalString(argument(args, kw, 1, "replacement", "")))
+	count, err := intArgument("regex_replace", "count", argument(args, kw, 4, "count", nil), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	text := fmt.Sprintf("%v", input)
+	if count == 0 {
+		return re.ReplaceAllString(text, replacement), nil
+	}
+
+	var out strings.Builder
+	last := 0
+	for _, match := range re.FindAllStringSubmatchIndex(text, count) {
+		out.WriteString(text[last:match[0]])
+		out.Write(re.ExpandString(nil, replacement, text, match))
+		last = match[1]
+	}
+	out.WriteString(text[last:])
+	return out.String(), nil
+}
+
+// pythonGroupRef matches the group references of a Python replacement
+var pythonGroupRef = regexp.MustCompile(`\\(\d+)|\\g<(\w+)>`)
+
+// pythonReplacement turns a Python replacement string into a Go one
+func pythonReplacement(replacement string) string {
+	replacement = strings.ReplaceAll(replacement, "$", "$$")
+	return pythonGroupRef.ReplaceAllStringFunc(replacement, func(ref string) string {
+		match := pythonGroupRef.FindStringSubmatch(ref)
+		if match[1] != "" {
+			return "${" + match[1] + "}"
+		}
+		return "${" + match[2] + "}"
+	})
+}
+
+// reverseFilter reverses a string or a list
+func reverseFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	if text, ok := input.(string); ok {
+		runes := []rune(text)
+		for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+			runes[i], runes[j] = runes[j], runes[i]
+		}
+		return string(runes), nil
+	}
+	items, err := dataItems("reverse", input)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]interface{}, len(items))
+	for i, item := range items {
+		result[len(items)-1-i] = item
+	}
+	return result, nil
+}
+
+// indentFilter implements indent(width=4, first=False, blank=False).
+// width may be a number of spaces or the indentation string itself.
+func indentFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, kw := SplitKeywords(args)
+	indentation := "    "
+	switch width := argument(args, kw, 0, "width", 4).(type) {
+	case string:
+		indentation = width
+	default:
+		n, err := intArgument("indent", "width", width, 4)
+		if err != nil {
+			return nil, err
+		}
+		indentation = strings.Repeat(" ", n)
+	}
+	first := isTrue(argument(args, kw, 1, "first", false))
+	blank := isTrue(argument(args, kw, 2, "blank", false))
+
+	lines := strings.Split(fmt.Sprintf("%v", input), "\n")
+	for i, line := range lines {
+		if (i == 0 && !first) || (line == "" && !blank) {
+			continue
+		}
+		lines[i] = indentation + line
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// centerFilter implements center(width=80) like Python's str.center
+func centerFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, kw := SplitKeywords(args)
+	width, err := intArgument("center", "width", argument(args, kw, 0, "width", nil), 80)
+	if err != nil {
+		return nil, err
+	}
+	text := fmt.Sprintf("%v", input)
+	pad := width - utf8.RuneCountInString(text)
+	if pad <= 0 {
+		return text, nil
+	}
+	left := pad/2 + (pad & width & 1)
+	return strings.Repeat(" ", left) + text + strings.Repeat(" ", pad-left), nil
+}
+
+// truncateFilter implements truncate(length=255, killwords=False,
+// end='...', leeway=5)
+func truncateFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, kw := SplitKeywords(args)
+	length, err := intArgument("truncate", "length", argument(args, kw, 0, "length", nil), 255)
+	if err != nil {
+		return nil, err
+	}
+	killwords := isTrue(argument(args, kw, 1, "killwords", false))
+	end := optionalString(argument(args, kw, 2, "end", "..."))
+	leeway, err := intArgument("truncate", "leeway", argument(args, kw, 3, "leeway", nil), 5)
+	if err != nil {
+		return nil, err
+	}
+
+	runes := []rune(fmt.Sprintf("%v", input))
+	if len(runes) <= length+leeway {
+		return string(runes), nil
+	}
+	cut := length - utf8.RuneCountInString(end)
+	if cut < 0 {
+		cut = 0
+	}
+	result := string(runes[:cut])
+	if !killwords {
+		if i := strings.LastIndex(result, " "); i >= 0 {
+			result = result[:i]
+		}
+	}
+	return result + end, nil
+}
+
+// numberValue converts a number, or a string holding one, to a float
+func numberValue(filter string, value interface{}) (float64, error) {
+	if n, ok := jpNumberValue(jpNormalize(value)); ok {
+		return n, nil
+	}
+	if text, ok := value.(string); ok {
+		if n, err := strconv.ParseFloat(strings.TrimSpace(text), 64); err == nil {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("%s requires a number, got %T", filter, value)
+}
+
+// isInteger reports whether a value is an integer number
+func isInteger(value interface{}) bool {
+	switch value.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return true
+	}
+	return false
+}
+
+// absFilter returns the absolute value of a number, keeping integers
+// integers
+func absFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	n, err := numberValue("abs", input)
+	if err != nil {
+		return nil, err
+	}
+	if isInteger(input) {
+		return int(math.Abs(n)), nil
+	}
+	return math.Abs(n), nil
+}
+
+// roundFilter implements round(precision=0, method='common'); method may
+// also be ceil or floor. Like Jinja2 it always returns a float.
+func roundFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, kw := SplitKeywords(args)
+	n, err := numberValue("round", input)
+	if err != nil {
+		return nil, err
+	}
+	precision, err := intArgument("round", "precision", argument(args, kw, 0, "precision", nil), 0)
+	if err != nil {
+		return nil, err
+	}
+	scale := math.Pow(10, float64(precision))
+
+	switch method := optionalString(argument(args, kw, 1, "method", "common")); method {
+	case "common":
+		return math.Round(n*scale) / scale, nil
+	case "ceil":
+		return math.Ceil(n*scale) / scale, nil
+	case "floor":
+		return math.Floor(n*scale) / scale, nil
+	default:
+		return nil, fmt.Errorf("round: method must be common, ceil or floor, got '%s'", method)
+	}
+}
+
+// attributeItems returns the items of a list, replaced by the value of
+// their attribute when one is given
+func attributeItems(filter string, input interface{}, attribute interface{}) ([]interface{}, error) {
+	items, err := dataItems(filter, input)
+	if err != nil || attribute == nil {
+		return items, err
+	}
+	path := fmt.Sprintf("%v", attribute)
+	values := make([]interface{}, len(items))
+	for i, item := range items {
+		value, found := dataAttribute(item, path)
+		if !found {
+			return nil, fmt.Errorf("%s: item %d has no attribute '%s'", filter, i, path)
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// extremeFilter implements max and min(attribute=None, case_sensitive=False)
+func extremeFilter(filter string, sign int) FilterFunction {
+	return func(input interface{}, args ...interface{}) (interface{}, error) {
+		args, kw := SplitKeywords(args)
+		items, err := dataItems(filter, input)
+		if err != nil {
+			return nil, err
+		}
+		keys, err := attributeItems(filter, items, argument(args, kw, -1, "attribute", nil))
+		if err != nil {
+			return nil, err
+		}
+		caseSensitive := isTrue(argument(args, kw, -1, "case_sensitive", false))
+
+		if len(items) == 0 {
+			return nil, nil
+		}
+		best := 0
+		for i := range items {
+			if sign*compareSortKeys(keys[i], keys[best], caseSensitive) > 0 {
+				best = i
+			}
+		}
+		return items[best], nil
+	}
+}
+
+// compareSortKeys orders sort keys, ignoring case unless caseSensitive
+func compareSortKeys(a, b interface{}, caseSensitive bool) int {
+	if !caseSensitive {
+		if text, ok := a.(string); ok {
+			a = strings.ToLower(text)
+		}
+		if text, ok := b.(string); ok {
+			b = strings.ToLower(text)
+		}
+	}
+	return dataCompare(a, b)
+}
+
+// sumFilter implements sum(attribute=None, start=0). The result is an
+// integer when every term is.
+func sumFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, kw := SplitKeywords(args)
+	items, err := attributeItems("sum", input, argument(args, kw, 0, "attribute", nil))
+	if err != nil {
+		return nil, err
+	}
+	start := argument(args, kw, 1, "start", 0)
+
+	integers := isInteger(start)
+	total, err := numberValue("sum", start)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		n, err := numberValue("sum", item)
+		if err != nil {
+			return nil, err
+		}
+		integers = integers && isInteger(item)
+		total += n
+	}
+	if integers {
+		return int(total), nil
+	}
+	return total, nil
+}
+
+// endFilter implements first and last for strings and lists
+func endFilter(filter string, last bool) FilterFunction {
+	return func(input interface{}, args ...interface{}) (interface{}, error) {
+		if text, ok := input.(string); ok {
+			runes := []rune(text)
+			if len(runes) == 0 {
+				return nil, nil
+			}
+			if last {
+				return string(runes[len(runes)-1]), nil
+			}
+			return string(runes[0]), nil
+		}
+		items, err := dataItems(filter, input)
+		if err != nil || len(items) == 0 {
+			return nil, err
+		}
+		if last {
+			return items[len(items)-1], nil
+		}
+		return items[0], nil
+	}
+}
+
+// uniqueItems returns the items in order, without repeats
+func uniqueItems(items []interface{}) []interface{} {
+	result := []interface{}{}
+	for _, item := range items {
+		if !containsItem(result, item) {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func containsItem(items []interface{}, item interface{}) bool {
+	for _, other := range items {
+		if reflect.DeepEqual(other, item) || (jpEqual(jpNormalize(other), jpNormalize(item))) {
+			return true
+		}
+	}
+	return false
+}
+
+// uniqueFilter implements unique
+func uniqueFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	items, err := dataItems("unique", input)
+	if err != nil {
+		return nil, err
+	}
+	return uniqueItems(items), nil
+}
+
+// setFilter implements union, intersect and difference, which return
+// unique items in the order of the input and then the argument
+func setFilter(filter string) FilterFunction {
+	return func(input interface{}, args ...interface{}) (interface{}, error) {
+		args, _ = SplitKeywords(args)
+		if len(args) == 0 {
+			return nil, fmt.Errorf("%s requires a list argument", filter)
+		}
+		lists, err := dataLists(filter, input, args[:1])
+		if err != nil {
+			return nil, err
+		}
+		a, b := lists[0], lists[1]
+
+		switch filter {
+		case "union":
+			return uniqueItems(append(append([]interface{}{}, a...), b...)), nil
+		case "intersect":
+			var result []interface{}
+			for _, item := range a {
+				if containsItem(b, item) {
+					result = append(result, item)
+				}
+			}
+			return uniqueItems(result), nil
+		default:
+			var result []interface{}
+			for _, item := range a {
+				if !containsItem(b, item) {
+					result = append(result, item)
+				}
+			}
+			return uniqueItems(result), nil
+		}
+	}
+}
+
+// sortFilter implements sort(reverse=False, case_sensitive=False,
+// attribute=None); the sort is stable
+func sortFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, kw := SplitKeywords(args)
+	items, err := dataItems("sort", input)
+	if err != nil {
+		return nil, err
+	}
+	reverse := isTrue(argument(args, kw, 0, "reverse", false))
+	caseSensitive := isTrue(argument(args, kw, 1, "case_sensitive", false))
+	keys, err := attributeItems("sort", items, argument(args, kw, 2, "attribute", nil))
+	if err != nil {
+		return nil, err
+	}
+
+	order := make([]int, len(items))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		cmp := compareSortKeys(keys[order[i]], keys[order[j]], caseSensitive)
+		if reverse {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
+
+	result := make([]interface{}, len(items))
+	for i, index := range order {
+		result[i] = items[index]
+	}
+	return result, nil
+}
+
+// flattenFilter implements flatten(levels=None, skip_nulls=True)
+func flattenFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, kw := SplitKeywords(args)
+	items, err := dataItems("flatten", input)
+	if err != nil {
+		return nil, err
+	}
+	levels := -1
+	if value := argument(args, kw, 0, "levels", nil); value != nil {
+		if levels, err = intArgument("flatten", "levels", value, 0); err != nil {
+			return nil, err
+		}
+	}
+	skipNulls := isTrue(argument(args, kw, 1, "skip_nulls", true))
+
+	var flatten func(items []interface{}, depth int) []interface{}
+	flatten = func(items []interface{}, depth int) []interface{} {
+		result := []interface{}{}
+		for _, item := range items {
+			if item == nil && skipNulls {
+				continue
+			}
+			if nested, err := dataItems("flatten", item); err == nil && depth != 0 {
+				if _, isString := item.(string); !isString {
+					result = append(result, flatten(nested, depth-1)...)
+					continue
+				}
+			}
+			result = append(result, item)
+		}
+		return result
+	}
+	return flatten(items, levels), nil
+}
+
+// truthy interprets a value the way Python does in a boolean context
+func truthy(value interface{}) bool {
+	if value == nil {
+		return false
+	}
+	if b, ok := value.(bool); ok {
+		return b
+	}
+	if n, ok := jpNumberValue(jpNormalize(value)); ok {
+		return n != 0
+	}
+	val := reflect.ValueOf(value)
+	switch val.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		return val.Len() > 0
+	}
+	return true
+}
+
+// jinjaTests are the tests select, reject, selectattr and rejectattr
+// accept, by name
+var jinjaTests = map[string]func(value interface{}, args []interface{}) (bool, error){
+	"defined":   func(interface{}, []interface{}) (bool, error) { return true, nil },
+	"undefined": func(interface{}, []interface{}) (bool, error) { return false, nil },
+	"none":      func(value interface{}, _ []interface{}) (bool, error) { return value == nil, nil },
+	"truthy":    func(value interface{}, _ []interface{}) (bool, error) { return truthy(value), nil },
+	"falsy":     func(value interface{}, _ []interface{}) (bool, error) { return !truthy(value), nil },
+	"boolean": func(value interface{}, _ []interface{}) (bool, error) {
+		_, ok := value.(bool)
+		return ok, nil
+	},
+	"string": func(value interface{}, _ []interface{}) (bool, error) {
+		_, ok := value.(string)
+		return ok, nil
+	},
+	"number": func(value interface{}, _ []interface{}) (bool, error) {
+		_, ok := jpNumberValue(jpNormalize(value))
+		return ok, nil
+	},
+	"integer": func(value interface{}, _ []interface{}) (bool, error) { return isInteger(value), nil },
+	"float": func(value interface{}, _ []interface{}) (bool, error) {
+		_, ok := value.(float64)
+		return ok, nil
+	},
+	"mapping": func(value interface{}, _ []interface{}) (bool, error) {
+		return value != nil && reflect.ValueOf(value).Kind() == reflect.Map, nil
+	},
+	"sequence": func(value interface{}, _ []interface{}) (bool, error) {
+		if _, ok := value.(string); ok {
+			return true, nil
+		}
+		_, err := dataItems("sequence", value)
+		return err == nil, nil
+	},
+	"lower": func(value interface{}, _ []interface{}) (bool, error) {
+		text, ok := value.(string)
+		return ok && text == strings.ToLower(text), nil
+	},
+	"upper": func(value interface{}, _ []interface{}) (bool, error) {
+		text, ok := value.(string)
+		return ok && text == strings.ToUpper(text), nil
+	},
+	"even": func(value interface{}, _ []interface{}) (bool, error) {
+		n, ok := ipInt(value)
+		return ok && n%2 == 0, nil
+	},
+	"odd": func(value interface{}, _ []interface{}) (bool, error) {
+		n, ok := ipInt(value)
+		return ok && n%2 != 0, nil
+	},
+	"divisibleby": func(value interface{}, args []interface{}) (bool, error) {
+		n, ok := ipInt(value)
+		d, dok := ipInt(testArgument(args))
+		return ok && dok && d != 0 && n%d == 0, nil
+	},
+	"eq": func(value interface{}, args []interface{}) (bool, error) {
+		return len(args) > 0 && containsItem(args[:1], value), nil
+	},
+	"ne": func(value interface{}, args []interface{}) (bool, error) {
+		return len(args) > 0 && !containsItem(args[:1], value), nil
+	},
+	"lt": compareTest(func(cmp int) bool { return cmp < 0 }),
+	"le": compareTest(func(cmp int) bool { return cmp <= 0 }),
+	"gt": compareTest(func(cmp int) bool { return cmp > 0 }),
+	"ge": compareTest(func(cmp int) bool { return cmp >= 0 }),
+	"in": func(value interface{}, args []interface{}) (bool, error) {
+		container := testArgument(args)
+		if text, ok := container.(string); ok {
+			return strings.Contains(text, fmt.Sprintf("%v", value)), nil
+		}
+		if container != nil && reflect.ValueOf(container).Kind() == reflect.Map {
+			_, found := dataIndex(container, value)
+			return found, nil
+		}
+		items, err := dataItems("in", container)
+		if err != nil {
+			return false, err
+		}
+		return containsItem(items, value), nil
+	},
+	"match":  regexTest("match"),
+	"search": regexTest("search"),
+	"regex":  regexTest("regex"),
+}
+
+// jinjaTestAliases maps the other names of tests to jinjaTests entries
+var jinjaTestAliases = map[string]string{
+	"equalto":     "eq",
+	"==":          "eq",
+	"sameas":      "eq",
+	"!=":          "ne",
+	"<":           "lt",
+	"lessthan":    "lt",
+	"<=":          "le",
+	">":           "gt",
+	"greaterthan": "gt",
+	">=":          "ge",
+	"iterable":    "sequence",
+}
+
+// testArgument returns the first argument of a test, or nil
+func testArgument(args []interface{}) interface{} {
+	if len(args) == 0 {
+		return nil
+	}
+	return args[0]
+}
+
+func compareTest(accept func(int) bool) func(interface{}, []interface{}) (bool, error) {
+	return func(value interface{}, args []interface{}) (bool, error) {
+		if len(args) == 0 {
+			return false, fmt.Errorf("comparison tests require a value to compare with")
+		}
+		return accept(dataCompare(value, args[0])), nil
+	}
+}
+
+// regexTest implements match, which anchors at the start, and search and
+// regex, which find the pattern anywhere
+func regexTest(test string) func(interface{}, []interface{}) (bool, error) {
+	return func(value interface{}, args []interface{}) (bool, error) {
+		args, kw := SplitKeywords(args)
+		if len(args) == 0 {
+			return false, fmt.Errorf("%s requires a regular expression", test)
+		}
+		re, err := compileRegex(test, args[0], isTrue(kw["ignorecase"]), isTrue(kw["multiline"]))
+		if err != nil {
+			return false, err
+		}
+		text := fmt.Sprintf("%v", value)
+		if test == "match" {
+			loc := re.FindStringIndex(text)
+			return loc != nil && loc[0] == 0, nil
+		}
+		return re.MatchString(text), nil
+	}
+}
+
+// runTest runs a named test; without a name it tests truthiness
+func runTest(filter string, name interface{}, value interface{}, args []interface{}) (bool, error) {
+	if name == nil {
+		return truthy(value), nil
+	}
+	testName := fmt.Sprintf("%v", name)
+	if alias, ok := jinjaTestAliases[testName]; ok {
+		testName = alias
+	}
+	test, ok := jinjaTests[testName]
+	if !ok {
+		return false, fmt.Errorf("%s: unknown test '%s'", filter, testName)
+	}
+	return test(value, args)
+}
+
+// selectFilter implements select and reject(test=None, *args)
+func selectFilter(filter string, keep bool) FilterFunction {
+	return func(input interface{}, args ...interface{}) (interface{}, error) {
+		items, err := dataItems(filter, input)
+		if err != nil {
+			return nil, err
+		}
+		var name interface{}
+		var testArgs []interface{}
+		if len(args) > 0 {
+			name, testArgs = args[0], args[1:]
+		}
+
+		result := []interface{}{}
+		for _, item := range items {
+			matched, err := runTest(filter, name, item, testArgs)
+			if err != nil {
+				return nil, err
+			}
+			if matched == keep {
+				result = append(result, item)
+			}
+		}
+		return result, nil
+	}
+}
+
+// selectAttrFilter implements selectattr and rejectattr(attribute,
+// test=None, *args). Items without the attribute are tested as undefined.
+func selectAttrFilter(filter string, keep bool) FilterFunction {
+	return func(input interface{}, args ...interface{}) (interface{}, error) {
+		items, err := dataItems(filter, input)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) == 0 {
+			return nil, fmt.Errorf("%s requires an attribute", filter)
+		}
+		path := fmt.Sprintf("%v", args[0])
+		var name interface{}
+		var testArgs []interface{}
+		if len(args) > 1 {
+			name, testArgs = args[1], args[2:]
+		}
+
+		result := []interface{}{}
+		for _, item := range items {
+			value, found := dataAttribute(item, path)
+			var matched bool
+			switch {
+			case !found && fmt.Sprintf("%v", name) == "undefined":
+				matched = true
+			case !found:
+				matched = false
+			default:
+				if matched, err = runTest(filter, name, value, testArgs); err != nil {
+					return nil, err
+				}
+			}
+			if matched == keep {
+				result = append(result, item)
+			}
+		}
+		return result, nil
+	}
+}
+
+// mapFilter implements map(attribute=..., default=None) and
+// map(filter, *args), applying the filter found by lookup to every item
+func mapFilter(lookup func(name string) (FilterFunction, bool)) FilterFunction {
+	return func(input interface{}, args ...interface{}) (interface{}, error) {
+		args, kw := SplitKeywords(args)
+		items, err := dataItems("map", input)
+		if err != nil {
+			return nil, err
+		}
+
+		result := make([]interface{}, len(items))
+		if attribute, ok := kw["attribute"]; ok {
+			path := fmt.Sprintf("%v", attribute)
+			fallback, hasDefault := kw["default"]
+			for i, item := range items {
+				value, found := dataAttribute(item, path)
+				if !found {
+					if !hasDefault {
+						return nil, fmt.Errorf("map: item %d has no attribute '%s'", i, path)
+					}
+					value = fallback
+				}
+				result[i] = value
+			}
+			return result, nil
+		}
+
+		if len(args) == 0 {
+			return nil, fmt.Errorf("map requires a filter name or attribute=")
+		}
+		name := fmt.Sprintf("%v", args[0])
+		fn, ok := lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("map: unknown filter '%s'", name)
+		}
+		filterArgs := append([]interface{}{}, args[1:]...)
+		if len(kw) > 0 {
+			filterArgs = append(filterArgs, kw)
+		}
+		for i, item := range items {
+			if result[i], err = fn(item, filterArgs...); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	}
+}
+
+// dict2itemsFilter implements dict2items(key_name='key',
+// value_name='value'); items are sorted by key
+func dict2itemsFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, kw := SplitKeywords(args)
+	keyName := optionalString(argument(args, kw, 0, "key_name", "key"))
+	valueName := optionalString(argument(args, kw, 1, "value_name", "value"))
+
+	val := reflect.ValueOf(input)
+	if input == nil || val.Kind() != reflect.Map {
+		return nil, fmt.Errorf("dict2items requires a dictionary, got %T", input)
+	}
+	keys := val.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return dataCompare(keys[i].Interface(), keys[j].Interface()) < 0
+	})
+
+	result := make([]interface{}, len(keys))
+	for i, key := range keys {
+		result[i] = map[string]interface{}{
+			keyName:   key.Interface(),
+			valueName: val.MapIndex(key).Interface(),
+		}
+	}
+	return result, nil
+}
+
+// items2dictFilter implements items2dict(key_name='key',
+// value_name='value')
+func items2dictFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, kw := SplitKeywords(args)
+	keyName := optionalString(argument(args, kw, 0, "key_name", "key"))
+	valueName := optionalString(argument(args, kw, 1, "value_name", "value"))
+
+	items, err := dataItems("items2dict", input)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]interface{}, len(items))
+	for i, item := range items {
+		key, found := dataIndex(item, keyName)
+		if !found {
+			return nil, fmt.Errorf("items2dict: item %d has no '%s'", i, keyName)
+		}
+		value, found := dataIndex(item, valueName)
+		if !found {
+			return nil, fmt.Errorf("items2dict: item %d has no '%s'", i, valueName)
+		}
+		result[fmt.Sprintf("%v", key)] = value
+	}
+	return result, nil
+}
+
+// combineFilter implements combine(*dicts, recursive=False,
+// list_merge='replace'). Lists of dictionaries, as input or argument, are
+// combined in order.
+func combineFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, kw := SplitKeywords(args)
+	recursive := isTrue(kw["recursive"])
+	listMerge := optionalString(kw["list_merge"])
+	switch listMerge {
+	case "":
+		listMerge = "replace"
+	case "replace", "keep", "append", "prepend", "append_rp", "prepend_rp":
+	default:
+		return nil, fmt.Errorf("combine: list_merge must be replace, keep, append, prepend, append_rp or prepend_rp, got '%s'", listMerge)
+	}
+
+	var dicts []map[string]interface{}
+	for _, term := range append([]interface{}{input}, args...) {
+		terms := []interface{}{term}
+		if items, err := dataItems("combine", term); err == nil {
+			terms = items
+		}
+		for _, term := range terms {
+			dict, ok := jpNormalize(term).(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("combine requires dictionaries, got %T", term)
+			}
+			if original, ok := term.(map[string]interface{}); ok {
+				dict = original
+			}
+			dicts = append(dicts, dict)
+		}
+	}
+
+	result := map[string]interface{}{}
+	for _, dict := range dicts {
+		result = mergeHash(result, dict, recursive, listMerge)
+	}
+	return result, nil
+}
+
+// urlencodeFilter implements urlencode: strings are quoted for use in a
+// URL path, dictionaries and lists of pairs become query strings
+func urlencodeFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	quote := func(value interface{}) string {
+		escaped := url.QueryEscape(fmt.Sprintf("%v", value))
+		return strings.ReplaceAll(strings.ReplaceAll(escaped, "+", "%20"), "%2F", "/")
+	}
+
+	val := reflect.ValueOf(input)
+	switch {
+	case input == nil:
+		return "", nil
+	case val.Kind() == reflect.Map:
+		keys := val.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return dataCompare(keys[i].Interface(), keys[j].Interface()) < 0
+		})
+		pairs := make([]string, len(keys))
+		for i, key := range keys {
+			pairs[i] = quote(key.Interface()) + "=" + quote(val.MapIndex(key).Interface())
+		}
+		return strings.Join(pairs, "&"), nil
+	case val.Kind() == reflect.Slice:
+		items, _ := dataItems("urlencode", input)
+		pairs := make([]string, len(items))
+		for i, item := range items {
+			pair, err := dataItems("urlencode", item)
+			if err != nil || len(pair) != 2 {
+				return nil, fmt.Errorf("urlencode requires a string, a dictionary or a list of pairs")
+			}
+			pairs[i] = quote(pair[0]) + "=" + quote(pair[1])
+		}
+		return strings.Join(pairs, "&"), nil
+	}
+	return quote(input), nil
+}
+
+// shellSafe matches strings that need no shell quoting
+var shellSafe = regexp.MustCompile(`^[\w@%+=:,./-]+$`)
+
+// quoteFilter quotes a string for the shell like Python's shlex.quote
+func quoteFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	text := ""
+	if input != nil {
+		text = fmt.Sprintf("%v", input)
+	}
+	if text == "" {
+		return "''", nil
+	}
+	if shellSafe.MatchString(text) {
+		return text, nil
+	}
+	return "'" + strings.ReplaceAll(text, "'", `'"'"'`) + "'", nil
+}
+
+// yamlFilter implements to_yaml(indent=2) and to_nice_yaml(indent=4)
+func yamlFilter(filter string, defaultIndent int) FilterFunction {
+	return func(input interface{}, args ...interface{}) (interface{}, error) {
+		args, kw := SplitKeywords(args)
+		indent, err := intArgument(filter, "indent", argument(args, kw, -1, "indent", nil), defaultIndent)
+		if err != nil {
+			return nil, err
+		}
+
+		var out strings.Builder
+		encoder := yaml.NewEncoder(&out)
+		encoder.SetIndent(indent)
+		if err := encoder.Encode(input); err != nil {
+			return nil, fmt.Errorf("%s: %v", filter, err)
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, fmt.Errorf("%s: %v", filter, err)
+		}
+		return out.String(), nil
+	}
+}
+
+// fromYamlFilter parses a YAML document
+func fromYamlFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	var result interface{}
+	if err := yaml.Unmarshal([]byte(fmt.Sprintf("%v", input)), &result); err != nil {
+		return nil, fmt.Errorf("from_yaml: %v", err)
+	}
+	return result, nil
+}
+
+// strftimeDirectives maps Python strftime directives to Go layouts
+var strftimeDirectives = map[byte]string{
+	'Y': "2006", 'y': "06", 'm': "01", 'd': "02", 'e': "_2",
+	'H': "15", 'I': "03", 'M': "04", 'S': "05", 'p': "PM",
+	'b': "Jan", 'h': "Jan", 'B': "January", 'a': "Mon", 'A': "Monday",
+	'Z': "MST", 'z': "-0700", 'f': "000000",
+}
+
+// formatTime formats a time with a Python strftime format
+func formatTime(format string, t time.Time) string {
+	var out strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			out.WriteByte(format[i])
+			continue
+		}
+		i++
+		switch directive := format[i]; directive {
+		case '%':
+			out.WriteByte('%')
+		case 'j':
+			fmt.Fprintf(&out, "%03d", t.YearDay())
+		case 's':
+			fmt.Fprintf(&out, "%d", t.Unix())
+		case 'w':
+			fmt.Fprintf(&out, "%d", t.Weekday())
+		case 'u':
+			fmt.Fprintf(&out, "%d", (int(t.Weekday())+6)%7+1)
+		case 'F':
+			out.WriteString(t.Format("2006-01-02"))
+		case 'T':
+			out.WriteString(t.Format("15:04:05"))
+		case 'f':
+			fmt.Fprintf(&out, "%06d", t.Nanosecond()/1000)
+		default:
+			if layout, ok := strftimeDirectives[directive]; ok {
+				out.WriteString(t.Format(layout))
+			} else {
+				out.WriteByte('%')
+				out.WriteByte(directive)
+			}
+		}
+	}
+	return out.String()
+}
+
+// strftimeFilter implements strftime(second=None, utc=False); the input is
+// the format
+func strftimeFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, kw := SplitKeywords(args)
+	t := time.Now()
+	if second := argument(args, kw, 0, "second", nil); second != nil {
+		n, err := numberValue("strftime", second)
+		if err != nil {
+			return nil, err
+		}
+		whole, fraction := math.Modf(n)
+		t = time.Unix(int64(whole), int64(fraction*1e9))
+	}
+	if isTrue(argument(args, kw, 1, "utc", false)) {
+		t = t.UTC()
+	}
+	return formatTime(fmt.Sprintf("%v", input), t), nil
+}
+
+// toDatetimeFilter implements to_datetime(format='%Y-%m-%d %H:%M:%S')
+func toDatetimeFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, kw := SplitKeywords(args)
+	format := optionalString(argument(args, kw, 0, "format", "%Y-%m-%d %H:%M:%S"))
+
+	var layout strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			layout.WriteByte(format[i])
+			continue
+		}
+		i++
+		switch directive := format[i]; directive {
+		case '%':
+			layout.WriteByte('%')
+		case 'f':
+			layout.WriteString("999999")
+		default:
+			value, ok := strftimeDirectives[directive]
+			if !ok {
+				return nil, fmt.Errorf("to_datetime: unsupported directive %%%c", directive)
+			}
+			layout.WriteString(value)
+		}
+	}
+
+	t, err := time.Parse(layout.String(), fmt.Sprintf("%v", input))
+	if err != nil {
+		return nil, fmt.Errorf("to_datetime: %v", err)
+	}
+	return t, nil
+}
+
+// versionParts splits a version into numbers and words, like Python's
+// LooseVersion
+var versionParts = regexp.MustCompile(`\d+|[a-zA-Z]+`)
+
+// compareVersions orders two versions part by part, numbers numerically
+func compareVersions(a, b string) int {
+	x, y := versionParts.FindAllString(a, -1), versionParts.FindAllString(b, -1)
+	for i := 0; i < len(x) && i < len(y); i++ {
+		n, nerr := strconv.Atoi(x[i])
+		m, merr := strconv.Atoi(y[i])
+		switch {
+		case nerr == nil && merr == nil:
+			if n != m {
+				if n < m {
+					return -1
+				}
+				return 1
+			}
+		case nerr == nil:
+			return 1
+		case merr == nil:
+			return -1
+		default:
+			if cmp := strings.Compare(x[i], y[i]); cmp != 0 {
+				return cmp
+			}
+		}
+	}
+	switch {
+	case len(x) < len(y):
+		return -1
+	case len(x) > len(y):
+		return 1
+	}
+	return 0
+}
+
+// versionCompareFilter implements version_compare(version, operator='eq')
+func versionCompareFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, kw := SplitKeywords(args)
+	version := argument(args, kw, 0, "version", nil)
+	if version == nil {
+		return nil, fmt.Errorf("version_compare requires a version to compare with")
+	}
+	cmp := compareVersions(fmt.Sprintf("%v", input), fmt.Sprintf("%v", version))
+
+	switch operator := optionalString(argument(args, kw, 1, "operator", "eq")); operator {
+	case "eq", "==", "=":
+		return cmp == 0, nil
+	case "ne", "!=", "<>":
+		return cmp != 0, nil
+	case "lt", "<":
+		return cmp < 0, nil
+	case "le", "<=":
+		return cmp <= 0, nil
+	case "gt", ">":
+		return cmp > 0, nil
+	case "ge", ">=":
+		return cmp >= 0, nil
+	default:
+		return nil, fmt.Errorf("version_compare: invalid operator '%s'", operator)
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filter
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCoreFilters(t *testing.T) {
+	filters := NewCoreFiltersPlugin().GetFilters()
+	users := []interface{}{
+		map[string]interface{}{"name": "bob", "uid": 1002, "groups": []interface{}{"wheel"}},
+		map[string]interface{}{"name": "alice", "uid": 1001, "groups": []interface{}{}},
+		map[string]interface{}{"name": "carol", "uid": 1003},
+	}
+
+	tests := []struct {
+		filter   string
+		input    interface{}
+		args     []interface{}
+		expected interface{}
+	}{
+		{"regex_replace", "web-01", []interface{}{`-(\d+)$`, `_\1`}, "web_01"},
+		{"regex_replace", "a.b.c", []interface{}{`\.`, "$", Keywords{"count": 1}}, "a$b.c"},
+		{"regex_replace", "Host", []interface{}{"(?P<first>h)", `\g<first>!`, Keywords{"ignorecase": true}}, "H!ost"},
+		{"reverse", "abc", nil, "cba"},
+		{"reverse", []interface{}{1, 2, 3}, nil, []interface{}{3, 2, 1}},
+		{"indent", "a\nb\n\nc", []interface{}{2}, "a\n  b\n\n  c"},
+		{"indent", "a\nb", []interface{}{Keywords{"width": "> ", "first": true}}, "> a\n> b"},
+		{"center", "ab", []interface{}{7}, "   ab  "},
+		{"truncate", "the quick brown fox", []interface{}{10, Keywords{"leeway": 0}}, "the..."},
+		{"truncate", "the quick brown fox", []interface{}{10, true, "~", 0}, "the quick~"},
+		{"abs", -3, nil, 3},
+		{"round", 2.675, []interface{}{1}, 2.7},
+		{"round", 2.1, []interface{}{0, "ceil"}, 3.0},
+		{"max", []interface{}{3, 10, 2}, nil, 10},
+		{"min", users, []interface{}{Keywords{"attribute": "uid"}}, users[1]},
+		{"sum", []interface{}{1, 2, 3}, nil, 6},
+		{"sum", []interface{}{1, 2.5}, nil, 3.5},
+		{"sum", users, []interface{}{Keywords{"attribute": "uid", "start": 1}}, 3007},
+		{"first", []interface{}{"a", "b"}, nil, "a"},
+		{"last", "xyz", nil, "z"},
+		{"unique", []interface{}{2, 1, 2, 3, 1}, nil, []interface{}{2, 1, 3}},
+		{"union", []interface{}{1, 2}, []interface{}{[]interface{}{2, 3}}, []interface{}{1, 2, 3}},
+		{"intersect", []interface{}{1, 2, 3}, []interface{}{[]interface{}{3, 2, 5}}, []interface{}{2, 3}},
+		{"difference", []interface{}{1, 2, 3}, []interface{}{[]interface{}{2}}, []interface{}{1, 3}},
+		{"sort", []interface{}{"b", "C", "a"}, nil, []interface{}{"a", "b", "C"}},
+		{"sort", []interface{}{3, 1, 2}, []interface{}{true}, []interface{}{3, 2, 1}},
+		{"sort", users, []interface{}{Keywords{"attribute": "uid"}}, []interface{}{users[1], users[0], users[2]}},
+		{"flatten", []interface{}{1, []interface{}{2, nil, []interface{}{3}}}, nil, []interface{}{1, 2, 3}},
+		{"flatten", []interface{}{1, []interface{}{2, []interface{}{3}}}, []interface{}{1}, []interface{}{1, 2, []interface{}{3}}},
+		{"select", []interface{}{0, 1, "", "a"}, nil, []interface{}{1, "a"}},
+		{"select", []interface{}{1, 2, 3, 4}, []interface{}{"even"}, []interface{}{2, 4}},
+		{"reject", []interface{}{1, 2, 3}, []interface{}{">", 1}, []interface{}{1}},
+		{"select", []interface{}{"web1", "db1"}, []interface{}{"match", "web"}, []interface{}{"web1"}},
+		{"map", users, []interface{}{Keywords{"attribute": "name"}}, []interface{}{"bob", "alice", "carol"}},
+		{"map", []interface{}{"a", "b"}, []interface{}{"upper"}, []interface{}{"A", "B"}},
+		{"map", users, []interface{}{Keywords{"attribute": "groups", "default": "none"}}, []interface{}{[]interface{}{"wheel"}, []interface{}{}, "none"}},
+		{"selectattr", users, []interface{}{"groups"}, []interface{}{users[0]}},
+		{"selectattr", users, []interface{}{"uid", "gt", 1001}, []interface{}{users[0], users[2]}},
+		{"rejectattr", users, []interface{}{"groups", "defined"}, []interface{}{users[2]}},
+		{"dict2items", map[string]interface{}{"b": 2, "a": 1}, nil, []interface{}{
+			map[string]interface{}{"key": "a", "value": 1},
+			map[string]interface{}{"key": "b", "value": 2},
+		}},
+		{"items2dict", []interface{}{map[string]interface{}{"k": "a", "v": 1}}, []interface{}{Keywords{"key_name": "k", "value_name": "v"}}, map[string]interface{}{"a": 1}},
+		{"combine", map[string]interface{}{"a": 1, "n": map[string]interface{}{"x": 1}}, []interface{}{map[string]interface{}{"n": map[string]interface{}{"y": 2}}, Keywords{"recursive": true}},
+			map[string]interface{}{"a": 1, "n": map[string]interface{}{"x": 1, "y": 2}}},
+		{"combine", []interface{}{map[string]interface{}{"a": 1}, map[string]interface{}{"a": 2}}, nil, map[string]interface{}{"a": 2}},
+		{"urlencode", "a b/c&d", nil, "a%20b/c%26d"},
+		{"urlencode", map[string]interface{}{"q": "x y", "n": 1}, nil, "n=1&q=x%20y"},
+		{"quote", "it's", nil, `'it'"'"'s'`},
+		{"quote", "/etc/hosts", nil, "/etc/hosts"},
+		{"to_yaml", map[string]interface{}{"a": []interface{}{1}}, nil, "a:\n  - 1\n"},
+		{"to_nice_yaml", map[string]interface{}{"a": map[string]interface{}{"b": 1}}, nil, "a:\n    b: 1\n"},
+		{"from_yaml", "a: [1, two]", nil, map[string]interface{}{"a": []interface{}{1, "two"}}},
+		{"strftime", "%Y-%m-%d %H:%M:%S %j %%", []interface{}{86400 * 40, Keywords{"utc": true}}, "1970-02-10 00:00:00 041 %"},
+		{"version_compare", "2.10.1", []interface{}{"2.9", ">="}, true},
+		{"version_compare", "1.0rc1", []interface{}{"1.0", "lt"}, false},
+		{"version_compare", "1.2", []interface{}{"1.2.0", "eq"}, false},
+	}
+
+	for _, test := range tests {
+		result, err := filters[test.filter](test.input, test.args...)
+		if err != nil {
+			t.Errorf("%s(%v, %v) error = %v", test.filter, test.input, test.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("%s(%v, %v) = %#v, want %#v", test.filter, test.input, test.args, result, test.expected)
+		}
+	}
+
+	result, err := filters["to_datetime"]("2024-03-01 12:30:00")
+	if err != nil || !result.(time.Time).Equal(time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)) {
+		t.Errorf("to_datetime = %v, %v", result, err)
+	}
+
+	for _, bad := range []struct {
+		filter string
+		input  interface{}
+		args   []interface{}
+	}{
+		{"sum", []interface{}{1, "x"}, nil},
+		{"round", 1.5, []interface{}{0, "up"}},
+		{"select", []interface{}{1}, []interface{}{"nonsense"}},
+		{"map", []interface{}{1}, []interface{}{"nonsense"}},
+		{"combine", 1, nil},
+		{"version_compare", "1", []interface{}{"2", "~"}},
+	} {
+		if _, err := filters[bad.filter](bad.input, bad.args...); err == nil {
+			t.Errorf("%s(%v, %v) expected an error", bad.filter, bad.input, bad.args)
+		}
+	}
+}
+
+func TestCoreFilters_MapRegistryFilters(t *testing.T) {
+	core, err := NewFilterPluginRegistry().Get("core")
+	if err != nil {
+		t.Fatalf("Failed to get core plugin: %v", err)
+	}
+	mapFn := core.GetFilters()["map"]
+
+	tests := []struct {
+		input    interface{}
+		args     []interface{}
+		expected interface{}
+	}{
+		{[]interface{}{"/etc/hosts", "/tmp/a.txt"}, []interface{}{"basename"}, []interface{}{"hosts", "a.txt"}},
+		{[]interface{}{"192.0.2.1", "nonsense"}, []interface{}{"ipaddr"}, []interface{}{"192.0.2.1", false}},
+		{[]interface{}{"a", "b"}, []interface{}{"upper"}, []interface{}{"A", "B"}},
+	}
+
+	for _, test := range tests {
+		result, err := mapFn(test.input, test.args...)
+		if err != nil {
+			t.Errorf("map(%v, %v) error = %v", test.input, test.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("map(%v, %v) = %#v, want %#v", test.input, test.args, result, test.expected)
+		}
+	}
+}
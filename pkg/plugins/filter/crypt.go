@@ -0,0 +1,357 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filter
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/blowfish"
+)
+
+// This file implements the crypt(3) password hashing schemes understood by
+// glibc and libxcrypt, so password_hash output can be used in /etc/shadow:
+// MD5-crypt ($1$), SHA-256-crypt ($5$), SHA-512-crypt ($6$) and bcrypt ($2b$).
+
+// cryptAlphabet is the base64 alphabet of crypt(3) hashes
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// bcryptAlphabet orders the same characters differently
+const bcryptAlphabet = "./ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+var bcryptEncoding = base64.NewEncoding(bcryptAlphabet).WithPadding(base64.NoPadding)
+
+const (
+	shaCryptDefaultRounds = 5000
+	shaCryptMinRounds     = 1000
+	shaCryptMaxRounds     = 999999999
+	bcryptDefaultCost     = 12
+)
+
+// cryptScheme describes a password hashing scheme
+type cryptScheme struct {
+	// saltSize is the default length of generated salts
+	saltSize int
+	// maxSalt is the number of salt characters the scheme uses
+	maxSalt int
+	hash    func(password, salt string, rounds int, ident string) (string, error)
+}
+
+var cryptSchemes = map[string]cryptScheme{
+	"md5_crypt":    {saltSize: 8, maxSalt: 8, hash: md5Crypt},
+	"sha256_crypt": {saltSize: 16, maxSalt: 16, hash: sha256Crypt},
+	"sha512_crypt": {saltSize: 16, maxSalt: 16, hash: sha512Crypt},
+	"bcrypt":       {saltSize: 22, maxSalt: 22, hash: bcryptHash},
+}
+
+// cryptSchemeAliases maps the names password_hash accepts to schemes
+var cryptSchemeAliases = map[string]string{
+	"md5":          "md5_crypt",
+	"md5_crypt":    "md5_crypt",
+	"sha256":       "sha256_crypt",
+	"sha256_crypt": "sha256_crypt",
+	"sha512":       "sha512_crypt",
+	"sha512_crypt": "sha512_crypt",
+	"bcrypt":       "bcrypt",
+	"blowfish":     "bcrypt",
+}
+
+// cryptPassword hashes a password with the named scheme. An empty salt is
+// replaced by a random one of saltSize characters (or the scheme default);
+// rounds of 0 selects the scheme default.
+func cryptPassword(scheme, password, salt string, saltSize, rounds int, ident string) (string, error) {
+	name, ok := cryptSchemeAliases[scheme]
+	if !ok {
+		return "", fmt.Errorf("unsupported password hash type '%s'", scheme)
+	}
+	s := cryptSchemes[name]
+
+	if salt == "" {
+		if saltSize <= 0 {
+			saltSize = s.saltSize
+		}
+		var err error
+		if name == "bcrypt" {
+			buf := make([]byte, 16)
+			if _, err = rand.Read(buf); err != nil {
+				return "", err
+			}
+			salt = bcryptEncoding.EncodeToString(buf)
+		} else if salt, err = randomSalt(min(saltSize, s.maxSalt)); err != nil {
+			return "", err
+		}
+	}
+
+	for _, c := range salt {
+		if !strings.ContainsRune(cryptAlphabet, c) {
+			return "", fmt.Errorf("invalid character '%c' in salt, salts may only contain %s", c, cryptAlphabet)
+		}
+	}
+	if len(salt) > s.maxSalt {
+		salt = salt[:s.maxSalt]
+	}
+
+	return s.hash(password, salt, rounds, ident)
+}
+
+// randomSalt returns n random characters from the crypt alphabet
+func randomSalt(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	for i, b := range buf {
+		buf[i] = cryptAlphabet[int(b)%len(cryptAlphabet)]
+	}
+	return string(buf), nil
+}
+
+// cryptBase64 encodes bytes three at a time in the crypt(3) alphabet, least
+// significant bits first; groups lists the byte indexes of each group and
+// the number of characters it produces
+func cryptBase64(sum []byte, groups [][4]int) string {
+	var b strings.Builder
+	for _, g := range groups {
+		w := uint(0)
+		for _, index := range g[:3] {
+			w <<= 8
+			if index >= 0 {
+				w |= uint(sum[index])
+			}
+		}
+		for i := 0; i < g[3]; i++ {
+			b.WriteByte(cryptAlphabet[w&0x3f])
+			w >>= 6
+		}
+	}
+	return b.String()
+}
+
+var md5CryptGroups = [][4]int{
+	{0, 6, 12, 4}, {1, 7, 13, 4}, {2, 8, 14, 4}, {3, 9, 15, 4}, {4, 10, 5, 4}, {-1, -1, 11, 2},
+}
+
+// md5Crypt implements the FreeBSD MD5-based crypt ($1$)
+func md5Crypt(password, salt string, _ int, _ string) (string, error) {
+	pw, s := []byte(password), []byte(salt)
+
+	alt := md5.New()
+	alt.Write(pw)
+	alt.Write(s)
+	alt.Write(pw)
+	final := alt.Sum(nil)
+
+	ctx := md5.New()
+	ctx.Write(pw)
+	ctx.Write([]byte("$1$"))
+	ctx.Write(s)
+	for n := len(pw); n > 0; n -= 16 {
+		ctx.Write(final[:min(n, 16)])
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(pw[:1])
+		}
+	}
+	final = ctx.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 != 0 {
+			round.Write(pw)
+		} else {
+			round.Write(final)
+		}
+		if i%3 != 0 {
+			round.Write(s)
+		}
+		if i%7 != 0 {
+			round.Write(pw)
+		}
+		if i&1 != 0 {
+			round.Write(final)
+		} else {
+			round.Write(pw)
+		}
+		final = round.Sum(nil)
+	}
+
+	return "$1$" + salt + "$" + cryptBase64(final, md5CryptGroups), nil
+}
+
+var sha256CryptGroups = [][4]int{
+	{0, 10, 20, 4}, {21, 1, 11, 4}, {12, 22, 2, 4}, {3, 13, 23, 4}, {24, 4, 14, 4},
+	{15, 25, 5, 4}, {6, 16, 26, 4}, {27, 7, 17, 4}, {18, 28, 8, 4}, {9, 19, 29, 4},
+	{-1, 31, 30, 3},
+}
+
+var sha512CryptGroups = [][4]int{
+	{0, 21, 42, 4}, {22, 43, 1, 4}, {44, 2, 23, 4}, {3, 24, 45, 4}, {25, 46, 4, 4},
+	{47, 5, 26, 4}, {6, 27, 48, 4}, {28, 49, 7, 4}, {50, 8, 29, 4}, {9, 30, 51, 4},
+	{31, 52, 10, 4}, {53, 11, 32, 4}, {12, 33, 54, 4}, {34, 55, 13, 4}, {56, 14, 35, 4},
+	{15, 36, 57, 4}, {37, 58, 16, 4}, {59, 17, 38, 4}, {18, 39, 60, 4}, {40, 61, 19, 4},
+	{62, 20, 41, 4}, {-1, -1, 63, 2},
+}
+
+func sha256Crypt(password, salt string, rounds int, _ string) (string, error) {
+	return shaCrypt(sha256.New, "$5$", sha256CryptGroups, password, salt, rounds)
+}
+
+func sha512Crypt(password, salt string, rounds int, _ string) (string, error) {
+	return shaCrypt(sha512.New, "$6$", sha512CryptGroups, password, salt, rounds)
+}
+
+// shaCrypt implements Ulrich Drepper's SHA-crypt. The rounds are only
+// written into the hash when they differ from the default.
+func shaCrypt(newHash func() hash.Hash, prefix string, groups [][4]int, password, salt string, rounds int) (string, error) {
+	explicitRounds := rounds != 0
+	if !explicitRounds {
+		rounds = shaCryptDefaultRounds
+	}
+	rounds = max(shaCryptMinRounds, min(rounds, shaCryptMaxRounds))
+	pw, s := []byte(password), []byte(salt)
+
+	digest := func(parts ...[]byte) []byte {
+		h := newHash()
+		for _, part := range parts {
+			h.Write(part)
+		}
+		return h.Sum(nil)
+	}
+	// repeat stretches a digest over n bytes
+	repeat := func(sum []byte, n int) []byte {
+		out := make([]byte, 0, n)
+		for len(out) < n {
+			out = append(out, sum[:min(len(sum), n-len(out))]...)
+		}
+		return out
+	}
+
+	altSum := digest(pw, s, pw)
+
+	a := newHash()
+	a.Write(pw)
+	a.Write(s)
+	a.Write(repeat(altSum, len(pw)))
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			a.Write(altSum)
+		} else {
+			a.Write(pw)
+		}
+	}
+	sum := a.Sum(nil)
+
+	dp := newHash()
+	for range pw {
+		dp.Write(pw)
+	}
+	p := repeat(dp.Sum(nil), len(pw))
+
+	ds := newHash()
+	for i := 0; i < 16+int(sum[0]); i++ {
+		ds.Write(s)
+	}
+	sp := repeat(ds.Sum(nil), len(s))
+
+	for i := 0; i < rounds; i++ {
+		c := newHash()
+		if i&1 != 0 {
+			c.Write(p)
+		} else {
+			c.Write(sum)
+		}
+		if i%3 != 0 {
+			c.Write(sp)
+		}
+		if i%7 != 0 {
+			c.Write(p)
+		}
+		if i&1 != 0 {
+			c.Write(sum)
+		} else {
+			c.Write(p)
+		}
+		sum = c.Sum(nil)
+	}
+
+	result := prefix
+	if explicitRounds {
+		result += "rounds=" + strconv.Itoa(rounds) + "$"
+	}
+	return result + salt + "$" + cryptBase64(sum, groups), nil
+}
+
+// bcryptHash implements OpenBSD's bcrypt ($2b$). The salt is 22 characters
+// encoding 16 bytes; rounds is the log2 cost.
+func bcryptHash(password, salt string, cost int, ident string) (string, error) {
+	if cost == 0 {
+		cost = bcryptDefaultCost
+	}
+	if cost < 4 || cost > 31 {
+		return "", fmt.Errorf("bcrypt rounds must be between 4 and 31, got %d", cost)
+	}
+	switch ident {
+	case "":
+		ident = "2b"
+	case "2", "2a", "2b", "2y":
+	default:
+		return "", fmt.Errorf("invalid bcrypt ident '%s'", ident)
+	}
+	if len(salt) != 22 {
+		return "", fmt.Errorf("bcrypt salts must be 22 characters long, got %d", len(salt))
+	}
+	rawSalt, err := bcryptEncoding.DecodeString(salt)
+	if err != nil {
+		return "", fmt.Errorf("invalid bcrypt salt: %w", err)
+	}
+
+	// Like the C implementation, the key includes its terminating NUL and
+	// is truncated to 72 bytes
+	key := append([]byte(password), 0)
+	if len(key) > 72 {
+		key = key[:72]
+	}
+
+	c, err := blowfish.NewSaltedCipher(key, rawSalt)
+	if err != nil {
+		return "", err
+	}
+	for i := uint64(0); i < 1<<uint(cost); i++ {
+		blowfish.ExpandKey(key, c)
+		blowfish.ExpandKey(rawSalt, c)
+	}
+
+	data := []byte("OrpheanBeholderScryDoubt")
+	for i := 0; i < len(data); i += 8 {
+		for j := 0; j < 64; j++ {
+			c.Encrypt(data[i:i+8], data[i:i+8])
+		}
+	}
+
+	// Only 23 of the 24 bytes are encoded, as in the C implementation
+	return fmt.Sprintf("$%s$%02d$%s%s", ident, cost, bcryptEncoding.EncodeToString(rawSalt), bcryptEncoding.EncodeToString(data[:23])), nil
+}
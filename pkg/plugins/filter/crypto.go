@@ -0,0 +1,203 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filter
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"strings"
+	"unicode/utf8"
+)
+
+// ansibleUUIDNamespace is the default namespace of to_uuid
+const ansibleUUIDNamespace = "361E6D51-FAEC-444A-9079-341386DA8E2E"
+
+// CryptoFiltersPlugin implements the password, checksum, UUID, random and
+// vault filters
+type CryptoFiltersPlugin struct {
+	*BaseFilterPlugin
+}
+
+func NewCryptoFiltersPlugin() *CryptoFiltersPlugin {
+	return &CryptoFiltersPlugin{
+		BaseFilterPlugin: NewBaseFilterPlugin(
+			"crypto",
+			"Password hashing, checksum, UUID, random and vault filters",
+			"1.0.0",
+			"Ansible Project",
+		),
+	}
+}
+
+func (p *CryptoFiltersPlugin) GetFilters() map[string]FilterFunction {
+	return map[string]FilterFunction{
+		"password_hash": passwordHashFilter,
+		"checksum":      checksumFilter,
+		"to_uuid":       toUUIDFilter,
+		"random":        randomFilter,
+		"vault":         vaultFilter,
+		"unvault":       unvaultFilter,
+	}
+}
+
+// passwordHashFilter implements password_hash(hashtype='sha512', salt=None,
+// salt_size=None, rounds=None, ident=None)
+func passwordHashFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, kw := SplitKeywords(args)
+	password, ok := input.(string)
+	if !ok {
+		return nil, fmt.Errorf("password_hash requires a string, got %T", input)
+	}
+
+	scheme := optionalString(argument(args, kw, 0, "hashtype", "sha512"))
+	salt := optionalString(argument(args, kw, 1, "salt", nil))
+	saltSize, err := intArgument("password_hash", "salt_size", argument(args, kw, 2, "salt_size", nil), 0)
+	if err != nil {
+		return nil, err
+	}
+	rounds, err := intArgument("password_hash", "rounds", argument(args, kw, 3, "rounds", nil), 0)
+	if err != nil {
+		return nil, err
+	}
+	ident := optionalString(argument(args, kw, 4, "ident", nil))
+
+	result, err := cryptPassword(scheme, password, salt, saltSize, rounds, ident)
+	if err != nil {
+		return nil, fmt.Errorf("password_hash: %w", err)
+	}
+	return result, nil
+}
+
+// checksumFilter implements checksum, the SHA-1 checksum Ansible reports
+// for file content
+func checksumFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	sum := sha1.Sum([]byte(fmt.Sprintf("%v", input)))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// toUUIDFilter implements to_uuid(namespace), a name-based (version 5)
+// UUID in the Ansible namespace or the one given
+func toUUIDFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, kw := SplitKeywords(args)
+	namespace := optionalString(argument(args, kw, 0, "namespace", ansibleUUIDNamespace))
+
+	ns, err := hex.DecodeString(strings.ReplaceAll(strings.Trim(namespace, "{}"), "-", ""))
+	if err != nil || len(ns) != 16 {
+		return nil, fmt.Errorf("to_uuid: invalid namespace UUID '%s'", namespace)
+	}
+
+	h := sha1.New()
+	h.Write(ns)
+	h.Write([]byte(fmt.Sprintf("%v", input)))
+	u := h.Sum(nil)[:16]
+	u[6] = u[6]&0x0f | 0x50
+	u[8] = u[8]&0x3f | 0x80
+
+	s := hex.EncodeToString(u)
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:], nil
+}
+
+// randomFilter implements random(start=0, step=1, seed=None): a number in
+// range(start, end, step) for an integer input, or a random item of a list
+// or string. A seed, such as inventory_hostname, makes the choice
+// reproducible.
+func randomFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, kw := SplitKeywords(args)
+
+	rng := rand.Intn
+	if seed, ok := kw["seed"]; ok && seed != nil {
+		h := fnv.New64a()
+		h.Write([]byte(fmt.Sprintf("%v", seed)))
+		rng = rand.New(rand.NewSource(int64(h.Sum64()))).Intn
+	}
+
+	if end, ok := ipInt(input); ok {
+		if _, isString := input.(string); !isString {
+			start, ok1 := ipInt(argument(args, kw, 0, "start", 0))
+			step, ok2 := ipInt(argument(args, kw, 1, "step", 1))
+			if !ok1 || !ok2 || step <= 0 {
+				return nil, fmt.Errorf("random: start and step must be integers and step positive")
+			}
+			count := (end - start + step - 1) / step
+			if count <= 0 {
+				return nil, fmt.Errorf("random: empty range for random(%d, %d, %d)", start, end, step)
+			}
+			return int(start + step*int64(rng(int(count)))), nil
+		}
+	}
+
+	if s, ok := input.(string); ok {
+		if s == "" {
+			return nil, fmt.Errorf("random: cannot choose from an empty string")
+		}
+		runes := []rune(s)
+		return string(runes[rng(utf8.RuneCountInString(s))]), nil
+	}
+
+	items, err := dataItems("random", input)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("random: cannot choose from an empty list")
+	}
+	return items[rng(len(items))], nil
+}
+
+// vaultFilter implements vault(secret, salt=None, vault_id='filter_default')
+func vaultFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, kw := SplitKeywords(args)
+	secret := optionalString(argument(args, kw, 0, "secret", nil))
+	salt := optionalString(argument(args, kw, 1, "salt", nil))
+	vaultID := optionalString(argument(args, kw, 2, "vault_id", "filter_default"))
+	if legacy, ok := kw["vaultid"]; ok {
+		vaultID = optionalString(legacy)
+	}
+
+	result, err := vaultEncrypt([]byte(fmt.Sprintf("%v", input)), secret, []byte(salt), vaultID)
+	if err != nil {
+		return nil, fmt.Errorf("vault: %w", err)
+	}
+	return result, nil
+}
+
+// unvaultFilter implements unvault(secret), decrypting vault data
+func unvaultFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, kw := SplitKeywords(args)
+	secret := optionalString(argument(args, kw, 0, "secret", nil))
+
+	text, ok := input.(string)
+	if !ok || !isVaultEncrypted(text) {
+		return nil, fmt.Errorf("unvault: input is not vault encrypted data")
+	}
+	plaintext, _, err := vaultDecrypt(text, secret)
+	if err != nil {
+		return nil, fmt.Errorf("unvault: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// optionalString converts an argument to a string, with nil as ""
+func optionalString(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", value)
+}
@@ -0,0 +1,134 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filter
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestPasswordHash(t *testing.T) {
+	tests := []struct {
+		password string
+		args     []interface{}
+		expected string
+	}{
+		// Reference hashes from glibc/libxcrypt crypt(3)
+		{"Hello world!", []interface{}{"sha512", "saltstring"},
+			"$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1"},
+		{"Hello world!", []interface{}{"sha256", "saltstringsaltstring", nil, 10000},
+			"$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA"},
+		{"secret", []interface{}{"sha512_crypt", Keywords{"salt": "mysalt", "rounds": 5000}},
+			"$6$rounds=5000$mysalt$UX6P1VKOf6dr0s2pg4pLNvIUB6hLXvP6M5f/Yj9hJgMPdAJ4oArvk5YUD9/XWtk1JOpVwt/JTsczMJr8sFVML1"},
+		{"a much longer password, over sixteen bytes", []interface{}{"sha256", "abcdefghijklmnopq"},
+			"$5$abcdefghijklmnop$ShUmb8eLb1hbcmu/wbOnxtQ0whq11BOA/byfFeIdiv2"},
+		{"password", []interface{}{"md5", "saltsalt"}, "$1$saltsalt$qjXMvbEw8oaL.CzflDtaK/"},
+		{"", []interface{}{"md5_crypt", "abc"}, "$1$abc$Or2rbeUYTvt12aiVzMuS/."},
+		{"password", []interface{}{"bcrypt", "abcdefghijklmnopqrstuu", nil, 10},
+			"$2b$10$abcdefghijklmnopqrstuu5Lo0g67CiD3M4RpN1BmBb4Crp5w7dbK"},
+		{"secret", []interface{}{"blowfish", Keywords{"salt": "abcdefghijklmnopqrstuu", "rounds": 4}},
+			"$2b$04$abcdefghijklmnopqrstuu2r9OfJnfCsdneAXAGHnS4UpFFP8WIrW"},
+	}
+
+	for _, test := range tests {
+		result, err := passwordHashFilter(test.password, test.args...)
+		if err != nil {
+			t.Errorf("password_hash(%q, %v) failed: %v", test.password, test.args, err)
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("password_hash(%q, %v): expected %s, got %s", test.password, test.args, test.expected, result)
+		}
+	}
+
+	// Generated salts
+	result, err := passwordHashFilter("secret")
+	if err != nil {
+		t.Fatalf("password_hash failed: %v", err)
+	}
+	if !regexp.MustCompile(`^\$6\$[./0-9A-Za-z]{16}\$[./0-9A-Za-z]{86}$`).MatchString(result.(string)) {
+		t.Errorf("unexpected sha512 hash with a random salt: %s", result)
+	}
+	result, err = passwordHashFilter("secret", "bcrypt", Keywords{"rounds": 4, "ident": "2y"})
+	if err != nil {
+		t.Fatalf("password_hash failed: %v", err)
+	}
+	if !regexp.MustCompile(`^\$2y\$04\$[./0-9A-Za-z]{53}$`).MatchString(result.(string)) {
+		t.Errorf("unexpected bcrypt hash with a random salt: %s", result)
+	}
+
+	for _, args := range [][]interface{}{{"sha1"}, {"sha512", "bad salt!"}, {"bcrypt", "short"}, {"bcrypt", nil, nil, 3}} {
+		if _, err := passwordHashFilter("secret", args...); err == nil {
+			t.Errorf("password_hash(%v): expected an error", args)
+		}
+	}
+}
+
+func TestCryptoFilters(t *testing.T) {
+	filters := NewCryptoFiltersPlugin().GetFilters()
+
+	tests := []struct {
+		filter   string
+		input    interface{}
+		args     []interface{}
+		expected interface{}
+	}{
+		{"checksum", "hello", nil, "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d"},
+		{"to_uuid", "example.com", nil, "ae780c3a-a3ab-53c2-bfb4-098da300b3fe"},
+		{"to_uuid", "example.com", []interface{}{"6ba7b810-9dad-11d1-80b4-00c04fd430c8"}, "cfbff0d1-9375-5685-968c-48ce8b15ae17"},
+		{"random", 1, nil, 0},
+		{"random", 10, []interface{}{9}, 9},
+		{"random", []interface{}{"only"}, nil, "only"},
+	}
+
+	for _, test := range tests {
+		result, err := filters[test.filter](test.input, test.args...)
+		if err != nil {
+			t.Errorf("%s(%v, %v) failed: %v", test.filter, test.input, test.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("%s(%v, %v): expected %v, got %v", test.filter, test.input, test.args, test.expected, result)
+		}
+	}
+
+	// A seed makes random reproducible, e.g. per host
+	first, _ := randomFilter(65534, Keywords{"seed": "web1"})
+	for i := 0; i < 5; i++ {
+		again, _ := randomFilter(65534, Keywords{"seed": "web1"})
+		if again != first {
+			t.Fatalf("random with a seed is not reproducible: %v != %v", again, first)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		value, err := randomFilter(100, 10, 10)
+		if err != nil {
+			t.Fatalf("random failed: %v", err)
+		}
+		if n := value.(int); n < 10 || n >= 100 || n%10 != 0 {
+			t.Fatalf("random(100, 10, 10) returned %d", n)
+		}
+	}
+
+	if _, err := filters["to_uuid"]("x", "not-a-uuid"); err == nil {
+		t.Error("to_uuid with an invalid namespace: expected an error")
+	}
+	if _, err := filters["random"]([]interface{}{}); err == nil {
+		t.Error("random of an empty list: expected an error")
+	}
+}
@@ -0,0 +1,56 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filter
+
+import (
+	"context"
+
+	"github.com/work-obs/ansible-go/pkg/plugins"
+)
+
+// ExternalFilterPlugin exposes the filters of an external executable plugin
+type ExternalFilterPlugin struct {
+	*BaseFilterPlugin
+	plugin *plugins.ExternalPlugin
+}
+
+func NewExternalFilterPlugin(plugin *plugins.ExternalPlugin) *ExternalFilterPlugin {
+	author := ""
+	if len(plugin.Author) > 0 {
+		author = plugin.Author[0]
+	}
+	return &ExternalFilterPlugin{
+		BaseFilterPlugin: NewBaseFilterPlugin(plugin.Name, plugin.Description, plugin.Version, author),
+		plugin:           plugin,
+	}
+}
+
+func (p *ExternalFilterPlugin) GetFilters() map[string]FilterFunction {
+	filters := make(map[string]FilterFunction, len(p.plugin.Filters))
+	for _, name := range p.plugin.Filters {
+		filters[name] = p.filter(name)
+	}
+	return filters
+}
+
+// filter forwards one filter to the plugin, keyword arguments as kwargs
+func (p *ExternalFilterPlugin) filter(name string) FilterFunction {
+	return func(input interface{}, args ...interface{}) (interface{}, error) {
+		args, kw := SplitKeywords(args)
+		return p.plugin.Filter(context.Background(), name, input, args, kw)
+	}
+}
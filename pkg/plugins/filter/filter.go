@@ -24,12 +24,10 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"math/rand"
 	"reflect"
-	"regexp"
+	"sort"
 	"strconv"
 	"strings"
-	"time"
 	"unicode"
 
 	"github.com/work-obs/ansible-go/pkg/plugins"
@@ -38,6 +36,33 @@ import (
 // FilterFunction represents a template filter function
 type FilterFunction func(input interface{}, args ...interface{}) (interface{}, error)
 
+// Keywords holds the keyword arguments of a filter call. Callers pass them
+// as the last argument, after the positional ones.
+type Keywords map[string]interface{}
+
+// SplitKeywords separates the trailing Keywords argument, if any, from the
+// positional arguments
+func SplitKeywords(args []interface{}) ([]interface{}, Keywords) {
+	if len(args) > 0 {
+		if kw, ok := args[len(args)-1].(Keywords); ok {
+			return args[:len(args)-1], kw
+		}
+	}
+	return args, Keywords{}
+}
+
+// argument returns the positional argument at index or the keyword argument
+// name, falling back to def when neither is given
+func argument(args []interface{}, kw Keywords, index int, name string, def interface{}) interface{} {
+	if value, ok := kw[name]; ok {
+		return value
+	}
+	if index >= 0 && index < len(args) {
+		return args[index]
+	}
+	return def
+}
+
 // FilterPlugin interface for filter plugins
 type FilterPlugin interface {
 	plugins.BasePlugin
@@ -82,6 +107,9 @@ func (f *BaseFilterPlugin) GetInfo() *plugins.PluginInfo {
 // CoreFiltersPlugin implements core Ansible filters
 type CoreFiltersPlugin struct {
 	*BaseFilterPlugin
+	// registry, if set, is where map() finds the filters it applies, so
+	// that it can apply the filters of every registered plugin
+	registry *FilterPluginRegistry
 }
 
 func NewCoreFiltersPlugin() *CoreFiltersPlugin {
@@ -249,20 +277,7 @@ func (c *CoreFiltersPlugin) replace(input interface{}, args ...interface{}) (int
 }
 
 func (c *CoreFiltersPlugin) regexReplace(input interface{}, args ...interface{}) (interface{}, error) {
-	if len(args) < 2 {
-		return nil, fmt.Errorf("regex_replace filter requires 2 arguments: pattern and replacement")
-	}
-
-	str := fmt.Sprintf("%v", input)
-	pattern := fmt.Sprintf("%v", args[0])
-	replacement := fmt.Sprintf("%v", args[1])
-
-	re, err := regexp.Compile(pattern)
-	if err != nil {
-		return nil, fmt.Errorf("invalid regex pattern: %v", err)
-	}
-
-	return re.ReplaceAllString(str, replacement), nil
+	return regexReplaceFilter(input, args...)
 }
 
 func (c *CoreFiltersPlugin) split(input interface{}, args ...interface{}) (interface{}, error) {
@@ -456,171 +471,144 @@ func (c *CoreFiltersPlugin) toNiceJson(input interface{}, args ...interface{}) (
 	return string(jsonData), nil
 }
 
-// Default implementations for remaining filters (simplified for brevity)
+// The remaining filters are implemented in core.go
 func (c *CoreFiltersPlugin) reverse(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would reverse strings or slices
-	return input, nil
+	return reverseFilter(input, args...)
 }
 
 func (c *CoreFiltersPlugin) indent(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would indent text
-	return input, nil
+	return indentFilter(input, args...)
 }
 
 func (c *CoreFiltersPlugin) center(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would center text
-	return input, nil
+	return centerFilter(input, args...)
 }
 
 func (c *CoreFiltersPlugin) truncate(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would truncate text
-	return input, nil
+	return truncateFilter(input, args...)
 }
 
 func (c *CoreFiltersPlugin) abs(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would return absolute value
-	return input, nil
+	return absFilter(input, args...)
 }
 
 func (c *CoreFiltersPlugin) round(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would round numbers
-	return input, nil
+	return roundFilter(input, args...)
 }
 
 func (c *CoreFiltersPlugin) random(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would return random element or number
-	rand.Seed(time.Now().UnixNano())
-	return rand.Intn(100), nil
+	return randomFilter(input, args...)
 }
 
 func (c *CoreFiltersPlugin) max(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would find maximum value
-	return input, nil
+	return extremeFilter("max", 1)(input, args...)
 }
 
 func (c *CoreFiltersPlugin) min(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would find minimum value
-	return input, nil
+	return extremeFilter("min", -1)(input, args...)
 }
 
 func (c *CoreFiltersPlugin) sum(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would sum numeric values
-	return input, nil
+	return sumFilter(input, args...)
 }
 
 func (c *CoreFiltersPlugin) first(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would return first element
-	return input, nil
+	return endFilter("first", false)(input, args...)
 }
 
 func (c *CoreFiltersPlugin) last(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would return last element
-	return input, nil
+	return endFilter("last", true)(input, args...)
 }
 
 func (c *CoreFiltersPlugin) unique(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would return unique elements
-	return input, nil
+	return uniqueFilter(input, args...)
 }
 
 func (c *CoreFiltersPlugin) union(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would return union of sets
-	return input, nil
+	return setFilter("union")(input, args...)
 }
 
 func (c *CoreFiltersPlugin) intersect(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would return intersection of sets
-	return input, nil
+	return setFilter("intersect")(input, args...)
 }
 
 func (c *CoreFiltersPlugin) difference(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would return difference of sets
-	return input, nil
+	return setFilter("difference")(input, args...)
 }
 
 func (c *CoreFiltersPlugin) sort(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would sort elements
-	return input, nil
+	return sortFilter(input, args...)
 }
 
 func (c *CoreFiltersPlugin) flatten(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would flatten nested structures
-	return input, nil
+	return flattenFilter(input, args...)
 }
 
 func (c *CoreFiltersPlugin) selectFilter(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would select elements matching condition
-	return input, nil
+	return selectFilter("select", true)(input, args...)
 }
 
 func (c *CoreFiltersPlugin) reject(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would reject elements matching condition
-	return input, nil
+	return selectFilter("reject", false)(input, args...)
 }
 
 func (c *CoreFiltersPlugin) mapFilter(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would map elements through function
-	return input, nil
+	if c.registry != nil {
+		return mapFilter(c.registry.Filter)(input, args...)
+	}
+	filters := c.GetFilters()
+	return mapFilter(func(name string) (FilterFunction, bool) {
+		fn, ok := filters[name]
+		return fn, ok
+	})(input, args...)
 }
 
 func (c *CoreFiltersPlugin) selectAttr(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would select by attribute
-	return input, nil
+	return selectAttrFilter("selectattr", true)(input, args...)
 }
 
 func (c *CoreFiltersPlugin) rejectAttr(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would reject by attribute
-	return input, nil
+	return selectAttrFilter("rejectattr", false)(input, args...)
 }
 
 func (c *CoreFiltersPlugin) dict2items(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would convert dict to items
-	return input, nil
+	return dict2itemsFilter(input, args...)
 }
 
 func (c *CoreFiltersPlugin) items2dict(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would convert items to dict
-	return input, nil
+	return items2dictFilter(input, args...)
 }
 
 func (c *CoreFiltersPlugin) combine(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would combine dictionaries
-	return input, nil
+	return combineFilter(input, args...)
 }
 
 func (c *CoreFiltersPlugin) urlencode(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would URL encode
-	return input, nil
+	return urlencodeFilter(input, args...)
 }
 
 func (c *CoreFiltersPlugin) quote(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would quote shell arguments
-	return input, nil
+	return quoteFilter(input, args...)
 }
 
 func (c *CoreFiltersPlugin) toYaml(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would convert to YAML
-	return input, nil
+	return yamlFilter("to_yaml", 2)(input, args...)
 }
 
 func (c *CoreFiltersPlugin) fromYaml(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would parse YAML
-	return input, nil
+	return fromYamlFilter(input, args...)
 }
 
 func (c *CoreFiltersPlugin) toNiceYaml(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would convert to pretty YAML
-	return input, nil
+	return yamlFilter("to_nice_yaml", 4)(input, args...)
 }
 
 func (c *CoreFiltersPlugin) strftime(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would format time
-	return input, nil
+	return strftimeFilter(input, args...)
 }
 
 func (c *CoreFiltersPlugin) toDatetime(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would parse datetime
-	return input, nil
+	return toDatetimeFilter(input, args...)
 }
 
 func (c *CoreFiltersPlugin) defaultFilter(input interface{}, args ...interface{}) (interface{}, error) {
@@ -655,68 +643,55 @@ func (c *CoreFiltersPlugin) ternary(input interface{}, args ...interface{}) (int
 }
 
 func (c *CoreFiltersPlugin) regexSearch(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would search with regex
-	return input, nil
+	return regexSearchFilter(input, args...)
 }
 
 func (c *CoreFiltersPlugin) regexFindall(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would find all regex matches
-	return input, nil
+	return regexFindallFilter(input, args...)
 }
 
 func (c *CoreFiltersPlugin) regexEscape(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would escape regex characters
-	return input, nil
+	return regexEscapeFilter(input, args...)
 }
 
 func (c *CoreFiltersPlugin) basename(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would get basename of path
-	return input, nil
+	return basenameFilter(input, args...)
 }
 
 func (c *CoreFiltersPlugin) dirname(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would get dirname of path
-	return input, nil
+	return dirnameFilter(input, args...)
 }
 
 func (c *CoreFiltersPlugin) expanduser(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would expand ~ in path
-	return input, nil
+	return expanduserFilter(input, args...)
 }
 
 func (c *CoreFiltersPlugin) realpath(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would resolve real path
-	return input, nil
+	return realpathFilter(input, args...)
 }
 
 func (c *CoreFiltersPlugin) relpath(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would get relative path
-	return input, nil
+	return relpathFilter(input, args...)
 }
 
 func (c *CoreFiltersPlugin) splitext(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would split file extension
-	return input, nil
+	return splitextFilter(input, args...)
 }
 
 func (c *CoreFiltersPlugin) versionCompare(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would compare versions
-	return input, nil
+	return versionCompareFilter(input, args...)
 }
 
 func (c *CoreFiltersPlugin) ipaddr(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would work with IP addresses
-	return input, nil
+	return ipaddrFilter(input, args...)
 }
 
 func (c *CoreFiltersPlugin) ipv4(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would work with IPv4 addresses
-	return input, nil
+	return ipv4Filter(input, args...)
 }
 
 func (c *CoreFiltersPlugin) ipv6(input interface{}, args ...interface{}) (interface{}, error) {
-	// Implementation would work with IPv6 addresses
-	return input, nil
+	return ipv6Filter(input, args...)
 }
 
 // FilterPluginRegistry manages filter plugin registration and creation
@@ -730,7 +705,16 @@ func NewFilterPluginRegistry() *FilterPluginRegistry {
 	}
 
 	// Register built-in filter plugins
-	registry.Register("core", func() FilterPlugin { return NewCoreFiltersPlugin() })
+	registry.Register("core", func() FilterPlugin {
+		plugin := NewCoreFiltersPlugin()
+		plugin.registry = registry
+		return plugin
+	})
+	registry.Register("ipaddr", func() FilterPlugin { return NewIPAddrFiltersPlugin() })
+	registry.Register("structured", func() FilterPlugin { return NewStructuredFiltersPlugin() })
+	registry.Register("crypto", func() FilterPlugin { return NewCryptoFiltersPlugin() })
+	registry.Register("regex", func() FilterPlugin { return NewRegexFiltersPlugin() })
+	registry.Register("path", func() FilterPlugin { return NewPathFiltersPlugin() })
 
 	return registry
 }
@@ -752,6 +736,24 @@ func (r *FilterPluginRegistry) Exists(name string) bool {
 	return exists
 }
 
+// Filter returns the filter called name from the registered plugins. Where
+// several plugins define it, the plugin whose name sorts last wins, as it
+// does when the plugins are registered with a template engine.
+func (r *FilterPluginRegistry) Filter(name string) (FilterFunction, bool) {
+	names := r.List()
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	for _, pluginName := range names {
+		plugin, err := r.Get(pluginName)
+		if err != nil {
+			continue
+		}
+		if fn, ok := plugin.GetFilters()[name]; ok {
+			return fn, true
+		}
+	}
+	return nil, false
+}
+
 func (r *FilterPluginRegistry) List() []string {
 	names := make([]string, 0, len(r.plugins))
 	for name := range r.plugins {
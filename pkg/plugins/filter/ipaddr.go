@@ -0,0 +1,636 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filter
+
+import (
+	"fmt"
+	"math/big"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// IPAddrFiltersPlugin implements the ipaddr filter family. Values are
+// addresses ("192.0.2.5"), networks ("192.0.2.0/24") or addresses with a
+// prefix ("192.0.2.5/24"); a netmask may stand in for the prefix length.
+// Like Ansible, the filters return false for values that do not match
+// instead of failing, and apply to each item of a list.
+type IPAddrFiltersPlugin struct {
+	*BaseFilterPlugin
+}
+
+func NewIPAddrFiltersPlugin() *IPAddrFiltersPlugin {
+	return &IPAddrFiltersPlugin{
+		BaseFilterPlugin: NewBaseFilterPlugin(
+			"ipaddr",
+			"IP address and network filters",
+			"1.0.0",
+			"Ansible Project",
+		),
+	}
+}
+
+func (p *IPAddrFiltersPlugin) GetFilters() map[string]FilterFunction {
+	return map[string]FilterFunction{
+		"ipaddr":             ipaddrFilter,
+		"ipv4":               ipv4Filter,
+		"ipv6":               ipv6Filter,
+		"ipsubnet":           ipsubnetFilter,
+		"ipmath":             ipmathFilter,
+		"nthhost":            nthhostFilter,
+		"network_in_usable":  networkInUsableFilter,
+		"network_in_network": networkInNetworkFilter,
+		"cidr_merge":         cidrMergeFilter,
+	}
+}
+
+// ipaddrFilter implements ipaddr(query)
+func ipaddrFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	return ipQueryFilter(0, input, args...)
+}
+
+// ipv4Filter implements ipv4(query), which only keeps IPv4 values
+func ipv4Filter(input interface{}, args ...interface{}) (interface{}, error) {
+	return ipQueryFilter(4, input, args...)
+}
+
+// ipv6Filter implements ipv6(query), which only keeps IPv6 values
+func ipv6Filter(input interface{}, args ...interface{}) (interface{}, error) {
+	return ipQueryFilter(6, input, args...)
+}
+
+func ipQueryFilter(version int, input interface{}, args ...interface{}) (interface{}, error) {
+	query := interface{}("")
+	if len(args) > 0 {
+		query = args[0]
+	}
+
+	if items, ok := ipList(input); ok {
+		result := make([]interface{}, 0, len(items))
+		for _, item := range items {
+			value, err := ipQuery(version, item, query)
+			if err != nil {
+				return nil, err
+			}
+			if value != false {
+				result = append(result, value)
+			}
+		}
+		return result, nil
+	}
+	return ipQuery(version, input, query)
+}
+
+// ipQuery answers one ipaddr query for one value
+func ipQuery(version int, input, query interface{}) (interface{}, error) {
+	p, ok := parseIPValue(input)
+	if !ok || (version == 4 && !p.Addr().Is4()) || (version == 6 && !p.Addr().Is6()) {
+		return false, nil
+	}
+
+	if n, ok := ipInt(query); ok {
+		addr, ok := ipNth(p, big.NewInt(n))
+		if !ok {
+			return false, nil
+		}
+		return netip.PrefixFrom(addr, p.Bits()).String(), nil
+	}
+
+	addr := p.Addr()
+	network := p.Masked().Addr()
+	hasBroadcast := ipHostBits(p) > 1
+
+	switch q := fmt.Sprintf("%v", query); q {
+	case "":
+		return ipString(input, p), nil
+	case "address":
+		if !hasBroadcast || addr != network {
+			return addr.String(), nil
+		}
+		return false, nil
+	case "host":
+		if !hasBroadcast || addr != network {
+			return p.String(), nil
+		}
+		return false, nil
+	case "network":
+		return network.String(), nil
+	case "netmask":
+		return ipNetmask(p).String(), nil
+	case "hostmask":
+		mask := ipToInt(ipNetmask(p))
+		return ipFromInt(mask.Xor(mask, ipMaxInt(addr)), addr.Is4()).String(), nil
+	case "prefix":
+		return p.Bits(), nil
+	case "broadcast":
+		if !hasBroadcast {
+			return false, nil
+		}
+		return ipLast(p).String(), nil
+	case "cidr", "subnet":
+		return p.Masked().String(), nil
+	case "net":
+		if hasBroadcast && addr == network {
+			return p.Masked().String(), nil
+		}
+		return false, nil
+	case "size":
+		return ipNumber(ipSize(p)), nil
+	case "version":
+		if addr.Is4() {
+			return 4, nil
+		}
+		return 6, nil
+	case "private":
+		if addr.IsPrivate() {
+			return ipString(input, p), nil
+		}
+		return false, nil
+	case "public":
+		if addr.IsGlobalUnicast() && !addr.IsPrivate() {
+			return ipString(input, p), nil
+		}
+		return false, nil
+	default:
+		return nil, fmt.Errorf("unknown ipaddr query '%s'", q)
+	}
+}
+
+// ipsubnetFilter implements ipsubnet(prefix, index). Without arguments it
+// returns the network of the value. With a longer prefix it returns the
+// number of subnets of that size, or the subnet at index; with a shorter
+// one it returns the enclosing network.
+func ipsubnetFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	if items, ok := ipList(input); ok {
+		result := make([]interface{}, 0, len(items))
+		for _, item := range items {
+			value, err := ipsubnetFilter(item, args...)
+			if err != nil {
+				return nil, err
+			}
+			if value != false {
+				result = append(result, value)
+			}
+		}
+		return result, nil
+	}
+
+	p, ok := parseIPValue(input)
+	if !ok {
+		return false, nil
+	}
+	if len(args) == 0 {
+		return p.Masked().String(), nil
+	}
+
+	bits64, ok := ipInt(args[0])
+	if !ok {
+		return nil, fmt.Errorf("ipsubnet: invalid prefix length '%v'", args[0])
+	}
+	bits := int(bits64)
+	if bits < 0 || bits > p.Addr().BitLen() {
+		return nil, fmt.Errorf("ipsubnet: prefix length %d out of range", bits)
+	}
+
+	if bits <= p.Bits() {
+		return netip.PrefixFrom(p.Addr(), bits).Masked().String(), nil
+	}
+
+	count := new(big.Int).Lsh(big.NewInt(1), uint(bits-p.Bits()))
+	if len(args) < 2 {
+		return ipNumber(count), nil
+	}
+
+	index, ok := ipInt(args[1])
+	if !ok {
+		return nil, fmt.Errorf("ipsubnet: invalid index '%v'", args[1])
+	}
+	n := big.NewInt(index)
+	if n.Sign() < 0 {
+		n.Add(n, count)
+	}
+	if n.Sign() < 0 || n.Cmp(count) >= 0 {
+		return false, nil
+	}
+
+	start := ipToInt(p.Masked().Addr())
+	start.Add(start, n.Lsh(n, uint(p.Addr().BitLen()-bits)))
+	return netip.PrefixFrom(ipFromInt(start, p.Addr().Is4()), bits).String(), nil
+}
+
+// ipmathFilter implements ipmath(amount), which adds to an address
+func ipmathFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	if items, ok := ipList(input); ok {
+		result := make([]interface{}, 0, len(items))
+		for _, item := range items {
+			value, err := ipmathFilter(item, args...)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, value)
+		}
+		return result, nil
+	}
+
+	p, ok := parseIPValue(input)
+	if !ok {
+		return nil, fmt.Errorf("ipmath: '%v' is not a valid IP address", input)
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("ipmath requires an amount")
+	}
+	amount, ok := ipInt(args[0])
+	if !ok {
+		return nil, fmt.Errorf("ipmath: invalid amount '%v'", args[0])
+	}
+
+	n := ipToInt(p.Addr())
+	n.Add(n, big.NewInt(amount))
+	if n.Sign() < 0 || n.Cmp(ipMaxInt(p.Addr())) > 0 {
+		return nil, fmt.Errorf("ipmath: %v plus %d is out of range", input, amount)
+	}
+	return ipFromInt(n, p.Addr().Is4()).String(), nil
+}
+
+// nthhostFilter implements nthhost(n), the nth address of a network;
+// negative values count back from the last address
+func nthhostFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	if items, ok := ipList(input); ok {
+		result := make([]interface{}, 0, len(items))
+		for _, item := range items {
+			value, err := nthhostFilter(item, args...)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, value)
+		}
+		return result, nil
+	}
+
+	p, ok := parseIPValue(input)
+	if !ok {
+		return false, nil
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("nthhost requires an index")
+	}
+	n, ok := ipInt(args[0])
+	if !ok {
+		return nil, fmt.Errorf("nthhost: invalid index '%v'", args[0])
+	}
+
+	addr, ok := ipNth(p, big.NewInt(n))
+	if !ok {
+		return false, nil
+	}
+	return addr.String(), nil
+}
+
+// networkInUsableFilter implements network_in_usable(test): whether test
+// lies within the usable host addresses of the network
+func networkInUsableFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	network, test, err := ipPair("network_in_usable", input, args)
+	if err != nil {
+		return nil, err
+	}
+	if network.Addr().Is4() != test.Addr().Is4() {
+		return false, nil
+	}
+
+	first, last := ipToInt(network.Masked().Addr()), ipToInt(ipLast(network))
+	if ipHostBits(network) > 1 {
+		first.Add(first, big.NewInt(1))
+		last.Sub(last, big.NewInt(1))
+	}
+	return ipRangeContains(first, last, test), nil
+}
+
+// networkInNetworkFilter implements network_in_network(test): whether the
+// address or network test lies within the network
+func networkInNetworkFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	network, test, err := ipPair("network_in_network", input, args)
+	if err != nil {
+		return nil, err
+	}
+	if network.Addr().Is4() != test.Addr().Is4() {
+		return false, nil
+	}
+	return ipRangeContains(ipToInt(network.Masked().Addr()), ipToInt(ipLast(network)), test), nil
+}
+
+// cidrMergeFilter implements cidr_merge(action). "merge" (the default)
+// returns the fewest networks covering the given ones; "span" returns the
+// smallest single network that contains them all.
+func cidrMergeFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	action := "merge"
+	if len(args) > 0 {
+		action = fmt.Sprintf("%v", args[0])
+	}
+
+	items, ok := ipList(input)
+	if !ok {
+		return nil, fmt.Errorf("cidr_merge: a list is required")
+	}
+
+	type ipRange struct {
+		first, last *big.Int
+	}
+	ranges := map[bool][]ipRange{}
+	for _, item := range items {
+		p, ok := parseIPValue(item)
+		if !ok {
+			return nil, fmt.Errorf("cidr_merge: '%v' is not a valid network", item)
+		}
+		is4 := p.Addr().Is4()
+		ranges[is4] = append(ranges[is4], ipRange{ipToInt(p.Masked().Addr()), ipToInt(ipLast(p))})
+	}
+
+	switch action {
+	case "merge":
+		result := []interface{}{}
+		for _, is4 := range []bool{true, false} {
+			family := ranges[is4]
+			sort.Slice(family, func(i, j int) bool { return family[i].first.Cmp(family[j].first) < 0 })
+
+			var merged []ipRange
+			for _, r := range family {
+				if n := len(merged); n > 0 {
+					next := new(big.Int).Add(merged[n-1].last, big.NewInt(1))
+					if r.first.Cmp(next) <= 0 {
+						if r.last.Cmp(merged[n-1].last) > 0 {
+							merged[n-1].last = r.last
+						}
+						continue
+					}
+				}
+				merged = append(merged, r)
+			}
+
+			for _, r := range merged {
+				for _, p := range ipRangeToPrefixes(r.first, r.last, is4) {
+					result = append(result, p.String())
+				}
+			}
+		}
+		return result, nil
+
+	case "span":
+		if len(ranges[true]) > 0 && len(ranges[false]) > 0 {
+			return nil, fmt.Errorf("cidr_merge: cannot span IPv4 and IPv6 networks")
+		}
+		if len(items) == 0 {
+			return false, nil
+		}
+		is4 := len(ranges[true]) > 0
+		family := ranges[is4]
+		first, last := family[0].first, family[0].last
+		for _, r := range family[1:] {
+			if r.first.Cmp(first) < 0 {
+				first = r.first
+			}
+			if r.last.Cmp(last) > 0 {
+				last = r.last
+			}
+		}
+
+		// The span keeps the bits first and last have in common
+		addr := ipFromInt(first, is4)
+		bits := addr.BitLen()
+		for diff := new(big.Int).Xor(first, last); diff.Sign() > 0; diff.Rsh(diff, 1) {
+			bits--
+		}
+		return netip.PrefixFrom(addr, bits).Masked().String(), nil
+
+	default:
+		return nil, fmt.Errorf("cidr_merge: unknown action '%s'", action)
+	}
+}
+
+// parseIPValue parses an address or network. The address keeps its host
+// bits; a plain address gets a full-length prefix.
+func parseIPValue(input interface{}) (netip.Prefix, bool) {
+	s, ok := input.(string)
+	if !ok {
+		return netip.Prefix{}, false
+	}
+	s = strings.TrimSpace(s)
+
+	addrPart, prefixPart, hasPrefix := strings.Cut(s, "/")
+	addr, err := netip.ParseAddr(addrPart)
+	if err != nil || addr.Zone() != "" {
+		return netip.Prefix{}, false
+	}
+	if !hasPrefix {
+		return netip.PrefixFrom(addr, addr.BitLen()), true
+	}
+
+	bits, err := strconv.Atoi(prefixPart)
+	if err != nil {
+		// A netmask such as 255.255.255.0 instead of a length
+		mask, err := netip.ParseAddr(prefixPart)
+		if err != nil || mask.BitLen() != addr.BitLen() {
+			return netip.Prefix{}, false
+		}
+		bits = ipMaskBits(mask)
+		if bits < 0 {
+			return netip.Prefix{}, false
+		}
+	}
+
+	p := netip.PrefixFrom(addr, bits)
+	return p, p.IsValid()
+}
+
+// ipMaskBits returns the prefix length of a netmask, or -1 if the mask's
+// ones are not contiguous
+func ipMaskBits(mask netip.Addr) int {
+	n := ipToInt(mask)
+	width := mask.BitLen()
+	bits := width - int(n.TrailingZeroBits())
+	if n.Sign() == 0 {
+		bits = 0
+	}
+	want := ipToInt(ipNetmask(netip.PrefixFrom(mask, bits)))
+	if want.Cmp(n) != 0 {
+		return -1
+	}
+	return bits
+}
+
+// ipString formats a value the way it was given
+func ipString(input interface{}, p netip.Prefix) string {
+	if strings.Contains(fmt.Sprintf("%v", input), "/") {
+		return p.String()
+	}
+	return p.Addr().String()
+}
+
+func ipHostBits(p netip.Prefix) int {
+	return p.Addr().BitLen() - p.Bits()
+}
+
+func ipSize(p netip.Prefix) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(ipHostBits(p)))
+}
+
+func ipNetmask(p netip.Prefix) netip.Addr {
+	mask := new(big.Int).Lsh(big.NewInt(1), uint(p.Bits()))
+	mask.Sub(mask, big.NewInt(1))
+	mask.Lsh(mask, uint(ipHostBits(p)))
+	return ipFromInt(mask, p.Addr().Is4())
+}
+
+// ipLast returns the last address of a network
+func ipLast(p netip.Prefix) netip.Addr {
+	n := ipToInt(p.Masked().Addr())
+	n.Add(n, ipSize(p))
+	n.Sub(n, big.NewInt(1))
+	return ipFromInt(n, p.Addr().Is4())
+}
+
+// ipNth returns the nth address of a network, counting back from the last
+// address for negative n
+func ipNth(p netip.Prefix, n *big.Int) (netip.Addr, bool) {
+	size := ipSize(p)
+	if n.Sign() < 0 {
+		n = new(big.Int).Add(n, size)
+	}
+	if n.Sign() < 0 || n.Cmp(size) >= 0 {
+		return netip.Addr{}, false
+	}
+	start := ipToInt(p.Masked().Addr())
+	return ipFromInt(start.Add(start, n), p.Addr().Is4()), true
+}
+
+func ipToInt(addr netip.Addr) *big.Int {
+	if addr.Is4() {
+		b := addr.As4()
+		return new(big.Int).SetBytes(b[:])
+	}
+	b := addr.As16()
+	return new(big.Int).SetBytes(b[:])
+}
+
+func ipFromInt(n *big.Int, is4 bool) netip.Addr {
+	if is4 {
+		var b [4]byte
+		n.FillBytes(b[:])
+		return netip.AddrFrom4(b)
+	}
+	var b [16]byte
+	n.FillBytes(b[:])
+	return netip.AddrFrom16(b)
+}
+
+func ipMaxInt(addr netip.Addr) *big.Int {
+	n := new(big.Int).Lsh(big.NewInt(1), uint(addr.BitLen()))
+	return n.Sub(n, big.NewInt(1))
+}
+
+// ipRangeToPrefixes splits an address range into the fewest networks
+func ipRangeToPrefixes(first, last *big.Int, is4 bool) []netip.Prefix {
+	width := 128
+	if is4 {
+		width = 32
+	}
+
+	var result []netip.Prefix
+	start := new(big.Int).Set(first)
+	for start.Cmp(last) <= 0 {
+		host := width
+		if start.Sign() != 0 {
+			host = int(start.TrailingZeroBits())
+		}
+		for host > 0 {
+			end := new(big.Int).Lsh(big.NewInt(1), uint(host))
+			end.Add(end, start)
+			end.Sub(end, big.NewInt(1))
+			if end.Cmp(last) <= 0 {
+				break
+			}
+			host--
+		}
+
+		result = append(result, netip.PrefixFrom(ipFromInt(start, is4), width-host))
+		start.Add(start, new(big.Int).Lsh(big.NewInt(1), uint(host)))
+	}
+	return result
+}
+
+// ipRangeContains reports whether the addresses of test lie in [first, last]
+func ipRangeContains(first, last *big.Int, test netip.Prefix) bool {
+	return ipToInt(test.Masked().Addr()).Cmp(first) >= 0 && ipToInt(ipLast(test)).Cmp(last) <= 0
+}
+
+// ipPair parses the network and test arguments of the network_in_* filters
+func ipPair(name string, input interface{}, args []interface{}) (netip.Prefix, netip.Prefix, error) {
+	if len(args) == 0 {
+		return netip.Prefix{}, netip.Prefix{}, fmt.Errorf("%s requires an address to test", name)
+	}
+	network, ok := parseIPValue(input)
+	if !ok {
+		return netip.Prefix{}, netip.Prefix{}, fmt.Errorf("%s: '%v' is not a valid network", name, input)
+	}
+	test, ok := parseIPValue(args[0])
+	if !ok {
+		return netip.Prefix{}, netip.Prefix{}, fmt.Errorf("%s: '%v' is not a valid address", name, args[0])
+	}
+	return network, test, nil
+}
+
+// ipList returns the items of a list value
+func ipList(input interface{}) ([]interface{}, bool) {
+	switch v := input.(type) {
+	case []interface{}:
+		return v, true
+	case []string:
+		items := make([]interface{}, len(v))
+		for i, s := range v {
+			items[i] = s
+		}
+		return items, true
+	}
+	return nil, false
+}
+
+// ipInt converts an integer argument, including numbers given as strings
+func ipInt(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int64:
+		return n, true
+	case float64:
+		if n == float64(int64(n)) {
+			return int64(n), true
+		}
+	case string:
+		if i, err := strconv.ParseInt(n, 10, 64); err == nil {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// ipNumber returns a count as an int when it fits
+func ipNumber(n *big.Int) interface{} {
+	if n.IsInt64() {
+		return int(n.Int64())
+	}
+	return n
+}
@@ -0,0 +1,104 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIPAddrFilters(t *testing.T) {
+	filters := NewIPAddrFiltersPlugin().GetFilters()
+
+	tests := []struct {
+		filter   string
+		input    interface{}
+		args     []interface{}
+		expected interface{}
+	}{
+		{"ipaddr", "192.168.0.5", nil, "192.168.0.5"},
+		{"ipaddr", "192.168.0.300", nil, false},
+		{"ipaddr", "192.168.0.5/24", []interface{}{"address"}, "192.168.0.5"},
+		{"ipaddr", "192.168.0.0/24", []interface{}{"address"}, false},
+		{"ipaddr", "192.168.0.5/24", []interface{}{"network"}, "192.168.0.0"},
+		{"ipaddr", "192.168.0.5/255.255.255.0", []interface{}{"prefix"}, 24},
+		{"ipaddr", "192.168.0.5/20", []interface{}{"netmask"}, "255.255.240.0"},
+		{"ipaddr", "192.168.0.5/24", []interface{}{"broadcast"}, "192.168.0.255"},
+		{"ipaddr", "192.168.0.5/32", []interface{}{"broadcast"}, false},
+		{"ipaddr", "192.168.0.5/24", []interface{}{"cidr"}, "192.168.0.0/24"},
+		{"ipaddr", "192.168.0.0/24", []interface{}{5}, "192.168.0.5/24"},
+		{"ipaddr", "192.168.0.0/24", []interface{}{-1}, "192.168.0.255/24"},
+		{"ipaddr", "192.168.0.0/24", []interface{}{256}, false},
+		{"ipaddr", "2001:db8::1/64", []interface{}{"network"}, "2001:db8::"},
+		{"ipaddr", "2001:db8::1/64", []interface{}{"netmask"}, "ffff:ffff:ffff:ffff::"},
+		{"ipaddr", []interface{}{"10.0.0.1", "bogus", "2001:db8::/32"}, nil, []interface{}{"10.0.0.1", "2001:db8::/32"}},
+		{"ipaddr", []interface{}{"10.0.0.1/8", "10.0.0.0/8"}, []interface{}{"address"}, []interface{}{"10.0.0.1"}},
+		{"ipv4", []interface{}{"10.0.0.1", "2001:db8::1"}, nil, []interface{}{"10.0.0.1"}},
+		{"ipv6", "10.0.0.1", nil, false},
+		{"ipv6", "2001:db8::5/64", []interface{}{"prefix"}, 64},
+		{"ipsubnet", "192.168.0.5/16", nil, "192.168.0.0/16"},
+		{"ipsubnet", "192.168.0.0/16", []interface{}{20}, 16},
+		{"ipsubnet", "192.168.0.0/16", []interface{}{20, 0}, "192.168.0.0/20"},
+		{"ipsubnet", "192.168.0.0/16", []interface{}{20, -1}, "192.168.240.0/20"},
+		{"ipsubnet", "192.168.144.5/24", []interface{}{18}, "192.168.128.0/18"},
+		{"ipmath", "192.168.1.5", []interface{}{5}, "192.168.1.10"},
+		{"ipmath", "192.168.1.5/24", []interface{}{-10}, "192.168.0.251"},
+		{"ipmath", "2001:db8::ff", []interface{}{1}, "2001:db8::100"},
+		{"ipmath", []interface{}{"10.0.0.1", "10.0.0.2"}, []interface{}{"1"}, []interface{}{"10.0.0.2", "10.0.0.3"}},
+		{"nthhost", "10.0.0.0/8", []interface{}{305}, "10.0.1.49"},
+		{"nthhost", "10.0.0.0/30", []interface{}{-1}, "10.0.0.3"},
+		{"nthhost", "10.0.0.0/30", []interface{}{4}, false},
+		{"network_in_usable", "192.168.0.0/24", []interface{}{"192.168.0.1"}, true},
+		{"network_in_usable", "192.168.0.0/24", []interface{}{"192.168.0.255"}, false},
+		{"network_in_usable", "192.168.0.0/24", []interface{}{"2001:db8::1"}, false},
+		{"network_in_network", "192.168.0.0/16", []interface{}{"192.168.4.0/24"}, true},
+		{"network_in_network", "192.168.0.0/24", []interface{}{"192.168.0.0/16"}, false},
+		{"cidr_merge", []interface{}{"192.168.0.0/24", "192.168.1.0/24", "192.168.1.128/25", "10.0.0.5", "2001:db8::/32"}, nil,
+			[]interface{}{"10.0.0.5/32", "192.168.0.0/23", "2001:db8::/32"}},
+		{"cidr_merge", []interface{}{"192.168.0.0/24", "192.168.1.0/25", "192.168.1.128/26"}, nil,
+			[]interface{}{"192.168.0.0/24", "192.168.1.0/25", "192.168.1.128/26"}},
+		{"cidr_merge", []interface{}{"192.168.1.0/24", "192.168.2.0/24"}, []interface{}{"span"}, "192.168.0.0/22"},
+	}
+
+	for _, test := range tests {
+		result, err := filters[test.filter](test.input, test.args...)
+		if err != nil {
+			t.Errorf("%s(%v, %v) failed: %v", test.filter, test.input, test.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("%s(%v, %v): expected %#v, got %#v", test.filter, test.input, test.args, test.expected, result)
+		}
+	}
+
+	failures := []struct {
+		filter string
+		input  interface{}
+		args   []interface{}
+	}{
+		{"ipaddr", "10.0.0.1", []interface{}{"bogus"}},
+		{"ipmath", "255.255.255.255", []interface{}{1}},
+		{"ipsubnet", "10.0.0.0/8", []interface{}{33}},
+		{"cidr_merge", []interface{}{"10.0.0.0/8", "2001:db8::/32"}, []interface{}{"span"}},
+	}
+
+	for _, test := range failures {
+		if _, err := filters[test.filter](test.input, test.args...); err == nil {
+			t.Errorf("%s(%v, %v): expected an error", test.filter, test.input, test.args)
+		}
+	}
+}
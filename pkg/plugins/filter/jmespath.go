@@ -0,0 +1,1414 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// This file implements JMESPath (https://jmespath.org/specification.html)
+// for the json_query filter: a lexer, a Pratt parser producing jpNode
+// trees and an interpreter over the generic values templates work with.
+
+// jpTokenType identifies a JMESPath token
+type jpTokenType int
+
+const (
+	jpEOF jpTokenType = iota
+	jpIdentifier
+	jpQuotedIdentifier
+	jpNumber
+	jpLiteral
+	jpRawString
+	jpDot
+	jpStar
+	jpFlatten
+	jpFilter
+	jpLBracket
+	jpRBracket
+	jpLBrace
+	jpRBrace
+	jpLParen
+	jpRParen
+	jpComma
+	jpColon
+	jpPipe
+	jpOr
+	jpAnd
+	jpNot
+	jpCurrent
+	jpExpref
+	jpEQ
+	jpNE
+	jpLT
+	jpLTE
+	jpGT
+	jpGTE
+)
+
+// jpBindingPowers drives the Pratt parser: a token binds to the
+// expression on its left when its power exceeds the current one
+var jpBindingPowers = map[jpTokenType]int{
+	jpPipe:     1,
+	jpOr:       2,
+	jpAnd:      3,
+	jpEQ:       5,
+	jpNE:       5,
+	jpLT:       5,
+	jpLTE:      5,
+	jpGT:       5,
+	jpGTE:      5,
+	jpFlatten:  9,
+	jpStar:     20,
+	jpFilter:   21,
+	jpDot:      40,
+	jpNot:      45,
+	jpLBrace:   50,
+	jpLBracket: 55,
+	jpLParen:   60,
+}
+
+type jpToken struct {
+	kind  jpTokenType
+	value string
+	pos   int
+}
+
+// jpLex splits an expression into tokens
+func jpLex(expr string) ([]jpToken, error) {
+	var tokens []jpToken
+	runes := []rune(expr)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		start := i
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+
+		case r == '_' || unicode.IsLetter(r):
+			for i < len(runes) && (runes[i] == '_' || unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])) {
+				i++
+			}
+			tokens = append(tokens, jpToken{jpIdentifier, string(runes[start:i]), start})
+			continue
+
+		case r == '-' || unicode.IsDigit(r):
+			i++
+			for i < len(runes) && unicode.IsDigit(runes[i]) {
+				i++
+			}
+			if string(runes[start:i]) == "-" {
+				return nil, fmt.Errorf("invalid number at position %d", start)
+			}
+			tokens = append(tokens, jpToken{jpNumber, string(runes[start:i]), start})
+			continue
+
+		case r == '"':
+			end, err := jpDelimited(runes, i, '"')
+			if err != nil {
+				return nil, err
+			}
+			var value string
+			if err := json.Unmarshal([]byte(string(runes[i:end+1])), &value); err != nil {
+				return nil, fmt.Errorf("invalid quoted identifier at position %d", start)
+			}
+			tokens = append(tokens, jpToken{jpQuotedIdentifier, value, start})
+			i = end + 1
+			continue
+
+		case r == '\'':
+			end, err := jpDelimited(runes, i, '\'')
+			if err != nil {
+				return nil, err
+			}
+			value := strings.ReplaceAll(string(runes[i+1:end]), `\'`, `'`)
+			tokens = append(tokens, jpToken{jpRawString, value, start})
+			i = end + 1
+			continue
+
+		case r == '`':
+			end, err := jpDelimited(runes, i, '`')
+			if err != nil {
+				return nil, err
+			}
+			value := strings.ReplaceAll(string(runes[i+1:end]), "\\`", "`")
+			tokens = append(tokens, jpToken{jpLiteral, value, start})
+			i = end + 1
+			continue
+		}
+
+		next := rune(0)
+		if i+1 < len(runes) {
+			next = runes[i+1]
+		}
+
+		kind, width := jpEOF, 1
+		switch r {
+		case '.':
+			kind = jpDot
+		case '*':
+			kind = jpStar
+		case ',':
+			kind = jpComma
+		case ':':
+			kind = jpColon
+		case '{':
+			kind = jpLBrace
+		case '}':
+			kind = jpRBrace
+		case ']':
+			kind = jpRBracket
+		case '(':
+			kind = jpLParen
+		case ')':
+			kind = jpRParen
+		case '@':
+			kind = jpCurrent
+		case '[':
+			switch next {
+			case ']':
+				kind, width = jpFlatten, 2
+			case '?':
+				kind, width = jpFilter, 2
+			default:
+				kind = jpLBracket
+			}
+		case '|':
+			kind = jpPipe
+			if next == '|' {
+				kind, width = jpOr, 2
+			}
+		case '&':
+			kind = jpExpref
+			if next == '&' {
+				kind, width = jpAnd, 2
+			}
+		case '!':
+			kind = jpNot
+			if next == '=' {
+				kind, width = jpNE, 2
+			}
+		case '=':
+			if next != '=' {
+				return nil, fmt.Errorf("unexpected '=' at position %d, use '=='", i)
+			}
+			kind, width = jpEQ, 2
+		case '<':
+			kind = jpLT
+			if next == '=' {
+				kind, width = jpLTE, 2
+			}
+		case '>':
+			kind = jpGT
+			if next == '=' {
+				kind, width = jpGTE, 2
+			}
+		default:
+			return nil, fmt.Errorf("unexpected character '%c' at position %d", r, i)
+		}
+
+		tokens = append(tokens, jpToken{kind, string(runes[i : i+width]), start})
+		i += width
+	}
+
+	return append(tokens, jpToken{jpEOF, "", len(runes)}), nil
+}
+
+// jpDelimited returns the index of the delimiter closing the token that
+// starts at start, skipping backslash escapes
+func jpDelimited(runes []rune, start int, delim rune) (int, error) {
+	for i := start + 1; i < len(runes); i++ {
+		switch runes[i] {
+		case '\\':
+			i++
+		case delim:
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unterminated %c at position %d", delim, start)
+}
+
+// jpNodeType identifies a JMESPath syntax tree node
+type jpNodeType int
+
+const (
+	jpNodeField jpNodeType = iota
+	jpNodeSubexpression
+	jpNodeIndex
+	jpNodeSlice
+	jpNodeIndexExpression
+	jpNodeProjection
+	jpNodeValueProjection
+	jpNodeFilterProjection
+	jpNodeFlatten
+	jpNodeComparator
+	jpNodeOr
+	jpNodeAnd
+	jpNodeNot
+	jpNodeIdentity
+	jpNodeLiteral
+	jpNodeMultiSelectList
+	jpNodeMultiSelectHash
+	jpNodePipe
+	jpNodeFunction
+	jpNodeExpref
+)
+
+type jpNode struct {
+	kind     jpNodeType
+	value    interface{}
+	children []*jpNode
+}
+
+// jpKeyValue is a multi-select hash entry
+type jpKeyValue struct {
+	key  string
+	node *jpNode
+}
+
+type jpParser struct {
+	tokens []jpToken
+	pos    int
+	expr   string
+}
+
+// jpCompile parses a JMESPath expression
+func jpCompile(expr string) (*jpNode, error) {
+	tokens, err := jpLex(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &jpParser{tokens: tokens, expr: expr}
+	node, err := p.parseExpression(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.current().kind != jpEOF {
+		return nil, p.unexpected()
+	}
+	return node, nil
+}
+
+func (p *jpParser) current() jpToken {
+	return p.tokens[p.pos]
+}
+
+func (p *jpParser) lookahead(n int) jpToken {
+	if p.pos+n < len(p.tokens) {
+		return p.tokens[p.pos+n]
+	}
+	return p.tokens[len(p.tokens)-1]
+}
+
+func (p *jpParser) advance() jpToken {
+	tok := p.tokens[p.pos]
+	if p.pos < len(p.tokens)-1 {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *jpParser) expect(kind jpTokenType) error {
+	if p.current().kind != kind {
+		return p.unexpected()
+	}
+	p.advance()
+	return nil
+}
+
+func (p *jpParser) unexpected() error {
+	tok := p.current()
+	if tok.kind == jpEOF {
+		return fmt.Errorf("unexpected end of expression")
+	}
+	return fmt.Errorf("unexpected token '%s' at position %d", tok.value, tok.pos)
+}
+
+func (p *jpParser) parseExpression(bindingPower int) (*jpNode, error) {
+	left, err := p.nud(p.advance())
+	if err != nil {
+		return nil, err
+	}
+	for bindingPower < jpBindingPowers[p.current().kind] {
+		left, err = p.led(p.advance(), left)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return left, nil
+}
+
+// nud parses a token that starts an expression
+func (p *jpParser) nud(tok jpToken) (*jpNode, error) {
+	identity := &jpNode{kind: jpNodeIdentity}
+
+	switch tok.kind {
+	case jpIdentifier:
+		return &jpNode{kind: jpNodeField, value: tok.value}, nil
+
+	case jpQuotedIdentifier:
+		if p.current().kind == jpLParen {
+			return nil, fmt.Errorf("quoted identifier cannot be a function name at position %d", tok.pos)
+		}
+		return &jpNode{kind: jpNodeField, value: tok.value}, nil
+
+	case jpRawString:
+		return &jpNode{kind: jpNodeLiteral, value: tok.value}, nil
+
+	case jpLiteral:
+		var value interface{}
+		if err := json.Unmarshal([]byte(tok.value), &value); err != nil {
+			return nil, fmt.Errorf("invalid literal `%s` at position %d", tok.value, tok.pos)
+		}
+		return &jpNode{kind: jpNodeLiteral, value: jpNormalize(value)}, nil
+
+	case jpStar:
+		right, err := p.parseProjectionRHS(jpBindingPowers[jpStar])
+		if err != nil {
+			return nil, err
+		}
+		return &jpNode{kind: jpNodeValueProjection, children: []*jpNode{identity, right}}, nil
+
+	case jpFilter:
+		return p.parseFilter(identity)
+
+	case jpLBrace:
+		return p.parseMultiSelectHash()
+
+	case jpFlatten:
+		right, err := p.parseProjectionRHS(jpBindingPowers[jpFlatten])
+		if err != nil {
+			return nil, err
+		}
+		flatten := &jpNode{kind: jpNodeFlatten, children: []*jpNode{identity}}
+		return &jpNode{kind: jpNodeProjection, children: []*jpNode{flatten, right}}, nil
+
+	case jpLBracket:
+		switch {
+		case p.current().kind == jpNumber || p.current().kind == jpColon:
+			index, err := p.parseIndex()
+			if err != nil {
+				return nil, err
+			}
+			return p.projectIfSlice(identity, index)
+		case p.current().kind == jpStar && p.lookahead(1).kind == jpRBracket:
+			p.advance()
+			p.advance()
+			right, err := p.parseProjectionRHS(jpBindingPowers[jpStar])
+			if err != nil {
+				return nil, err
+			}
+			return &jpNode{kind: jpNodeProjection, children: []*jpNode{identity, right}}, nil
+		}
+		return p.parseMultiSelectList()
+
+	case jpCurrent:
+		return identity, nil
+
+	case jpExpref:
+		child, err := p.parseExpression(jpBindingPowers[jpExpref])
+		if err != nil {
+			return nil, err
+		}
+		return &jpNode{kind: jpNodeExpref, children: []*jpNode{child}}, nil
+
+	case jpNot:
+		child, err := p.parseExpression(jpBindingPowers[jpNot])
+		if err != nil {
+			return nil, err
+		}
+		return &jpNode{kind: jpNodeNot, children: []*jpNode{child}}, nil
+
+	case jpLParen:
+		child, err := p.parseExpression(0)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(jpRParen); err != nil {
+			return nil, err
+		}
+		return child, nil
+	}
+
+	p.pos--
+	return nil, p.unexpected()
+}
+
+// led parses a token that continues the expression on its left
+func (p *jpParser) led(tok jpToken, left *jpNode) (*jpNode, error) {
+	switch tok.kind {
+	case jpDot:
+		if p.current().kind != jpStar {
+			right, err := p.parseDotRHS(jpBindingPowers[jpDot])
+			if err != nil {
+				return nil, err
+			}
+			return &jpNode{kind: jpNodeSubexpression, children: []*jpNode{left, right}}, nil
+		}
+		p.advance()
+		right, err := p.parseProjectionRHS(jpBindingPowers[jpDot])
+		if err != nil {
+			return nil, err
+		}
+		return &jpNode{kind: jpNodeValueProjection, children: []*jpNode{left, right}}, nil
+
+	case jpPipe, jpOr, jpAnd:
+		right, err := p.parseExpression(jpBindingPowers[tok.kind])
+		if err != nil {
+			return nil, err
+		}
+		kind := map[jpTokenType]jpNodeType{jpPipe: jpNodePipe, jpOr: jpNodeOr, jpAnd: jpNodeAnd}[tok.kind]
+		return &jpNode{kind: kind, children: []*jpNode{left, right}}, nil
+
+	case jpLParen:
+		if left.kind != jpNodeField {
+			return nil, fmt.Errorf("invalid function call at position %d", tok.pos)
+		}
+		var args []*jpNode
+		for p.current().kind != jpRParen {
+			arg, err := p.parseExpression(0)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.current().kind == jpComma {
+				p.advance()
+			} else if p.current().kind != jpRParen {
+				return nil, p.unexpected()
+			}
+		}
+		p.advance()
+		return &jpNode{kind: jpNodeFunction, value: left.value, children: args}, nil
+
+	case jpFilter:
+		return p.parseFilter(left)
+
+	case jpFlatten:
+		right, err := p.parseProjectionRHS(jpBindingPowers[jpFlatten])
+		if err != nil {
+			return nil, err
+		}
+		flatten := &jpNode{kind: jpNodeFlatten, children: []*jpNode{left}}
+		return &jpNode{kind: jpNodeProjection, children: []*jpNode{flatten, right}}, nil
+
+	case jpEQ, jpNE, jpLT, jpLTE, jpGT, jpGTE:
+		right, err := p.parseExpression(jpBindingPowers[tok.kind])
+		if err != nil {
+			return nil, err
+		}
+		return &jpNode{kind: jpNodeComparator, value: tok.kind, children: []*jpNode{left, right}}, nil
+
+	case jpLBracket:
+		switch p.current().kind {
+		case jpNumber, jpColon:
+			index, err := p.parseIndex()
+			if err != nil {
+				return nil, err
+			}
+			return p.projectIfSlice(left, index)
+		case jpStar:
+			p.advance()
+			if err := p.expect(jpRBracket); err != nil {
+				return nil, err
+			}
+			right, err := p.parseProjectionRHS(jpBindingPowers[jpStar])
+			if err != nil {
+				return nil, err
+			}
+			return &jpNode{kind: jpNodeProjection, children: []*jpNode{left, right}}, nil
+		}
+	}
+
+	p.pos--
+	return nil, p.unexpected()
+}
+
+// parseIndex parses "[n]" or "[start:stop:step]" after the bracket
+func (p *jpParser) parseIndex() (*jpNode, error) {
+	var parts [3]*int
+	part := 0
+	for p.current().kind != jpRBracket {
+		switch p.current().kind {
+		case jpColon:
+			part++
+			if part > 2 {
+				return nil, p.unexpected()
+			}
+		case jpNumber:
+			n, err := strconv.Atoi(p.current().value)
+			if err != nil {
+				return nil, err
+			}
+			parts[part] = &n
+		default:
+			return nil, p.unexpected()
+		}
+		p.advance()
+	}
+	p.advance()
+
+	if part == 0 {
+		return &jpNode{kind: jpNodeIndex, value: *parts[0]}, nil
+	}
+	if parts[2] != nil && *parts[2] == 0 {
+		return nil, fmt.Errorf("slice step cannot be 0")
+	}
+	return &jpNode{kind: jpNodeSlice, value: parts}, nil
+}
+
+// projectIfSlice applies an index to left; slices start a projection
+func (p *jpParser) projectIfSlice(left, index *jpNode) (*jpNode, error) {
+	indexExpr := &jpNode{kind: jpNodeIndexExpression, children: []*jpNode{left, index}}
+	if index.kind != jpNodeSlice {
+		return indexExpr, nil
+	}
+	right, err := p.parseProjectionRHS(jpBindingPowers[jpStar])
+	if err != nil {
+		return nil, err
+	}
+	return &jpNode{kind: jpNodeProjection, children: []*jpNode{indexExpr, right}}, nil
+}
+
+func (p *jpParser) parseFilter(left *jpNode) (*jpNode, error) {
+	condition, err := p.parseExpression(0)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(jpRBracket); err != nil {
+		return nil, err
+	}
+
+	right := &jpNode{kind: jpNodeIdentity}
+	if p.current().kind != jpFlatten {
+		right, err = p.parseProjectionRHS(jpBindingPowers[jpFilter])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &jpNode{kind: jpNodeFilterProjection, children: []*jpNode{left, right, condition}}, nil
+}
+
+func (p *jpParser) parseDotRHS(bindingPower int) (*jpNode, error) {
+	switch p.current().kind {
+	case jpIdentifier, jpQuotedIdentifier, jpStar:
+		return p.parseExpression(bindingPower)
+	case jpLBracket:
+		p.advance()
+		return p.parseMultiSelectList()
+	case jpLBrace:
+		p.advance()
+		return p.parseMultiSelectHash()
+	}
+	return nil, p.unexpected()
+}
+
+func (p *jpParser) parseProjectionRHS(bindingPower int) (*jpNode, error) {
+	switch current := p.current().kind; {
+	case jpBindingPowers[current] < 10:
+		return &jpNode{kind: jpNodeIdentity}, nil
+	case current == jpLBracket || current == jpFilter:
+		return p.parseExpression(bindingPower)
+	case current == jpDot:
+		p.advance()
+		return p.parseDotRHS(bindingPower)
+	}
+	return nil, p.unexpected()
+}
+
+func (p *jpParser) parseMultiSelectList() (*jpNode, error) {
+	node := &jpNode{kind: jpNodeMultiSelectList}
+	for {
+		child, err := p.parseExpression(0)
+		if err != nil {
+			return nil, err
+		}
+		node.children = append(node.children, child)
+		if p.current().kind == jpRBracket {
+			p.advance()
+			return node, nil
+		}
+		if err := p.expect(jpComma); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *jpParser) parseMultiSelectHash() (*jpNode, error) {
+	var entries []jpKeyValue
+	for {
+		key := p.current()
+		if key.kind != jpIdentifier && key.kind != jpQuotedIdentifier {
+			return nil, p.unexpected()
+		}
+		p.advance()
+		if err := p.expect(jpColon); err != nil {
+			return nil, err
+		}
+		value, err := p.parseExpression(0)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, jpKeyValue{key.value, value})
+
+		if p.current().kind == jpRBrace {
+			p.advance()
+			return &jpNode{kind: jpNodeMultiSelectHash, value: entries}, nil
+		}
+		if err := p.expect(jpComma); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// jpExprefValue is the value of an expression reference (&expr)
+type jpExprefValue struct {
+	node *jpNode
+}
+
+// jpSearch evaluates a compiled expression against data
+func jpSearch(node *jpNode, data interface{}) (interface{}, error) {
+	switch node.kind {
+	case jpNodeField:
+		if m, ok := data.(map[string]interface{}); ok {
+			return m[node.value.(string)], nil
+		}
+		return nil, nil
+
+	case jpNodeSubexpression, jpNodeIndexExpression:
+		left, err := jpSearch(node.children[0], data)
+		if err != nil || left == nil {
+			return nil, err
+		}
+		return jpSearch(node.children[1], left)
+
+	case jpNodeIndex:
+		list, ok := data.([]interface{})
+		if !ok {
+			return nil, nil
+		}
+		index := node.value.(int)
+		if index < 0 {
+			index += len(list)
+		}
+		if index < 0 || index >= len(list) {
+			return nil, nil
+		}
+		return list[index], nil
+
+	case jpNodeSlice:
+		list, ok := data.([]interface{})
+		if !ok {
+			return nil, nil
+		}
+		return jpSlice(list, node.value.([3]*int)), nil
+
+	case jpNodeProjection:
+		left, err := jpSearch(node.children[0], data)
+		if err != nil {
+			return nil, err
+		}
+		list, ok := left.([]interface{})
+		if !ok {
+			return nil, nil
+		}
+		return jpProject(list, node.children[1])
+
+	case jpNodeValueProjection:
+		left, err := jpSearch(node.children[0], data)
+		if err != nil {
+			return nil, err
+		}
+		m, ok := left.(map[string]interface{})
+		if !ok {
+			return nil, nil
+		}
+		return jpProject(jpValues(m), node.children[1])
+
+	case jpNodeFilterProjection:
+		left, err := jpSearch(node.children[0], data)
+		if err != nil {
+			return nil, err
+		}
+		list, ok := left.([]interface{})
+		if !ok {
+			return nil, nil
+		}
+		var matched []interface{}
+		for _, item := range list {
+			cond, err := jpSearch(node.children[2], item)
+			if err != nil {
+				return nil, err
+			}
+			if jpTruthy(cond) {
+				matched = append(matched, item)
+			}
+		}
+		return jpProject(matched, node.children[1])
+
+	case jpNodeFlatten:
+		left, err := jpSearch(node.children[0], data)
+		if err != nil {
+			return nil, err
+		}
+		list, ok := left.([]interface{})
+		if !ok {
+			return nil, nil
+		}
+		result := []interface{}{}
+		for _, item := range list {
+			if inner, ok := item.([]interface{}); ok {
+				result = append(result, inner...)
+			} else {
+				result = append(result, item)
+			}
+		}
+		return result, nil
+
+	case jpNodeComparator:
+		left, err := jpSearch(node.children[0], data)
+		if err != nil {
+			return nil, err
+		}
+		right, err := jpSearch(node.children[1], data)
+		if err != nil {
+			return nil, err
+		}
+		return jpCompare(node.value.(jpTokenType), left, right), nil
+
+	case jpNodeOr, jpNodeAnd:
+		left, err := jpSearch(node.children[0], data)
+		if err != nil {
+			return nil, err
+		}
+		if jpTruthy(left) == (node.kind == jpNodeOr) {
+			return left, nil
+		}
+		return jpSearch(node.children[1], data)
+
+	case jpNodeNot:
+		value, err := jpSearch(node.children[0], data)
+		if err != nil {
+			return nil, err
+		}
+		return !jpTruthy(value), nil
+
+	case jpNodeIdentity:
+		return data, nil
+
+	case jpNodeLiteral:
+		return node.value, nil
+
+	case jpNodeMultiSelectList:
+		if data == nil {
+			return nil, nil
+		}
+		result := make([]interface{}, 0, len(node.children))
+		for _, child := range node.children {
+			value, err := jpSearch(child, data)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, value)
+		}
+		return result, nil
+
+	case jpNodeMultiSelectHash:
+		if data == nil {
+			return nil, nil
+		}
+		entries := node.value.([]jpKeyValue)
+		result := make(map[string]interface{}, len(entries))
+		for _, entry := range entries {
+			value, err := jpSearch(entry.node, data)
+			if err != nil {
+				return nil, err
+			}
+			result[entry.key] = value
+		}
+		return result, nil
+
+	case jpNodePipe:
+		left, err := jpSearch(node.children[0], data)
+		if err != nil {
+			return nil, err
+		}
+		return jpSearch(node.children[1], left)
+
+	case jpNodeFunction:
+		args := make([]interface{}, len(node.children))
+		for i, child := range node.children {
+			if child.kind == jpNodeExpref {
+				args[i] = jpExprefValue{child.children[0]}
+				continue
+			}
+			value, err := jpSearch(child, data)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = value
+		}
+		return jpCall(node.value.(string), args)
+
+	case jpNodeExpref:
+		return jpExprefValue{node.children[0]}, nil
+	}
+
+	return nil, fmt.Errorf("unknown expression node %d", node.kind)
+}
+
+// jpProject applies an expression to each item, dropping null results
+func jpProject(items []interface{}, node *jpNode) (interface{}, error) {
+	result := []interface{}{}
+	for _, item := range items {
+		value, err := jpSearch(node, item)
+		if err != nil {
+			return nil, err
+		}
+		if value != nil {
+			result = append(result, value)
+		}
+	}
+	return result, nil
+}
+
+// jpSlice implements Python-style slicing
+func jpSlice(list []interface{}, parts [3]*int) []interface{} {
+	step := 1
+	if parts[2] != nil {
+		step = *parts[2]
+	}
+	length := len(list)
+
+	bound := func(v *int, def int) int {
+		if v == nil {
+			return def
+		}
+		n := *v
+		if n < 0 {
+			n += length
+			if n < 0 {
+				if step < 0 {
+					return -1
+				}
+				return 0
+			}
+		} else if n >= length {
+			if step < 0 {
+				return length - 1
+			}
+			return length
+		}
+		return n
+	}
+
+	result := []interface{}{}
+	if step > 0 {
+		for i := bound(parts[0], 0); i < bound(parts[1], length); i += step {
+			result = append(result, list[i])
+		}
+	} else {
+		for i := bound(parts[0], length-1); i > bound(parts[1], -1); i += step {
+			result = append(result, list[i])
+		}
+	}
+	return result
+}
+
+// jpValues returns the values of an object in key order
+func jpValues(m map[string]interface{}) []interface{} {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		values[i] = m[key]
+	}
+	return values
+}
+
+// jpTruthy reports whether a value is true: everything but null, false
+// and empty strings, arrays and objects
+func jpTruthy(v interface{}) bool {
+	switch val := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return val
+	case string:
+		return val != ""
+	case []interface{}:
+		return len(val) > 0
+	case map[string]interface{}:
+		return len(val) > 0
+	}
+	return true
+}
+
+func jpCompare(op jpTokenType, left, right interface{}) interface{} {
+	switch op {
+	case jpEQ:
+		return jpEqual(left, right)
+	case jpNE:
+		return !jpEqual(left, right)
+	}
+
+	a, ok1 := jpNumberValue(left)
+	b, ok2 := jpNumberValue(right)
+	if !ok1 || !ok2 {
+		return nil
+	}
+	switch op {
+	case jpLT:
+		return a < b
+	case jpLTE:
+		return a <= b
+	case jpGT:
+		return a > b
+	default:
+		return a >= b
+	}
+}
+
+func jpEqual(a, b interface{}) bool {
+	if x, ok := jpNumberValue(a); ok {
+		y, ok := jpNumberValue(b)
+		return ok && x == y
+	}
+	switch va := a.(type) {
+	case []interface{}:
+		vb, ok := b.([]interface{})
+		if !ok || len(va) != len(vb) {
+			return false
+		}
+		for i := range va {
+			if !jpEqual(va[i], vb[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		vb, ok := b.(map[string]interface{})
+		if !ok || len(va) != len(vb) {
+			return false
+		}
+		for key, value := range va {
+			other, ok := vb[key]
+			if !ok || !jpEqual(value, other) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+func jpNumberValue(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// jpNumeric returns integral results as ints
+func jpNumeric(f float64) interface{} {
+	if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+		return int(f)
+	}
+	return f
+}
+
+// jpNormalize converts data to the types the interpreter works on: maps
+// with string keys, []interface{} and ints for integral JSON numbers
+func jpNormalize(v interface{}) interface{} {
+	switch val := v.(type) {
+	case nil, bool, string, int, int64:
+		return v
+	case float64:
+		return jpNumeric(val)
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(val))
+		for key, item := range val {
+			result[key] = jpNormalize(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(val))
+		for i, item := range val {
+			result[i] = jpNormalize(item)
+		}
+		return result
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		result := make([]interface{}, rv.Len())
+		for i := range result {
+			result[i] = jpNormalize(rv.Index(i).Interface())
+		}
+		return result
+	case reflect.Map:
+		result := make(map[string]interface{}, rv.Len())
+		for _, key := range rv.MapKeys() {
+			result[fmt.Sprintf("%v", key.Interface())] = jpNormalize(rv.MapIndex(key).Interface())
+		}
+		return result
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return int(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int(rv.Uint())
+	case reflect.Float32:
+		return jpNumeric(rv.Float())
+	}
+	return v
+}
+
+// jpType returns the JMESPath type name of a value
+func jpType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case int, int64, float64:
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	case jpExprefValue:
+		return "expref"
+	}
+	return "unknown"
+}
+
+// jpCall runs a built-in function
+func jpCall(name string, args []interface{}) (interface{}, error) {
+	fn, ok := jpFunctions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function: %s()", name)
+	}
+	if len(args) < len(fn.types) || (!fn.variadic && len(args) > len(fn.types)) {
+		return nil, fmt.Errorf("invalid number of arguments for %s()", name)
+	}
+	for i, arg := range args {
+		allowed := fn.types[min(i, len(fn.types)-1)]
+		if allowed != "any" && !strings.Contains(" "+allowed+" ", " "+jpType(arg)+" ") {
+			return nil, fmt.Errorf("invalid type for argument %d of %s(): expected %s, got %s", i+1, name, allowed, jpType(arg))
+		}
+	}
+	return fn.call(args)
+}
+
+// jpFunction describes a built-in function: the accepted types of each
+// argument, separated by spaces, and whether the last one repeats
+type jpFunction struct {
+	types    []string
+	variadic bool
+	call     func(args []interface{}) (interface{}, error)
+}
+
+var jpFunctions map[string]jpFunction
+
+func init() {
+	jpFunctions = map[string]jpFunction{
+		"abs": {types: []string{"number"}, call: func(args []interface{}) (interface{}, error) {
+			n, _ := jpNumberValue(args[0])
+			return jpNumeric(math.Abs(n)), nil
+		}},
+		"avg": {types: []string{"array"}, call: func(args []interface{}) (interface{}, error) {
+			list := args[0].([]interface{})
+			if len(list) == 0 {
+				return nil, nil
+			}
+			sum, err := jpSum("avg", list)
+			if err != nil {
+				return nil, err
+			}
+			return sum / float64(len(list)), nil
+		}},
+		"ceil": {types: []string{"number"}, call: func(args []interface{}) (interface{}, error) {
+			n, _ := jpNumberValue(args[0])
+			return jpNumeric(math.Ceil(n)), nil
+		}},
+		"contains": {types: []string{"array string", "any"}, call: func(args []interface{}) (interface{}, error) {
+			if s, ok := args[0].(string); ok {
+				sub, ok := args[1].(string)
+				return ok && strings.Contains(s, sub), nil
+			}
+			for _, item := range args[0].([]interface{}) {
+				if jpEqual(item, args[1]) {
+					return true, nil
+				}
+			}
+			return false, nil
+		}},
+		"ends_with": {types: []string{"string", "string"}, call: func(args []interface{}) (interface{}, error) {
+			return strings.HasSuffix(args[0].(string), args[1].(string)), nil
+		}},
+		"floor": {types: []string{"number"}, call: func(args []interface{}) (interface{}, error) {
+			n, _ := jpNumberValue(args[0])
+			return jpNumeric(math.Floor(n)), nil
+		}},
+		"join": {types: []string{"string", "array"}, call: func(args []interface{}) (interface{}, error) {
+			var parts []string
+			for _, item := range args[1].([]interface{}) {
+				s, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("join() requires an array of strings")
+				}
+				parts = append(parts, s)
+			}
+			return strings.Join(parts, args[0].(string)), nil
+		}},
+		"keys": {types: []string{"object"}, call: func(args []interface{}) (interface{}, error) {
+			m := args[0].(map[string]interface{})
+			keys := make([]string, 0, len(m))
+			for key := range m {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			result := make([]interface{}, len(keys))
+			for i, key := range keys {
+				result[i] = key
+			}
+			return result, nil
+		}},
+		"length": {types: []string{"string array object"}, call: func(args []interface{}) (interface{}, error) {
+			switch v := args[0].(type) {
+			case string:
+				return len([]rune(v)), nil
+			case []interface{}:
+				return len(v), nil
+			default:
+				return len(v.(map[string]interface{})), nil
+			}
+		}},
+		"map": {types: []string{"expref", "array"}, call: func(args []interface{}) (interface{}, error) {
+			expr := args[0].(jpExprefValue)
+			list := args[1].([]interface{})
+			result := make([]interface{}, len(list))
+			for i, item := range list {
+				value, err := jpSearch(expr.node, item)
+				if err != nil {
+					return nil, err
+				}
+				result[i] = value
+			}
+			return result, nil
+		}},
+		"max": {types: []string{"array"}, call: func(args []interface{}) (interface{}, error) {
+			return jpExtreme("max", args[0].([]interface{}), nil, 1)
+		}},
+		"max_by": {types: []string{"array", "expref"}, call: func(args []interface{}) (interface{}, error) {
+			expr := args[1].(jpExprefValue)
+			return jpExtreme("max_by", args[0].([]interface{}), &expr, 1)
+		}},
+		"merge": {types: []string{"object"}, variadic: true, call: func(args []interface{}) (interface{}, error) {
+			result := make(map[string]interface{})
+			for _, arg := range args {
+				for key, value := range arg.(map[string]interface{}) {
+					result[key] = value
+				}
+			}
+			return result, nil
+		}},
+		"min": {types: []string{"array"}, call: func(args []interface{}) (interface{}, error) {
+			return jpExtreme("min", args[0].([]interface{}), nil, -1)
+		}},
+		"min_by": {types: []string{"array", "expref"}, call: func(args []interface{}) (interface{}, error) {
+			expr := args[1].(jpExprefValue)
+			return jpExtreme("min_by", args[0].([]interface{}), &expr, -1)
+		}},
+		"not_null": {types: []string{"any"}, variadic: true, call: func(args []interface{}) (interface{}, error) {
+			for _, arg := range args {
+				if arg != nil {
+					return arg, nil
+				}
+			}
+			return nil, nil
+		}},
+		"reverse": {types: []string{"array string"}, call: func(args []interface{}) (interface{}, error) {
+			if s, ok := args[0].(string); ok {
+				runes := []rune(s)
+				for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+					runes[i], runes[j] = runes[j], runes[i]
+				}
+				return string(runes), nil
+			}
+			list := args[0].([]interface{})
+			result := make([]interface{}, len(list))
+			for i, item := range list {
+				result[len(list)-1-i] = item
+			}
+			return result, nil
+		}},
+		"sort": {types: []string{"array"}, call: func(args []interface{}) (interface{}, error) {
+			return jpSortBy("sort", args[0].([]interface{}), nil)
+		}},
+		"sort_by": {types: []string{"array", "expref"}, call: func(args []interface{}) (interface{}, error) {
+			expr := args[1].(jpExprefValue)
+			return jpSortBy("sort_by", args[0].([]interface{}), &expr)
+		}},
+		"starts_with": {types: []string{"string", "string"}, call: func(args []interface{}) (interface{}, error) {
+			return strings.HasPrefix(args[0].(string), args[1].(string)), nil
+		}},
+		"sum": {types: []string{"array"}, call: func(args []interface{}) (interface{}, error) {
+			sum, err := jpSum("sum", args[0].([]interface{}))
+			if err != nil {
+				return nil, err
+			}
+			return jpNumeric(sum), nil
+		}},
+		"to_array": {types: []string{"any"}, call: func(args []interface{}) (interface{}, error) {
+			if list, ok := args[0].([]interface{}); ok {
+				return list, nil
+			}
+			return []interface{}{args[0]}, nil
+		}},
+		"to_number": {types: []string{"any"}, call: func(args []interface{}) (interface{}, error) {
+			switch v := args[0].(type) {
+			case int, int64, float64:
+				return v, nil
+			case string:
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					return jpNumeric(f), nil
+				}
+			}
+			return nil, nil
+		}},
+		"to_string": {types: []string{"any"}, call: func(args []interface{}) (interface{}, error) {
+			if s, ok := args[0].(string); ok {
+				return s, nil
+			}
+			data, err := json.Marshal(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return string(data), nil
+		}},
+		"type": {types: []string{"any"}, call: func(args []interface{}) (interface{}, error) {
+			return jpType(args[0]), nil
+		}},
+		"values": {types: []string{"object"}, call: func(args []interface{}) (interface{}, error) {
+			return jpValues(args[0].(map[string]interface{})), nil
+		}},
+	}
+}
+
+func jpSum(name string, list []interface{}) (float64, error) {
+	sum := 0.0
+	for _, item := range list {
+		n, ok := jpNumberValue(item)
+		if !ok {
+			return 0, fmt.Errorf("%s() requires an array of numbers", name)
+		}
+		sum += n
+	}
+	return sum, nil
+}
+
+// jpKey evaluates the sort key of an item, which must be a number or a
+// string of the same type as the first key
+func jpKey(name string, item interface{}, expr *jpExprefValue, want string) (interface{}, string, error) {
+	key := item
+	if expr != nil {
+		var err error
+		if key, err = jpSearch(expr.node, item); err != nil {
+			return nil, "", err
+		}
+	}
+	kind := jpType(key)
+	if (kind != "number" && kind != "string") || (want != "" && kind != want) {
+		return nil, "", fmt.Errorf("%s() requires all keys to be numbers or all strings", name)
+	}
+	return key, kind, nil
+}
+
+func jpLess(a, b interface{}) bool {
+	if x, ok := jpNumberValue(a); ok {
+		y, _ := jpNumberValue(b)
+		return x < y
+	}
+	return a.(string) < b.(string)
+}
+
+// jpExtreme implements max, min, max_by and min_by; sign is 1 for max
+func jpExtreme(name string, list []interface{}, expr *jpExprefValue, sign int) (interface{}, error) {
+	if len(list) == 0 {
+		return nil, nil
+	}
+	best := list[0]
+	bestKey, kind, err := jpKey(name, best, expr, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range list[1:] {
+		key, _, err := jpKey(name, item, expr, kind)
+		if err != nil {
+			return nil, err
+		}
+		if (sign > 0 && jpLess(bestKey, key)) || (sign < 0 && jpLess(key, bestKey)) {
+			best, bestKey = item, key
+		}
+	}
+	return best, nil
+}
+
+// jpSortBy implements sort and sort_by with a stable sort
+func jpSortBy(name string, list []interface{}, expr *jpExprefValue) (interface{}, error) {
+	keys := make([]interface{}, len(list))
+	kind := ""
+	for i, item := range list {
+		key, k, err := jpKey(name, item, expr, kind)
+		if err != nil {
+			return nil, err
+		}
+		keys[i], kind = key, k
+	}
+
+	indexes := make([]int, len(list))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(i, j int) bool { return jpLess(keys[indexes[i]], keys[indexes[j]]) })
+
+	result := make([]interface{}, len(list))
+	for i, index := range indexes {
+		result[i] = list[index]
+	}
+	return result, nil
+}
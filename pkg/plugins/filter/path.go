@@ -0,0 +1,157 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filter
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// PathFiltersPlugin implements the path filters with the semantics of
+// Python's os.path, which differ from path/filepath for trailing slashes
+// and extensions
+type PathFiltersPlugin struct {
+	*BaseFilterPlugin
+}
+
+func NewPathFiltersPlugin() *PathFiltersPlugin {
+	return &PathFiltersPlugin{
+		BaseFilterPlugin: NewBaseFilterPlugin(
+			"path",
+			"File path filters",
+			"1.0.0",
+			"Ansible Project",
+		),
+	}
+}
+
+func (p *PathFiltersPlugin) GetFilters() map[string]FilterFunction {
+	return map[string]FilterFunction{
+		"basename":   basenameFilter,
+		"dirname":    dirnameFilter,
+		"expanduser": expanduserFilter,
+		"realpath":   realpathFilter,
+		"relpath":    relpathFilter,
+		"splitext":   splitextFilter,
+	}
+}
+
+// basenameFilter implements basename: everything after the last slash, so
+// "/etc/ssh/" has an empty basename
+func basenameFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	path := fmt.Sprintf("%v", input)
+	return path[strings.LastIndex(path, "/")+1:], nil
+}
+
+// dirnameFilter implements dirname: everything before the last slash,
+// without trailing slashes unless it is the root
+func dirnameFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	path := fmt.Sprintf("%v", input)
+	head := path[:strings.LastIndex(path, "/")+1]
+	if strings.Trim(head, "/") != "" {
+		head = strings.TrimRight(head, "/")
+	}
+	return head, nil
+}
+
+// expanduserFilter implements expanduser, replacing a leading ~ or ~user
+// with the home directory
+func expanduserFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	path := fmt.Sprintf("%v", input)
+	if !strings.HasPrefix(path, "~") {
+		return path, nil
+	}
+
+	name, rest, _ := strings.Cut(path[1:], "/")
+	home := ""
+	if name == "" {
+		home = os.Getenv("HOME")
+		if home == "" {
+			if current, err := user.Current(); err == nil {
+				home = current.HomeDir
+			}
+		}
+	} else if u, err := user.Lookup(name); err == nil {
+		home = u.HomeDir
+	}
+	if home == "" {
+		return path, nil
+	}
+
+	if rest == "" && !strings.Contains(path, "/") {
+		return home, nil
+	}
+	return strings.TrimRight(home, "/") + "/" + rest, nil
+}
+
+// realpathFilter implements realpath: the absolute path with symbolic
+// links resolved as far as the path exists
+func realpathFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	path, err := filepath.Abs(fmt.Sprintf("%v", input))
+	if err != nil {
+		return nil, err
+	}
+	return resolveExisting(path), nil
+}
+
+func resolveExisting(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	parent := filepath.Dir(path)
+	if parent == path {
+		return path
+	}
+	return filepath.Join(resolveExisting(parent), filepath.Base(path))
+}
+
+// relpathFilter implements relpath(start), the path relative to start,
+// which defaults to the current directory
+func relpathFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, kw := SplitKeywords(args)
+	path := fmt.Sprintf("%v", input)
+	if path == "" {
+		return nil, fmt.Errorf("relpath: no path specified")
+	}
+	start := optionalString(argument(args, kw, 0, "start", "."))
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	absStart, err := filepath.Abs(start)
+	if err != nil {
+		return nil, err
+	}
+	return filepath.Rel(absStart, absPath)
+}
+
+// splitextFilter implements splitext, returning [root, extension]. Leading
+// dots of the file name do not start an extension, so ".bashrc" has none.
+func splitextFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	path := fmt.Sprintf("%v", input)
+	nameStart := strings.LastIndex(path, "/") + 1
+	dot := strings.LastIndex(path, ".")
+
+	if dot > nameStart && strings.Trim(path[nameStart:dot], ".") != "" {
+		return []interface{}{path[:dot], path[dot:]}, nil
+	}
+	return []interface{}{path, ""}, nil
+}
@@ -0,0 +1,92 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filter
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPathFilters(t *testing.T) {
+	filters := NewPathFiltersPlugin().GetFilters()
+	t.Setenv("HOME", "/home/tester")
+
+	tests := []struct {
+		filter   string
+		input    interface{}
+		args     []interface{}
+		expected interface{}
+	}{
+		{"basename", "/etc/ssh/sshd_config", nil, "sshd_config"},
+		{"basename", "/etc/ssh/", nil, ""},
+		{"dirname", "/etc/ssh/sshd_config", nil, "/etc/ssh"},
+		{"dirname", "/etc", nil, "/"},
+		{"dirname", "file", nil, ""},
+		{"dirname", "a//b", nil, "a"},
+		{"expanduser", "~/.ssh", nil, "/home/tester/.ssh"},
+		{"expanduser", "~", nil, "/home/tester"},
+		{"expanduser", "/tmp/~", nil, "/tmp/~"},
+		{"relpath", "/etc/ssh/sshd_config", []interface{}{"/etc"}, "ssh/sshd_config"},
+		{"relpath", "/usr/bin", []interface{}{"/etc/ssh"}, "../../usr/bin"},
+		{"splitext", "/tmp/archive.tar.gz", nil, []interface{}{"/tmp/archive.tar", ".gz"}},
+		{"splitext", "/home/u/.bashrc", nil, []interface{}{"/home/u/.bashrc", ""}},
+		{"splitext", "dir.d/file", nil, []interface{}{"dir.d/file", ""}},
+		{"splitext", "name.", nil, []interface{}{"name", "."}},
+	}
+
+	for _, test := range tests {
+		result, err := filters[test.filter](test.input, test.args...)
+		if err != nil {
+			t.Errorf("%s(%q, %v) failed: %v", test.filter, test.input, test.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("%s(%q, %v): expected %#v, got %#v", test.filter, test.input, test.args, test.expected, result)
+		}
+	}
+}
+
+func TestRealpathFilter(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "real"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "real"), filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		filepath.Join(dir, "link"):                    filepath.Join(dir, "real"),
+		filepath.Join(dir, "link", "missing", "file"): filepath.Join(dir, "real", "missing", "file"),
+		filepath.Join(dir, "real", "..", "link"):      filepath.Join(dir, "real"),
+	}
+	for input, expected := range tests {
+		result, err := realpathFilter(input)
+		if err != nil {
+			t.Errorf("realpath(%q) failed: %v", input, err)
+			continue
+		}
+		if result != expected {
+			t.Errorf("realpath(%q): expected %q, got %q", input, expected, result)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// RegexFiltersPlugin implements regex_search, regex_findall and
+// regex_escape. Patterns use Python syntax as far as Go's RE2 engine
+// supports it; named groups may be written (?P<name>...).
+type RegexFiltersPlugin struct {
+	*BaseFilterPlugin
+}
+
+func NewRegexFiltersPlugin() *RegexFiltersPlugin {
+	return &RegexFiltersPlugin{
+		BaseFilterPlugin: NewBaseFilterPlugin(
+			"regex",
+			"Regular expression filters",
+			"1.0.0",
+			"Ansible Project",
+		),
+	}
+}
+
+func (p *RegexFiltersPlugin) GetFilters() map[string]FilterFunction {
+	return map[string]FilterFunction{
+		"regex_search":  regexSearchFilter,
+		"regex_findall": regexFindallFilter,
+		"regex_escape":  regexEscapeFilter,
+	}
+}
+
+// compileRegex compiles a Python-style pattern with the ignorecase and
+// multiline flags
+func compileRegex(filter string, pattern interface{}, ignorecase, multiline bool) (*regexp.Regexp, error) {
+	expr, ok := pattern.(string)
+	if !ok {
+		return nil, fmt.Errorf("%s requires a regular expression", filter)
+	}
+	// \Z is Python's end of text
+	expr = strings.ReplaceAll(expr, `\Z`, `\z`)
+
+	flags := ""
+	if ignorecase {
+		flags += "i"
+	}
+	if multiline {
+		flags += "m"
+	}
+	if flags != "" {
+		expr = "(?" + flags + ")" + expr
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid regular expression: %w", filter, err)
+	}
+	return re, nil
+}
+
+// regexSearchFilter implements regex_search(regex, *groups,
+// ignorecase=False, multiline=False). Without groups it returns the match;
+// groups given as '\\1' or '\\g<name>' return a list of their values. It
+// returns nil when nothing matches.
+func regexSearchFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, kw := SplitKeywords(args)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("regex_search requires a regular expression")
+	}
+	re, err := compileRegex("regex_search", args[0], isTrue(kw["ignorecase"]), isTrue(kw["multiline"]))
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []int
+	for _, arg := range args[1:] {
+		ref := fmt.Sprintf("%v", arg)
+		switch {
+		case strings.HasPrefix(ref, `\g<`) && strings.HasSuffix(ref, ">"):
+			name := ref[3 : len(ref)-1]
+			index := re.SubexpIndex(name)
+			if index < 0 {
+				if n, err := strconv.Atoi(name); err == nil && n <= re.NumSubexp() {
+					index = n
+				} else {
+					return nil, fmt.Errorf("regex_search: unknown group name '%s'", name)
+				}
+			}
+			groups = append(groups, index)
+		case strings.HasPrefix(ref, `\`):
+			n, err := strconv.Atoi(ref[1:])
+			if err != nil || n > re.NumSubexp() {
+				return nil, fmt.Errorf("regex_search: invalid group reference '%s'", ref)
+			}
+			groups = append(groups, n)
+		default:
+			return nil, fmt.Errorf("regex_search: unknown argument '%s'", ref)
+		}
+	}
+
+	text := fmt.Sprintf("%v", input)
+	match := re.FindStringSubmatchIndex(text)
+	if match == nil {
+		return nil, nil
+	}
+	if len(groups) == 0 {
+		return text[match[0]:match[1]], nil
+	}
+
+	result := make([]interface{}, len(groups))
+	for i, group := range groups {
+		if start := match[2*group]; start >= 0 {
+			result[i] = text[start:match[2*group+1]]
+		}
+	}
+	return result, nil
+}
+
+// regexFindallFilter implements regex_findall(regex, multiline=False,
+// ignorecase=False) with Python's findall results: the matches, the values
+// of the single group, or a list of group values per match
+func regexFindallFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, kw := SplitKeywords(args)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("regex_findall requires a regular expression")
+	}
+	multiline := isTrue(argument(args, kw, 1, "multiline", false))
+	ignorecase := isTrue(argument(args, kw, 2, "ignorecase", false))
+	re, err := compileRegex("regex_findall", args[0], ignorecase, multiline)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []interface{}{}
+	for _, match := range re.FindAllStringSubmatch(fmt.Sprintf("%v", input), -1) {
+		switch len(match) {
+		case 1:
+			result = append(result, match[0])
+		case 2:
+			result = append(result, match[1])
+		default:
+			groups := make([]interface{}, len(match)-1)
+			for i, group := range match[1:] {
+				groups[i] = group
+			}
+			result = append(result, groups)
+		}
+	}
+	return result, nil
+}
+
+// posixBasicSpecial matches the characters special in POSIX basic regular
+// expressions
+var posixBasicSpecial = regexp.MustCompile(`([].^$*\[\\])`)
+
+// regexEscapeFilter implements regex_escape(re_type='python')
+func regexEscapeFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, kw := SplitKeywords(args)
+	text := fmt.Sprintf("%v", input)
+
+	switch reType := optionalString(argument(args, kw, 0, "re_type", "python")); reType {
+	case "python":
+		return regexp.QuoteMeta(text), nil
+	case "posix_basic":
+		return posixBasicSpecial.ReplaceAllString(text, `\$1`), nil
+	default:
+		return nil, fmt.Errorf("regex_escape: unsupported re_type '%s'", reType)
+	}
+}
+
+// isTrue interprets a flag argument
+func isTrue(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		switch strings.ToLower(v) {
+		case "yes", "true", "on", "1":
+			return true
+		}
+	case int:
+		return v != 0
+	}
+	return false
+}
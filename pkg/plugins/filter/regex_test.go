@@ -0,0 +1,62 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRegexFilters(t *testing.T) {
+	filters := NewRegexFiltersPlugin().GetFilters()
+
+	tests := []struct {
+		filter   string
+		input    interface{}
+		args     []interface{}
+		expected interface{}
+	}{
+		{"regex_search", "server1 server22", []interface{}{`server\d+`}, "server1"},
+		{"regex_search", "server1", []interface{}{`database`}, nil},
+		{"regex_search", "Server1", []interface{}{`server`, Keywords{"ignorecase": true}}, "Server"},
+		{"regex_search", "a\nfoo=bar", []interface{}{`^foo=(.*)$`, `\1`, Keywords{"multiline": true}}, []interface{}{"bar"}},
+		{"regex_search", "user=ann id=7", []interface{}{`user=(?P<user>\w+) id=(\d+)`, `\g<user>`, `\2`}, []interface{}{"ann", "7"}},
+		{"regex_findall", "a1 b2 c3", []interface{}{`[a-z]\d`}, []interface{}{"a1", "b2", "c3"}},
+		{"regex_findall", "a1 b2", []interface{}{`[a-z](\d)`}, []interface{}{"1", "2"}},
+		{"regex_findall", "a1 b2", []interface{}{`([a-z])(\d)`}, []interface{}{[]interface{}{"a", "1"}, []interface{}{"b", "2"}}},
+		{"regex_findall", "A1 a2", []interface{}{`a\d`, Keywords{"ignorecase": true}}, []interface{}{"A1", "a2"}},
+		{"regex_escape", "^f.*o(.*)$", nil, `\^f\.\*o\(\.\*\)\$`},
+		{"regex_escape", "^f.*o(.*)$", []interface{}{"posix_basic"}, `\^f\.\*o(\.\*)\$`},
+	}
+
+	for _, test := range tests {
+		result, err := filters[test.filter](test.input, test.args...)
+		if err != nil {
+			t.Errorf("%s(%q, %v) failed: %v", test.filter, test.input, test.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("%s(%q, %v): expected %#v, got %#v", test.filter, test.input, test.args, test.expected, result)
+		}
+	}
+
+	for _, args := range [][]interface{}{{`(`}, {`a`, `\3`}, {`a`, "bogus"}} {
+		if _, err := regexSearchFilter("abc", args...); err == nil {
+			t.Errorf("regex_search(%v): expected an error", args)
+		}
+	}
+}
@@ -0,0 +1,718 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filter
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// StructuredFiltersPlugin implements filters that query and reshape lists
+// and dictionaries: json_query, the Python itertools family (zip, product,
+// permutations, ...), Jinja2's groupby, batch and slice, and Ansible's
+// subelements, extract, dict and lists_mergeby.
+type StructuredFiltersPlugin struct {
+	*BaseFilterPlugin
+}
+
+func NewStructuredFiltersPlugin() *StructuredFiltersPlugin {
+	return &StructuredFiltersPlugin{
+		BaseFilterPlugin: NewBaseFilterPlugin(
+			"structured",
+			"Structured data query and transformation filters",
+			"1.0.0",
+			"Ansible Project",
+		),
+	}
+}
+
+func (p *StructuredFiltersPlugin) GetFilters() map[string]FilterFunction {
+	return map[string]FilterFunction{
+		"json_query":    jsonQueryFilter,
+		"subelements":   subelementsFilter,
+		"zip":           zipFilter,
+		"zip_longest":   zipLongestFilter,
+		"product":       productFilter,
+		"permutations":  permutationsFilter,
+		"combinations":  combinationsFilter,
+		"groupby":       groupbyFilter,
+		"batch":         batchFilter,
+		"slice":         sliceFilter,
+		"extract":       extractFilter,
+		"dict":          dictFilter,
+		"lists_mergeby": listsMergebyFilter,
+	}
+}
+
+// jsonQueryFilter implements json_query(expression)
+func jsonQueryFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, _ = SplitKeywords(args)
+	if len(args) != 1 {
+		return nil, fmt.Errorf("json_query requires a query expression")
+	}
+	expr, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("json_query expression must be a string, got %T", args[0])
+	}
+
+	node, err := jpCompile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("json_query: invalid expression '%s': %w", expr, err)
+	}
+	result, err := jpSearch(node, jpNormalize(input))
+	if err != nil {
+		return nil, fmt.Errorf("json_query: %w", err)
+	}
+	return result, nil
+}
+
+// subelementsFilter implements subelements(key, skip_missing). For each
+// item of the input it pairs the item with every element of the list found
+// under key, which may be a dotted path.
+func subelementsFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, kw := SplitKeywords(args)
+
+	items, err := dataItems("subelements", input)
+	if err != nil {
+		if m, ok := input.(map[string]interface{}); ok {
+			items = jpValues(m)
+		} else {
+			return nil, err
+		}
+	}
+
+	key, ok := argument(args, kw, 0, "subelements", nil).(string)
+	if !ok || key == "" {
+		return nil, fmt.Errorf("subelements requires the name of a key holding a list")
+	}
+
+	skipMissing := false
+	switch flags := argument(args, kw, 1, "skip_missing", false).(type) {
+	case bool:
+		skipMissing = flags
+	case map[string]interface{}:
+		skipMissing, _ = flags["skip_missing"].(bool)
+	default:
+		return nil, fmt.Errorf("subelements: the optional third argument must be a boolean or a dictionary, got %T", flags)
+	}
+
+	result := []interface{}{}
+	for _, item := range items {
+		value, found := dataAttribute(item, key)
+		if !found {
+			if skipMissing {
+				continue
+			}
+			return nil, fmt.Errorf("subelements: could not find '%s' key in iterated item %v", key, item)
+		}
+		if value == nil && skipMissing {
+			continue
+		}
+		subitems, err := dataItems("subelements", value)
+		if err != nil {
+			return nil, fmt.Errorf("subelements: the key '%s' should point to a list, got %v", key, value)
+		}
+		for _, subitem := range subitems {
+			result = append(result, []interface{}{item, subitem})
+		}
+	}
+	return result, nil
+}
+
+// zipFilter implements zip(*lists), stopping at the shortest list
+func zipFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, _ = SplitKeywords(args)
+	lists, err := dataLists("zip", input, args)
+	if err != nil {
+		return nil, err
+	}
+
+	length := len(lists[0])
+	for _, list := range lists[1:] {
+		length = min(length, len(list))
+	}
+	return zipLists(lists, length, nil), nil
+}
+
+// zipLongestFilter implements zip_longest(*lists, fillvalue=None)
+func zipLongestFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, kw := SplitKeywords(args)
+	lists, err := dataLists("zip_longest", input, args)
+	if err != nil {
+		return nil, err
+	}
+
+	length := 0
+	for _, list := range lists {
+		length = max(length, len(list))
+	}
+	return zipLists(lists, length, kw["fillvalue"]), nil
+}
+
+func zipLists(lists [][]interface{}, length int, fill interface{}) []interface{} {
+	result := make([]interface{}, length)
+	for i := range result {
+		tuple := make([]interface{}, len(lists))
+		for j, list := range lists {
+			if i < len(list) {
+				tuple[j] = list[i]
+			} else {
+				tuple[j] = fill
+			}
+		}
+		result[i] = tuple
+	}
+	return result
+}
+
+// productFilter implements product(*lists, repeat=1), the cartesian product
+func productFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, kw := SplitKeywords(args)
+	lists, err := dataLists("product", input, args)
+	if err != nil {
+		return nil, err
+	}
+
+	repeat, err := intArgument("product", "repeat", kw["repeat"], 1)
+	if err != nil {
+		return nil, err
+	}
+	var pools [][]interface{}
+	for i := 0; i < repeat; i++ {
+		pools = append(pools, lists...)
+	}
+
+	result := []interface{}{[]interface{}{}}
+	for _, pool := range pools {
+		var next []interface{}
+		for _, prefix := range result {
+			for _, item := range pool {
+				tuple := append(append([]interface{}{}, prefix.([]interface{})...), item)
+				next = append(next, tuple)
+			}
+		}
+		result = next
+	}
+	if result == nil {
+		return []interface{}{}, nil
+	}
+	return result, nil
+}
+
+// permutationsFilter implements permutations(r=len(input))
+func permutationsFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, kw := SplitKeywords(args)
+	items, err := dataItems("permutations", input)
+	if err != nil {
+		return nil, err
+	}
+	r, err := intArgument("permutations", "r", argument(args, kw, 0, "r", nil), len(items))
+	if err != nil {
+		return nil, err
+	}
+
+	result := []interface{}{}
+	if r > len(items) {
+		return result, nil
+	}
+
+	used := make([]bool, len(items))
+	var current []interface{}
+	var permute func()
+	permute = func() {
+		if len(current) == r {
+			result = append(result, append([]interface{}{}, current...))
+			return
+		}
+		for i, item := range items {
+			if used[i] {
+				continue
+			}
+			used[i] = true
+			current = append(current, item)
+			permute()
+			current = current[:len(current)-1]
+			used[i] = false
+		}
+	}
+	permute()
+	return result, nil
+}
+
+// combinationsFilter implements combinations(r)
+func combinationsFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, kw := SplitKeywords(args)
+	items, err := dataItems("combinations", input)
+	if err != nil {
+		return nil, err
+	}
+	value := argument(args, kw, 0, "r", nil)
+	if value == nil {
+		return nil, fmt.Errorf("combinations requires the length of the combinations")
+	}
+	r, err := intArgument("combinations", "r", value, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []interface{}{}
+	var current []interface{}
+	var combine func(start int)
+	combine = func(start int) {
+		if len(current) == r {
+			result = append(result, append([]interface{}{}, current...))
+			return
+		}
+		for i := start; i < len(items); i++ {
+			current = append(current, items[i])
+			combine(i + 1)
+			current = current[:len(current)-1]
+		}
+	}
+	combine(0)
+	return result, nil
+}
+
+// groupbyFilter implements groupby(attribute), returning [grouper, items]
+// pairs sorted by the grouper like Jinja2
+func groupbyFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, kw := SplitKeywords(args)
+	items, err := dataItems("groupby", input)
+	if err != nil {
+		return nil, err
+	}
+	attribute := fmt.Sprintf("%v", argument(args, kw, 0, "attribute", ""))
+	if attribute == "" {
+		return nil, fmt.Errorf("groupby requires an attribute")
+	}
+	def := kw["default"]
+
+	keys := make([]interface{}, len(items))
+	for i, item := range items {
+		value, found := dataAttribute(item, attribute)
+		if !found {
+			if def == nil {
+				return nil, fmt.Errorf("groupby: item %v has no attribute '%s'", item, attribute)
+			}
+			value = def
+		}
+		keys[i] = value
+	}
+
+	indexes := make([]int, len(items))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(i, j int) bool { return dataCompare(keys[indexes[i]], keys[indexes[j]]) < 0 })
+
+	result := []interface{}{}
+	for _, index := range indexes {
+		if n := len(result); n > 0 {
+			group := result[n-1].([]interface{})
+			if dataCompare(group[0], keys[index]) == 0 {
+				group[1] = append(group[1].([]interface{}), items[index])
+				continue
+			}
+		}
+		result = append(result, []interface{}{keys[index], []interface{}{items[index]}})
+	}
+	return result, nil
+}
+
+// batchFilter implements batch(linecount, fill_with=None)
+func batchFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, kw := SplitKeywords(args)
+	items, err := dataItems("batch", input)
+	if err != nil {
+		return nil, err
+	}
+	size, err := intArgument("batch", "linecount", argument(args, kw, 0, "linecount", nil), 0)
+	if err != nil {
+		return nil, err
+	}
+	if size <= 0 {
+		return nil, fmt.Errorf("batch requires a positive number of items per batch")
+	}
+	fill, hasFill := argumentSet(args, kw, 1, "fill_with")
+
+	result := []interface{}{}
+	for start := 0; start < len(items); start += size {
+		batch := append([]interface{}{}, items[start:min(start+size, len(items))]...)
+		for hasFill && len(batch) < size {
+			batch = append(batch, fill)
+		}
+		result = append(result, batch)
+	}
+	return result, nil
+}
+
+// sliceFilter implements slice(slices, fill_with=None), splitting the input
+// into that many columns the way Jinja2 does
+func sliceFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, kw := SplitKeywords(args)
+	items, err := dataItems("slice", input)
+	if err != nil {
+		return nil, err
+	}
+	slices, err := intArgument("slice", "slices", argument(args, kw, 0, "slices", nil), 0)
+	if err != nil {
+		return nil, err
+	}
+	if slices <= 0 {
+		return nil, fmt.Errorf("slice requires a positive number of slices")
+	}
+	fill, hasFill := argumentSet(args, kw, 1, "fill_with")
+
+	perSlice := len(items) / slices
+	withExtra := len(items) % slices
+	offset := 0
+
+	result := make([]interface{}, 0, slices)
+	for n := 0; n < slices; n++ {
+		start := offset + n*perSlice
+		if n < withExtra {
+			offset++
+		}
+		end := offset + (n+1)*perSlice
+		part := append([]interface{}{}, items[start:end]...)
+		if hasFill && n >= withExtra {
+			part = append(part, fill)
+		}
+		result = append(result, part)
+	}
+	return result, nil
+}
+
+// extractFilter implements extract(container, morekeys): it looks the input
+// up in container, then each of morekeys in the result
+func extractFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, kw := SplitKeywords(args)
+	container := argument(args, kw, 0, "container", nil)
+	if container == nil {
+		return nil, fmt.Errorf("extract requires a container")
+	}
+
+	keys := []interface{}{input}
+	switch more := argument(args, kw, 1, "morekeys", nil).(type) {
+	case nil:
+	case []interface{}:
+		keys = append(keys, more...)
+	case []string:
+		for _, key := range more {
+			keys = append(keys, key)
+		}
+	default:
+		keys = append(keys, more)
+	}
+
+	value := container
+	for _, key := range keys {
+		next, found := dataIndex(value, key)
+		if !found {
+			return nil, fmt.Errorf("extract: key %v not found in %v", key, value)
+		}
+		value = next
+	}
+	return value, nil
+}
+
+// dictFilter implements dict, building a dictionary from key/value pairs
+func dictFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	items, err := dataItems("dict", input)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]interface{}, len(items))
+	for _, item := range items {
+		pair, err := dataItems("dict", item)
+		if err != nil || len(pair) != 2 {
+			return nil, fmt.Errorf("dict requires a list of key/value pairs, got %v", item)
+		}
+		result[fmt.Sprintf("%v", pair[0])] = pair[1]
+	}
+	return result, nil
+}
+
+// listsMergebyFilter implements community.general's lists_mergeby(*lists,
+// index, recursive=False, list_merge='replace'). Dictionaries sharing the
+// same value of index are merged, later lists winning; the result is sorted
+// by that value.
+func listsMergebyFilter(input interface{}, args ...interface{}) (interface{}, error) {
+	args, kw := SplitKeywords(args)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("lists_mergeby requires the name of the index attribute")
+	}
+	index, ok := args[len(args)-1].(string)
+	if !ok {
+		return nil, fmt.Errorf("lists_mergeby: the index must be a string, got %T", args[len(args)-1])
+	}
+
+	recursive, _ := kw["recursive"].(bool)
+	listMerge := "replace"
+	if value, ok := kw["list_merge"]; ok {
+		listMerge = fmt.Sprintf("%v", value)
+	}
+	switch listMerge {
+	case "replace", "keep", "append", "prepend", "append_rp", "prepend_rp":
+	default:
+		return nil, fmt.Errorf("lists_mergeby: list_merge must be one of replace, keep, append, prepend, append_rp or prepend_rp, got '%s'", listMerge)
+	}
+
+	// A single list of lists may stand in for the list arguments
+	terms := append([]interface{}{input}, args[:len(args)-1]...)
+	if len(terms) == 1 {
+		if nested, err := dataItems("lists_mergeby", input); err == nil && len(nested) > 0 {
+			if _, err := dataItems("lists_mergeby", nested[0]); err == nil {
+				terms = nested
+			}
+		}
+	}
+
+	merged := make(map[string]map[string]interface{})
+	values := make(map[string]interface{})
+	for _, term := range terms {
+		items, err := dataItems("lists_mergeby", term)
+		if err != nil {
+			return nil, fmt.Errorf("lists_mergeby: all arguments before the index must be lists, got %T", term)
+		}
+		for _, item := range items {
+			elem, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("lists_mergeby: elements of the lists must be dictionaries, got %T", item)
+			}
+			value, ok := elem[index]
+			if !ok {
+				continue
+			}
+			key := fmt.Sprintf("%T:%v", value, value)
+			if existing, ok := merged[key]; ok {
+				merged[key] = mergeHash(existing, elem, recursive, listMerge)
+			} else {
+				merged[key] = mergeHash(map[string]interface{}{}, elem, recursive, listMerge)
+				values[key] = value
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(merged))
+	for key := range merged {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return dataCompare(values[keys[i]], values[keys[j]]) < 0 })
+
+	result := make([]interface{}, len(keys))
+	for i, key := range keys {
+		result[i] = merged[key]
+	}
+	return result, nil
+}
+
+// mergeHash merges b into a copy of a like Ansible's combine: with
+// recursive, nested dictionaries are merged instead of replaced, and
+// listMerge decides how two lists under the same key are combined
+func mergeHash(a, b map[string]interface{}, recursive bool, listMerge string) map[string]interface{} {
+	result := make(map[string]interface{}, len(a)+len(b))
+	for key, value := range a {
+		result[key] = value
+	}
+
+	for key, value := range b {
+		existing, ok := result[key]
+		if !ok {
+			result[key] = value
+			continue
+		}
+
+		if recursive {
+			em, eok := existing.(map[string]interface{})
+			vm, vok := value.(map[string]interface{})
+			if eok && vok {
+				result[key] = mergeHash(em, vm, recursive, listMerge)
+				continue
+			}
+		}
+
+		el, eok := existing.([]interface{})
+		vl, vok := value.([]interface{})
+		if !eok || !vok {
+			result[key] = value
+			continue
+		}
+		switch listMerge {
+		case "keep":
+		case "append":
+			result[key] = append(append([]interface{}{}, el...), vl...)
+		case "prepend":
+			result[key] = append(append([]interface{}{}, vl...), el...)
+		case "append_rp":
+			result[key] = append(withoutItems(el, vl), vl...)
+		case "prepend_rp":
+			result[key] = append(append([]interface{}{}, vl...), withoutItems(el, vl)...)
+		default:
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// withoutItems returns the items of list that are not in remove
+func withoutItems(list, remove []interface{}) []interface{} {
+	result := []interface{}{}
+	for _, item := range list {
+		found := false
+		for _, other := range remove {
+			if reflect.DeepEqual(item, other) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// dataItems converts a list of any element type to []interface{}
+func dataItems(filter string, input interface{}) ([]interface{}, error) {
+	if items, ok := input.([]interface{}); ok {
+		return items, nil
+	}
+	val := reflect.ValueOf(input)
+	if input == nil || (val.Kind() != reflect.Slice && val.Kind() != reflect.Array) {
+		return nil, fmt.Errorf("%s requires a list, got %T", filter, input)
+	}
+	items := make([]interface{}, val.Len())
+	for i := range items {
+		items[i] = val.Index(i).Interface()
+	}
+	return items, nil
+}
+
+// dataLists converts the input and the list arguments of zip and friends
+func dataLists(filter string, input interface{}, args []interface{}) ([][]interface{}, error) {
+	var lists [][]interface{}
+	for _, value := range append([]interface{}{input}, args...) {
+		items, err := dataItems(filter, value)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, items)
+	}
+	return lists, nil
+}
+
+// argumentSet returns an optional argument and whether it was given
+func argumentSet(args []interface{}, kw Keywords, index int, name string) (interface{}, bool) {
+	if value, ok := kw[name]; ok {
+		return value, true
+	}
+	if index < len(args) {
+		return args[index], true
+	}
+	return nil, false
+}
+
+// intArgument converts an integer argument, using def when it is nil
+func intArgument(filter, name string, value interface{}, def int) (int, error) {
+	if value == nil {
+		return def, nil
+	}
+	n, ok := ipInt(value)
+	if !ok || n < 0 {
+		return 0, fmt.Errorf("%s: %s must be a non-negative integer, got %v", filter, name, value)
+	}
+	return int(n), nil
+}
+
+// dataAttribute follows a dotted path of keys and list indexes
+func dataAttribute(item interface{}, path string) (interface{}, bool) {
+	value := item
+	for _, part := range strings.Split(path, ".") {
+		next, found := dataIndex(value, part)
+		if !found {
+			if n, err := strconv.Atoi(part); err == nil {
+				next, found = dataIndex(value, n)
+			}
+		}
+		if !found {
+			return nil, false
+		}
+		value = next
+	}
+	return value, true
+}
+
+// dataIndex looks a key up in a map or an index up in a list
+func dataIndex(container, key interface{}) (interface{}, bool) {
+	val := reflect.ValueOf(container)
+	switch val.Kind() {
+	case reflect.Map:
+		keyVal := reflect.ValueOf(key)
+		if val.Type().Key().Kind() == reflect.String {
+			keyVal = reflect.ValueOf(fmt.Sprintf("%v", key)).Convert(val.Type().Key())
+		}
+		if !keyVal.IsValid() || !keyVal.Type().AssignableTo(val.Type().Key()) {
+			return nil, false
+		}
+		result := val.MapIndex(keyVal)
+		if !result.IsValid() {
+			return nil, false
+		}
+		return result.Interface(), true
+
+	case reflect.Slice, reflect.Array:
+		n, ok := ipInt(key)
+		if !ok {
+			return nil, false
+		}
+		if n < 0 {
+			n += int64(val.Len())
+		}
+		if n < 0 || n >= int64(val.Len()) {
+			return nil, false
+		}
+		return val.Index(int(n)).Interface(), true
+	}
+	return nil, false
+}
+
+// dataCompare orders numbers numerically, strings lexically and anything
+// else by its string form
+func dataCompare(a, b interface{}) int {
+	x, aNum := jpNumberValue(jpNormalize(a))
+	y, bNum := jpNumberValue(jpNormalize(b))
+	switch {
+	case aNum && bNum:
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case aNum:
+		return -1
+	case bNum:
+		return 1
+	}
+	return strings.Compare(fmt.Sprintf("%v", a), fmt.Sprintf("%v", b))
+}
@@ -0,0 +1,232 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filter
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONQuery(t *testing.T) {
+	var data interface{}
+	err := json.Unmarshal([]byte(`{
+		"domain": {
+			"cluster": [
+				{"name": "c1", "servers": [{"name": "s1", "port": 8080, "tags": ["a", "b"]}, {"name": "s2", "port": 9090, "tags": ["c"]}]},
+				{"name": "c2", "servers": [{"name": "s3", "port": 8080, "tags": []}]}
+			]
+		},
+		"people": [{"name": "ann", "age": 40}, {"name": "bob", "age": 25}, {"name": "cy", "age": 31}],
+		"list": [0, 1, 2, 3, 4, 5],
+		"obj": {"b": 2, "a": 1}
+	}`), &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query    string
+		expected interface{}
+	}{
+		{"domain.cluster[0].name", "c1"},
+		{"domain.cluster[].servers[].name", []interface{}{"s1", "s2", "s3"}},
+		{"domain.cluster[*].servers[?port==`8080`].name", []interface{}{[]interface{}{"s1"}, []interface{}{"s3"}}},
+		{"domain.cluster[].servers[?port==`8080`].name | [0]", []interface{}{"s1"}},
+		{"domain.cluster[].servers[?port==`8080`].name[] | [0]", "s1"},
+		{"domain.cluster[].servers[] | [?port > `8500`].name", []interface{}{"s2"}},
+		{"domain.cluster[].servers[].tags[]", []interface{}{"a", "b", "c"}},
+		{"people[?age > `30` && name != 'ann'].name", []interface{}{"cy"}},
+		{"people[?!(age < `30`)].name", []interface{}{"ann", "cy"}},
+		{"people[].{n: name, old: age >= `40`}", []interface{}{
+			map[string]interface{}{"n": "ann", "old": true},
+			map[string]interface{}{"n": "bob", "old": false},
+			map[string]interface{}{"n": "cy", "old": false},
+		}},
+		{"people[*].[name, age][1]", []interface{}{"bob", 25}},
+		{"sort_by(people, &age)[].name", []interface{}{"bob", "cy", "ann"}},
+		{"max_by(people, &age).name", "ann"},
+		{"sum(people[].age)", 96},
+		{"avg(list)", 2.5},
+		{"length(people)", 3},
+		{"join(', ', people[].name)", "ann, bob, cy"},
+		{"contains(people[].name, 'bob')", true},
+		{"keys(obj)", []interface{}{"a", "b"}},
+		{"obj.*", []interface{}{1, 2}},
+		{"list[1:4]", []interface{}{1, 2, 3}},
+		{"list[::-2]", []interface{}{5, 3, 1}},
+		{"list[-1]", 5},
+		{"missing.key", nil},
+		{"missing || 'default'", "default"},
+		{"map(&to_string(@), list[:2])", []interface{}{"0", "1"}},
+		{"people[?starts_with(name, 'b')] | length(@)", 1},
+		{"\"obj\".a", 1},
+		{"`{\"a\": [1, 2]}`.a[1]", 2},
+		{"type(obj)", "object"},
+		{"merge(obj, `{\"c\": 3}`).c", 3},
+	}
+
+	for _, test := range tests {
+		result, err := jsonQueryFilter(data, test.query)
+		if err != nil {
+			t.Errorf("json_query(%q) failed: %v", test.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("json_query(%q): expected %#v, got %#v", test.query, test.expected, result)
+		}
+	}
+
+	for _, query := range []string{"people[?age >", "foo.", "[1", "a = b", "`{bad`", "unknown_fn(@)"} {
+		if _, err := jsonQueryFilter(data, query); err == nil {
+			t.Errorf("json_query(%q): expected an error", query)
+		}
+	}
+}
+
+func TestStructuredFilters(t *testing.T) {
+	filters := NewStructuredFiltersPlugin().GetFilters()
+
+	users := []interface{}{
+		map[string]interface{}{"name": "alice", "groups": []interface{}{"wheel", "docker"}, "shell": "bash"},
+		map[string]interface{}{"name": "bob", "shell": "zsh"},
+		map[string]interface{}{"name": "carol", "groups": []interface{}{"wheel"}, "shell": "bash"},
+	}
+
+	tests := []struct {
+		filter   string
+		input    interface{}
+		args     []interface{}
+		expected interface{}
+	}{
+		{"subelements", users, []interface{}{"groups", true}, []interface{}{
+			[]interface{}{users[0], "wheel"},
+			[]interface{}{users[0], "docker"},
+			[]interface{}{users[2], "wheel"},
+		}},
+		{"subelements", users[2:], []interface{}{"groups", Keywords{"skip_missing": false}}, []interface{}{
+			[]interface{}{users[2], "wheel"},
+		}},
+		{"zip", []interface{}{1, 2, 3}, []interface{}{[]string{"a", "b"}}, []interface{}{
+			[]interface{}{1, "a"}, []interface{}{2, "b"},
+		}},
+		{"zip_longest", []interface{}{1, 2}, []interface{}{[]interface{}{"a"}, Keywords{"fillvalue": 0}}, []interface{}{
+			[]interface{}{1, "a"}, []interface{}{2, 0},
+		}},
+		{"product", []interface{}{1, 2}, []interface{}{[]interface{}{"a", "b"}}, []interface{}{
+			[]interface{}{1, "a"}, []interface{}{1, "b"}, []interface{}{2, "a"}, []interface{}{2, "b"},
+		}},
+		{"product", []interface{}{0, 1}, []interface{}{Keywords{"repeat": 2}}, []interface{}{
+			[]interface{}{0, 0}, []interface{}{0, 1}, []interface{}{1, 0}, []interface{}{1, 1},
+		}},
+		{"permutations", []interface{}{1, 2, 3}, []interface{}{2}, []interface{}{
+			[]interface{}{1, 2}, []interface{}{1, 3}, []interface{}{2, 1},
+			[]interface{}{2, 3}, []interface{}{3, 1}, []interface{}{3, 2},
+		}},
+		{"combinations", []interface{}{1, 2, 3}, []interface{}{2}, []interface{}{
+			[]interface{}{1, 2}, []interface{}{1, 3}, []interface{}{2, 3},
+		}},
+		{"groupby", users, []interface{}{"shell"}, []interface{}{
+			[]interface{}{"bash", []interface{}{users[0], users[2]}},
+			[]interface{}{"zsh", []interface{}{users[1]}},
+		}},
+		{"groupby", users, []interface{}{"groups.0", Keywords{"default": "none"}}, []interface{}{
+			[]interface{}{"none", []interface{}{users[1]}},
+			[]interface{}{"wheel", []interface{}{users[0], users[2]}},
+		}},
+		{"batch", []interface{}{1, 2, 3, 4, 5}, []interface{}{2}, []interface{}{
+			[]interface{}{1, 2}, []interface{}{3, 4}, []interface{}{5},
+		}},
+		{"batch", []interface{}{1, 2, 3}, []interface{}{2, "-"}, []interface{}{
+			[]interface{}{1, 2}, []interface{}{3, "-"},
+		}},
+		{"slice", []interface{}{1, 2, 3, 4, 5, 6, 7}, []interface{}{3}, []interface{}{
+			[]interface{}{1, 2, 3}, []interface{}{4, 5}, []interface{}{6, 7},
+		}},
+		{"slice", []interface{}{1, 2, 3, 4}, []interface{}{3, 0}, []interface{}{
+			[]interface{}{1, 2}, []interface{}{3, 0}, []interface{}{4, 0},
+		}},
+		{"extract", 1, []interface{}{[]interface{}{"a", "b"}}, "b"},
+		{"extract", "web", []interface{}{
+			map[string]interface{}{"web": map[string]interface{}{"ansible_host": "10.0.0.1"}},
+			"ansible_host",
+		}, "10.0.0.1"},
+		{"dict", []interface{}{[]interface{}{"a", 1}, []interface{}{"b", 2}}, nil,
+			map[string]interface{}{"a": 1, "b": 2}},
+		{"lists_mergeby",
+			[]interface{}{
+				map[string]interface{}{"name": "b", "x": 1},
+				map[string]interface{}{"name": "a", "x": 1},
+			},
+			[]interface{}{
+				[]interface{}{map[string]interface{}{"name": "b", "y": 2}},
+				"name",
+			},
+			[]interface{}{
+				map[string]interface{}{"name": "a", "x": 1},
+				map[string]interface{}{"name": "b", "x": 1, "y": 2},
+			}},
+		{"lists_mergeby",
+			[]interface{}{
+				[]interface{}{map[string]interface{}{"name": "a", "p": map[string]interface{}{"l": []interface{}{1, 2}, "k": 1}}},
+				[]interface{}{map[string]interface{}{"name": "a", "p": map[string]interface{}{"l": []interface{}{2, 3}}}},
+			},
+			[]interface{}{"name", Keywords{"recursive": true, "list_merge": "append_rp"}},
+			[]interface{}{
+				map[string]interface{}{"name": "a", "p": map[string]interface{}{"l": []interface{}{1, 2, 3}, "k": 1}},
+			}},
+	}
+
+	for _, test := range tests {
+		result, err := filters[test.filter](test.input, test.args...)
+		if err != nil {
+			t.Errorf("%s(%v, %v) failed: %v", test.filter, test.input, test.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("%s(%v, %v): expected %v, got %v", test.filter, test.input, test.args, test.expected, result)
+		}
+	}
+}
+
+func TestStructuredFilters_Errors(t *testing.T) {
+	filters := NewStructuredFiltersPlugin().GetFilters()
+
+	tests := []struct {
+		filter  string
+		input   interface{}
+		args    []interface{}
+		message string
+	}{
+		{"subelements", []interface{}{map[string]interface{}{"name": "x"}}, []interface{}{"groups"}, "could not find 'groups'"},
+		{"subelements", []interface{}{map[string]interface{}{"groups": "x"}}, []interface{}{"groups"}, "should point to a list"},
+		{"combinations", []interface{}{1}, nil, "requires the length"},
+		{"batch", []interface{}{1}, []interface{}{0}, "positive"},
+		{"extract", "missing", []interface{}{map[string]interface{}{}}, "not found"},
+		{"dict", []interface{}{[]interface{}{"a"}}, nil, "key/value pairs"},
+		{"lists_mergeby", []interface{}{"x"}, []interface{}{[]interface{}{}, "name"}, "must be dictionaries"},
+		{"lists_mergeby", []interface{}{}, []interface{}{[]interface{}{}, "name", Keywords{"list_merge": "bogus"}}, "list_merge"},
+	}
+
+	for _, test := range tests {
+		_, err := filters[test.filter](test.input, test.args...)
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("%s(%v, %v): expected error containing %q, got %v", test.filter, test.input, test.args, test.message, err)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filter
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// This file implements the Ansible Vault 1.1/1.2 AES256 format used by the
+// vault and unvault filters. The payload is hexlify(hexlify(salt) "\n"
+// hmac "\n" hexlify(ciphertext)), with keys derived by PBKDF2-SHA256.
+
+const (
+	vaultHeader     = "$ANSIBLE_VAULT"
+	vaultCipher     = "AES256"
+	vaultIterations = 10000
+	vaultSaltSize   = 32
+	vaultLineLength = 80
+)
+
+// vaultKeys derives the AES key, HMAC key and counter IV from a secret
+func vaultKeys(secret string, salt []byte) (cipherKey, hmacKey, iv []byte, err error) {
+	derived, err := pbkdf2.Key(sha256.New, secret, salt, vaultIterations, 2*32+aes.BlockSize)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return derived[:32], derived[32:64], derived[64:], nil
+}
+
+// vaultEncrypt encrypts plaintext into a vault envelope. An empty salt is
+// replaced by a random one; vault IDs other than "default" produce the 1.2
+// format, which records the ID in the header.
+func vaultEncrypt(plaintext []byte, secret string, salt []byte, vaultID string) (string, error) {
+	if secret == "" {
+		return "", fmt.Errorf("a vault secret is required to encrypt")
+	}
+	if len(salt) == 0 {
+		salt = make([]byte, vaultSaltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+	}
+
+	cipherKey, hmacKey, iv, err := vaultKeys(secret, salt)
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(cipherKey)
+	if err != nil {
+		return "", err
+	}
+
+	// PKCS#7 padding to the AES block size
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	padded := append(append([]byte{}, plaintext...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	ciphertext := make([]byte, len(padded))
+	cipher.NewCTR(block, iv).XORKeyStream(ciphertext, padded)
+
+	mac := hmac.New(sha256.New, hmacKey)
+	mac.Write(ciphertext)
+
+	payload := hex.EncodeToString(salt) + "\n" + hex.EncodeToString(mac.Sum(nil)) + "\n" + hex.EncodeToString(ciphertext)
+	body := hex.EncodeToString([]byte(payload))
+
+	header := vaultHeader + ";1.1;" + vaultCipher
+	if vaultID != "" && vaultID != "default" {
+		header = vaultHeader + ";1.2;" + vaultCipher + ";" + vaultID
+	}
+
+	var b strings.Builder
+	b.WriteString(header)
+	b.WriteByte('\n')
+	for i := 0; i < len(body); i += vaultLineLength {
+		b.WriteString(body[i:min(i+vaultLineLength, len(body))])
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
+
+// isVaultEncrypted reports whether text is a vault envelope
+func isVaultEncrypted(text string) bool {
+	return strings.HasPrefix(strings.TrimSpace(text), vaultHeader+";")
+}
+
+// vaultDecrypt decrypts a vault envelope and returns the plaintext and the
+// vault ID recorded in a 1.2 header
+func vaultDecrypt(vaulttext, secret string) ([]byte, string, error) {
+	lines := strings.Split(strings.TrimSpace(vaulttext), "\n")
+	header := strings.Split(strings.TrimSpace(lines[0]), ";")
+	if len(header) < 3 || header[0] != vaultHeader {
+		return nil, "", fmt.Errorf("input is not vault encrypted data")
+	}
+	if header[2] != vaultCipher {
+		return nil, "", fmt.Errorf("unsupported vault cipher '%s'", header[2])
+	}
+	vaultID := ""
+	if header[1] == "1.2" && len(header) > 3 {
+		vaultID = header[3]
+	}
+
+	var body strings.Builder
+	for _, line := range lines[1:] {
+		body.WriteString(strings.TrimSpace(line))
+	}
+	payload, err := hex.DecodeString(body.String())
+	if err != nil {
+		return nil, "", fmt.Errorf("vault format error: %w", err)
+	}
+	parts := strings.SplitN(string(payload), "\n", 3)
+	if len(parts) != 3 {
+		return nil, "", fmt.Errorf("vault format error: expected salt, hmac and ciphertext")
+	}
+	salt, err1 := hex.DecodeString(parts[0])
+	expectedMAC, err2 := hex.DecodeString(parts[1])
+	ciphertext, err3 := hex.DecodeString(strings.TrimSpace(parts[2]))
+	if err1 != nil || err2 != nil || err3 != nil {
+		return nil, "", fmt.Errorf("vault format error: invalid hex data")
+	}
+
+	cipherKey, hmacKey, iv, err := vaultKeys(secret, salt)
+	if err != nil {
+		return nil, "", err
+	}
+	mac := hmac.New(sha256.New, hmacKey)
+	mac.Write(ciphertext)
+	if !hmac.Equal(mac.Sum(nil), expectedMAC) {
+		return nil, "", fmt.Errorf("decryption failed: HMAC mismatch, the vault secret is probably wrong")
+	}
+
+	block, err := aes.NewCipher(cipherKey)
+	if err != nil {
+		return nil, "", err
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCTR(block, iv).XORKeyStream(plaintext, ciphertext)
+
+	if n := len(plaintext); n > 0 {
+		padding := int(plaintext[n-1])
+		if padding > 0 && padding <= aes.BlockSize && padding <= n {
+			plaintext = plaintext[:n-padding]
+		}
+	}
+	return plaintext, vaultID, nil
+}
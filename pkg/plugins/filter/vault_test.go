@@ -0,0 +1,80 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filter
+
+import (
+	"strings"
+	"testing"
+)
+
+// vaultReference was produced independently with PBKDF2-SHA256 and
+// AES-256-CTR for "hello world", secret "s3cret" and a fixed salt
+const vaultReference = `$ANSIBLE_VAULT;1.2;AES256;filter_default
+33303331333233333334333533363337333833393631363236333634363536363330333133323333
+3334333533363337333833393631363236333634363536360a356165393165393866656266303463
+35663631343839333561646434386230653232366330323736373066323030386236623531646639
+3766326264333335650a356164306530613061316561366438326261303830366130656332396234
+3037
+`
+
+func TestVaultFilters(t *testing.T) {
+	result, err := vaultFilter("hello world", "s3cret", "0123456789abcdef0123456789abcdef")
+	if err != nil {
+		t.Fatalf("vault failed: %v", err)
+	}
+	if result != vaultReference {
+		t.Errorf("vault: expected\n%s\ngot\n%s", vaultReference, result)
+	}
+
+	plain, err := unvaultFilter(vaultReference, "s3cret")
+	if err != nil {
+		t.Fatalf("unvault failed: %v", err)
+	}
+	if plain != "hello world" {
+		t.Errorf("unvault: expected 'hello world', got %q", plain)
+	}
+
+	// Random salts, other vault IDs and block-sized plaintexts round-trip
+	for _, vaultID := range []string{"default", "prod"} {
+		secret := strings.Repeat("x", 16)
+		encrypted, err := vaultFilter(secret, "pw", Keywords{"vault_id": vaultID})
+		if err != nil {
+			t.Fatalf("vault failed: %v", err)
+		}
+		header := strings.SplitN(encrypted.(string), "\n", 2)[0]
+		if vaultID == "default" && header != "$ANSIBLE_VAULT;1.1;AES256" {
+			t.Errorf("unexpected header %q", header)
+		}
+		if vaultID == "prod" && header != "$ANSIBLE_VAULT;1.2;AES256;prod" {
+			t.Errorf("unexpected header %q", header)
+		}
+		decrypted, err := unvaultFilter(encrypted, "pw")
+		if err != nil || decrypted != secret {
+			t.Errorf("round trip with vault ID %s: got %q, %v", vaultID, decrypted, err)
+		}
+	}
+
+	if _, err := unvaultFilter(vaultReference, "wrong"); err == nil || !strings.Contains(err.Error(), "HMAC") {
+		t.Errorf("unvault with a wrong secret: expected an HMAC error, got %v", err)
+	}
+	if _, err := unvaultFilter("plain text", "s3cret"); err == nil {
+		t.Error("unvault of plain text: expected an error")
+	}
+	if _, err := vaultFilter("data"); err == nil {
+		t.Error("vault without a secret: expected an error")
+	}
+}
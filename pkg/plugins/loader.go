@@ -17,6 +17,7 @@ limitations under the License.
 package plugins
 
 import (
+	"context"
 	"fmt"
 	"path/filepath"
 	"plugin"
@@ -97,34 +98,57 @@ func (l *Loader) LoadPlugin(pluginType PluginType, name string) (*Plugin, error)
 	return loadedPlugin, nil
 }
 
-// ListPlugins returns all available plugins of a given type
+// ListPlugins returns all available plugins of a given type. A name found
+// in several search paths is loaded from the first one.
 func (l *Loader) ListPlugins(pluginType PluginType) ([]*Plugin, error) {
-	l.mutex.RLock()
-	defer l.mutex.RUnlock()
-
-	var plugins []*Plugin
-
-	// Get search paths for the plugin type
-	searchPaths := l.getPluginPaths(pluginType)
-
-	for _, path := range searchPaths {
+	// LoadPlugin takes the lock itself, so names are collected first
+	l.mutex.Lock()
+	var names []string
+	seen := make(map[string]bool)
+	for _, path := range l.getPluginPaths(pluginType) {
 		pluginNames, err := l.discoverPluginsInPath(path, pluginType)
 		if err != nil {
 			continue // Skip paths with errors
 		}
-
 		for _, name := range pluginNames {
-			plugin, err := l.LoadPlugin(pluginType, name)
-			if err != nil {
-				continue // Skip plugins that fail to load
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
 			}
-			plugins = append(plugins, plugin)
 		}
 	}
+	l.mutex.Unlock()
+
+	var plugins []*Plugin
+	for _, name := range names {
+		plugin, err := l.LoadPlugin(pluginType, name)
+		if err != nil {
+			continue // Skip plugins that fail to load
+		}
+		plugins = append(plugins, plugin)
+	}
 
 	return plugins, nil
 }
 
+// ExternalPlugins loads the external executable plugins found in the search
+// paths of a plugin type, e.g. Config.FilterPluginPath for filters. Each
+// executable is described once and then cached for the rest of the run.
+func (l *Loader) ExternalPlugins(pluginType PluginType) ([]*ExternalPlugin, error) {
+	plugins, err := l.ListPlugins(pluginType)
+	if err != nil {
+		return nil, err
+	}
+
+	var external []*ExternalPlugin
+	for _, plugin := range plugins {
+		if instance, ok := plugin.Instance.(*ExternalPlugin); ok {
+			external = append(external, instance)
+		}
+	}
+	return external, nil
+}
+
 // findPlugin finds a plugin file by name and type
 func (l *Loader) findPlugin(pluginType PluginType, name string) (string, error) {
 	searchPaths := l.getPluginPaths(pluginType)
@@ -144,6 +168,17 @@ func (l *Loader) findPlugin(pluginType PluginType, name string) (string, error)
 		if exists, _ := afero.DirExists(l.fs, dirPath); exists {
 			return dirPath, nil
 		}
+
+		// External plugins are executables with any extension
+		if l.isExecutable(dirPath) {
+			return dirPath, nil
+		}
+		matches, _ := afero.Glob(l.fs, filepath.Join(path, name+".*"))
+		for _, match := range matches {
+			if l.isExecutable(match) {
+				return match, nil
+			}
+		}
 	}
 
 	return "", fmt.Errorf("plugin '%s' of type '%s' not found", name, pluginType)
@@ -153,6 +188,10 @@ func (l *Loader) findPlugin(pluginType PluginType, name string) (string, error)
 func (l *Loader) loadPluginFromPath(pluginType PluginType, name, path string) (*Plugin, error) {
 	ext := filepath.Ext(path)
 
+	if ext != ".so" && l.isExecutable(path) {
+		return l.loadExternalPlugin(pluginType, name, path)
+	}
+
 	switch ext {
 	case ".so":
 		return l.loadGoPlugin(pluginType, name, path)
@@ -211,6 +250,30 @@ func (l *Loader) loadPythonPlugin(pluginType PluginType, name, path string) (*Pl
 	}, fmt.Errorf("Python plugin loading not yet implemented")
 }
 
+// loadExternalPlugin describes an external executable plugin
+func (l *Loader) loadExternalPlugin(pluginType PluginType, name, path string) (*Plugin, error) {
+	external, err := DescribeExternalPlugin(context.Background(), path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Plugin{
+		Name:        name,
+		Type:        pluginType,
+		Path:        path,
+		Description: external.Description,
+		Version:     external.Version,
+		Author:      external.Author,
+		Instance:    external,
+	}, nil
+}
+
+// isExecutable reports whether path is a regular file with an execute bit
+func (l *Loader) isExecutable(path string) bool {
+	info, err := l.fs.Stat(path)
+	return err == nil && info.Mode().IsRegular() && info.Mode().Perm()&0111 != 0
+}
+
 // loadDirectoryPlugin loads a directory-based plugin
 func (l *Loader) loadDirectoryPlugin(pluginType PluginType, name, path string) (*Plugin, error) {
 	// This would handle complex plugin structures
@@ -280,9 +343,9 @@ func (l *Loader) discoverPluginsInPath(path string, pluginType PluginType) ([]st
 			// Directory-based plugin
 			pluginNames = append(pluginNames, name)
 		} else {
-			// File-based plugin
+			// File-based plugin, or an external executable
 			ext := filepath.Ext(name)
-			if ext == ".so" || ext == ".py" || ext == ".go" {
+			if ext == ".so" || ext == ".py" || ext == ".go" || l.isExecutable(filepath.Join(path, name)) {
 				pluginName := strings.TrimSuffix(name, ext)
 				pluginNames = append(pluginNames, pluginName)
 			}
@@ -302,11 +365,29 @@ func (l *Loader) GetPlugin(pluginType PluginType, name string) (*Plugin, bool) {
 	return plugin, exists
 }
 
+// Close stops the long-lived external plugin processes
+func (l *Loader) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for _, plugin := range l.pluginCache {
+		if external, ok := plugin.Instance.(*ExternalPlugin); ok {
+			external.Close()
+		}
+	}
+	return nil
+}
+
 // ClearCache clears the plugin cache
 func (l *Loader) ClearCache() {
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
 
+	for _, plugin := range l.pluginCache {
+		if external, ok := plugin.Instance.(*ExternalPlugin); ok {
+			external.Close()
+		}
+	}
 	l.pluginCache = make(map[string]*Plugin)
 	l.pathCache = make(map[PluginType][]string)
 }
@@ -0,0 +1,122 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lookup
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/work-obs/ansible-go/pkg/config"
+)
+
+// ConfigLookupPlugin implements the config lookup, which returns
+// configuration values. Settings are named by their configuration key
+// (become_user) or Ansible's constant name (DEFAULT_BECOME_USER).
+type ConfigLookupPlugin struct {
+	*BaseLookupPlugin
+	config *config.Config
+}
+
+// NewConfigLookupPlugin creates a config lookup reading cfg. With a nil cfg
+// the configuration is loaded from the usual files and environment.
+func NewConfigLookupPlugin(cfg *config.Config) *ConfigLookupPlugin {
+	return &ConfigLookupPlugin{
+		BaseLookupPlugin: NewBaseLookupPlugin(
+			"config",
+			"Display the 'resolved' Ansible option values",
+			"1.0.0",
+			"Ansible Project",
+		),
+		config: cfg,
+	}
+}
+
+func (c *ConfigLookupPlugin) Run(ctx context.Context, terms []string, variables map[string]interface{}, options map[string]interface{}) ([]interface{}, error) {
+	onMissing := "error"
+	if value, ok := options["on_missing"]; ok {
+		onMissing = fmt.Sprintf("%v", value)
+	}
+	switch onMissing {
+	case "error", "warn", "skip":
+	default:
+		return nil, fmt.Errorf("on_missing must be one of error, warn or skip, got '%s'", onMissing)
+	}
+	if _, ok := options["plugin_type"]; ok {
+		return nil, fmt.Errorf("plugin settings are not available through the config lookup")
+	}
+
+	cfg := c.config
+	if cfg == nil {
+		manager := config.NewManager(afero.NewOsFs())
+		if err := manager.LoadConfig(); err != nil {
+			return nil, err
+		}
+		cfg = manager.GetConfig()
+	}
+
+	results := make([]interface{}, 0, len(terms))
+	for _, term := range terms {
+		value, ok := configValue(cfg, term)
+		if !ok {
+			if onMissing == "error" {
+				return nil, fmt.Errorf("unable to find setting %s", term)
+			}
+			continue
+		}
+		results = append(results, value)
+	}
+
+	return results, nil
+}
+
+// configValue finds a setting by key, field or constant name. Durations are
+// returned in seconds and lists as []interface{}.
+func configValue(cfg *config.Config, name string) (interface{}, bool) {
+	wanted := strings.ToLower(name)
+	short := strings.TrimPrefix(wanted, "default_")
+
+	rv := reflect.ValueOf(cfg).Elem()
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		key := strings.ToLower(field.Tag.Get("mapstructure"))
+		fieldName := strings.ToLower(field.Name)
+		if key != wanted && key != short && fieldName != wanted {
+			continue
+		}
+
+		switch value := rv.Field(i).Interface().(type) {
+		case time.Duration:
+			if value%time.Second == 0 {
+				return int(value / time.Second), true
+			}
+			return value.Seconds(), true
+		case []string:
+			return stringTerms(value), true
+		default:
+			return value, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,63 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lookup
+
+import (
+	"context"
+
+	"github.com/work-obs/ansible-go/pkg/plugins"
+	"github.com/work-obs/ansible-go/pkg/template"
+)
+
+// ExternalLookupPlugin runs one lookup of an external executable plugin
+type ExternalLookupPlugin struct {
+	*BaseLookupPlugin
+	plugin *plugins.ExternalPlugin
+}
+
+func NewExternalLookupPlugin(plugin *plugins.ExternalPlugin, name string) *ExternalLookupPlugin {
+	author := ""
+	if len(plugin.Author) > 0 {
+		author = plugin.Author[0]
+	}
+	return &ExternalLookupPlugin{
+		BaseLookupPlugin: NewBaseLookupPlugin(name, plugin.Description, plugin.Version, author),
+		plugin:           plugin,
+	}
+}
+
+func (l *ExternalLookupPlugin) Run(ctx context.Context, terms []string, variables map[string]interface{}, options map[string]interface{}) ([]interface{}, error) {
+	if l.plugin.Variables {
+		variables = sentVariables(variables)
+	}
+	return l.plugin.Lookup(ctx, l.name, terms, variables, options)
+}
+
+// sentVariables resolves the variables sent to an external plugin, which
+// cannot resolve them on access. Like values that cannot be encoded as
+// JSON, variables that fail to resolve are not sent.
+func sentVariables(variables map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(variables))
+	for name, value := range variables {
+		resolved, err := template.ResolveVariable(value)
+		if err != nil {
+			continue
+		}
+		result[name] = resolved
+	}
+	return result
+}
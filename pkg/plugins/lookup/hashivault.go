@@ -0,0 +1,402 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lookup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// defaultVaultAddr is used when neither url nor VAULT_ADDR is set
+const defaultVaultAddr = "http://127.0.0.1:8200"
+
+// HashiVaultLookupPlugin implements the hashi_vault lookup, which reads
+// secrets from a HashiCorp Vault compatible secrets store. Terms name the
+// secret path, optionally followed by :field, and may carry name=value
+// parameters that take precedence over the lookup options:
+//
+//	lookup('hashi_vault', 'secret/data/app:password', url='https://vault:8200')
+//	lookup('hashi_vault', 'secret=secret/data/app:password auth_method=approle role_id=... secret_id=...')
+//
+// KV version 2 responses are unwrapped to the secret's data. Login tokens
+// and secrets are cached for the run, so loops do not hit the store for
+// every item.
+type HashiVaultLookupPlugin struct {
+	*BaseLookupPlugin
+	cache *vaultCache
+}
+
+// vaultCache holds the login tokens and secrets read during a run
+type vaultCache struct {
+	mutex   sync.Mutex
+	tokens  map[string]string
+	secrets map[string]map[string]interface{}
+}
+
+func newVaultCache() *vaultCache {
+	return &vaultCache{
+		tokens:  make(map[string]string),
+		secrets: make(map[string]map[string]interface{}),
+	}
+}
+
+func NewHashiVaultLookupPlugin() *HashiVaultLookupPlugin {
+	return newHashiVaultLookupPlugin(newVaultCache())
+}
+
+func newHashiVaultLookupPlugin(cache *vaultCache) *HashiVaultLookupPlugin {
+	return &HashiVaultLookupPlugin{
+		BaseLookupPlugin: NewBaseLookupPlugin(
+			"hashi_vault",
+			"Retrieve secrets from HashiCorp's Vault",
+			"1.0.0",
+			"Ansible Project",
+		),
+		cache: cache,
+	}
+}
+
+// vaultParams are the parameters of one hashi_vault term
+type vaultParams struct {
+	url          string
+	namespace    string
+	authMethod   string
+	mountPoint   string
+	token        string
+	tokenPath    string
+	roleID       string
+	secretID     string
+	jwt          string
+	secret       string
+	field        string
+	version      string
+	returnFormat string
+	options      map[string]interface{}
+}
+
+func (h *HashiVaultLookupPlugin) Run(ctx context.Context, terms []string, variables map[string]interface{}, options map[string]interface{}) ([]interface{}, error) {
+	results := make([]interface{}, 0, len(terms))
+
+	for _, term := range terms {
+		params, err := h.parseTerm(term, options)
+		if err != nil {
+			return nil, err
+		}
+
+		client, err := newHTTPClient(params.options)
+		if err != nil {
+			return nil, err
+		}
+
+		token, err := h.login(ctx, client, params)
+		if err != nil {
+			return nil, err
+		}
+
+		response, err := h.read(ctx, client, params, token)
+		if err != nil {
+			return nil, err
+		}
+
+		data, _ := response["data"].(map[string]interface{})
+		// KV version 2 wraps the secret in data.data next to data.metadata
+		if inner, ok := data["data"].(map[string]interface{}); ok {
+			if _, ok := data["metadata"]; ok {
+				data = inner
+			}
+		}
+
+		if params.field != "" {
+			value, exists := data[params.field]
+			if !exists {
+				return nil, fmt.Errorf("the secret %s does not contain the field '%s'", params.secret, params.field)
+			}
+			results = append(results, value)
+			continue
+		}
+
+		switch params.returnFormat {
+		case "dict":
+			results = append(results, data)
+		case "values":
+			for _, key := range sortedKeys(data) {
+				results = append(results, data[key])
+			}
+		case "raw":
+			results = append(results, response)
+		}
+	}
+
+	return results, nil
+}
+
+// parseTerm reads the secret and parameters of a term over the options
+// and the VAULT_* environment variables
+func (h *HashiVaultLookupPlugin) parseTerm(term string, options map[string]interface{}) (*vaultParams, error) {
+	values := make(map[string]interface{}, len(options))
+	for name, value := range options {
+		values[name] = value
+	}
+
+	fields, err := splitTerm(term)
+	if err != nil {
+		return nil, err
+	}
+	secrets := 0
+	for _, field := range fields {
+		name, value, found := strings.Cut(field, "=")
+		if !found {
+			if secrets++; secrets > 1 {
+				return nil, fmt.Errorf("hashi_vault term '%s' names more than one secret", term)
+			}
+			values["secret"] = field
+			continue
+		}
+		values[name] = value
+	}
+
+	str := func(name string, env ...string) string {
+		if value, ok := values[name]; ok && value != nil {
+			return fmt.Sprintf("%v", value)
+		}
+		for _, key := range env {
+			if value := os.Getenv(key); value != "" {
+				return value
+			}
+		}
+		return ""
+	}
+
+	params := &vaultParams{
+		url:          strings.TrimRight(str("url", "VAULT_ADDR"), "/"),
+		namespace:    str("namespace", "VAULT_NAMESPACE"),
+		authMethod:   str("auth_method", "VAULT_AUTH_METHOD"),
+		mountPoint:   str("mount_point"),
+		token:        str("token", "VAULT_TOKEN"),
+		tokenPath:    str("token_path"),
+		roleID:       str("role_id", "VAULT_ROLE_ID"),
+		secretID:     str("secret_id", "VAULT_SECRET_ID"),
+		jwt:          str("jwt", "VAULT_JWT"),
+		secret:       str("secret"),
+		version:      str("version"),
+		returnFormat: str("return_format"),
+		options:      values,
+	}
+	if params.url == "" {
+		params.url = defaultVaultAddr
+	}
+	if params.authMethod == "" {
+		params.authMethod = "token"
+	}
+	if params.returnFormat == "" {
+		params.returnFormat = "dict"
+	}
+	if role := str("role"); params.roleID == "" {
+		params.roleID = role
+	}
+	if params.secret == "" {
+		return nil, fmt.Errorf("hashi_vault term '%s' does not name a secret", term)
+	}
+	if path, field, found := strings.Cut(params.secret, ":"); found {
+		params.secret, params.field = path, field
+	}
+	params.secret = strings.Trim(params.secret, "/")
+
+	switch params.returnFormat {
+	case "dict", "values", "raw":
+	default:
+		return nil, fmt.Errorf("return_format must be one of dict, values or raw, got '%s'", params.returnFormat)
+	}
+	return params, nil
+}
+
+// login returns the token to read secrets with, logging in with AppRole or
+// JWT credentials when asked to. Login tokens are cached per credentials.
+func (h *HashiVaultLookupPlugin) login(ctx context.Context, client *http.Client, params *vaultParams) (string, error) {
+	var body map[string]interface{}
+	switch params.authMethod {
+	case "token":
+		if params.token != "" {
+			return params.token, nil
+		}
+		// Without token_path the token file is looked for in $HOME
+		tokenPath := params.tokenPath
+		if tokenPath == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", fmt.Errorf("no vault token specified: set token, VAULT_TOKEN or token_path (cannot look for ~/.vault-token: %v)", err)
+			}
+			tokenPath = home
+		}
+		tokenFile := filepath.Join(tokenPath, ".vault-token")
+		data, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return "", fmt.Errorf("no vault token specified: set token or VAULT_TOKEN, or write one to %s (%v)", tokenFile, err)
+		}
+		if strings.TrimSpace(string(data)) == "" {
+			return "", fmt.Errorf("no vault token specified: set token or VAULT_TOKEN; %s is empty", tokenFile)
+		}
+		return strings.TrimSpace(string(data)), nil
+	case "approle":
+		if params.roleID == "" {
+			return "", fmt.Errorf("the approle auth method requires role_id")
+		}
+		body = map[string]interface{}{"role_id": params.roleID}
+		if params.secretID != "" {
+			body["secret_id"] = params.secretID
+		}
+	case "jwt":
+		if params.roleID == "" || params.jwt == "" {
+			return "", fmt.Errorf("the jwt auth method requires role_id and jwt")
+		}
+		body = map[string]interface{}{"role": params.roleID, "jwt": params.jwt}
+	default:
+		return "", fmt.Errorf("unsupported vault auth method '%s': use token, approle or jwt", params.authMethod)
+	}
+
+	mountPoint := params.mountPoint
+	if mountPoint == "" {
+		mountPoint = params.authMethod
+	}
+
+	credentials := sha256.Sum256([]byte(params.secretID + "\x00" + params.jwt))
+	cacheKey := strings.Join([]string{params.url, params.namespace, mountPoint, params.roleID, hex.EncodeToString(credentials[:])}, "|")
+
+	h.cache.mutex.Lock()
+	token, cached := h.cache.tokens[cacheKey]
+	h.cache.mutex.Unlock()
+	if cached {
+		return token, nil
+	}
+
+	response, err := vaultRequest(ctx, client, params, http.MethodPost, "auth/"+strings.Trim(mountPoint, "/")+"/login", "", body)
+	if err != nil {
+		return "", fmt.Errorf("vault %s login failed: %w", params.authMethod, err)
+	}
+	auth, _ := response["auth"].(map[string]interface{})
+	token, _ = auth["client_token"].(string)
+	if token == "" {
+		return "", fmt.Errorf("vault %s login returned no client token", params.authMethod)
+	}
+
+	h.cache.mutex.Lock()
+	h.cache.tokens[cacheKey] = token
+	h.cache.mutex.Unlock()
+	return token, nil
+}
+
+// read fetches a secret, from the cache when it was read before
+func (h *HashiVaultLookupPlugin) read(ctx context.Context, client *http.Client, params *vaultParams, token string) (map[string]interface{}, error) {
+	path := params.secret
+	if params.version != "" {
+		path += "?version=" + url.QueryEscape(params.version)
+	}
+
+	tokenHash := sha256.Sum256([]byte(token))
+	cacheKey := strings.Join([]string{params.url, params.namespace, hex.EncodeToString(tokenHash[:]), path}, "|")
+
+	h.cache.mutex.Lock()
+	response, cached := h.cache.secrets[cacheKey]
+	h.cache.mutex.Unlock()
+	if cached {
+		return response, nil
+	}
+
+	response, err := vaultRequest(ctx, client, params, http.MethodGet, path, token, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret %s: %w", params.secret, err)
+	}
+
+	h.cache.mutex.Lock()
+	h.cache.secrets[cacheKey] = response
+	h.cache.mutex.Unlock()
+	return response, nil
+}
+
+// vaultRequest sends a request to the vault API and decodes the response,
+// turning the errors list of failed requests into an error
+func vaultRequest(ctx context.Context, client *http.Client, params *vaultParams, method, path, token string, body map[string]interface{}) (map[string]interface{}, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, params.url+"/v1/"+path, reader)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vault URL %s: %v", params.url, err)
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if params.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", params.namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the vault response: %v", err)
+	}
+
+	var response map[string]interface{}
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, &response); err != nil && resp.StatusCode < 300 {
+			return nil, fmt.Errorf("invalid vault response: %v", err)
+		}
+	}
+
+	if resp.StatusCode >= 300 {
+		var messages []string
+		if errs, ok := response["errors"].([]interface{}); ok {
+			for _, e := range errs {
+				messages = append(messages, fmt.Sprintf("%v", e))
+			}
+		}
+		switch {
+		case resp.StatusCode == http.StatusNotFound && len(messages) == 0:
+			return nil, fmt.Errorf("the secret doesn't seem to exist (HTTP 404)")
+		case resp.StatusCode == http.StatusForbidden:
+			return nil, fmt.Errorf("permission denied (HTTP 403): %s", strings.Join(messages, "; "))
+		default:
+			return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.Join(messages, "; "))
+		}
+	}
+	return response, nil
+}
@@ -0,0 +1,286 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lookup
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeVault is an in-process stand-in for a vault server with a KV v2
+// engine at secret/, a KV v1 engine at kv/ and AppRole and JWT logins
+type fakeVault struct {
+	mutex    sync.Mutex
+	requests map[string]int
+	headers  http.Header
+}
+
+func (v *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.mutex.Lock()
+	v.requests[r.Method+" "+r.URL.Path]++
+	v.headers = r.Header.Clone()
+	v.mutex.Unlock()
+
+	reply := func(status int, body interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}
+
+	var login map[string]string
+	if r.Method == http.MethodPost {
+		json.NewDecoder(r.Body).Decode(&login)
+	}
+
+	switch r.URL.Path {
+	case "/v1/auth/approle/login":
+		if login["role_id"] != "app" || login["secret_id"] != "s3cret" {
+			reply(http.StatusBadRequest, map[string]interface{}{"errors": []string{"invalid role or secret ID"}})
+			return
+		}
+		reply(http.StatusOK, map[string]interface{}{"auth": map[string]interface{}{"client_token": "approle-token"}})
+		return
+	case "/v1/auth/ci-jwt/login":
+		if login["role"] != "deploy" || login["jwt"] != "eyJ.token" {
+			reply(http.StatusBadRequest, map[string]interface{}{"errors": []string{"invalid jwt"}})
+			return
+		}
+		reply(http.StatusOK, map[string]interface{}{"auth": map[string]interface{}{"client_token": "jwt-token"}})
+		return
+	}
+
+	switch r.Header.Get("X-Vault-Token") {
+	case "root", "approle-token", "jwt-token":
+	default:
+		reply(http.StatusForbidden, map[string]interface{}{"errors": []string{"permission denied"}})
+		return
+	}
+
+	switch r.URL.Path {
+	case "/v1/secret/data/app":
+		password := "hunter2"
+		if r.URL.Query().Get("version") == "1" {
+			password = "old"
+		}
+		reply(http.StatusOK, map[string]interface{}{
+			"data": map[string]interface{}{
+				"data":     map[string]interface{}{"user": "app", "password": password},
+				"metadata": map[string]interface{}{"version": 2},
+			},
+		})
+	case "/v1/kv/legacy":
+		reply(http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"api_key": "abc123"}})
+	default:
+		reply(http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+	}
+}
+
+func newFakeVault(t *testing.T) (*fakeVault, *httptest.Server, string) {
+	t.Helper()
+	vault := &fakeVault{requests: make(map[string]int)}
+	server := httptest.NewTLSServer(vault)
+	t.Cleanup(server.Close)
+
+	caCert := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caCert, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return vault, server, caCert
+}
+
+func TestHashiVaultLookup(t *testing.T) {
+	vault, server, caCert := newFakeVault(t)
+	t.Setenv("VAULT_TOKEN", "")
+	t.Setenv("VAULT_ADDR", "")
+
+	tests := []struct {
+		name     string
+		terms    []string
+		options  map[string]interface{}
+		expected []interface{}
+	}{
+		{
+			name:     "kv v2 field with token",
+			terms:    []string{"secret/data/app:password"},
+			options:  map[string]interface{}{"token": "root"},
+			expected: []interface{}{"hunter2"},
+		},
+		{
+			name:     "kv v2 dict",
+			terms:    []string{"secret/data/app"},
+			options:  map[string]interface{}{"token": "root"},
+			expected: []interface{}{map[string]interface{}{"user": "app", "password": "hunter2"}},
+		},
+		{
+			name:     "kv v2 version and values",
+			terms:    []string{"secret=secret/data/app version=1 return_format=values"},
+			options:  map[string]interface{}{"token": "root"},
+			expected: []interface{}{"old", "app"},
+		},
+		{
+			name:     "kv v1",
+			terms:    []string{"kv/legacy:api_key"},
+			options:  map[string]interface{}{"token": "root"},
+			expected: []interface{}{"abc123"},
+		},
+		{
+			name:     "approle",
+			terms:    []string{"secret/data/app:user auth_method=approle role_id=app secret_id=s3cret"},
+			expected: []interface{}{"app"},
+		},
+		{
+			name:     "jwt with a custom mount point",
+			terms:    []string{"secret/data/app:user"},
+			options:  map[string]interface{}{"auth_method": "jwt", "role_id": "deploy", "jwt": "eyJ.token", "mount_point": "ci-jwt"},
+			expected: []interface{}{"app"},
+		},
+	}
+
+	plugin := NewHashiVaultLookupPlugin()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := map[string]interface{}{"url": server.URL, "ca_cert": caCert}
+			for name, value := range test.options {
+				options[name] = value
+			}
+			result, err := plugin.Run(context.Background(), test.terms, nil, options)
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if !reflect.DeepEqual(result, test.expected) {
+				t.Errorf("Run() = %v, want %v", result, test.expected)
+			}
+		})
+	}
+
+	// The namespace is sent with every request
+	_, err := plugin.Run(context.Background(), []string{"kv/legacy"}, nil, map[string]interface{}{
+		"url": server.URL, "ca_cert": caCert, "token": "root", "namespace": "team-a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := vault.headers.Get("X-Vault-Namespace"); got != "team-a" {
+		t.Errorf("X-Vault-Namespace = %q", got)
+	}
+}
+
+func TestHashiVaultLookup_Cache(t *testing.T) {
+	vault, server, caCert := newFakeVault(t)
+	options := map[string]interface{}{
+		"url": server.URL, "ca_cert": caCert,
+		"auth_method": "approle", "role_id": "app", "secret_id": "s3cret",
+	}
+
+	// Plugins from the same registry share the cache, as in one run
+	registry := NewLookupPluginRegistry()
+	for i := 0; i < 3; i++ {
+		plugin, err := registry.Get("hashi_vault")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := plugin.Run(context.Background(), []string{"secret/data/app:password", "secret/data/app:user"}, nil, options); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := vault.requests["POST /v1/auth/approle/login"]; n != 1 {
+		t.Errorf("expected one login, got %d", n)
+	}
+	if n := vault.requests["GET /v1/secret/data/app"]; n != 1 {
+		t.Errorf("expected one read of the secret, got %d", n)
+	}
+
+	// A new registry is a new run
+	plugin, _ := NewLookupPluginRegistry().Get("hashi_vault")
+	if _, err := plugin.Run(context.Background(), []string{"secret/data/app:password"}, nil, options); err != nil {
+		t.Fatal(err)
+	}
+	if n := vault.requests["GET /v1/secret/data/app"]; n != 2 {
+		t.Errorf("expected a new run to read the secret again, got %d reads", n)
+	}
+}
+
+func TestHashiVaultLookup_Errors(t *testing.T) {
+	_, server, caCert := newFakeVault(t)
+	t.Setenv("VAULT_TOKEN", "")
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	tokenDir := t.TempDir()
+	os.WriteFile(filepath.Join(tokenDir, ".vault-token"), []byte("\n"), 0600)
+
+	tests := []struct {
+		name    string
+		term    string
+		options map[string]interface{}
+		errMsg  string
+	}{
+		{"unknown certificate authority", "kv/legacy", map[string]interface{}{"ca_cert": nil, "token": "root"}, "certificate"},
+		{"missing secret", "kv/missing", map[string]interface{}{"token": "root"}, "doesn't seem to exist"},
+		{"missing field", "kv/legacy:nope", map[string]interface{}{"token": "root"}, "does not contain the field 'nope'"},
+		{"bad token", "kv/legacy", map[string]interface{}{"token": "wrong"}, "permission denied"},
+		{"no token", "kv/legacy", nil, "no vault token"},
+		{"no token file", "kv/legacy", nil, filepath.Join(home, ".vault-token")},
+		{"empty token file", "kv/legacy", map[string]interface{}{"token_path": tokenDir}, filepath.Join(tokenDir, ".vault-token") + " is empty"},
+		{"bad approle login", "kv/legacy", map[string]interface{}{"auth_method": "approle", "role_id": "app", "secret_id": "nope"}, "invalid role or secret ID"},
+		{"unknown auth method", "kv/legacy", map[string]interface{}{"auth_method": "ldap"}, "unsupported vault auth method"},
+		{"no secret", "token=root", nil, "does not name a secret"},
+		{"bad return format", "kv/legacy return_format=yaml", map[string]interface{}{"token": "root"}, "return_format"},
+	}
+
+	plugin := NewHashiVaultLookupPlugin()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := map[string]interface{}{"url": server.URL, "ca_cert": caCert}
+			for name, value := range test.options {
+				if value == nil {
+					delete(options, name)
+					continue
+				}
+				options[name] = value
+			}
+			_, err := plugin.Run(context.Background(), []string{test.term}, nil, options)
+			if err == nil || !strings.Contains(err.Error(), test.errMsg) {
+				t.Errorf("expected error containing '%s', got %v", test.errMsg, err)
+			}
+		})
+	}
+
+	// Without HOME the error says why no token file was read
+	t.Run("no home", func(t *testing.T) {
+		t.Setenv("HOME", "")
+		_, err := plugin.Run(context.Background(), []string{"kv/legacy"}, nil, map[string]interface{}{"url": server.URL, "ca_cert": caCert})
+		if err == nil || !strings.Contains(err.Error(), "cannot look for ~/.vault-token") {
+			t.Errorf("expected the missing home directory in the error, got %v", err)
+		}
+	})
+
+	// validate_certs=false skips verification instead
+	result, err := plugin.Run(context.Background(), []string{"kv/legacy:api_key validate_certs=false"}, nil, map[string]interface{}{"url": server.URL, "token": "root"})
+	if err != nil || !reflect.DeepEqual(result, []interface{}{"abc123"}) {
+		t.Errorf("validate_certs=false: %v, %v", result, err)
+	}
+}
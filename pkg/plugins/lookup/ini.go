@@ -0,0 +1,335 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lookup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// propertiesSection is the section properties files are read into
+const propertiesSection = "java_properties"
+
+// IniLookupPlugin implements the ini lookup, which reads values from INI
+// and Java properties files. Terms are a key optionally followed by
+// name=value parameters, which take precedence over the lookup options:
+//
+//	lookup('ini', 'user section=integration file=users.ini')
+//	lookup('ini', 'user', section='integration', file='users.ini')
+type IniLookupPlugin struct {
+	*BaseLookupPlugin
+}
+
+func NewIniLookupPlugin() *IniLookupPlugin {
+	return &IniLookupPlugin{
+		BaseLookupPlugin: NewBaseLookupPlugin(
+			"ini",
+			"Read data from an INI or Java properties file",
+			"1.0.0",
+			"Ansible Project",
+		),
+	}
+}
+
+// iniParams are the parameters of one ini lookup term
+type iniParams struct {
+	key           string
+	fileType      string
+	file          string
+	section       string
+	defaultValue  string
+	regexp        bool
+	caseSensitive bool
+	allowNoValue  bool
+}
+
+func (i *IniLookupPlugin) Run(ctx context.Context, terms []string, variables map[string]interface{}, options map[string]interface{}) ([]interface{}, error) {
+	results := make([]interface{}, 0, len(terms))
+	files := make(map[string]map[string]map[string]*string)
+
+	for _, term := range terms {
+		params, err := i.parseTerm(term, options)
+		if err != nil {
+			return nil, err
+		}
+
+		path, found := findFile(variables, "files", params.file)
+		if !found {
+			return nil, fmt.Errorf("could not find ini file '%s'", params.file)
+		}
+
+		cacheKey := fmt.Sprintf("%s:%s:%t:%t", path, params.fileType, params.caseSensitive, params.allowNoValue)
+		sections, ok := files[cacheKey]
+		if !ok {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read ini file %s: %v", path, err)
+			}
+			content := string(data)
+			if params.fileType == "properties" {
+				content = "[" + propertiesSection + "]\n" + content
+			}
+			sections, err = parseIni(content, params.caseSensitive, params.allowNoValue)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse ini file %s: %v", path, err)
+			}
+			files[cacheKey] = sections
+		}
+
+		section, exists := sections[params.section]
+		if !exists {
+			return nil, fmt.Errorf("no section '%s' in ini file %s", params.section, path)
+		}
+
+		key := params.key
+		if !params.caseSensitive {
+			key = strings.ToLower(key)
+		}
+
+		if params.regexp {
+			pattern, err := regexp.Compile("^(?:" + params.key + ")")
+			if err != nil {
+				return nil, fmt.Errorf("invalid ini key regex '%s': %v", params.key, err)
+			}
+			for _, name := range sortedOptionNames(section) {
+				if pattern.MatchString(name) {
+					results = append(results, optionValue(section[name]))
+				}
+			}
+			continue
+		}
+
+		if value, exists := section[key]; exists {
+			results = append(results, optionValue(value))
+		} else {
+			results = append(results, params.defaultValue)
+		}
+	}
+
+	return results, nil
+}
+
+// parseTerm reads the key and parameters of a term over the options
+func (i *IniLookupPlugin) parseTerm(term string, options map[string]interface{}) (*iniParams, error) {
+	params := &iniParams{
+		fileType: "ini",
+		file:     "ansible.ini",
+		section:  "global",
+	}
+
+	values := make(map[string]interface{}, len(options))
+	for name, value := range options {
+		values[name] = value
+	}
+
+	fields, err := splitTerm(term)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range fields {
+		name, value, found := strings.Cut(field, "=")
+		if !found {
+			if params.key != "" {
+				return nil, fmt.Errorf("ini lookup term '%s' has more than one key", term)
+			}
+			params.key = field
+			continue
+		}
+		values[name] = value
+	}
+	if params.key == "" {
+		if key, ok := values["_raw_params"]; ok {
+			params.key = fmt.Sprintf("%v", key)
+		} else {
+			return nil, fmt.Errorf("ini lookup term '%s' has no key", term)
+		}
+	}
+
+	for name, value := range values {
+		switch name {
+		case "type":
+			params.fileType = fmt.Sprintf("%v", value)
+			if params.fileType != "ini" && params.fileType != "properties" {
+				return nil, fmt.Errorf("ini lookup type must be ini or properties, got '%s'", params.fileType)
+			}
+		case "file":
+			params.file = fmt.Sprintf("%v", value)
+		case "section":
+			params.section = fmt.Sprintf("%v", value)
+		case "default":
+			params.defaultValue = fmt.Sprintf("%v", value)
+		case "re":
+			params.regexp = isTrue(value)
+		case "case_sensitive":
+			params.caseSensitive = isTrue(value)
+		case "allow_no_value", "allow_none":
+			params.allowNoValue = isTrue(value)
+		case "encoding", "interpolation", "_raw_params":
+			// Files are read as UTF-8 and values are not interpolated
+		default:
+			return nil, fmt.Errorf("unknown ini lookup parameter '%s'", name)
+		}
+	}
+	if params.fileType == "properties" {
+		params.section = propertiesSection
+	}
+
+	return params, nil
+}
+
+// parseIni parses INI data the way Python's configparser does: "=" or ":"
+// delimiters, full-line "#" and ";" comments, indented continuation lines
+// and DEFAULT values inherited by every section. Option names are lowered
+// unless caseSensitive is set; options without a value are nil.
+func parseIni(content string, caseSensitive, allowNoValue bool) (map[string]map[string]*string, error) {
+	sections := make(map[string]map[string]*string)
+	defaults := make(map[string]*string)
+
+	var (
+		current    map[string]*string
+		lastOption *string
+		lastIndent int
+	)
+	for number, line := range strings.Split(content, "\n") {
+		line = strings.TrimRight(line, "\r")
+		trimmed := strings.TrimSpace(line)
+		indent := len(line) - len(strings.TrimLeftFunc(line, unicode.IsSpace))
+
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";") {
+			continue
+		}
+
+		// Indented lines continue the previous value
+		if lastOption != nil && indent > lastIndent {
+			*lastOption += "\n" + trimmed
+			continue
+		}
+		lastOption = nil
+
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			name := trimmed[1 : len(trimmed)-1]
+			if name == "DEFAULT" {
+				current = defaults
+			} else {
+				if _, exists := sections[name]; !exists {
+					sections[name] = make(map[string]*string)
+				}
+				current = sections[name]
+			}
+			continue
+		}
+		if current == nil {
+			return nil, fmt.Errorf("line %d: option outside of a section: %s", number+1, trimmed)
+		}
+
+		separator := strings.IndexAny(trimmed, "=:")
+		var name string
+		var value *string
+		if separator < 0 {
+			if !allowNoValue {
+				return nil, fmt.Errorf("line %d: expected name = value: %s", number+1, trimmed)
+			}
+			name = trimmed
+		} else {
+			name = strings.TrimSpace(trimmed[:separator])
+			v := strings.TrimSpace(trimmed[separator+1:])
+			value = &v
+		}
+		if !caseSensitive {
+			name = strings.ToLower(name)
+		}
+		current[name] = value
+		lastOption, lastIndent = value, indent
+	}
+
+	for _, section := range sections {
+		for name, value := range defaults {
+			if _, exists := section[name]; !exists {
+				section[name] = value
+			}
+		}
+	}
+	return sections, nil
+}
+
+// optionValue returns an option's value, nil for options without one
+func optionValue(value *string) interface{} {
+	if value == nil {
+		return nil
+	}
+	return *value
+}
+
+// sortedOptionNames returns the option names of a section in order
+func sortedOptionNames(section map[string]*string) []string {
+	values := make(map[string]interface{}, len(section))
+	for name := range section {
+		values[name] = nil
+	}
+	return sortedKeys(values)
+}
+
+// splitTerm splits a term on whitespace, honouring single and double
+// quotes and backslash escapes like a shell
+func splitTerm(term string) ([]string, error) {
+	var (
+		fields  []string
+		current strings.Builder
+		quote   rune
+		inField bool
+		escaped bool
+	)
+	for _, r := range term {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inField = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inField = true
+		case unicode.IsSpace(r):
+			if inField {
+				fields = append(fields, current.String())
+				current.Reset()
+				inField = false
+			}
+		default:
+			current.WriteRune(r)
+			inField = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in term '%s'", term)
+	}
+	if inField {
+		fields = append(fields, current.String())
+	}
+	return fields, nil
+}
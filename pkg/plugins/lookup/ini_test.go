@@ -0,0 +1,122 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lookup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testIni = `# users
+[DEFAULT]
+shell = /bin/bash
+
+[integration]
+User = alice
+password: s3cret
+motd = first line
+  second line
+; comment
+[production]
+user = "bob smith"
+user_home = /home/bob
+user_shell = /bin/zsh
+`
+
+const testProperties = `# settings
+app.name=demo
+app.port : 8080
+`
+
+func TestIniLookup(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "users.ini"), []byte(testIni), 0644)
+	os.WriteFile(filepath.Join(dir, "app.properties"), []byte(testProperties), 0644)
+	variables := map[string]interface{}{"playbook_dir": dir}
+
+	tests := []struct {
+		name     string
+		terms    []string
+		options  map[string]interface{}
+		expected []interface{}
+	}{
+		{"parameters in the term", []string{"user section=integration file=users.ini"}, nil, []interface{}{"alice"}},
+		{"parameters as options", []string{"password"}, map[string]interface{}{"section": "integration", "file": "users.ini"}, []interface{}{"s3cret"}},
+		{"continuation lines", []string{"motd section=integration file=users.ini"}, nil, []interface{}{"first line\nsecond line"}},
+		{"DEFAULT values", []string{"shell section=production file=users.ini"}, nil, []interface{}{"/bin/bash"}},
+		{"quotes are kept", []string{"user section=production file=users.ini"}, nil, []interface{}{`"bob smith"`}},
+		{"default for a missing key", []string{"missing section=production file=users.ini default='n/a'"}, nil, []interface{}{"n/a"}},
+		{"case sensitive keys", []string{"User section=integration file=users.ini case_sensitive=true"}, nil, []interface{}{"alice"}},
+		{"case sensitive miss", []string{"user section=integration file=users.ini case_sensitive=true"}, nil, []interface{}{""}},
+		{"regex keys", []string{"user_.* section=production file=users.ini re=true"}, nil, []interface{}{"/home/bob", "/bin/zsh"}},
+		{"properties", []string{"app.name type=properties file=app.properties", "app.port type=properties file=app.properties"}, nil, []interface{}{"demo", "8080"}},
+	}
+
+	plugin := NewIniLookupPlugin()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := plugin.Run(context.Background(), test.terms, variables, test.options)
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if !reflect.DeepEqual(result, test.expected) {
+				t.Errorf("Run() = %q, want %q", result, test.expected)
+			}
+		})
+	}
+}
+
+func TestIniLookup_Errors(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "users.ini"), []byte(testIni), 0644)
+	os.WriteFile(filepath.Join(dir, "bad.ini"), []byte("[s]\nno value here\n"), 0644)
+	variables := map[string]interface{}{"playbook_dir": dir}
+
+	tests := []struct {
+		term   string
+		errMsg string
+	}{
+		{"user section=staging file=users.ini", "no section 'staging'"},
+		{"user file=missing.ini", "could not find ini file"},
+		{"user file=users.ini type=xml", "ini or properties"},
+		{"user file=users.ini colour=blue", "unknown ini lookup parameter"},
+		{"user section=s file=bad.ini", "line 2"},
+		{"user file='users.ini", "unterminated quote"},
+	}
+
+	plugin := NewIniLookupPlugin()
+	for _, test := range tests {
+		_, err := plugin.Run(context.Background(), []string{test.term}, variables, nil)
+		if err == nil || !strings.Contains(err.Error(), test.errMsg) {
+			t.Errorf("%s: expected error containing '%s', got %v", test.term, test.errMsg, err)
+		}
+	}
+
+	// allow_no_value accepts options without a value
+	result, err := plugin.Run(context.Background(), []string{"no value here section=s file=bad.ini allow_no_value=true"}, variables, nil)
+	if err == nil {
+		t.Errorf("expected a term with several keys to fail, got %v", result)
+	}
+	result, err = plugin.Run(context.Background(), []string{`"no value here" section=s file=bad.ini allow_no_value=true`}, variables, nil)
+	if err != nil || !reflect.DeepEqual(result, []interface{}{nil}) {
+		t.Errorf("allow_no_value: got %v, %v", result, err)
+	}
+}
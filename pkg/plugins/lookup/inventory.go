@@ -0,0 +1,129 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lookup
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/spf13/afero"
+	"github.com/work-obs/ansible-go/pkg/inventory"
+)
+
+// InventoryHostnamesLookupPlugin implements the inventory_hostnames lookup,
+// which returns the hosts matching inventory patterns. Like Ansible, it
+// builds the inventory from the groups variable.
+type InventoryHostnamesLookupPlugin struct {
+	*BaseLookupPlugin
+}
+
+func NewInventoryHostnamesLookupPlugin() *InventoryHostnamesLookupPlugin {
+	return &InventoryHostnamesLookupPlugin{
+		BaseLookupPlugin: NewBaseLookupPlugin(
+			"inventory_hostnames",
+			"List of inventory hosts matching a host pattern",
+			"1.0.0",
+			"Ansible Project",
+		),
+	}
+}
+
+func (i *InventoryHostnamesLookupPlugin) Run(ctx context.Context, terms []string, variables map[string]interface{}, options map[string]interface{}) ([]interface{}, error) {
+	groups, err := groupsVariable(variables)
+	if err != nil {
+		return nil, err
+	}
+
+	// Hosts keep the order of the all group, then of the other groups
+	groupNames := make([]string, 0, len(groups))
+	for name := range groups {
+		if name != "all" {
+			groupNames = append(groupNames, name)
+		}
+	}
+	sort.Strings(groupNames)
+	groupNames = append([]string{"all"}, groupNames...)
+
+	manager := inventory.NewManager(afero.NewMemMapFs())
+	inv := manager.GetInventory()
+	order := make(map[string]int)
+	for _, groupName := range groupNames {
+		hosts, exists := groups[groupName]
+		if !exists {
+			continue
+		}
+		group := inv.GetOrCreateGroup(groupName)
+		for _, hostName := range hosts {
+			if _, exists := inv.Hosts[hostName]; !exists {
+				inv.Hosts[hostName] = &inventory.Host{
+					Name:      hostName,
+					Variables: make(map[string]interface{}),
+					Groups:    make([]string, 0),
+				}
+				order[hostName] = len(order)
+			}
+			group.AddHost(hostName)
+		}
+	}
+
+	var names []string
+	seen := make(map[string]bool)
+	for _, term := range terms {
+		hosts, err := manager.GetHosts(term)
+		if err != nil {
+			return nil, err
+		}
+		for _, host := range hosts {
+			if !seen[host.Name] {
+				seen[host.Name] = true
+				names = append(names, host.Name)
+			}
+		}
+	}
+	sort.SliceStable(names, func(a, b int) bool { return order[names[a]] < order[names[b]] })
+
+	results := make([]interface{}, len(names))
+	for index, name := range names {
+		results[index] = name
+	}
+	return results, nil
+}
+
+// groupsVariable reads the groups magic variable
+func groupsVariable(variables map[string]interface{}) (map[string][]string, error) {
+	value, err := variable(variables, "groups")
+	if err != nil {
+		return nil, err
+	}
+	switch groups := value.(type) {
+	case map[string][]string:
+		return groups, nil
+	case map[string]interface{}:
+		result := make(map[string][]string, len(groups))
+		for name, hosts := range groups {
+			for _, host := range listTerm(hosts) {
+				result[name] = append(result[name], fmt.Sprintf("%v", host))
+			}
+		}
+		return result, nil
+	case nil:
+		return map[string][]string{}, nil
+	default:
+		return nil, fmt.Errorf("the groups variable must be a dict of host lists, got %T", groups)
+	}
+}
@@ -0,0 +1,451 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lookup
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// This file holds the lookups behind the with_<name> loops that reshape
+// lists and dictionaries. They implement ListLookupPlugin so that templates
+// hand them structured terms.
+
+// DictLookupPlugin implements the dict lookup, which turns dictionaries
+// into lists of {key, value} items
+type DictLookupPlugin struct {
+	*BaseLookupPlugin
+}
+
+func NewDictLookupPlugin() *DictLookupPlugin {
+	return &DictLookupPlugin{
+		BaseLookupPlugin: NewBaseLookupPlugin(
+			"dict",
+			"Return key/value pair items from dictionaries",
+			"1.0.0",
+			"Ansible Project",
+		),
+	}
+}
+
+func (d *DictLookupPlugin) Run(ctx context.Context, terms []string, variables map[string]interface{}, options map[string]interface{}) ([]interface{}, error) {
+	return d.RunList(ctx, stringTerms(terms), variables, options)
+}
+
+func (d *DictLookupPlugin) RunList(ctx context.Context, terms []interface{}, variables map[string]interface{}, options map[string]interface{}) ([]interface{}, error) {
+	results := make([]interface{}, 0)
+
+	for _, term := range terms {
+		dict, ok := mapTerm(term)
+		if !ok {
+			return nil, fmt.Errorf("with_dict expects a dict, got %T", term)
+		}
+		for _, key := range sortedKeys(dict) {
+			results = append(results, map[string]interface{}{"key": key, "value": dict[key]})
+		}
+	}
+
+	return results, nil
+}
+
+// ItemsLookupPlugin implements the items lookup, which flattens its terms
+// one level
+type ItemsLookupPlugin struct {
+	*BaseLookupPlugin
+}
+
+func NewItemsLookupPlugin() *ItemsLookupPlugin {
+	return &ItemsLookupPlugin{
+		BaseLookupPlugin: NewBaseLookupPlugin(
+			"items",
+			"List of items",
+			"1.0.0",
+			"Ansible Project",
+		),
+	}
+}
+
+func (i *ItemsLookupPlugin) Run(ctx context.Context, terms []string, variables map[string]interface{}, options map[string]interface{}) ([]interface{}, error) {
+	return i.RunList(ctx, stringTerms(terms), variables, options)
+}
+
+func (i *ItemsLookupPlugin) RunList(ctx context.Context, terms []interface{}, variables map[string]interface{}, options map[string]interface{}) ([]interface{}, error) {
+	return flattenTerms(terms), nil
+}
+
+// IndexedItemsLookupPlugin implements the indexed_items lookup, which
+// pairs each item with its index
+type IndexedItemsLookupPlugin struct {
+	*BaseLookupPlugin
+}
+
+func NewIndexedItemsLookupPlugin() *IndexedItemsLookupPlugin {
+	return &IndexedItemsLookupPlugin{
+		BaseLookupPlugin: NewBaseLookupPlugin(
+			"indexed_items",
+			"Rewrite lists to return indexed items",
+			"1.0.0",
+			"Ansible Project",
+		),
+	}
+}
+
+func (i *IndexedItemsLookupPlugin) Run(ctx context.Context, terms []string, variables map[string]interface{}, options map[string]interface{}) ([]interface{}, error) {
+	return i.RunList(ctx, stringTerms(terms), variables, options)
+}
+
+func (i *IndexedItemsLookupPlugin) RunList(ctx context.Context, terms []interface{}, variables map[string]interface{}, options map[string]interface{}) ([]interface{}, error) {
+	items := flattenTerms(terms)
+	results := make([]interface{}, len(items))
+	for index, item := range items {
+		results[index] = []interface{}{index, item}
+	}
+	return results, nil
+}
+
+// NestedLookupPlugin implements the nested and cartesian lookups, which
+// return every combination of the items of their lists
+type NestedLookupPlugin struct {
+	*BaseLookupPlugin
+}
+
+func NewNestedLookupPlugin() *NestedLookupPlugin {
+	return &NestedLookupPlugin{
+		BaseLookupPlugin: NewBaseLookupPlugin(
+			"nested",
+			"Composes a list with nested elements of other lists",
+			"1.0.0",
+			"Ansible Project",
+		),
+	}
+}
+
+func NewCartesianLookupPlugin() *NestedLookupPlugin {
+	return &NestedLookupPlugin{
+		BaseLookupPlugin: NewBaseLookupPlugin(
+			"cartesian",
+			"Returns the cartesian product of lists",
+			"1.0.0",
+			"Ansible Project",
+		),
+	}
+}
+
+func (n *NestedLookupPlugin) Run(ctx context.Context, terms []string, variables map[string]interface{}, options map[string]interface{}) ([]interface{}, error) {
+	return n.RunList(ctx, stringTerms(terms), variables, options)
+}
+
+func (n *NestedLookupPlugin) RunList(ctx context.Context, terms []interface{}, variables map[string]interface{}, options map[string]interface{}) ([]interface{}, error) {
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("with_%s requires at least one element in the nested list", n.name)
+	}
+
+	// Each combination is flattened one level, so list items spread into
+	// the combination like they do in Ansible
+	combinations := [][]interface{}{{}}
+	for _, term := range terms {
+		var next [][]interface{}
+		for _, combination := range combinations {
+			for _, item := range listTerm(term) {
+				extended := append(append([]interface{}{}, combination...), listTerm(item)...)
+				next = append(next, extended)
+			}
+		}
+		combinations = next
+	}
+
+	results := make([]interface{}, len(combinations))
+	for i, combination := range combinations {
+		results[i] = combination
+	}
+	return results, nil
+}
+
+// TogetherLookupPlugin implements the together lookup, which zips lists,
+// filling the shorter ones with nil
+type TogetherLookupPlugin struct {
+	*BaseLookupPlugin
+}
+
+func NewTogetherLookupPlugin() *TogetherLookupPlugin {
+	return &TogetherLookupPlugin{
+		BaseLookupPlugin: NewBaseLookupPlugin(
+			"together",
+			"Merges lists into synchronized list",
+			"1.0.0",
+			"Ansible Project",
+		),
+	}
+}
+
+func (t *TogetherLookupPlugin) Run(ctx context.Context, terms []string, variables map[string]interface{}, options map[string]interface{}) ([]interface{}, error) {
+	return t.RunList(ctx, stringTerms(terms), variables, options)
+}
+
+func (t *TogetherLookupPlugin) RunList(ctx context.Context, terms []interface{}, variables map[string]interface{}, options map[string]interface{}) ([]interface{}, error) {
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("with_together requires at least one element in each list")
+	}
+
+	lists := make([][]interface{}, len(terms))
+	longest := 0
+	for i, term := range terms {
+		lists[i] = listTerm(term)
+		longest = max(longest, len(lists[i]))
+	}
+
+	results := make([]interface{}, longest)
+	for i := range results {
+		row := make([]interface{}, len(lists))
+		for j, list := range lists {
+			if i < len(list) {
+				row[j] = list[i]
+			}
+		}
+		results[i] = row
+	}
+	return results, nil
+}
+
+// SubelementsLookupPlugin implements the subelements lookup. Its terms are
+// a list (or dictionary) of dictionaries, the dotted path of a list in each
+// of them and optionally {skip_missing: true}; it returns an [item,
+// subelement] pair per subelement.
+type SubelementsLookupPlugin struct {
+	*BaseLookupPlugin
+}
+
+func NewSubelementsLookupPlugin() *SubelementsLookupPlugin {
+	return &SubelementsLookupPlugin{
+		BaseLookupPlugin: NewBaseLookupPlugin(
+			"subelements",
+			"Traverse nested key from a list of dictionaries",
+			"1.0.0",
+			"Ansible Project",
+		),
+	}
+}
+
+func (s *SubelementsLookupPlugin) Run(ctx context.Context, terms []string, variables map[string]interface{}, options map[string]interface{}) ([]interface{}, error) {
+	return s.RunList(ctx, stringTerms(terms), variables, options)
+}
+
+func (s *SubelementsLookupPlugin) RunList(ctx context.Context, terms []interface{}, variables map[string]interface{}, options map[string]interface{}) ([]interface{}, error) {
+	if len(terms) < 2 || len(terms) > 3 {
+		return nil, fmt.Errorf("subelements lookup expects a list of two or three items: the list, the subkey and optional flags")
+	}
+
+	skipMissing := false
+	if value, ok := options["skip_missing"]; ok {
+		skipMissing = isTrue(value)
+	}
+	if len(terms) == 3 {
+		flags, ok := mapTerm(terms[2])
+		if !ok {
+			return nil, fmt.Errorf("the optional third item of subelements must be a dict with flags skip_missing")
+		}
+		for flag, value := range flags {
+			if flag != "skip_missing" {
+				return nil, fmt.Errorf("the optional third item of subelements must be a dict with flags skip_missing, got '%s'", flag)
+			}
+			skipMissing = isTrue(value)
+		}
+	}
+
+	subkey, ok := terms[1].(string)
+	if !ok || subkey == "" {
+		return nil, fmt.Errorf("the second item of subelements must be a string naming the subkey")
+	}
+	path := strings.Split(subkey, ".")
+
+	// A dictionary is iterated by value; a skipped task result yields nothing
+	var elements []interface{}
+	if dict, ok := mapTerm(terms[0]); ok {
+		if isTrue(dict["skipped"]) {
+			return []interface{}{}, nil
+		}
+		for _, key := range sortedKeys(dict) {
+			elements = append(elements, dict[key])
+		}
+	} else if list, ok := asList(terms[0]); ok {
+		elements = list
+	} else {
+		return nil, fmt.Errorf("the first item of subelements must be a list or a dict, got %T", terms[0])
+	}
+
+	results := make([]interface{}, 0)
+elements:
+	for _, element := range elements {
+		item, ok := mapTerm(element)
+		if !ok {
+			return nil, fmt.Errorf("subelements lookup expects a dictionary, got '%v'", element)
+		}
+		if isTrue(item["skipped"]) {
+			continue
+		}
+
+		value := interface{}(item)
+		for i, key := range path {
+			dict, ok := mapTerm(value)
+			if !ok {
+				return nil, fmt.Errorf("the key %s should point to a dictionary, got '%v'", path[i-1], value)
+			}
+			next, exists := dict[key]
+			if !exists {
+				if skipMissing {
+					continue elements
+				}
+				return nil, fmt.Errorf("could not find '%s' key in iterated item '%v'", key, element)
+			}
+			value = next
+		}
+
+		subelements, ok := asList(value)
+		if !ok {
+			return nil, fmt.Errorf("the key %s should point to a list, got '%v'", path[len(path)-1], value)
+		}
+		for _, subelement := range subelements {
+			results = append(results, []interface{}{element, subelement})
+		}
+	}
+
+	return results, nil
+}
+
+// RandomChoiceLookupPlugin implements the random_choice lookup
+type RandomChoiceLookupPlugin struct {
+	*BaseLookupPlugin
+}
+
+func NewRandomChoiceLookupPlugin() *RandomChoiceLookupPlugin {
+	return &RandomChoiceLookupPlugin{
+		BaseLookupPlugin: NewBaseLookupPlugin(
+			"random_choice",
+			"Return a random element from a list",
+			"1.0.0",
+			"Ansible Project",
+		),
+	}
+}
+
+func (r *RandomChoiceLookupPlugin) Run(ctx context.Context, terms []string, variables map[string]interface{}, options map[string]interface{}) ([]interface{}, error) {
+	return r.RunList(ctx, stringTerms(terms), variables, options)
+}
+
+func (r *RandomChoiceLookupPlugin) RunList(ctx context.Context, terms []interface{}, variables map[string]interface{}, options map[string]interface{}) ([]interface{}, error) {
+	items := flattenTerms(terms)
+	if len(items) == 0 {
+		return []interface{}{}, nil
+	}
+	return []interface{}{items[rand.Intn(len(items))]}, nil
+}
+
+// stringTerms converts string terms to the terms RunList takes
+func stringTerms(terms []string) []interface{} {
+	result := make([]interface{}, len(terms))
+	for i, term := range terms {
+		result[i] = term
+	}
+	return result
+}
+
+// flattenTerms flattens the terms one level, like with_items
+func flattenTerms(terms []interface{}) []interface{} {
+	result := make([]interface{}, 0, len(terms))
+	for _, term := range terms {
+		result = append(result, listTerm(term)...)
+	}
+	return result
+}
+
+// listTerm returns the items of a list term, or the term as a single item
+func listTerm(term interface{}) []interface{} {
+	if list, ok := asList(term); ok {
+		return list
+	}
+	return []interface{}{term}
+}
+
+// asList converts slices and arrays of any element type to []interface{}
+func asList(value interface{}) ([]interface{}, bool) {
+	switch v := value.(type) {
+	case []interface{}:
+		return v, true
+	case []string:
+		return stringTerms(v), true
+	case nil, string:
+		return nil, false
+	}
+
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, false
+	}
+	result := make([]interface{}, rv.Len())
+	for i := range result {
+		result[i] = rv.Index(i).Interface()
+	}
+	return result, true
+}
+
+// mapTerm converts maps of any key type to map[string]interface{}
+func mapTerm(value interface{}) (map[string]interface{}, bool) {
+	if m, ok := value.(map[string]interface{}); ok {
+		return m, true
+	}
+
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Map {
+		return nil, false
+	}
+	result := make(map[string]interface{}, rv.Len())
+	iter := rv.MapRange()
+	for iter.Next() {
+		result[fmt.Sprintf("%v", iter.Key().Interface())] = iter.Value().Interface()
+	}
+	return result, true
+}
+
+// sortedKeys returns the keys of a map in order, for deterministic loops
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// isTrue interprets booleans and the strings Ansible accepts as true
+func isTrue(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		switch strings.ToLower(v) {
+		case "true", "yes", "on", "1":
+			return true
+		}
+	case int:
+		return v != 0
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lookup
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestListLookups(t *testing.T) {
+	users := []interface{}{
+		map[string]interface{}{"name": "alice", "keys": []interface{}{"k1", "k2"}},
+		map[string]interface{}{"name": "bob", "keys": []interface{}{"k3"}},
+		map[string]interface{}{"name": "carol"},
+		map[string]interface{}{"name": "dave", "skipped": true},
+	}
+
+	tests := []struct {
+		name     string
+		plugin   ListLookupPlugin
+		terms    []interface{}
+		options  map[string]interface{}
+		expected []interface{}
+	}{
+		{
+			name:   "dict",
+			plugin: NewDictLookupPlugin(),
+			terms:  []interface{}{map[string]interface{}{"b": 2, "a": 1}},
+			expected: []interface{}{
+				map[string]interface{}{"key": "a", "value": 1},
+				map[string]interface{}{"key": "b", "value": 2},
+			},
+		},
+		{
+			name:     "items flattens one level",
+			plugin:   NewItemsLookupPlugin(),
+			terms:    []interface{}{[]interface{}{"a", []interface{}{"b"}}, "c"},
+			expected: []interface{}{"a", []interface{}{"b"}, "c"},
+		},
+		{
+			name:     "indexed_items",
+			plugin:   NewIndexedItemsLookupPlugin(),
+			terms:    []interface{}{[]interface{}{"a", "b"}},
+			expected: []interface{}{[]interface{}{0, "a"}, []interface{}{1, "b"}},
+		},
+		{
+			name:   "nested",
+			plugin: NewNestedLookupPlugin(),
+			terms:  []interface{}{[]interface{}{"alice", "bob"}, []string{"db1", "db2"}},
+			expected: []interface{}{
+				[]interface{}{"alice", "db1"}, []interface{}{"alice", "db2"},
+				[]interface{}{"bob", "db1"}, []interface{}{"bob", "db2"},
+			},
+		},
+		{
+			name:     "cartesian with a scalar term",
+			plugin:   NewCartesianLookupPlugin(),
+			terms:    []interface{}{[]interface{}{"a", "b"}, "x"},
+			expected: []interface{}{[]interface{}{"a", "x"}, []interface{}{"b", "x"}},
+		},
+		{
+			name:     "together fills with nil",
+			plugin:   NewTogetherLookupPlugin(),
+			terms:    []interface{}{[]interface{}{"a", "b", "c"}, []interface{}{1, 2}},
+			expected: []interface{}{[]interface{}{"a", 1}, []interface{}{"b", 2}, []interface{}{"c", nil}},
+		},
+		{
+			name:   "subelements skip_missing",
+			plugin: NewSubelementsLookupPlugin(),
+			terms:  []interface{}{users, "keys", map[string]interface{}{"skip_missing": true}},
+			expected: []interface{}{
+				[]interface{}{users[0], "k1"},
+				[]interface{}{users[0], "k2"},
+				[]interface{}{users[1], "k3"},
+			},
+		},
+		{
+			name:    "subelements dotted path and option",
+			plugin:  NewSubelementsLookupPlugin(),
+			terms:   []interface{}{map[string]interface{}{"x": map[string]interface{}{"a": map[string]interface{}{"b": []interface{}{1}}}}, "a.b"},
+			options: map[string]interface{}{"skip_missing": true},
+			expected: []interface{}{
+				[]interface{}{map[string]interface{}{"a": map[string]interface{}{"b": []interface{}{1}}}, 1},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := test.plugin.RunList(context.Background(), test.terms, nil, test.options)
+			if err != nil {
+				t.Fatalf("RunList() error = %v", err)
+			}
+			if !reflect.DeepEqual(result, test.expected) {
+				t.Errorf("RunList() = %v, want %v", result, test.expected)
+			}
+		})
+	}
+}
+
+func TestListLookups_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		plugin ListLookupPlugin
+		terms  []interface{}
+		errMsg string
+	}{
+		{"dict of a list", NewDictLookupPlugin(), []interface{}{[]interface{}{1}}, "expects a dict"},
+		{"nested without terms", NewNestedLookupPlugin(), nil, "at least one element"},
+		{"together without terms", NewTogetherLookupPlugin(), nil, "at least one element"},
+		{"subelements missing key", NewSubelementsLookupPlugin(), []interface{}{[]interface{}{map[string]interface{}{"name": "x"}}, "keys"}, "could not find 'keys'"},
+		{"subelements not a list", NewSubelementsLookupPlugin(), []interface{}{[]interface{}{map[string]interface{}{"keys": "k"}}, "keys"}, "should point to a list"},
+		{"subelements bad flags", NewSubelementsLookupPlugin(), []interface{}{[]interface{}{}, "keys", map[string]interface{}{"skip": true}}, "skip_missing"},
+		{"subelements of strings", NewSubelementsLookupPlugin(), []interface{}{[]interface{}{"x"}, "keys"}, "expects a dictionary"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := test.plugin.RunList(context.Background(), test.terms, nil, nil)
+			if err == nil || !strings.Contains(err.Error(), test.errMsg) {
+				t.Errorf("expected error containing '%s', got %v", test.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestRandomChoiceLookup(t *testing.T) {
+	plugin := NewRandomChoiceLookupPlugin()
+
+	result, err := plugin.Run(context.Background(), []string{"a", "b", "c"}, nil, nil)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(result) != 1 || !strings.Contains("abc", result[0].(string)) {
+		t.Errorf("Run() = %v", result)
+	}
+
+	result, err = plugin.RunList(context.Background(), nil, nil, nil)
+	if err != nil || len(result) != 0 {
+		t.Errorf("RunList(nil) = %v, %v", result, err)
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"bufio"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/csv"
 	"fmt"
 	"io"
@@ -28,8 +29,10 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/work-obs/ansible-go/pkg/plugins"
+	"github.com/work-obs/ansible-go/pkg/template"
 )
 
 // LookupPlugin interface for lookup plugins
@@ -38,6 +41,14 @@ type LookupPlugin interface {
 	Run(ctx context.Context, terms []string, variables map[string]interface{}, options map[string]interface{}) ([]interface{}, error)
 }
 
+// ListLookupPlugin is implemented by lookups that work on structured terms,
+// such as dict and nested, rather than on strings. Templates pass them the
+// terms as given instead of flattening them to strings.
+type ListLookupPlugin interface {
+	LookupPlugin
+	RunList(ctx context.Context, terms []interface{}, variables map[string]interface{}, options map[string]interface{}) ([]interface{}, error)
+}
+
 // BaseLookupPlugin provides common functionality for lookup plugins
 type BaseLookupPlugin struct {
 	name        string
@@ -73,6 +84,75 @@ func (l *BaseLookupPlugin) GetInfo() *plugins.PluginInfo {
 	}
 }
 
+// variable returns the named variable, resolving it if it is lazy. Lookups
+// read variables through it so only the variables they use are resolved.
+func variable(variables map[string]interface{}, name string) (interface{}, error) {
+	return template.ResolveVariable(variables[name])
+}
+
+// searchPaths returns the directories relative lookup terms are resolved
+// against: ansible_search_path when set, otherwise the role and playbook
+// directories
+func searchPaths(variables map[string]interface{}) []string {
+	var paths []string
+
+	// The search path variables are set by the play, not templated, so
+	// one that fails to resolve is treated as unset
+	searchPath, _ := variable(variables, "ansible_search_path")
+	switch v := searchPath.(type) {
+	case []string:
+		paths = append(paths, v...)
+	case []interface{}:
+		for _, p := range v {
+			paths = append(paths, fmt.Sprintf("%v", p))
+		}
+	case string:
+		paths = append(paths, v)
+	}
+
+	if len(paths) == 0 {
+		for _, key := range []string{"role_path", "playbook_dir"} {
+			value, _ := variable(variables, key)
+			if dir, ok := value.(string); ok && dir != "" {
+				paths = append(paths, dir)
+			}
+		}
+	}
+
+	return paths
+}
+
+// findFile locates a lookup term in the search path, trying
+// <path>/<subdir>/<term> before <path>/<term>. Absolute terms and terms
+// not found in the search path are returned relative to the working directory.
+func findFile(variables map[string]interface{}, subdir, term string) (string, bool) {
+	if strings.HasPrefix(term, "~/") {
+		if home := os.Getenv("HOME"); home != "" {
+			term = filepath.Join(home, term[2:])
+		}
+	}
+
+	if filepath.IsAbs(term) {
+		_, err := os.Stat(term)
+		return term, err == nil
+	}
+
+	for _, dir := range searchPaths(variables) {
+		candidates := []string{filepath.Join(dir, term)}
+		if subdir != "" {
+			candidates = append([]string{filepath.Join(dir, subdir, term)}, candidates...)
+		}
+		for _, candidate := range candidates {
+			if _, err := os.Stat(candidate); err == nil {
+				return candidate, true
+			}
+		}
+	}
+
+	_, err := os.Stat(term)
+	return term, err == nil
+}
+
 // FileLookupPlugin implements file content lookup
 type FileLookupPlugin struct {
 	*BaseLookupPlugin
@@ -93,8 +173,7 @@ func (f *FileLookupPlugin) Run(ctx context.Context, terms []string, variables ma
 	results := make([]interface{}, 0, len(terms))
 
 	for _, term := range terms {
-		// Support template expansion
-		filepath := f.expandPath(term, variables)
+		filepath, _ := findFile(variables, "files", term)
 
 		content, err := os.ReadFile(filepath)
 		if err != nil {
@@ -117,16 +196,6 @@ func (f *FileLookupPlugin) Run(ctx context.Context, terms []string, variables ma
 	return results, nil
 }
 
-func (f *FileLookupPlugin) expandPath(path string, variables map[string]interface{}) string {
-	// Simple variable expansion - in real implementation would use template engine
-	if strings.HasPrefix(path, "~/") {
-		if home := os.Getenv("HOME"); home != "" {
-			path = filepath.Join(home, path[2:])
-		}
-	}
-	return path
-}
-
 // EnvLookupPlugin implements environment variable lookup
 type EnvLookupPlugin struct {
 	*BaseLookupPlugin
@@ -209,7 +278,14 @@ func (f *FileGlobLookupPlugin) Run(ctx context.Context, terms []string, variable
 	results := make([]interface{}, 0)
 
 	for _, term := range terms {
-		matches, err := filepath.Glob(term)
+		pattern := term
+		if !filepath.IsAbs(term) {
+			if dir, found := findFile(variables, "files", filepath.Dir(term)); found {
+				pattern = filepath.Join(dir, filepath.Base(term))
+			}
+		}
+
+		matches, err := filepath.Glob(pattern)
 		if err != nil {
 			return nil, fmt.Errorf("invalid glob pattern %s: %v", term, err)
 		}
@@ -255,8 +331,8 @@ func (f *FirstFoundLookupPlugin) Run(ctx context.Context, terms []string, variab
 
 	// Check each path
 	for _, path := range paths {
-		if _, err := os.Stat(path); err == nil {
-			return []interface{}{path}, nil
+		if found, ok := findFile(variables, "files", path); ok {
+			return []interface{}{found}, nil
 		}
 	}
 
@@ -288,7 +364,8 @@ func (l *LinesLookupPlugin) Run(ctx context.Context, terms []string, variables m
 	results := make([]interface{}, 0)
 
 	for _, term := range terms {
-		file, err := os.Open(term)
+		path, _ := findFile(variables, "files", term)
+		file, err := os.Open(path)
 		if err != nil {
 			return nil, fmt.Errorf("failed to open file %s: %v", term, err)
 		}
@@ -332,14 +409,9 @@ func (u *URLLookupPlugin) Run(ctx context.Context, terms []string, variables map
 	results := make([]interface{}, 0, len(terms))
 
 	// Create HTTP client with options
-	client := &http.Client{}
-
-	// Handle TLS validation options
-	if validate, ok := options["validate_certs"].(bool); ok && !validate {
-		tr := &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		}
-		client.Transport = tr
+	client, err := newHTTPClient(options)
+	if err != nil {
+		return nil, err
 	}
 
 	for _, term := range terms {
@@ -372,6 +444,44 @@ func (u *URLLookupPlugin) Run(ctx context.Context, terms []string, variables map
 	return results, nil
 }
 
+// newHTTPClient creates the HTTP client of the url and hashi_vault lookups.
+// It honours validate_certs, ca_cert (a PEM bundle to trust) and timeout
+// (seconds).
+func newHTTPClient(options map[string]interface{}) (*http.Client, error) {
+	client := &http.Client{}
+	tlsConfig := &tls.Config{}
+
+	// Handle TLS validation options
+	if validate, ok := options["validate_certs"]; ok && !isTrue(validate) {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	if caCert, ok := options["ca_cert"].(string); ok && caCert != "" {
+		pem, err := os.ReadFile(caCert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate %s: %v", caCert, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caCert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	client.Transport = &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}
+
+	if timeout, ok := options["timeout"]; ok {
+		seconds, err := strconv.ParseFloat(fmt.Sprintf("%v", timeout), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout '%v'", timeout)
+		}
+		client.Timeout = time.Duration(seconds * float64(time.Second))
+	}
+
+	return client, nil
+}
+
 // PasswordLookupPlugin implements password generation/retrieval
 type PasswordLookupPlugin struct {
 	*BaseLookupPlugin
@@ -563,6 +673,7 @@ func (c *CSVFileLookupPlugin) Run(ctx context.Context, terms []string, variables
 	for _, term := range terms {
 		// Parse term: "key file=path.csv delimiter=, col=1"
 		key, filename, col, delimiter := c.parseCSVTerm(term)
+		filename, _ = findFile(variables, "files", filename)
 
 		file, err := os.Open(filename)
 		if err != nil {
@@ -630,6 +741,7 @@ func NewLookupPluginRegistry() *LookupPluginRegistry {
 	registry := &LookupPluginRegistry{
 		plugins: make(map[string]func() LookupPlugin),
 	}
+	vaultCache := newVaultCache()
 
 	// Register built-in lookup plugins
 	registry.Register("file", func() LookupPlugin { return NewFileLookupPlugin() })
@@ -642,6 +754,21 @@ func NewLookupPluginRegistry() *LookupPluginRegistry {
 	registry.Register("password", func() LookupPlugin { return NewPasswordLookupPlugin() })
 	registry.Register("sequence", func() LookupPlugin { return NewSequenceLookupPlugin() })
 	registry.Register("csvfile", func() LookupPlugin { return NewCSVFileLookupPlugin() })
+	registry.Register("template", func() LookupPlugin { return NewTemplateLookupPlugin(nil) })
+	registry.Register("vars", func() LookupPlugin { return NewVarsLookupPlugin() })
+	registry.Register("varnames", func() LookupPlugin { return NewVarnamesLookupPlugin() })
+	registry.Register("dict", func() LookupPlugin { return NewDictLookupPlugin() })
+	registry.Register("items", func() LookupPlugin { return NewItemsLookupPlugin() })
+	registry.Register("indexed_items", func() LookupPlugin { return NewIndexedItemsLookupPlugin() })
+	registry.Register("nested", func() LookupPlugin { return NewNestedLookupPlugin() })
+	registry.Register("cartesian", func() LookupPlugin { return NewCartesianLookupPlugin() })
+	registry.Register("subelements", func() LookupPlugin { return NewSubelementsLookupPlugin() })
+	registry.Register("together", func() LookupPlugin { return NewTogetherLookupPlugin() })
+	registry.Register("ini", func() LookupPlugin { return NewIniLookupPlugin() })
+	registry.Register("inventory_hostnames", func() LookupPlugin { return NewInventoryHostnamesLookupPlugin() })
+	registry.Register("config", func() LookupPlugin { return NewConfigLookupPlugin(nil) })
+	registry.Register("random_choice", func() LookupPlugin { return NewRandomChoiceLookupPlugin() })
+	registry.Register("hashi_vault", func() LookupPlugin { return newHashiVaultLookupPlugin(vaultCache) })
 
 	return registry
 }
@@ -0,0 +1,202 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lookup
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/work-obs/ansible-go/pkg/template"
+)
+
+// TemplateLookupPlugin implements the template lookup, which renders
+// template files with the current variables
+type TemplateLookupPlugin struct {
+	*BaseLookupPlugin
+	engine *template.Engine
+}
+
+// NewTemplateLookupPlugin creates a template lookup rendering with engine,
+// so templates see the same filters and lookups as the caller. A nil
+// engine is replaced by a new one.
+func NewTemplateLookupPlugin(engine *template.Engine) *TemplateLookupPlugin {
+	if engine == nil {
+		engine = template.NewEngine()
+	}
+	return &TemplateLookupPlugin{
+		BaseLookupPlugin: NewBaseLookupPlugin(
+			"template",
+			"Retrieve contents of file after templating with Jinja2",
+			"1.0.0",
+			"Ansible Project",
+		),
+		engine: engine,
+	}
+}
+
+func (t *TemplateLookupPlugin) Run(ctx context.Context, terms []string, variables map[string]interface{}, options map[string]interface{}) ([]interface{}, error) {
+	results := make([]interface{}, 0, len(terms))
+
+	// template_vars are added to the variables for these templates only
+	templateVars := make(map[string]interface{}, len(variables))
+	for name, value := range variables {
+		templateVars[name] = value
+	}
+	if extra, ok := options["template_vars"]; ok && extra != nil {
+		extraVars, ok := mapTerm(extra)
+		if !ok {
+			return nil, fmt.Errorf("template_vars must be a dict, got %T", extra)
+		}
+		for name, value := range extraVars {
+			templateVars[name] = value
+		}
+	}
+
+	templateCtx := &template.Context{Variables: templateVars}
+	hostvars, err := variable(variables, "hostvars")
+	if err != nil {
+		return nil, err
+	}
+	if hostvars, ok := hostvars.(map[string]map[string]interface{}); ok {
+		templateCtx.Hostvars = hostvars
+	}
+	groups, err := variable(variables, "groups")
+	if err != nil {
+		return nil, err
+	}
+	if groups, ok := groups.(map[string][]string); ok {
+		templateCtx.Groups = groups
+	}
+
+	for _, term := range terms {
+		result, err := t.engine.RenderFile(term, templateCtx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to template %s: %w", term, err)
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// VarsLookupPlugin implements the vars lookup, which returns the values of
+// variables named by the terms
+type VarsLookupPlugin struct {
+	*BaseLookupPlugin
+}
+
+func NewVarsLookupPlugin() *VarsLookupPlugin {
+	return &VarsLookupPlugin{
+		BaseLookupPlugin: NewBaseLookupPlugin(
+			"vars",
+			"Lookup templated value of variables",
+			"1.0.0",
+			"Ansible Project",
+		),
+	}
+}
+
+func (v *VarsLookupPlugin) Run(ctx context.Context, terms []string, variables map[string]interface{}, options map[string]interface{}) ([]interface{}, error) {
+	results := make([]interface{}, 0, len(terms))
+
+	// Variables of the current host are found through hostvars too
+	var hostVars map[string]interface{}
+	hostname, err := variable(variables, "inventory_hostname")
+	if err != nil {
+		return nil, err
+	}
+	if hostname, ok := hostname.(string); ok {
+		hostvars, err := variable(variables, "hostvars")
+		if err != nil {
+			return nil, err
+		}
+		switch hostvars := hostvars.(type) {
+		case map[string]map[string]interface{}:
+			hostVars = hostvars[hostname]
+		case map[string]interface{}:
+			hostVars, _ = mapTerm(hostvars[hostname])
+		}
+	}
+
+	for _, term := range terms {
+		if _, ok := variables[term]; ok {
+			value, err := variable(variables, term)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve variable %s: %w", term, err)
+			}
+			results = append(results, value)
+			continue
+		}
+		if _, ok := hostVars[term]; ok {
+			value, err := variable(hostVars, term)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve variable %s: %w", term, err)
+			}
+			results = append(results, value)
+			continue
+		}
+		if defaultValue, ok := options["default"]; ok {
+			results = append(results, defaultValue)
+			continue
+		}
+		return nil, fmt.Errorf("no variable found with this name: %s", term)
+	}
+
+	return results, nil
+}
+
+// VarnamesLookupPlugin implements the varnames lookup, which returns the
+// names of the variables matching regular expressions
+type VarnamesLookupPlugin struct {
+	*BaseLookupPlugin
+}
+
+func NewVarnamesLookupPlugin() *VarnamesLookupPlugin {
+	return &VarnamesLookupPlugin{
+		BaseLookupPlugin: NewBaseLookupPlugin(
+			"varnames",
+			"Lookup matching variable names",
+			"1.0.0",
+			"Ansible Project",
+		),
+	}
+}
+
+func (v *VarnamesLookupPlugin) Run(ctx context.Context, terms []string, variables map[string]interface{}, options map[string]interface{}) ([]interface{}, error) {
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	results := make([]interface{}, 0)
+	for _, term := range terms {
+		pattern, err := regexp.Compile(term)
+		if err != nil {
+			return nil, fmt.Errorf("unable to use '%s' as a search parameter: %v", term, err)
+		}
+		for _, name := range names {
+			if pattern.MatchString(name) {
+				results = append(results, name)
+			}
+		}
+	}
+
+	return results, nil
+}
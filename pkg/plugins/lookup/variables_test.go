@@ -0,0 +1,160 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lookup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/work-obs/ansible-go/pkg/config"
+)
+
+func TestTemplateLookup(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "templates"), 0755)
+	os.WriteFile(filepath.Join(dir, "templates", "motd.j2"), []byte("Welcome to {{ inventory_hostname }}, {{ user }}"), 0644)
+
+	variables := map[string]interface{}{
+		"playbook_dir":       dir,
+		"inventory_hostname": "web1",
+		"user":               "alice",
+	}
+
+	plugin := NewTemplateLookupPlugin(nil)
+	result, err := plugin.Run(context.Background(), []string{"motd.j2"}, variables, nil)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !reflect.DeepEqual(result, []interface{}{"Welcome to web1, alice"}) {
+		t.Errorf("Run() = %v", result)
+	}
+
+	result, err = plugin.Run(context.Background(), []string{"motd.j2"}, variables, map[string]interface{}{
+		"template_vars": map[string]interface{}{"user": "bob"},
+	})
+	if err != nil || !reflect.DeepEqual(result, []interface{}{"Welcome to web1, bob"}) {
+		t.Errorf("Run() with template_vars = %v, %v", result, err)
+	}
+	if variables["user"] != "alice" {
+		t.Error("template_vars must not change the caller's variables")
+	}
+
+	if _, err := plugin.Run(context.Background(), []string{"missing.j2"}, variables, nil); err == nil {
+		t.Error("expected an error for a missing template")
+	}
+}
+
+func TestVarsLookups(t *testing.T) {
+	variables := map[string]interface{}{
+		"inventory_hostname": "web1",
+		"app_port":           8080,
+		"app_name":           "demo",
+		"db_port":            5432,
+		"hostvars": map[string]map[string]interface{}{
+			"web1": {"host_only": "yes"},
+		},
+	}
+
+	vars := NewVarsLookupPlugin()
+	result, err := vars.Run(context.Background(), []string{"app_port", "host_only"}, variables, nil)
+	if err != nil || !reflect.DeepEqual(result, []interface{}{8080, "yes"}) {
+		t.Errorf("vars = %v, %v", result, err)
+	}
+	result, err = vars.Run(context.Background(), []string{"missing"}, variables, map[string]interface{}{"default": "fallback"})
+	if err != nil || !reflect.DeepEqual(result, []interface{}{"fallback"}) {
+		t.Errorf("vars with default = %v, %v", result, err)
+	}
+	if _, err := vars.Run(context.Background(), []string{"missing"}, variables, nil); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("expected an error naming the variable, got %v", err)
+	}
+
+	varnames := NewVarnamesLookupPlugin()
+	result, err = varnames.Run(context.Background(), []string{"^app_", "port$"}, variables, nil)
+	if err != nil || !reflect.DeepEqual(result, []interface{}{"app_name", "app_port", "app_port", "db_port"}) {
+		t.Errorf("varnames = %v, %v", result, err)
+	}
+	if _, err := varnames.Run(context.Background(), []string{"("}, variables, nil); err == nil {
+		t.Error("expected an error for an invalid regex")
+	}
+}
+
+func TestConfigLookup(t *testing.T) {
+	cfg := &config.Config{
+		BecomeUser:      "root",
+		HostKeyChecking: true,
+		Timeout:         10 * time.Second,
+		RolesPath:       []string{"/etc/ansible/roles"},
+	}
+	plugin := NewConfigLookupPlugin(cfg)
+
+	result, err := plugin.Run(context.Background(), []string{"DEFAULT_BECOME_USER", "host_key_checking", "DEFAULT_TIMEOUT", "DEFAULT_ROLES_PATH"}, nil, nil)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	expected := []interface{}{"root", true, 10, []interface{}{"/etc/ansible/roles"}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Run() = %v, want %v", result, expected)
+	}
+
+	if _, err := plugin.Run(context.Background(), []string{"NO_SUCH_SETTING"}, nil, nil); err == nil {
+		t.Error("expected an error for an unknown setting")
+	}
+	result, err = plugin.Run(context.Background(), []string{"NO_SUCH_SETTING", "become_user"}, nil, map[string]interface{}{"on_missing": "skip"})
+	if err != nil || !reflect.DeepEqual(result, []interface{}{"root"}) {
+		t.Errorf("on_missing=skip: %v, %v", result, err)
+	}
+	if _, err := plugin.Run(context.Background(), nil, nil, map[string]interface{}{"on_missing": "ignore"}); err == nil {
+		t.Error("expected an error for an invalid on_missing")
+	}
+}
+
+func TestInventoryHostnamesLookup(t *testing.T) {
+	variables := map[string]interface{}{
+		"groups": map[string][]string{
+			"all":       {"web2", "web1", "db1"},
+			"webserver": {"web2", "web1"},
+			"dbserver":  {"db1"},
+		},
+	}
+
+	plugin := NewInventoryHostnamesLookupPlugin()
+	tests := []struct {
+		terms    []string
+		expected []interface{}
+	}{
+		{[]string{"all"}, []interface{}{"web2", "web1", "db1"}},
+		{[]string{"webserver"}, []interface{}{"web2", "web1"}},
+		{[]string{"dbserver", "webserver"}, []interface{}{"web2", "web1", "db1"}},
+		{[]string{"web*"}, []interface{}{"web2", "web1"}},
+		{[]string{"nomatch"}, []interface{}{}},
+	}
+	for _, test := range tests {
+		result, err := plugin.Run(context.Background(), test.terms, variables, nil)
+		if err != nil {
+			t.Errorf("%v: %v", test.terms, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("%v: got %v, want %v", test.terms, result, test.expected)
+		}
+	}
+}
@@ -0,0 +1,551 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package template
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// compilation collects everything produced while converting one template
+// together with the templates it imports or extends. Macros, blocks and
+// set blocks become separate Go templates ("definitions") so their line
+// numbers stay those of the file they come from.
+type compilation struct {
+	engine *Engine
+	ctx    *Context
+
+	// main is the name of the template holding the body to execute; for
+	// a child template this is its root ancestor
+	main string
+
+	definitions []definition
+	defined     map[string]bool
+
+	// names maps template variables back to their Jinja2 names
+	names   map[string]string
+	counter int
+
+	// blockTop maps a block name to its most derived definition and
+	// blockLast to its least derived one found so far; parents links each
+	// block definition to the one super() renders
+	blockTop  map[string]string
+	blockLast map[string]string
+	parents   map[string]string
+
+	// loading lists the files being converted, to detect cycles, and
+	// loaded the converters of files already converted
+	loading []string
+	loaded  map[string]*converter
+}
+
+// definition is a named template body
+type definition struct {
+	name string
+	text string
+}
+
+func newCompilation(engine *Engine, ctx *Context) *compilation {
+	return &compilation{
+		engine:    engine,
+		ctx:       ctx,
+		defined:   make(map[string]bool),
+		names:     make(map[string]string),
+		blockTop:  make(map[string]string),
+		blockLast: make(map[string]string),
+		parents:   make(map[string]string),
+		loaded:    make(map[string]*converter),
+	}
+}
+
+// converter returns a converter for the named file within the compilation
+func (u *compilation) converter(file string) *converter {
+	return &converter{
+		engine: u.engine,
+		unit:   u,
+		file:   file,
+		scopes: []map[string]string{{}},
+		out:    &strings.Builder{},
+		macros: make(map[string]string),
+	}
+}
+
+// compile converts the main template. In native mode its expression tags
+// capture their value instead of printing it.
+func (u *compilation) compile(name, src string, native bool) (string, error) {
+	u.main = name
+	u.loading = append(u.loading, name)
+	c := u.converter(name)
+	c.native = native
+	return c.convert(src)
+}
+
+// define adds a definition
+func (u *compilation) define(name, text string) error {
+	if u.defined[name] {
+		return fmt.Errorf("'%s' is defined twice", definitionLabel(name))
+	}
+	u.defined[name] = true
+	u.definitions = append(u.definitions, definition{name: name, text: text})
+	return nil
+}
+
+// load converts another template file for import or extends and returns
+// the converter that processed it. A file imported more than once is
+// converted once.
+func (u *compilation) load(name, from string) (*converter, string, error) {
+	path, src, err := u.engine.loadTemplate(name, u.ctx, from)
+	if err != nil {
+		return nil, "", err
+	}
+	if c, ok := u.loaded[path]; ok {
+		return c, c.out.String(), nil
+	}
+
+	for _, loading := range u.loading {
+		if loading == path {
+			return nil, "", fmt.Errorf("template '%s' refers to itself", name)
+		}
+	}
+	u.loading = append(u.loading, path)
+	defer func() { u.loading = u.loading[:len(u.loading)-1] }()
+
+	c := u.converter(path)
+	text, err := c.convert(src)
+	if err != nil {
+		var tmplErr *TemplateError
+		if errors.As(err, &tmplErr) {
+			return nil, "", err
+		}
+		return nil, "", &TemplateError{Template: path, Line: c.line, Message: err.Error(), Err: err, nested: true}
+	}
+	u.loaded[path] = c
+	return c, text, nil
+}
+
+// extend converts the parent of a child template and returns the body to
+// render, which is the parent's
+func (u *compilation) extend(parent, from string) (string, error) {
+	c, text, err := u.load(parent, from)
+	if err != nil {
+		return "", err
+	}
+	if c.extends == "" {
+		u.main = c.file
+	}
+	return text, nil
+}
+
+// definitionName builds the name of a definition in file
+func definitionName(file, kind, name string) string {
+	return file + "#" + kind + "/" + name
+}
+
+// definitionLabel describes a definition for error messages
+func definitionLabel(name string) string {
+	idx := strings.Index(name, "#")
+	if idx < 0 {
+		return name
+	}
+	return strings.Replace(name[idx+1:], "/", " ", 1)
+}
+
+// definitionFile returns the file a template or definition belongs to
+func definitionFile(name string) string {
+	if idx := strings.Index(name, "#"); idx >= 0 {
+		return name[:idx]
+	}
+	return name
+}
+
+// beginDefinition starts converting a definition body. Isolated bodies
+// (macros, blocks) do not see the locals of the enclosing template.
+func (c *converter) beginDefinition(kind, def string, isolated bool) {
+	c.frames = append(c.frames, frame{
+		kind:   kind,
+		def:    def,
+		line:   c.line,
+		out:    c.out,
+		scopes: c.scopes,
+		loops:  c.loops,
+	})
+
+	c.out = &strings.Builder{}
+	c.out.WriteString(lineComment(strings.Repeat("\n", c.line-1)))
+
+	if isolated {
+		c.scopes = []map[string]string{{}}
+		c.loops = nil
+	} else {
+		c.scopes = append([]map[string]string{}, c.scopes...)
+		c.loops = append([]string{}, c.loops...)
+	}
+	c.pushBlock(kind)
+}
+
+// endDefinition finishes the innermost definition and returns its frame
+func (c *converter) endDefinition(kind string) (frame, error) {
+	if err := c.popBlock(kind); err != nil {
+		return frame{}, err
+	}
+
+	f := c.frames[len(c.frames)-1]
+	c.frames = c.frames[:len(c.frames)-1]
+
+	if err := c.unit.define(f.def, c.out.String()); err != nil {
+		return frame{}, err
+	}
+
+	// The enclosing template keeps its line numbering
+	c.out = f.out
+	c.out.WriteString(lineComment(strings.Repeat("\n", c.line-f.line)))
+	c.scopes = f.scopes
+	c.loops = f.loops
+	return f, nil
+}
+
+// currentBlock returns the definition of the innermost block being
+// converted
+func (c *converter) currentBlock() string {
+	for i := len(c.frames) - 1; i >= 0; i-- {
+		if c.frames[i].kind == "block" {
+			return c.frames[i].def
+		}
+	}
+	return ""
+}
+
+// visibleLocals returns the template variables in scope, including the
+// loop bookkeeping variables, mapped to their Jinja2 names
+func (c *converter) visibleLocals() map[string]string {
+	locals := make(map[string]string)
+	for _, scope := range c.scopes {
+		for name, goVar := range scope {
+			locals[goVar] = name
+		}
+	}
+	for _, id := range c.loops {
+		locals["$__idx"+id] = ""
+		locals["$__seq"+id] = ""
+	}
+	return locals
+}
+
+// localsDict emits a dict of the visible locals keyed by template variable
+func (c *converter) localsDict() string {
+	locals := c.visibleLocals()
+	goVars := make([]string, 0, len(locals))
+	for goVar := range locals {
+		goVars = append(goVars, goVar)
+	}
+	sort.Strings(goVars)
+
+	parts := []string{"newDict"}
+	for _, goVar := range goVars {
+		parts = append(parts, strconv.Quote(goVar), goVar)
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+// contextDict emits a dict of the visible locals keyed by Jinja2 name, for
+// templates included with context
+func (c *converter) contextDict() string {
+	seen := make(map[string]bool)
+	parts := []string{"newDict"}
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		names := make([]string, 0, len(c.scopes[i]))
+		for name := range c.scopes[i] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			parts = append(parts, strconv.Quote(name), c.scopes[i][name])
+		}
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+// beginSetBlock translates "set name" followed by a body captured as a string
+func (c *converter) beginSetBlock(rest string) error {
+	name := strings.TrimSpace(rest)
+	if !c.engine.isSimpleVariable(name) {
+		return fmt.Errorf("invalid set target '%s'", name)
+	}
+
+	c.unit.counter++
+	def := definitionName(c.file, "set", name+"_"+strconv.Itoa(c.unit.counter))
+	locals := c.visibleLocals()
+	c.beginDefinition("set", def, false)
+	c.frames[len(c.frames)-1].kind = "set:" + name
+
+	// The body sees the enclosing locals through the Locals dict
+	goVars := make([]string, 0, len(locals))
+	for goVar := range locals {
+		goVars = append(goVars, goVar)
+	}
+	sort.Strings(goVars)
+	for _, goVar := range goVars {
+		c.out.WriteString(fmt.Sprintf("{{%s := (getitem $.Locals %s)}}", goVar, strconv.Quote(goVar)))
+	}
+	return nil
+}
+
+func (c *converter) endSetBlock() error {
+	f, err := c.endDefinition("set")
+	if err != nil {
+		return err
+	}
+	name := strings.TrimPrefix(f.kind, "set:")
+	c.assign(name, fmt.Sprintf("(callDefinition %s $ %s)", strconv.Quote(f.def), c.localsDict()))
+	return nil
+}
+
+// beginMacro translates "macro name(param, param=default)"
+func (c *converter) beginMacro(rest string) error {
+	node, err := parseExpression(rest)
+	if err != nil {
+		return fmt.Errorf("invalid macro definition %q: %w", rest, err)
+	}
+	call, ok := node.(callNode)
+	if !ok {
+		return fmt.Errorf("invalid macro definition %q", rest)
+	}
+	fn, ok := call.fn.(nameNode)
+	if !ok {
+		return fmt.Errorf("invalid macro name in %q", rest)
+	}
+
+	def := definitionName(c.file, "macro", fn.name)
+	c.macros[fn.name] = def
+	c.beginDefinition("macro", def, true)
+
+	// Bind the parameters; missing arguments without a default are undefined
+	var params []string
+	defaults := make(map[string]string)
+	for _, arg := range call.args {
+		param, ok := arg.(nameNode)
+		if !ok {
+			return fmt.Errorf("invalid macro parameter in %q", rest)
+		}
+		params = append(params, param.name)
+		defaults[param.name] = fmt.Sprintf("(undefined %s)", strconv.Quote(param.name))
+	}
+	for _, kw := range call.kwargs {
+		value, err := c.emit(kw.value)
+		if err != nil {
+			return err
+		}
+		params = append(params, kw.name)
+		defaults[kw.name] = value
+	}
+
+	scope := c.scopes[len(c.scopes)-1]
+	for i, param := range params {
+		goVar := "$" + param
+		c.out.WriteString(fmt.Sprintf("{{%s := (macroArg $ %d %s %s)}}", goVar, i, strconv.Quote(param), defaults[param]))
+		scope[param] = goVar
+		c.unit.names[goVar] = param
+	}
+	return nil
+}
+
+// beginBlock translates "block name"
+func (c *converter) beginBlock(rest string) error {
+	name := strings.Fields(rest)
+	if len(name) == 0 || !c.engine.isSimpleVariable(name[0]) {
+		return fmt.Errorf("invalid block name '%s'", rest)
+	}
+
+	def := definitionName(c.file, "block", name[0])
+	u := c.unit
+	if last, ok := u.blockLast[name[0]]; ok {
+		u.parents[last] = def
+	} else {
+		u.blockTop[name[0]] = def
+	}
+	u.blockLast[name[0]] = def
+
+	c.beginDefinition("block", def, true)
+	return nil
+}
+
+func (c *converter) endBlock() error {
+	f, err := c.endDefinition("block")
+	if err != nil {
+		return err
+	}
+
+	// Render the most derived version of the block in its place
+	name := f.def[strings.LastIndex(f.def, "/")+1:]
+	c.out.WriteString(fmt.Sprintf("{{template %s $}}", strconv.Quote(c.unit.blockTop[name])))
+	return nil
+}
+
+// extendsStatement translates "extends 'parent'"
+func (c *converter) extendsStatement(rest string) error {
+	if c.extends != "" {
+		return fmt.Errorf("template extends more than one parent")
+	}
+	name, err := stringLiteral(rest)
+	if err != nil {
+		return fmt.Errorf("extends: %w", err)
+	}
+	c.extends = name
+	return nil
+}
+
+// includeStatement translates "include name [ignore missing]
+// [with context|without context]"
+func (c *converter) includeStatement(rest string) error {
+	ignoreMissing := false
+	withContext := true
+	for {
+		switch {
+		case strings.HasSuffix(rest, " ignore missing"):
+			ignoreMissing = true
+			rest = strings.TrimSpace(strings.TrimSuffix(rest, " ignore missing"))
+			continue
+		case strings.HasSuffix(rest, " without context"):
+			withContext = false
+			rest = strings.TrimSpace(strings.TrimSuffix(rest, " without context"))
+			continue
+		case strings.HasSuffix(rest, " with context"):
+			rest = strings.TrimSpace(strings.TrimSuffix(rest, " with context"))
+			continue
+		}
+		break
+	}
+
+	name, err := c.translate(rest)
+	if err != nil {
+		return err
+	}
+
+	locals := "(newDict)"
+	if withContext {
+		locals = c.contextDict()
+	}
+	c.out.WriteString(fmt.Sprintf("{{include %s %s %s %t}}", name, strconv.Quote(c.file), locals, ignoreMissing))
+	return nil
+}
+
+// importStatement translates "import 'file' as alias"
+func (c *converter) importStatement(rest string) error {
+	rest = trimContextClause(rest)
+	idx := strings.LastIndex(rest, " as ")
+	if idx < 0 {
+		return fmt.Errorf("invalid import statement: %s", rest)
+	}
+
+	alias := strings.TrimSpace(rest[idx+4:])
+	if !c.engine.isSimpleVariable(alias) {
+		return fmt.Errorf("invalid import alias '%s'", alias)
+	}
+
+	macros, err := c.importMacros(strings.TrimSpace(rest[:idx]))
+	if err != nil {
+		return err
+	}
+	for name, def := range macros {
+		c.macros[alias+"."+name] = def
+	}
+	return nil
+}
+
+// fromStatement translates "from 'file' import name, name as alias"
+func (c *converter) fromStatement(rest string) error {
+	rest = trimContextClause(rest)
+	idx := strings.Index(rest, " import ")
+	if idx < 0 {
+		return fmt.Errorf("invalid from statement: %s", rest)
+	}
+
+	macros, err := c.importMacros(strings.TrimSpace(rest[:idx]))
+	if err != nil {
+		return err
+	}
+
+	for _, item := range strings.Split(rest[idx+8:], ",") {
+		fields := strings.Fields(item)
+		name, alias := "", ""
+		switch {
+		case len(fields) == 1:
+			name, alias = fields[0], fields[0]
+		case len(fields) == 3 && fields[1] == "as":
+			name, alias = fields[0], fields[2]
+		default:
+			return fmt.Errorf("invalid import name '%s'", strings.TrimSpace(item))
+		}
+
+		def, ok := macros[name]
+		if !ok {
+			return fmt.Errorf("the template does not export '%s'", name)
+		}
+		c.macros[alias] = def
+	}
+	return nil
+}
+
+// importMacros loads a template and returns the macros it defines
+func (c *converter) importMacros(expr string) (map[string]string, error) {
+	name, err := stringLiteral(expr)
+	if err != nil {
+		return nil, fmt.Errorf("import: %w", err)
+	}
+
+	imported, _, err := c.unit.load(name, c.file)
+	if err != nil {
+		return nil, err
+	}
+
+	macros := make(map[string]string)
+	for callable, def := range imported.macros {
+		if !strings.Contains(callable, ".") {
+			macros[callable] = def
+		}
+	}
+	return macros, nil
+}
+
+// trimContextClause strips a trailing "with context" or "without context"
+func trimContextClause(rest string) string {
+	rest = strings.TrimSpace(rest)
+	rest = strings.TrimSuffix(rest, " without context")
+	rest = strings.TrimSuffix(rest, " with context")
+	return strings.TrimSpace(rest)
+}
+
+// stringLiteral parses an expression that must be a string constant
+func stringLiteral(expr string) (string, error) {
+	node, err := parseExpression(expr)
+	if err != nil {
+		return "", err
+	}
+	if lit, ok := node.(literalNode); ok {
+		if s, ok := lit.value.(string); ok {
+			return s, nil
+		}
+	}
+	return "", fmt.Errorf("template name must be a string literal, got %s", strings.TrimSpace(expr))
+}
@@ -0,0 +1,876 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package template
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// globalFunctions maps Jinja2 global function names to template functions
+var globalFunctions = map[string]string{
+	"lookup": "lookup",
+	"query":  "query",
+	"q":      "query",
+	"range":  "rangeList",
+	"dict":   "newDict",
+}
+
+// filterAliases maps filter names to their canonical template function
+var filterAliases = map[string]string{
+	"d": "default",
+}
+
+// converter translates a Jinja2 template into Go template syntax. It keeps
+// track of the local variables introduced by for loops and set statements
+// so they resolve to template variables instead of context variables.
+//
+// The output keeps the line structure of the source: newlines swallowed by
+// whitespace control or spanned by a tag are re-emitted inside template
+// comments, so line numbers in text/template errors match the source.
+type converter struct {
+	engine *Engine
+	unit   *compilation
+	scopes []map[string]string
+	loops  []string
+	blocks []string
+	out    *strings.Builder
+
+	// file names the template being converted, empty for inline strings
+	file string
+
+	// line is the source line of the tag being translated
+	line int
+
+	// macros maps callable names, including imported "alias.name" forms,
+	// to the template defining the macro
+	macros map[string]string
+
+	// frames holds the definitions (macros, blocks, set blocks) being
+	// converted; their bodies become separate templates
+	frames []frame
+
+	// extends names the parent template, if any
+	extends string
+
+	// safe is set while emitting operands that Jinja2 evaluates lazily;
+	// missing variables then yield Undefined instead of failing
+	safe bool
+
+	// native makes expression tags capture their value instead of
+	// printing it
+	native bool
+}
+
+// frame is a definition under conversion
+type frame struct {
+	kind   string
+	def    string
+	line   int
+	out    *strings.Builder
+	scopes []map[string]string
+	loops  []string
+}
+
+func newConverter(engine *Engine) *converter {
+	return newCompilation(engine, nil).converter("")
+}
+
+// tagKind identifies the delimiter type of a template tag
+type tagKind int
+
+const (
+	tagExpression tagKind = iota
+	tagStatement
+	tagComment
+)
+
+// convert translates a complete template
+func (c *converter) convert(src string) (string, error) {
+	pending := ""
+	trimLeft := false
+	trimNewline := false
+
+	appendText := func(text string) {
+		trimmed := text
+		if trimLeft {
+			trimmed = strings.TrimLeft(text, " \t\r\n")
+		} else if trimNewline {
+			if strings.HasPrefix(text, "\r\n") {
+				trimmed = text[2:]
+			} else if strings.HasPrefix(text, "\n") {
+				trimmed = text[1:]
+			}
+		}
+		c.out.WriteString(lineComment(text[:len(text)-len(trimmed)]))
+		text = trimmed
+		trimLeft = false
+		trimNewline = false
+		pending += text
+	}
+
+	pos := 0
+	for pos < len(src) {
+		start, kind := findTagStart(src, pos)
+		if start < 0 {
+			appendText(src[pos:])
+			break
+		}
+		appendText(src[pos:start])
+
+		body, end, err := scanTag(src, start, kind)
+		if err != nil {
+			return "", err
+		}
+
+		removed := ""
+		if strings.HasPrefix(body, "-") {
+			trimmed := strings.TrimRight(pending, " \t\r\n")
+			removed = pending[len(trimmed):]
+			pending = trimmed
+			body = body[1:]
+		}
+		rstrip := false
+		if strings.HasSuffix(body, "-") {
+			rstrip = true
+			body = body[:len(body)-1]
+		}
+		body = strings.TrimSpace(body)
+
+		c.out.WriteString(escapeText(pending))
+		c.out.WriteString(lineComment(removed))
+		pending = ""
+		c.line = lineOf(src, start)
+
+		if kind == tagStatement && body == "raw" {
+			text, rawEnd, err := scanRaw(src, end)
+			if err != nil {
+				return "", fmt.Errorf("line %d: %w", c.line, err)
+			}
+			c.out.WriteString(lineComment(src[start:end]))
+			trimNewline = true
+			appendText(text)
+			c.out.WriteString(lineComment(src[end+len(text) : rawEnd]))
+			trimNewline = true
+			pos = rawEnd
+			continue
+		}
+
+		switch kind {
+		case tagExpression:
+			goExpr, err := c.translate(body)
+			if err != nil {
+				return "", fmt.Errorf("line %d: %w", lineOf(src, start), err)
+			}
+			if c.native {
+				c.out.WriteString("{{capture " + goExpr + "}}")
+			} else {
+				c.out.WriteString("{{output " + goExpr + "}}")
+			}
+
+		case tagStatement:
+			if err := c.statement(body); err != nil {
+				return "", fmt.Errorf("line %d: %w", lineOf(src, start), err)
+			}
+			trimNewline = true
+		}
+		c.out.WriteString(lineComment(src[start:end]))
+
+		trimLeft = rstrip
+		pos = end
+	}
+	c.out.WriteString(escapeText(pending))
+
+	if len(c.blocks) > 0 {
+		return "", fmt.Errorf("unclosed '%s' block", c.blocks[len(c.blocks)-1])
+	}
+
+	// A child template only contributes its blocks; the parent's body is
+	// what gets rendered
+	if c.extends != "" {
+		return c.unit.extend(c.extends, c.file)
+	}
+
+	return c.out.String(), nil
+}
+
+var endRawPattern = regexp.MustCompile(`\{%-?\s*endraw\s*-?%\}`)
+
+// scanRaw returns the literal text of a raw block starting at pos and the
+// position after its endraw tag
+func scanRaw(src string, pos int) (string, int, error) {
+	loc := endRawPattern.FindStringIndex(src[pos:])
+	if loc == nil {
+		return "", 0, fmt.Errorf("unclosed 'raw' block")
+	}
+	text := src[pos : pos+loc[0]]
+	if strings.HasPrefix(src[pos+loc[0]:], "{%-") {
+		text = strings.TrimRight(text, " \t\r\n")
+	}
+	return text, pos + loc[1], nil
+}
+
+// findTagStart returns the position and kind of the next tag at or after pos
+func findTagStart(src string, pos int) (int, tagKind) {
+	for i := pos; i+1 < len(src); i++ {
+		if src[i] != '{' {
+			continue
+		}
+		switch src[i+1] {
+		case '{':
+			return i, tagExpression
+		case '%':
+			return i, tagStatement
+		case '#':
+			return i, tagComment
+		}
+	}
+	return -1, tagExpression
+}
+
+// scanTag returns the body of the tag starting at start and the position
+// after its closing delimiter. Like Jinja's lexer, it only accepts the
+// closing delimiter outside quoted strings and brackets, so dict and list
+// literals may contain one.
+func scanTag(src string, start int, kind tagKind) (string, int, error) {
+	closer := map[tagKind]string{tagExpression: "}}", tagStatement: "%}", tagComment: "#}"}[kind]
+	bodyStart := start + 2
+
+	if kind == tagComment {
+		idx := strings.Index(src[bodyStart:], closer)
+		if idx < 0 {
+			return "", 0, fmt.Errorf("line %d: unterminated comment", lineOf(src, start))
+		}
+		return "", bodyStart + idx + 2, nil
+	}
+
+	var quote byte
+	depth := 0
+	for i := bodyStart; i < len(src); i++ {
+		ch := src[i]
+		if quote != 0 {
+			if ch == '\\' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch ch {
+		case '\'', '"':
+			quote = ch
+			continue
+		case '{', '[', '(':
+			depth++
+			continue
+		case '}', ']', ')':
+			if depth > 0 {
+				depth--
+				continue
+			}
+		}
+		if depth == 0 && strings.HasPrefix(src[i:], closer) {
+			return src[bodyStart:i], i + 2, nil
+		}
+	}
+
+	return "", 0, fmt.Errorf("line %d: unterminated tag, expected %q", lineOf(src, start), closer)
+}
+
+// lineOf returns the 1-based line number of the byte offset pos
+func lineOf(src string, pos int) int {
+	return strings.Count(src[:pos], "\n") + 1
+}
+
+// lineComment returns a template comment holding the newlines of text,
+// or nothing if there are none
+func lineComment(text string) string {
+	n := strings.Count(text, "\n")
+	if n == 0 {
+		return ""
+	}
+	return "{{/*" + strings.Repeat("\n", n) + "*/}}"
+}
+
+// escapeText makes literal text safe to embed in a Go template
+func escapeText(text string) string {
+	return strings.ReplaceAll(text, "{{", `{{"{{"}}`)
+}
+
+// statement translates a {% ... %} tag
+func (c *converter) statement(body string) error {
+	keyword := body
+	rest := ""
+	if idx := strings.IndexAny(body, " \t\r\n"); idx >= 0 {
+		keyword = body[:idx]
+		rest = strings.TrimSpace(body[idx:])
+	}
+
+	switch keyword {
+	case "if":
+		cond, err := c.translate(rest)
+		if err != nil {
+			return err
+		}
+		c.pushBlock("if")
+		c.out.WriteString("{{if " + cond + "}}")
+
+	case "elif":
+		if err := c.expectBlock("if", "elif"); err != nil {
+			return err
+		}
+		cond, err := c.translate(rest)
+		if err != nil {
+			return err
+		}
+		c.popScope()
+		c.pushScope()
+		c.out.WriteString("{{else if " + cond + "}}")
+
+	case "else":
+		if len(c.blocks) == 0 || (c.blocks[len(c.blocks)-1] != "if" && c.blocks[len(c.blocks)-1] != "for") {
+			return fmt.Errorf("unexpected 'else'")
+		}
+		c.popScope()
+		c.pushScope()
+		c.out.WriteString("{{else}}")
+
+	case "endif":
+		if err := c.popBlock("if"); err != nil {
+			return err
+		}
+		c.out.WriteString("{{end}}")
+
+	case "for":
+		return c.forStatement(rest)
+
+	case "endfor":
+		if err := c.popBlock("for"); err != nil {
+			return err
+		}
+		c.loops = c.loops[:len(c.loops)-1]
+		c.out.WriteString("{{end}}")
+
+	case "set":
+		if !strings.Contains(rest, "=") {
+			return c.beginSetBlock(rest)
+		}
+		return c.setStatement(rest)
+
+	case "endset":
+		return c.endSetBlock()
+
+	case "macro":
+		return c.beginMacro(rest)
+
+	case "endmacro":
+		_, err := c.endDefinition("macro")
+		return err
+
+	case "block":
+		return c.beginBlock(rest)
+
+	case "endblock":
+		return c.endBlock()
+
+	case "extends":
+		return c.extendsStatement(rest)
+
+	case "include":
+		return c.includeStatement(rest)
+
+	case "import":
+		return c.importStatement(rest)
+
+	case "from":
+		return c.fromStatement(rest)
+
+	default:
+		return fmt.Errorf("unsupported tag '%s'", keyword)
+	}
+
+	return nil
+}
+
+// forStatement translates "for target[, target] in iterable"
+func (c *converter) forStatement(rest string) error {
+	idx := strings.Index(rest, " in ")
+	if idx < 0 {
+		return fmt.Errorf("invalid for statement: %s", rest)
+	}
+
+	var targets []string
+	for _, target := range strings.Split(rest[:idx], ",") {
+		target = strings.TrimSpace(target)
+		if !c.engine.isSimpleVariable(target) {
+			return fmt.Errorf("invalid loop variable '%s'", target)
+		}
+		targets = append(targets, target)
+	}
+
+	iterable, err := c.translate(rest[idx+4:])
+	if err != nil {
+		return err
+	}
+
+	c.unit.counter++
+	id := strconv.Itoa(c.unit.counter)
+	seqVar := "$__seq" + id
+	idxVar := "$__idx" + id
+	itemVar := "$__item" + id
+
+	c.out.WriteString(fmt.Sprintf("{{%s := (iterable %s)}}{{range %s, %s := %s}}", seqVar, iterable, idxVar, itemVar, seqVar))
+
+	c.pushBlock("for")
+	c.loops = append(c.loops, id)
+	scope := c.scopes[len(c.scopes)-1]
+
+	if len(targets) == 1 {
+		scope[targets[0]] = itemVar
+		c.unit.names[itemVar] = targets[0]
+		return nil
+	}
+
+	for i, target := range targets {
+		goVar := "$" + target + "_" + id
+		c.out.WriteString(fmt.Sprintf("{{%s := (getitem %s %d)}}", goVar, itemVar, i))
+		scope[target] = goVar
+		c.unit.names[goVar] = target
+	}
+	return nil
+}
+
+// setStatement translates "set name = expression"
+func (c *converter) setStatement(rest string) error {
+	idx := strings.Index(rest, "=")
+	if idx < 0 {
+		return fmt.Errorf("invalid set statement: %s", rest)
+	}
+
+	name := strings.TrimSpace(rest[:idx])
+	if !c.engine.isSimpleVariable(name) {
+		return fmt.Errorf("invalid set target '%s'", name)
+	}
+
+	value, err := c.translate(rest[idx+1:])
+	if err != nil {
+		return err
+	}
+
+	c.assign(name, value)
+	return nil
+}
+
+// assign binds a local variable to a template operand
+func (c *converter) assign(name, value string) {
+	if goVar, ok := c.lookupLocal(name); ok {
+		c.out.WriteString(fmt.Sprintf("{{%s = %s}}", goVar, value))
+		return
+	}
+
+	goVar := "$" + name
+	c.scopes[len(c.scopes)-1][name] = goVar
+	c.unit.names[goVar] = name
+	c.out.WriteString(fmt.Sprintf("{{%s := %s}}", goVar, value))
+}
+
+func (c *converter) pushBlock(kind string) {
+	c.blocks = append(c.blocks, kind)
+	c.pushScope()
+}
+
+func (c *converter) expectBlock(kind, tag string) error {
+	if len(c.blocks) == 0 || c.blocks[len(c.blocks)-1] != kind {
+		return fmt.Errorf("unexpected '%s'", tag)
+	}
+	return nil
+}
+
+func (c *converter) popBlock(kind string) error {
+	if err := c.expectBlock(kind, "end"+kind); err != nil {
+		return err
+	}
+	c.blocks = c.blocks[:len(c.blocks)-1]
+	c.popScope()
+	return nil
+}
+
+func (c *converter) pushScope() {
+	c.scopes = append(c.scopes, map[string]string{})
+}
+
+func (c *converter) popScope() {
+	c.scopes = c.scopes[:len(c.scopes)-1]
+}
+
+func (c *converter) lookupLocal(name string) (string, bool) {
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		if goVar, ok := c.scopes[i][name]; ok {
+			return goVar, true
+		}
+	}
+	return "", false
+}
+
+// translate converts a Jinja2 expression into a Go template pipeline
+func (c *converter) translate(expr string) (string, error) {
+	if strings.TrimSpace(expr) == "" {
+		return "", fmt.Errorf("empty expression")
+	}
+
+	node, err := parseExpression(expr)
+	if err != nil {
+		return "", fmt.Errorf("invalid expression %q: %w", expr, err)
+	}
+	return c.emit(node)
+}
+
+// emitSafe renders an operand that Jinja2 evaluates lazily
+func (c *converter) emitSafe(node exprNode) (string, error) {
+	c.safe = true
+	return c.emit(node)
+}
+
+// emit renders an expression node as a Go template operand. Safe mode
+// applies to the node being emitted and carries through variable and
+// attribute chains only; other operands are evaluated strictly again.
+func (c *converter) emit(node exprNode) (string, error) {
+	safe := c.safe
+	c.safe = false
+
+	switch n := node.(type) {
+	case literalNode:
+		return emitLiteral(n.value), nil
+
+	case nameNode:
+		if goVar, ok := c.lookupLocal(n.name); ok {
+			return goVar, nil
+		}
+		if n.name == "loop" && len(c.loops) > 0 {
+			id := c.loops[len(c.loops)-1]
+			return fmt.Sprintf("(loopInfo $__idx%s $__seq%s)", id, id), nil
+		}
+		switch n.name {
+		case "omit":
+			return strconv.Quote(OmitPlaceholder), nil
+		case "hostvars":
+			return "$.Hostvars", nil
+		case "groups":
+			return "$.Groups", nil
+		case "inventory":
+			return "$.Inventory", nil
+		case "ansible_facts":
+			return "$.Facts", nil
+		}
+		if safe {
+			return fmt.Sprintf("(tryvar $.Variables %s)", strconv.Quote(n.name)), nil
+		}
+		return "(resolve $.Variables." + n.name + ")", nil
+
+	case attrNode:
+		c.safe = safe
+		obj, err := c.emit(n.obj)
+		if err != nil {
+			return "", err
+		}
+		if safe {
+			return fmt.Sprintf("(tryitem %s %s %s)", obj, strconv.Quote(n.attr), strconv.Quote(describe(n))), nil
+		}
+		if isFieldChain(obj) {
+			return obj + "." + n.attr, nil
+		}
+		return fmt.Sprintf("(getitem %s %s)", obj, strconv.Quote(n.attr)), nil
+
+	case itemNode:
+		c.safe = safe
+		obj, err := c.emit(n.obj)
+		if err != nil {
+			return "", err
+		}
+		key, err := c.emit(n.key)
+		if err != nil {
+			return "", err
+		}
+		if safe {
+			return fmt.Sprintf("(tryitem %s %s %s)", obj, key, strconv.Quote(describe(n))), nil
+		}
+		return fmt.Sprintf("(getitem %s %s)", obj, key), nil
+
+	case sliceNode:
+		obj, err := c.emit(n.obj)
+		if err != nil {
+			return "", err
+		}
+		start, end := "nil", "nil"
+		if n.start != nil {
+			if start, err = c.emit(n.start); err != nil {
+				return "", err
+			}
+		}
+		if n.end != nil {
+			if end, err = c.emit(n.end); err != nil {
+				return "", err
+			}
+		}
+		return fmt.Sprintf("(getslice %s %s %s)", obj, start, end), nil
+
+	case callNode:
+		return c.emitCall(n)
+
+	case filterNode:
+		name := n.name
+		if alias, ok := filterAliases[name]; ok {
+			name = alias
+		}
+		// default and mandatory exist to handle undefined values
+		lazy := name == "default" || name == "mandatory"
+		c.safe = lazy
+		input, err := c.emit(n.input)
+		if err != nil {
+			return "", err
+		}
+		args, err := c.emitArgs(n.args, n.kwargs, lazy)
+		if err != nil {
+			return "", err
+		}
+		return "(" + strings.Join(append([]string{name, input}, args...), " ") + ")", nil
+
+	case testNode:
+		c.safe = n.name == "defined" || n.name == "undefined"
+		input, err := c.emit(n.input)
+		if err != nil {
+			return "", err
+		}
+		args, err := c.emitArgs(n.args, nil, false)
+		if err != nil {
+			return "", err
+		}
+		result := "(" + strings.Join(append([]string{"is_" + n.name, input}, args...), " ") + ")"
+		if n.negate {
+			result = "(not " + result + ")"
+		}
+		return result, nil
+
+	case binaryNode:
+		left, err := c.emit(n.left)
+		if err != nil {
+			return "", err
+		}
+		right, err := c.emit(n.right)
+		if err != nil {
+			return "", err
+		}
+		switch n.op {
+		case "in":
+			return fmt.Sprintf("(contains %s %s)", right, left), nil
+		case "not in":
+			return fmt.Sprintf("(not (contains %s %s))", right, left), nil
+		}
+		fn, ok := binaryFunctions[n.op]
+		if !ok {
+			return "", fmt.Errorf("unsupported operator '%s'", n.op)
+		}
+		return fmt.Sprintf("(%s %s %s)", fn, left, right), nil
+
+	case unaryNode:
+		operand, err := c.emit(n.operand)
+		if err != nil {
+			return "", err
+		}
+		if n.op == "not" {
+			return "(not " + operand + ")", nil
+		}
+		return "(neg " + operand + ")", nil
+
+	case condNode:
+		cond, err := c.emit(n.cond)
+		if err != nil {
+			return "", err
+		}
+		then, err := c.emitSafe(n.then)
+		if err != nil {
+			return "", err
+		}
+		otherwise, err := c.emitSafe(n.otherwise)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(ifElse %s %s %s)", cond, then, otherwise), nil
+
+	case listNode:
+		items, err := c.emitArgs(n.items, nil, false)
+		if err != nil {
+			return "", err
+		}
+		return "(" + strings.Join(append([]string{"newList"}, items...), " ") + ")", nil
+
+	case dictNode:
+		parts := []string{"newDict"}
+		for i := range n.keys {
+			key, err := c.emit(n.keys[i])
+			if err != nil {
+				return "", err
+			}
+			value, err := c.emit(n.values[i])
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, key, value)
+		}
+		return "(" + strings.Join(parts, " ") + ")", nil
+	}
+
+	return "", fmt.Errorf("unsupported expression")
+}
+
+// isFieldChain reports whether operand is a template variable or context
+// variable followed by field accesses, which attributes can extend
+func isFieldChain(operand string) bool {
+	if strings.HasPrefix(operand, "(resolve $.Variables.") {
+		end := strings.Index(operand, ")")
+		return !strings.ContainsAny(operand[end+1:], " ()")
+	}
+	return strings.HasPrefix(operand, "$") && !strings.ContainsAny(operand, " ()")
+}
+
+// binaryFunctions maps binary operators to template functions
+var binaryFunctions = map[string]string{
+	"and": "and",
+	"or":  "or",
+	"==":  "eq",
+	"!=":  "ne",
+	"<":   "lt",
+	"<=":  "le",
+	">":   "gt",
+	">=":  "ge",
+	"+":   "add",
+	"-":   "sub",
+	"*":   "mul",
+	"/":   "div",
+	"//":  "floordiv",
+	"%":   "mod",
+	"**":  "pow",
+	"~":   "concat",
+}
+
+// emitCall renders function and method calls
+func (c *converter) emitCall(n callNode) (string, error) {
+	args, err := c.emitArgs(n.args, n.kwargs, false)
+	if err != nil {
+		return "", err
+	}
+
+	switch fn := n.fn.(type) {
+	case nameNode:
+		if def, ok := c.macros[fn.name]; ok {
+			return "(" + strings.Join(append([]string{"callMacro", strconv.Quote(def), "$"}, args...), " ") + ")", nil
+		}
+		if fn.name == "super" {
+			block := c.currentBlock()
+			if block == "" {
+				return "", fmt.Errorf("super() used outside of a block")
+			}
+			return fmt.Sprintf("(superBlock %s $)", strconv.Quote(block)), nil
+		}
+		name, ok := globalFunctions[fn.name]
+		if !ok {
+			if _, custom := c.engine.functions[fn.name]; !custom {
+				return "", fmt.Errorf("unknown function '%s'", fn.name)
+			}
+			name = fn.name
+		}
+		return "(" + strings.Join(append([]string{name}, args...), " ") + ")", nil
+
+	case attrNode:
+		if alias, ok := fn.obj.(nameNode); ok {
+			if def, ok := c.macros[alias.name+"."+fn.attr]; ok {
+				return "(" + strings.Join(append([]string{"callMacro", strconv.Quote(def), "$"}, args...), " ") + ")", nil
+			}
+		}
+		obj, err := c.emit(fn.obj)
+		if err != nil {
+			return "", err
+		}
+		parts := append([]string{"callMethod", obj, strconv.Quote(fn.attr)}, args...)
+		return "(" + strings.Join(parts, " ") + ")", nil
+	}
+
+	return "", fmt.Errorf("expression is not callable")
+}
+
+// emitArgs renders positional arguments followed by keyword arguments
+func (c *converter) emitArgs(args []exprNode, kwargs []keywordNode, safe bool) ([]string, error) {
+	result := make([]string, 0, len(args)+len(kwargs))
+	for _, arg := range args {
+		c.safe = safe
+		value, err := c.emit(arg)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, value)
+	}
+	for _, kw := range kwargs {
+		c.safe = safe
+		value, err := c.emit(kw.value)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, fmt.Sprintf("(kwarg %s %s)", strconv.Quote(kw.name), value))
+	}
+	return result, nil
+}
+
+// describe renders a variable or attribute chain in Jinja2 notation for
+// use in error messages
+func describe(node exprNode) string {
+	switch n := node.(type) {
+	case nameNode:
+		return n.name
+	case attrNode:
+		return describe(n.obj) + "." + n.attr
+	case itemNode:
+		if key, ok := n.key.(literalNode); ok {
+			if s, ok := key.value.(string); ok {
+				return describe(n.obj) + "['" + s + "']"
+			}
+			return describe(n.obj) + "[" + fmt.Sprint(key.value) + "]"
+		}
+		return describe(n.obj) + "[...]"
+	}
+	return "expression"
+}
+
+// emitLiteral renders a constant as a Go template literal
+func emitLiteral(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return strconv.Quote(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		s := strconv.FormatFloat(v, 'f', -1, 64)
+		if !strings.Contains(s, ".") {
+			s += ".0"
+		}
+		return s
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return "nil"
+}
@@ -0,0 +1,793 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package template
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// tokenKind identifies the lexical class of an expression token
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenName
+	tokenString
+	tokenNumber
+	tokenOperator
+)
+
+// token is a single lexical element of a Jinja2 expression
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+// operators lists multi-character operators before their single-character prefixes
+var operators = []string{
+	"==", "!=", "<=", ">=", "//", "**",
+	"<", ">", "+", "-", "*", "/", "%", "~", "|",
+	".", ",", "(", ")", "[", "]", "{", "}", ":", "=",
+}
+
+// tokenizeExpression splits a Jinja2 expression into tokens
+func tokenizeExpression(src string) ([]token, error) {
+	var tokens []token
+	i := 0
+
+	for i < len(src) {
+		ch := src[i]
+
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			i++
+
+		case ch == '\'' || ch == '"':
+			value, next, err := scanStringLiteral(src, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokenString, value: value, pos: i})
+			i = next
+
+		case isDigit(ch):
+			start := i
+			for i < len(src) && (isDigit(src[i]) || src[i] == '_') {
+				i++
+			}
+			// A fraction is only part of the number when it does not follow
+			// an attribute dot, so "items.0.name" stays an attribute chain.
+			afterDot := len(tokens) > 0 && tokens[len(tokens)-1].value == "." && tokens[len(tokens)-1].kind == tokenOperator
+			if !afterDot && i+1 < len(src) && src[i] == '.' && isDigit(src[i+1]) {
+				i++
+				for i < len(src) && isDigit(src[i]) {
+					i++
+				}
+			}
+			tokens = append(tokens, token{kind: tokenNumber, value: strings.ReplaceAll(src[start:i], "_", ""), pos: start})
+
+		case isNameStart(ch):
+			start := i
+			for i < len(src) && isNameChar(src[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenName, value: src[start:i], pos: start})
+
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(src[i:], op) {
+					tokens = append(tokens, token{kind: tokenOperator, value: op, pos: i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at position %d", ch, i)
+			}
+		}
+	}
+
+	tokens = append(tokens, token{kind: tokenEOF, pos: len(src)})
+	return tokens, nil
+}
+
+// scanStringLiteral reads a quoted string starting at src[start]
+func scanStringLiteral(src string, start int) (string, int, error) {
+	quote := src[start]
+	var sb strings.Builder
+
+	for i := start + 1; i < len(src); i++ {
+		ch := src[i]
+		if ch == quote {
+			return sb.String(), i + 1, nil
+		}
+		if ch == '\\' && i+1 < len(src) {
+			i++
+			switch src[i] {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case 'r':
+				sb.WriteByte('\r')
+			case '0':
+				sb.WriteByte(0)
+			case '\\', '\'', '"':
+				sb.WriteByte(src[i])
+			default:
+				sb.WriteByte('\\')
+				sb.WriteByte(src[i])
+			}
+			continue
+		}
+		sb.WriteByte(ch)
+	}
+
+	return "", 0, fmt.Errorf("unterminated string starting at position %d", start)
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+func isNameStart(ch byte) bool {
+	return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+func isNameChar(ch byte) bool {
+	return isNameStart(ch) || isDigit(ch)
+}
+
+// Expression AST
+
+type exprNode interface{}
+
+type nameNode struct {
+	name string
+}
+
+type literalNode struct {
+	value interface{} // string, int64, float64, bool or nil
+}
+
+type attrNode struct {
+	obj  exprNode
+	attr string
+}
+
+type itemNode struct {
+	obj exprNode
+	key exprNode
+}
+
+type sliceNode struct {
+	obj        exprNode
+	start, end exprNode
+}
+
+type callNode struct {
+	fn     exprNode
+	args   []exprNode
+	kwargs []keywordNode
+}
+
+type keywordNode struct {
+	name  string
+	value exprNode
+}
+
+type filterNode struct {
+	input  exprNode
+	name   string
+	args   []exprNode
+	kwargs []keywordNode
+}
+
+type testNode struct {
+	input  exprNode
+	name   string
+	args   []exprNode
+	negate bool
+}
+
+type binaryNode struct {
+	op          string
+	left, right exprNode
+}
+
+type unaryNode struct {
+	op      string
+	operand exprNode
+}
+
+type condNode struct {
+	cond, then, otherwise exprNode
+}
+
+type listNode struct {
+	items []exprNode
+}
+
+type dictNode struct {
+	keys, values []exprNode
+}
+
+// exprParser is a recursive descent parser following Jinja2 operator precedence
+type exprParser struct {
+	tokens []token
+	pos    int
+}
+
+// parseExpression parses a complete Jinja2 expression
+func parseExpression(src string) (exprNode, error) {
+	tokens, err := tokenizeExpression(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &exprParser{tokens: tokens}
+	node, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.peek().value, p.peek().pos)
+	}
+	return node, nil
+}
+
+func (p *exprParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *exprParser) isOp(value string) bool {
+	tok := p.peek()
+	return tok.kind == tokenOperator && tok.value == value
+}
+
+func (p *exprParser) isKeyword(value string) bool {
+	tok := p.peek()
+	return tok.kind == tokenName && tok.value == value
+}
+
+func (p *exprParser) expectOp(value string) error {
+	tok := p.next()
+	if tok.kind != tokenOperator || tok.value != value {
+		if tok.kind == tokenEOF {
+			return fmt.Errorf("expected %q but reached end of expression", value)
+		}
+		return fmt.Errorf("expected %q at position %d, got %q", value, tok.pos, tok.value)
+	}
+	return nil
+}
+
+func (p *exprParser) expectName() (string, error) {
+	tok := p.next()
+	if tok.kind != tokenName {
+		return "", fmt.Errorf("expected name at position %d, got %q", tok.pos, tok.value)
+	}
+	return tok.value, nil
+}
+
+func (p *exprParser) parseTernary() (exprNode, error) {
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.isKeyword("if") {
+		p.next()
+		cond, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		var otherwise exprNode = literalNode{value: nil}
+		if p.isKeyword("else") {
+			p.next()
+			otherwise, err = p.parseTernary()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return condNode{cond: cond, then: node, otherwise: otherwise}, nil
+	}
+
+	return node, nil
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: "or", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("and") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: "and", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseNot() (exprNode, error) {
+	if p.isKeyword("not") {
+		p.next()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return unaryNode{op: "not", operand: operand}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *exprParser) parseCompare() (exprNode, error) {
+	left, err := p.parseMath1()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		tok := p.peek()
+		var op string
+		switch {
+		case tok.kind == tokenOperator && (tok.value == "==" || tok.value == "!=" || tok.value == "<" ||
+			tok.value == ">" || tok.value == "<=" || tok.value == ">="):
+			op = tok.value
+			p.next()
+		case tok.kind == tokenName && tok.value == "in":
+			op = "in"
+			p.next()
+		case tok.kind == tokenName && tok.value == "not" && p.tokens[p.pos+1].kind == tokenName && p.tokens[p.pos+1].value == "in":
+			op = "not in"
+			p.next()
+			p.next()
+		default:
+			return left, nil
+		}
+
+		right, err := p.parseMath1()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *exprParser) parseMath1() (exprNode, error) {
+	left, err := p.parseConcat()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("+") || p.isOp("-") {
+		op := p.next().value
+		right, err := p.parseConcat()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseConcat() (exprNode, error) {
+	left, err := p.parseMath2()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("~") {
+		p.next()
+		right, err := p.parseMath2()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: "~", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseMath2() (exprNode, error) {
+	left, err := p.parsePow()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("*") || p.isOp("/") || p.isOp("//") || p.isOp("%") {
+		op := p.next().value
+		right, err := p.parsePow()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parsePow() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("**") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: "**", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.isOp("-") || p.isOp("+") {
+		op := p.next().value
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if lit, ok := operand.(literalNode); ok && op == "-" {
+			switch v := lit.value.(type) {
+			case int64:
+				return literalNode{value: -v}, nil
+			case float64:
+				return literalNode{value: -v}, nil
+			}
+		}
+		if op == "+" {
+			return operand, nil
+		}
+		return unaryNode{op: "-", operand: operand}, nil
+	}
+
+	node, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	node, err = p.parsePostfix(node)
+	if err != nil {
+		return nil, err
+	}
+	return p.parseFilterExpr(node)
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	tok := p.next()
+
+	switch tok.kind {
+	case tokenString:
+		value := tok.value
+		// Adjacent string literals are concatenated, as in Jinja2
+		for p.peek().kind == tokenString {
+			value += p.next().value
+		}
+		return literalNode{value: value}, nil
+
+	case tokenNumber:
+		if strings.Contains(tok.value, ".") {
+			f, err := strconv.ParseFloat(tok.value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q", tok.value)
+			}
+			return literalNode{value: f}, nil
+		}
+		i, err := strconv.ParseInt(tok.value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", tok.value)
+		}
+		return literalNode{value: i}, nil
+
+	case tokenName:
+		switch tok.value {
+		case "true", "True":
+			return literalNode{value: true}, nil
+		case "false", "False":
+			return literalNode{value: false}, nil
+		case "none", "None":
+			return literalNode{value: nil}, nil
+		}
+		return nameNode{name: tok.value}, nil
+
+	case tokenOperator:
+		switch tok.value {
+		case "(":
+			if p.isOp(")") {
+				p.next()
+				return listNode{}, nil
+			}
+			first, err := p.parseTernary()
+			if err != nil {
+				return nil, err
+			}
+			if !p.isOp(",") {
+				if err := p.expectOp(")"); err != nil {
+					return nil, err
+				}
+				return first, nil
+			}
+			items := []exprNode{first}
+			for p.isOp(",") {
+				p.next()
+				if p.isOp(")") {
+					break
+				}
+				item, err := p.parseTernary()
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+			}
+			if err := p.expectOp(")"); err != nil {
+				return nil, err
+			}
+			return listNode{items: items}, nil
+
+		case "[":
+			var items []exprNode
+			for !p.isOp("]") {
+				item, err := p.parseTernary()
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+				if !p.isOp(",") {
+					break
+				}
+				p.next()
+			}
+			if err := p.expectOp("]"); err != nil {
+				return nil, err
+			}
+			return listNode{items: items}, nil
+
+		case "{":
+			node := dictNode{}
+			for !p.isOp("}") {
+				key, err := p.parseTernary()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expectOp(":"); err != nil {
+					return nil, err
+				}
+				value, err := p.parseTernary()
+				if err != nil {
+					return nil, err
+				}
+				node.keys = append(node.keys, key)
+				node.values = append(node.values, value)
+				if !p.isOp(",") {
+					break
+				}
+				p.next()
+			}
+			if err := p.expectOp("}"); err != nil {
+				return nil, err
+			}
+			return node, nil
+		}
+	}
+
+	if tok.kind == tokenEOF {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", tok.value, tok.pos)
+}
+
+func (p *exprParser) parsePostfix(node exprNode) (exprNode, error) {
+	for {
+		switch {
+		case p.isOp("."):
+			p.next()
+			tok := p.next()
+			if tok.kind != tokenName && tok.kind != tokenNumber {
+				return nil, fmt.Errorf("expected attribute name at position %d", tok.pos)
+			}
+			if tok.kind == tokenNumber {
+				i, _ := strconv.ParseInt(tok.value, 10, 64)
+				node = itemNode{obj: node, key: literalNode{value: i}}
+			} else {
+				node = attrNode{obj: node, attr: tok.value}
+			}
+
+		case p.isOp("["):
+			p.next()
+			var start exprNode
+			if !p.isOp(":") {
+				key, err := p.parseTernary()
+				if err != nil {
+					return nil, err
+				}
+				if p.isOp("]") {
+					p.next()
+					node = itemNode{obj: node, key: key}
+					continue
+				}
+				start = key
+			}
+			if err := p.expectOp(":"); err != nil {
+				return nil, err
+			}
+			var end exprNode
+			if !p.isOp("]") {
+				var err error
+				end, err = p.parseTernary()
+				if err != nil {
+					return nil, err
+				}
+			}
+			if err := p.expectOp("]"); err != nil {
+				return nil, err
+			}
+			node = sliceNode{obj: node, start: start, end: end}
+
+		case p.isOp("("):
+			p.next()
+			args, kwargs, err := p.parseCallArgs()
+			if err != nil {
+				return nil, err
+			}
+			node = callNode{fn: node, args: args, kwargs: kwargs}
+
+		default:
+			return node, nil
+		}
+	}
+}
+
+// parseCallArgs parses positional and keyword arguments up to the closing paren
+func (p *exprParser) parseCallArgs() ([]exprNode, []keywordNode, error) {
+	var args []exprNode
+	var kwargs []keywordNode
+
+	for !p.isOp(")") {
+		tok := p.peek()
+		if tok.kind == tokenName && p.tokens[p.pos+1].kind == tokenOperator && p.tokens[p.pos+1].value == "=" {
+			p.next()
+			p.next()
+			value, err := p.parseTernary()
+			if err != nil {
+				return nil, nil, err
+			}
+			kwargs = append(kwargs, keywordNode{name: tok.value, value: value})
+		} else {
+			if len(kwargs) > 0 {
+				return nil, nil, fmt.Errorf("positional argument follows keyword argument at position %d", tok.pos)
+			}
+			arg, err := p.parseTernary()
+			if err != nil {
+				return nil, nil, err
+			}
+			args = append(args, arg)
+		}
+
+		if !p.isOp(",") {
+			break
+		}
+		p.next()
+	}
+
+	if err := p.expectOp(")"); err != nil {
+		return nil, nil, err
+	}
+	return args, kwargs, nil
+}
+
+func (p *exprParser) parseFilterExpr(node exprNode) (exprNode, error) {
+	for {
+		switch {
+		case p.isOp("|"):
+			p.next()
+			name, err := p.parseQualifiedName()
+			if err != nil {
+				return nil, err
+			}
+			filter := filterNode{input: node, name: name}
+			if p.isOp("(") {
+				p.next()
+				filter.args, filter.kwargs, err = p.parseCallArgs()
+				if err != nil {
+					return nil, err
+				}
+			} else {
+				// Legacy Go-template style arguments: "var | default \"x\""
+				for p.peek().kind == tokenString || p.peek().kind == tokenNumber {
+					arg, err := p.parsePrimary()
+					if err != nil {
+						return nil, err
+					}
+					filter.args = append(filter.args, arg)
+				}
+			}
+			node = filter
+
+		case p.isKeyword("is"):
+			p.next()
+			test := testNode{input: node}
+			if p.isKeyword("not") {
+				p.next()
+				test.negate = true
+			}
+			name, err := p.parseQualifiedName()
+			if err != nil {
+				return nil, err
+			}
+			test.name = name
+			if p.isOp("(") {
+				p.next()
+				test.args, _, err = p.parseCallArgs()
+				if err != nil {
+					return nil, err
+				}
+			} else if p.peek().kind == tokenString || p.peek().kind == tokenNumber {
+				arg, err := p.parsePrimary()
+				if err != nil {
+					return nil, err
+				}
+				test.args = append(test.args, arg)
+			}
+			node = test
+
+		default:
+			return node, nil
+		}
+	}
+}
+
+// parseQualifiedName parses filter and test names, which may be collection
+// qualified (ansible.builtin.to_json); only the last segment is kept
+func (p *exprParser) parseQualifiedName() (string, error) {
+	name, err := p.expectName()
+	if err != nil {
+		return "", err
+	}
+	for p.isOp(".") && p.tokens[p.pos+1].kind == tokenName {
+		p.next()
+		name = p.next().value
+	}
+	return name, nil
+}
@@ -0,0 +1,63 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package template
+
+import (
+	"errors"
+)
+
+// Lazy is a context variable whose value is computed when a template first
+// uses it, typically a variable that is itself defined by a template.
+// Resolve is responsible for memoisation and cycle detection.
+type Lazy struct {
+	Name    string
+	Resolve func() (interface{}, error)
+}
+
+// resolve returns the value of a context variable, resolving it if lazy
+func (e *Engine) resolve(v interface{}) (interface{}, error) {
+	if lazy, ok := v.(*Lazy); ok {
+		return lazy.Resolve()
+	}
+	return v, nil
+}
+
+// resolveLazily resolves a variable in a lazily evaluated operand, where a
+// variable built on undefined variables is itself undefined
+func (e *Engine) resolveLazily(v interface{}, name string) (interface{}, error) {
+	value, err := e.resolve(v)
+	if err != nil {
+		var undefined *UndefinedError
+		var tmplErr *TemplateError
+		if errors.As(err, &undefined) || (errors.As(err, &tmplErr) && tmplErr.Variable != "") {
+			return Undefined{Name: name}, nil
+		}
+		return nil, err
+	}
+	return value, nil
+}
+
+// ResolveVariable returns the value of a variable handed to a consumer
+// outside the template, such as a lookup plugin, resolving it if lazy.
+// Consumers resolve only the variables they read, so a variable that
+// cannot be resolved fails only the lookups that use it.
+func ResolveVariable(v interface{}) (interface{}, error) {
+	if lazy, ok := v.(*Lazy); ok {
+		return lazy.Resolve()
+	}
+	return v, nil
+}
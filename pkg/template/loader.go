@@ -0,0 +1,233 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package template
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/afero"
+)
+
+// errTemplateNotFound is returned when no search path entry holds a template
+var errTemplateNotFound = errors.New("template not found")
+
+// maxIncludeDepth bounds recursive includes
+const maxIncludeDepth = 32
+
+// SetFilesystem sets the filesystem templates are loaded from
+func (e *Engine) SetFilesystem(fs afero.Fs) {
+	e.fs = fs
+}
+
+// WithFilesystem returns a copy of the engine that loads templates from
+// fs. The copy shares the functions, filters, tests and lookups of the
+// engine, so one configured engine can serve several filesystems.
+func (e *Engine) WithFilesystem(fs afero.Fs) *Engine {
+	engine := *e
+	engine.fs = fs
+	return &engine
+}
+
+// RenderFile loads a template by name from the search path and renders it
+func (e *Engine) RenderFile(name string, ctx *Context) (string, error) {
+	path, src, err := e.loadTemplate(name, ctx, "")
+	if err != nil {
+		return "", &TemplateError{Template: name, Message: err.Error(), Err: err}
+	}
+
+	result, _, err := e.execute(path, src, ctx, false)
+	return result, err
+}
+
+// loadTemplate finds a template and reads it. Relative names are looked up
+// next to the including template, then in the "templates" directory and
+// the root of each search path entry, like Ansible's template lookup.
+func (e *Engine) loadTemplate(name string, ctx *Context, from string) (string, string, error) {
+	var candidates []string
+	if filepath.IsAbs(name) {
+		candidates = append(candidates, name)
+	} else {
+		if from != "" {
+			candidates = append(candidates, filepath.Join(filepath.Dir(from), name))
+		}
+		for _, dir := range e.searchPath(ctx) {
+			candidates = append(candidates, filepath.Join(dir, "templates", name), filepath.Join(dir, name))
+		}
+		candidates = append(candidates, name)
+	}
+
+	for _, candidate := range candidates {
+		isFile, err := afero.Exists(e.fs, candidate)
+		if err != nil || !isFile {
+			continue
+		}
+		if isDir, _ := afero.IsDir(e.fs, candidate); isDir {
+			continue
+		}
+
+		data, err := afero.ReadFile(e.fs, candidate)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read template '%s': %w", candidate, err)
+		}
+		return candidate, string(data), nil
+	}
+
+	return "", "", fmt.Errorf("%w: '%s'", errTemplateNotFound, name)
+}
+
+// searchPath returns the directories templates are looked up in: the
+// context's SearchPath followed by ansible_search_path, role_path and
+// playbook_dir from the variables
+func (e *Engine) searchPath(ctx *Context) []string {
+	if ctx == nil {
+		return nil
+	}
+
+	dirs := append([]string{}, ctx.SearchPath...)
+	for _, name := range []string{"ansible_search_path", "role_path", "playbook_dir"} {
+		value, ok := ctx.Variables[name]
+		if !ok {
+			continue
+		}
+		value, err := e.resolve(value)
+		if err != nil {
+			continue
+		}
+		switch v := value.(type) {
+		case string:
+			dirs = append(dirs, v)
+		case []string:
+			dirs = append(dirs, v...)
+		case []interface{}:
+			for _, dir := range v {
+				dirs = append(dirs, e.toString(dir))
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	result := dirs[:0]
+	for _, dir := range dirs {
+		if dir == "" || seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		result = append(result, dir)
+	}
+	return result
+}
+
+// addDefinitionFuncs adds the functions through which a template renders
+// its macros, blocks, set blocks and includes
+func (e *Engine) addDefinitionFuncs(funcMap template.FuncMap, ctx *Context, unit *compilation, tmpl func() *template.Template) {
+	render := func(def string, data map[string]interface{}) (string, error) {
+		var result strings.Builder
+		if err := tmpl().ExecuteTemplate(&result, def, data); err != nil {
+			inner := e.templateError(def, "", err, unit.names)
+			inner.nested = true
+			return "", inner
+		}
+		return result.String(), nil
+	}
+
+	// frame copies the template data for a definition call
+	frame := func(data map[string]interface{}) map[string]interface{} {
+		result := make(map[string]interface{}, len(data)+2)
+		for key, value := range data {
+			result[key] = value
+		}
+		return result
+	}
+
+	funcMap["callMacro"] = func(def string, data map[string]interface{}, args ...interface{}) (string, error) {
+		positional, keywords := splitKeywordArgs(args)
+		call := frame(data)
+		call["Args"] = positional
+		call["Kwargs"] = keywords
+		return render(def, call)
+	}
+
+	funcMap["macroArg"] = func(data map[string]interface{}, index int, name string, fallback interface{}) interface{} {
+		if keywords, ok := data["Kwargs"].(map[string]interface{}); ok {
+			if value, ok := keywords[name]; ok {
+				return value
+			}
+		}
+		if positional, ok := data["Args"].([]interface{}); ok && index < len(positional) {
+			return positional[index]
+		}
+		return fallback
+	}
+
+	funcMap["undefined"] = func(name string) Undefined {
+		return Undefined{Name: name}
+	}
+
+	funcMap["callDefinition"] = func(def string, data map[string]interface{}, locals map[string]interface{}) (string, error) {
+		call := frame(data)
+		call["Locals"] = locals
+		return render(def, call)
+	}
+
+	funcMap["superBlock"] = func(def string, data map[string]interface{}) (string, error) {
+		parent, ok := unit.parents[def]
+		if !ok {
+			return "", fmt.Errorf("no parent block '%s' to render with super()", definitionLabel(def))
+		}
+		return render(parent, data)
+	}
+
+	funcMap["include"] = func(name interface{}, from string, locals map[string]interface{}, ignoreMissing bool) (string, error) {
+		if ctx.includeDepth >= maxIncludeDepth {
+			return "", fmt.Errorf("maximum include depth of %d exceeded", maxIncludeDepth)
+		}
+
+		path, src, err := e.loadTemplate(e.toString(name), ctx, from)
+		if err != nil {
+			if ignoreMissing && errors.Is(err, errTemplateNotFound) {
+				return "", nil
+			}
+			return "", err
+		}
+
+		// The included template sees the variables and the caller's locals
+		included := *ctx
+		included.Variables = make(map[string]interface{}, len(ctx.Variables)+len(locals))
+		for key, value := range ctx.Variables {
+			included.Variables[key] = value
+		}
+		for key, value := range locals {
+			included.Variables[key] = value
+		}
+		included.includeDepth++
+
+		result, _, err := e.execute(path, src, &included, false)
+		ctx.Warnings = included.Warnings
+		if err != nil {
+			var tmplErr *TemplateError
+			if errors.As(err, &tmplErr) {
+				tmplErr.nested = true
+			}
+			return "", err
+		}
+		return result, nil
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package template
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LookupFunc runs a lookup plugin by name. Terms are the positional
+// arguments given in the template, options the keyword arguments (minus
+// wantlist and errors, which the engine handles itself).
+type LookupFunc func(name string, terms []interface{}, options map[string]interface{}, variables map[string]interface{}) ([]interface{}, error)
+
+// SetLookupFunc installs the function used by lookup(), query() and q()
+func (e *Engine) SetLookupFunc(fn LookupFunc) {
+	e.lookupFunc = fn
+}
+
+// lookup implements the lookup() and query() template functions
+func (e *Engine) lookup(ctx *Context, name string, wantList bool, args ...interface{}) (interface{}, error) {
+	terms, options := splitKeywordArgs(args)
+
+	if wl, ok := options["wantlist"]; ok {
+		wantList = wantList || e.toBool(wl)
+		delete(options, "wantlist")
+	}
+
+	errorMode := "strict"
+	if mode, ok := options["errors"]; ok {
+		errorMode = e.toString(mode)
+		delete(options, "errors")
+	}
+	switch errorMode {
+	case "strict", "warn", "ignore":
+	default:
+		return nil, fmt.Errorf("lookup '%s': errors must be one of strict, warn or ignore, got '%s'", name, errorMode)
+	}
+
+	if e.lookupFunc == nil {
+		return nil, fmt.Errorf("lookup plugin '%s' is not available: no lookup plugins configured", name)
+	}
+
+	var variables map[string]interface{}
+	if ctx != nil {
+		// Lazy variables are passed through unresolved; lookups resolve
+		// the ones they read with ResolveVariable
+		variables = make(map[string]interface{}, len(ctx.Variables)+2)
+		for name, value := range ctx.Variables {
+			variables[name] = value
+		}
+		// Lookups such as vars and inventory_hostnames see the magic
+		// variables templates reach through hostvars and groups
+		if _, ok := variables["hostvars"]; !ok && ctx.Hostvars != nil {
+			variables["hostvars"] = ctx.Hostvars
+		}
+		if _, ok := variables["groups"]; !ok && ctx.Groups != nil {
+			variables["groups"] = ctx.Groups
+		}
+	}
+
+	results, err := e.lookupFunc(name, terms, options, variables)
+	if err != nil {
+		if errorMode == "strict" {
+			return nil, fmt.Errorf("lookup plugin '%s' failed: %w", name, err)
+		}
+		if errorMode == "warn" && ctx != nil {
+			ctx.Warnings = append(ctx.Warnings, fmt.Sprintf("lookup plugin '%s' failed: %v", name, err))
+		}
+		if wantList {
+			return []interface{}{}, nil
+		}
+		return nil, nil
+	}
+
+	if wantList {
+		if results == nil {
+			results = []interface{}{}
+		}
+		return results, nil
+	}
+
+	// Like Ansible, lookup() unwraps single results and joins the rest
+	if len(results) == 1 {
+		return results[0], nil
+	}
+	parts := make([]string, len(results))
+	for i, result := range results {
+		parts[i] = e.toString(result)
+	}
+	return strings.Join(parts, ","), nil
+}
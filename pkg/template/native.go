@@ -0,0 +1,50 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package template
+
+import (
+	"strings"
+)
+
+// RenderNative renders a template the way Ansible's jinja2_native mode
+// does. A template made of a single expression returns the expression's
+// value with its Go type intact, so "{{ [1, 2] }}" yields a list and
+// "{{ port | int }}" an int. Any other template renders to a string.
+func (e *Engine) RenderNative(templateStr string, ctx *Context) (interface{}, error) {
+	if !isSingleExpression(templateStr) {
+		return e.Render(templateStr, ctx)
+	}
+
+	_, value, err := e.execute("", strings.TrimSpace(templateStr), ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// isSingleExpression reports whether the template consists of exactly one
+// {{ }} tag, ignoring surrounding whitespace
+func isSingleExpression(templateStr string) bool {
+	src := strings.TrimSpace(templateStr)
+	start, kind := findTagStart(src, 0)
+	if start != 0 || kind != tagExpression {
+		return false
+	}
+
+	_, end, err := scanTag(src, start, kind)
+	return err == nil && end == len(src)
+}
@@ -0,0 +1,490 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package template
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Runtime helpers backing the Go template functions emitted by the converter
+
+// keywordArg carries a name=value argument through a Go template call
+type keywordArg struct {
+	Name  string
+	Value interface{}
+}
+
+// SplitKeywordArgs separates the keyword arguments a template passes to a
+// custom filter or function (name=value) from its positional arguments
+func SplitKeywordArgs(args []interface{}) ([]interface{}, map[string]interface{}) {
+	return splitKeywordArgs(args)
+}
+
+// splitKeywordArgs separates keyword arguments from positional arguments
+func splitKeywordArgs(args []interface{}) ([]interface{}, map[string]interface{}) {
+	positional := make([]interface{}, 0, len(args))
+	keywords := make(map[string]interface{})
+
+	for _, arg := range args {
+		if kw, ok := arg.(keywordArg); ok {
+			keywords[kw.Name] = kw.Value
+			continue
+		}
+		positional = append(positional, arg)
+	}
+
+	return positional, keywords
+}
+
+func (e *Engine) equal(a, b interface{}) bool {
+	if e.isNumber(a) && e.isNumber(b) {
+		return e.toFloat(a) == e.toFloat(b)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compare orders numbers numerically and strings lexically
+func (e *Engine) compare(a, b interface{}) (int, error) {
+	if e.isNumber(a) && e.isNumber(b) {
+		fa, fb := e.toFloat(a), e.toFloat(b)
+		switch {
+		case fa < fb:
+			return -1, nil
+		case fa > fb:
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	sa, aok := a.(string)
+	sb, bok := b.(string)
+	if aok && bok {
+		return strings.Compare(sa, sb), nil
+	}
+
+	return 0, fmt.Errorf("cannot compare %T with %T", a, b)
+}
+
+// contains implements the Jinja2 "in" operator
+func (e *Engine) contains(container, item interface{}) bool {
+	if container == nil {
+		return false
+	}
+
+	if s, ok := container.(string); ok {
+		return strings.Contains(s, e.toString(item))
+	}
+
+	val := reflect.ValueOf(container)
+	switch val.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < val.Len(); i++ {
+			if e.equal(val.Index(i).Interface(), item) {
+				return true
+			}
+		}
+	case reflect.Map:
+		for _, key := range val.MapKeys() {
+			if e.equal(key.Interface(), item) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (e *Engine) floordiv(a, b interface{}) (interface{}, error) {
+	divisor := e.toFloat(b)
+	if divisor == 0 {
+		return nil, fmt.Errorf("integer division by zero")
+	}
+	return int(math.Floor(e.toFloat(a) / divisor)), nil
+}
+
+func (e *Engine) mod(a, b interface{}) (interface{}, error) {
+	if _, ok := a.(string); ok {
+		// String formatting: "%s-%d" % (a, b)
+		if args, ok := b.([]interface{}); ok {
+			return fmt.Sprintf(a.(string), args...), nil
+		}
+		return fmt.Sprintf(a.(string), b), nil
+	}
+
+	divisor := e.toFloat(b)
+	if divisor == 0 {
+		return nil, fmt.Errorf("integer division or modulo by zero")
+	}
+	result := math.Mod(e.toFloat(a), divisor)
+	if result != 0 && (result < 0) != (divisor < 0) {
+		result += divisor
+	}
+	if e.isInteger(a) && e.isInteger(b) {
+		return int(result), nil
+	}
+	return result, nil
+}
+
+func (e *Engine) isInteger(v interface{}) bool {
+	switch v.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return true
+	}
+	return false
+}
+
+// newDict builds a map from alternating key/value arguments and keyword
+// arguments. Like Python's dict(), a single argument may also be a mapping
+// or a list of key/value pairs, e.g. dict(keys | zip(values)).
+func (e *Engine) newDict(args ...interface{}) (map[string]interface{}, error) {
+	pairs, keywords := splitKeywordArgs(args)
+	if len(pairs) == 1 {
+		var err error
+		if pairs, err = e.dictPairs(pairs[0]); err != nil {
+			return nil, err
+		}
+	}
+	if len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("dict requires key/value pairs")
+	}
+
+	result := make(map[string]interface{}, len(pairs)/2+len(keywords))
+	for i := 0; i < len(pairs); i += 2 {
+		result[e.toString(pairs[i])] = pairs[i+1]
+	}
+	for k, v := range keywords {
+		result[k] = v
+	}
+	return result, nil
+}
+
+// dictPairs flattens a mapping or a list of two-item lists into
+// alternating keys and values
+func (e *Engine) dictPairs(v interface{}) ([]interface{}, error) {
+	val := reflect.ValueOf(v)
+	switch val.Kind() {
+	case reflect.Map:
+		var pairs []interface{}
+		for _, key := range val.MapKeys() {
+			pairs = append(pairs, key.Interface(), val.MapIndex(key).Interface())
+		}
+		return pairs, nil
+	case reflect.Slice, reflect.Array:
+		pairs := make([]interface{}, 0, 2*val.Len())
+		for i := 0; i < val.Len(); i++ {
+			item := reflect.ValueOf(val.Index(i).Interface())
+			if (item.Kind() != reflect.Slice && item.Kind() != reflect.Array) || item.Len() != 2 {
+				return nil, fmt.Errorf("dict requires a list of key/value pairs, got %v", val.Index(i).Interface())
+			}
+			pairs = append(pairs, item.Index(0).Interface(), item.Index(1).Interface())
+		}
+		return pairs, nil
+	}
+	return nil, fmt.Errorf("dict requires key/value pairs")
+}
+
+// getItem implements subscript and attribute access on maps, lists and
+// structs, returning nil when the key does not exist
+func (e *Engine) getItem(obj, key interface{}) interface{} {
+	value, _ := e.lookupItem(obj, key)
+	return value
+}
+
+// lookupItem is getItem that also reports whether the key exists
+func (e *Engine) lookupItem(obj, key interface{}) (interface{}, bool) {
+	if obj == nil {
+		return nil, false
+	}
+
+	val := reflect.ValueOf(obj)
+	for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			return nil, false
+		}
+		val = val.Elem()
+	}
+
+	switch val.Kind() {
+	case reflect.Map:
+		keyVal := reflect.ValueOf(key)
+		if val.Type().Key().Kind() == reflect.String {
+			keyVal = reflect.ValueOf(e.toString(key)).Convert(val.Type().Key())
+		}
+		if !keyVal.IsValid() || !keyVal.Type().AssignableTo(val.Type().Key()) {
+			return nil, false
+		}
+		result := val.MapIndex(keyVal)
+		if !result.IsValid() {
+			return nil, false
+		}
+		return result.Interface(), true
+
+	case reflect.Slice, reflect.Array, reflect.String:
+		if !e.isNumber(key) {
+			return nil, false
+		}
+		idx := e.toInt(key)
+		if idx < 0 {
+			idx += val.Len()
+		}
+		if idx < 0 || idx >= val.Len() {
+			return nil, false
+		}
+		if val.Kind() == reflect.String {
+			return string(val.String()[idx]), true
+		}
+		return val.Index(idx).Interface(), true
+
+	case reflect.Struct:
+		field := val.FieldByName(e.toString(key))
+		if field.IsValid() && field.CanInterface() {
+			return field.Interface(), true
+		}
+	}
+
+	return nil, false
+}
+
+// getSlice implements Python slice semantics for lists and strings
+func (e *Engine) getSlice(obj, start, end interface{}) interface{} {
+	val := reflect.ValueOf(obj)
+	if val.Kind() != reflect.Slice && val.Kind() != reflect.Array && val.Kind() != reflect.String {
+		return nil
+	}
+
+	length := val.Len()
+	clamp := func(v interface{}, def int) int {
+		if v == nil {
+			return def
+		}
+		i := e.toInt(v)
+		if i < 0 {
+			i += length
+		}
+		if i < 0 {
+			return 0
+		}
+		if i > length {
+			return length
+		}
+		return i
+	}
+
+	from, to := clamp(start, 0), clamp(end, length)
+	if from > to {
+		from = to
+	}
+
+	if val.Kind() == reflect.String {
+		return val.String()[from:to]
+	}
+
+	result := make([]interface{}, 0, to-from)
+	for i := from; i < to; i++ {
+		result = append(result, val.Index(i).Interface())
+	}
+	return result
+}
+
+// iterable converts a value into the sequence a for loop iterates over;
+// mappings iterate over their sorted keys, as in Jinja2
+func (e *Engine) iterable(v interface{}) []interface{} {
+	if v == nil {
+		return nil
+	}
+
+	val := reflect.ValueOf(v)
+	switch val.Kind() {
+	case reflect.Slice, reflect.Array:
+		result := make([]interface{}, val.Len())
+		for i := range result {
+			result[i] = val.Index(i).Interface()
+		}
+		return result
+
+	case reflect.Map:
+		keys := e.keys(v)
+		sort.Strings(keys)
+		result := make([]interface{}, len(keys))
+		for i, key := range keys {
+			result[i] = key
+		}
+		return result
+
+	case reflect.String:
+		var result []interface{}
+		for _, r := range val.String() {
+			result = append(result, string(r))
+		}
+		return result
+	}
+
+	return []interface{}{v}
+}
+
+// loopInfo builds the Jinja2 "loop" variable for the current iteration
+func (e *Engine) loopInfo(index int, seq []interface{}) map[string]interface{} {
+	length := len(seq)
+	return map[string]interface{}{
+		"index":     index + 1,
+		"index0":    index,
+		"revindex":  length - index,
+		"revindex0": length - index - 1,
+		"first":     index == 0,
+		"last":      index == length-1,
+		"length":    length,
+	}
+}
+
+// rangeList implements the range() global
+func (e *Engine) rangeList(args ...interface{}) ([]interface{}, error) {
+	start, stop, step := 0, 0, 1
+
+	switch len(args) {
+	case 1:
+		stop = e.toInt(args[0])
+	case 2:
+		start, stop = e.toInt(args[0]), e.toInt(args[1])
+	case 3:
+		start, stop, step = e.toInt(args[0]), e.toInt(args[1]), e.toInt(args[2])
+	default:
+		return nil, fmt.Errorf("range expected 1 to 3 arguments, got %d", len(args))
+	}
+
+	if step == 0 {
+		return nil, fmt.Errorf("range step must not be zero")
+	}
+
+	var result []interface{}
+	for i := start; (step > 0 && i < stop) || (step < 0 && i > stop); i += step {
+		result = append(result, i)
+	}
+	return result, nil
+}
+
+// callMethod implements the Python methods commonly called on strings,
+// lists and dicts in Ansible templates
+func (e *Engine) callMethod(obj interface{}, method string, args ...interface{}) (interface{}, error) {
+	argString := func(i int, def string) string {
+		if i < len(args) {
+			return e.toString(args[i])
+		}
+		return def
+	}
+
+	if s, ok := obj.(string); ok {
+		switch method {
+		case "upper":
+			return strings.ToUpper(s), nil
+		case "lower":
+			return strings.ToLower(s), nil
+		case "strip":
+			if len(args) > 0 {
+				return strings.Trim(s, argString(0, "")), nil
+			}
+			return strings.TrimSpace(s), nil
+		case "lstrip":
+			if len(args) > 0 {
+				return strings.TrimLeft(s, argString(0, "")), nil
+			}
+			return strings.TrimLeft(s, " \t\r\n"), nil
+		case "rstrip":
+			if len(args) > 0 {
+				return strings.TrimRight(s, argString(0, "")), nil
+			}
+			return strings.TrimRight(s, " \t\r\n"), nil
+		case "startswith":
+			return strings.HasPrefix(s, argString(0, "")), nil
+		case "endswith":
+			return strings.HasSuffix(s, argString(0, "")), nil
+		case "replace":
+			return strings.ReplaceAll(s, argString(0, ""), argString(1, "")), nil
+		case "split":
+			var parts []string
+			if len(args) == 0 {
+				parts = strings.Fields(s)
+			} else {
+				parts = strings.Split(s, argString(0, ""))
+			}
+			result := make([]interface{}, len(parts))
+			for i, part := range parts {
+				result[i] = part
+			}
+			return result, nil
+		case "join":
+			return e.join(args[0], s), nil
+		case "format":
+			return fmt.Sprintf(strings.ReplaceAll(s, "{}", "%v"), args...), nil
+		}
+	}
+
+	if e.isDict(obj) {
+		switch method {
+		case "keys":
+			keys := e.iterable(obj)
+			return keys, nil
+		case "values":
+			var result []interface{}
+			for _, key := range e.iterable(obj) {
+				result = append(result, e.getItem(obj, key))
+			}
+			return result, nil
+		case "items":
+			var result []interface{}
+			for _, key := range e.iterable(obj) {
+				result = append(result, []interface{}{key, e.getItem(obj, key)})
+			}
+			return result, nil
+		case "get":
+			if len(args) == 0 {
+				return nil, fmt.Errorf("get expected at least 1 argument")
+			}
+			if e.contains(obj, args[0]) {
+				return e.getItem(obj, args[0]), nil
+			}
+			if len(args) > 1 {
+				return args[1], nil
+			}
+			return nil, nil
+		}
+	}
+
+	if e.isList(obj) {
+		switch method {
+		case "index":
+			for i, item := range e.iterable(obj) {
+				if len(args) > 0 && e.equal(item, args[0]) {
+					return i, nil
+				}
+			}
+			return nil, fmt.Errorf("value is not in list")
+		case "count":
+			count := 0
+			for _, item := range e.iterable(obj) {
+				if len(args) > 0 && e.equal(item, args[0]) {
+					count++
+				}
+			}
+			return count, nil
+		}
+	}
+
+	return nil, fmt.Errorf("'%T' object has no method '%s'", obj, method)
+}
@@ -17,20 +17,27 @@ limitations under the License.
 package template
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"reflect"
-	"regexp"
 	"strconv"
 	"strings"
 	"text/template"
 	"unicode"
+
+	"github.com/spf13/afero"
 )
 
 // Engine provides Jinja2-compatible template rendering
 type Engine struct {
-	functions map[string]interface{}
-	filters   map[string]interface{}
-	tests     map[string]interface{}
+	functions  map[string]interface{}
+	filters    map[string]interface{}
+	tests      map[string]interface{}
+	lookupFunc LookupFunc
+
+	// fs holds the templates loaded by include, import and extends
+	fs afero.Fs
 }
 
 // Context holds the template rendering context
@@ -40,6 +47,16 @@ type Context struct {
 	Groups    map[string][]string
 	Inventory map[string]interface{}
 	Facts     map[string]interface{}
+
+	// Warnings collects non-fatal problems raised while rendering,
+	// such as lookups run with errors='warn'
+	Warnings []string
+
+	// SearchPath lists the directories templates are loaded from, in
+	// addition to role_path and playbook_dir
+	SearchPath []string
+
+	includeDepth int
 }
 
 // NewEngine creates a new template engine
@@ -48,6 +65,7 @@ func NewEngine() *Engine {
 		functions: make(map[string]interface{}),
 		filters:   make(map[string]interface{}),
 		tests:     make(map[string]interface{}),
+		fs:        afero.NewOsFs(),
 	}
 
 	// Register default functions, filters, and tests
@@ -55,20 +73,58 @@ func NewEngine() *Engine {
 	return engine
 }
 
-// Render renders a template string with the given context
+// Render renders a template string with the given context. Undefined
+// variables are errors, reported as a *TemplateError naming the variable.
 func (e *Engine) Render(templateStr string, ctx *Context) (string, error) {
+	return e.RenderNamed("", templateStr, ctx)
+}
+
+// RenderNamed renders a template loaded from the named file; the name is
+// used in error messages
+func (e *Engine) RenderNamed(name, templateStr string, ctx *Context) (string, error) {
+	result, _, err := e.execute(name, templateStr, ctx, false)
+	return result, err
+}
+
+// execute converts and runs a template. In native mode expression tags
+// hand their value to the returned capture instead of printing it.
+func (e *Engine) execute(name, templateStr string, ctx *Context, native bool) (string, interface{}, error) {
 	// Convert Jinja2-style template to Go template
-	goTemplate, err := e.convertJinja2ToGoTemplate(templateStr)
+	unit := newCompilation(e, ctx)
+	goTemplate, err := unit.compile(name, templateStr, native)
 	if err != nil {
-		return "", fmt.Errorf("failed to convert template: %w", err)
+		var tmplErr *TemplateError
+		if errors.As(err, &tmplErr) {
+			return "", nil, tmplErr
+		}
+		return "", nil, &TemplateError{Template: name, Source: templateStr, Message: err.Error(), Err: err}
 	}
 
-	// Create Go template
-	tmpl, err := template.New("ansible").
-		Funcs(e.createFuncMap(ctx)).
+	var tmpl *template.Template
+	var captured interface{}
+	funcMap := e.createFuncMap(ctx)
+	funcMap["capture"] = func(v interface{}) (string, error) {
+		if u, ok := v.(Undefined); ok {
+			return "", &UndefinedError{Name: u.Name}
+		}
+		captured = v
+		return "", nil
+	}
+	e.addDefinitionFuncs(funcMap, ctx, unit, func() *template.Template { return tmpl })
+
+	// Create Go template; missing map keys fail instead of rendering
+	// "<no value>", which gives Jinja2's StrictUndefined behaviour
+	tmpl, err = template.New(unit.main).
+		Funcs(funcMap).
+		Option("missingkey=error").
 		Parse(goTemplate)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse template: %w", err)
+		return "", nil, e.templateError(name, templateStr, err, unit.names)
+	}
+	for _, def := range unit.definitions {
+		if _, err := tmpl.New(def.name).Parse(def.text); err != nil {
+			return "", nil, e.templateError(name, templateStr, err, unit.names)
+		}
 	}
 
 	// Prepare template data
@@ -78,10 +134,10 @@ func (e *Engine) Render(templateStr string, ctx *Context) (string, error) {
 	var result strings.Builder
 	err = tmpl.Execute(&result, data)
 	if err != nil {
-		return "", fmt.Errorf("failed to execute template: %w", err)
+		return "", nil, e.templateError(name, templateStr, err, unit.names)
 	}
 
-	return result.String(), nil
+	return result.String(), captured, nil
 }
 
 // RenderBool renders a template and returns a boolean result
@@ -96,230 +152,19 @@ func (e *Engine) RenderBool(templateStr string, ctx *Context) (bool, error) {
 
 // convertJinja2ToGoTemplate converts Jinja2 template syntax to Go template syntax
 func (e *Engine) convertJinja2ToGoTemplate(templateStr string) (string, error) {
-	result := templateStr
-
-	// Convert variable expressions: {{ var }} -> {{.Variables.var}}
-	varRegex := regexp.MustCompile(`\{\{\s*([^}]+)\s*\}\}`)
-	result = varRegex.ReplaceAllStringFunc(result, func(match string) string {
-		expr := strings.TrimSpace(match[2 : len(match)-2])
-		goExpr := e.convertExpression(expr)
-		return "{{" + goExpr + "}}"
-	})
-
-	// Convert control structures: {% ... %} -> {{...}}
-	controlRegex := regexp.MustCompile(`\{%\s*([^%]+)\s*%\}`)
-	result = controlRegex.ReplaceAllStringFunc(result, func(match string) string {
-		expr := strings.TrimSpace(match[2 : len(match)-2])
-		return e.convertControlStructure(expr)
-	})
-
-	return result, nil
+	return newConverter(e).convert(templateStr)
 }
 
-// convertExpression converts a Jinja2 expression to Go template expression
+// convertExpression converts a Jinja2 expression to Go template expression.
+// Expressions that cannot be parsed are returned unchanged.
 func (e *Engine) convertExpression(expr string) string {
-	expr = strings.TrimSpace(expr)
-
-	// Handle filters: var | filter -> (filter .Variables.var)
-	if strings.Contains(expr, "|") {
-		return e.convertFilter(expr)
-	}
-
-	// Handle tests: var is test -> (test .Variables.var)
-	if strings.Contains(expr, " is ") {
-		return e.convertTest(expr)
-	}
-
-	// Handle attribute access: var.attr -> .Variables.var.attr
-	if strings.Contains(expr, ".") {
-		return e.convertAttributeAccess(expr)
-	}
-
-	// Handle array/dict access: var[key] -> (index .Variables.var key)
-	if strings.Contains(expr, "[") {
-		return e.convertArrayAccess(expr)
-	}
-
-	// Handle arithmetic and comparison operators
-	expr = e.convertOperators(expr)
-
-	// Simple variable: var -> .Variables.var
-	if e.isSimpleVariable(expr) {
-		return ".Variables." + expr
-	}
-
-	return expr
-}
-
-// convertFilter converts Jinja2 filter syntax to Go template function calls
-func (e *Engine) convertFilter(expr string) string {
-	parts := strings.Split(expr, "|")
-	if len(parts) < 2 {
-		return expr
-	}
-
-	variable := strings.TrimSpace(parts[0])
-	filters := parts[1:]
-
-	result := e.convertExpression(variable)
-
-	for _, filter := range filters {
-		filterName := strings.TrimSpace(filter)
-		filterArgs := ""
-
-		// Handle filters with arguments: filter(arg1, arg2)
-		if strings.Contains(filterName, "(") {
-			parenIndex := strings.Index(filterName, "(")
-			filterArgs = filterName[parenIndex+1 : len(filterName)-1]
-			filterName = filterName[:parenIndex]
-		}
-
-		if filterArgs != "" {
-			result = fmt.Sprintf("(%s %s %s)", filterName, result, filterArgs)
-		} else {
-			result = fmt.Sprintf("(%s %s)", filterName, result)
-		}
-	}
-
-	return result
-}
-
-// convertTest converts Jinja2 test syntax to Go template function calls
-func (e *Engine) convertTest(expr string) string {
-	parts := strings.Split(expr, " is ")
-	if len(parts) != 2 {
-		return expr
-	}
-
-	variable := strings.TrimSpace(parts[0])
-	test := strings.TrimSpace(parts[1])
-
-	// Handle negation: var is not test -> (not (test .Variables.var))
-	negate := false
-	if strings.HasPrefix(test, "not ") {
-		negate = true
-		test = strings.TrimSpace(test[4:])
-	}
-
-	variableExpr := e.convertExpression(variable)
-	result := fmt.Sprintf("(%s %s)", test, variableExpr)
-
-	if negate {
-		result = fmt.Sprintf("(not %s)", result)
-	}
-
-	return result
-}
-
-// convertAttributeAccess converts attribute access syntax
-func (e *Engine) convertAttributeAccess(expr string) string {
-	// Handle hostvars, groups, etc.
-	if strings.HasPrefix(expr, "hostvars") {
-		return ".Hostvars" + expr[8:]
-	}
-	if strings.HasPrefix(expr, "groups") {
-		return ".Groups" + expr[6:]
-	}
-	if strings.HasPrefix(expr, "inventory") {
-		return ".Inventory" + expr[9:]
-	}
-	if strings.HasPrefix(expr, "ansible_facts") {
-		return ".Facts" + expr[13:]
-	}
-
-	return ".Variables." + expr
-}
-
-// convertArrayAccess converts array/dict access syntax
-func (e *Engine) convertArrayAccess(expr string) string {
-	// Simple implementation for var[key] -> (index .Variables.var key)
-	bracketIndex := strings.Index(expr, "[")
-	if bracketIndex == -1 {
+	result, err := newConverter(e).translate(expr)
+	if err != nil {
 		return expr
 	}
-
-	variable := expr[:bracketIndex]
-	key := expr[bracketIndex+1 : len(expr)-1]
-
-	variableExpr := e.convertExpression(variable)
-	return fmt.Sprintf("(index %s %s)", variableExpr, key)
-}
-
-// convertOperators converts arithmetic and comparison operators
-func (e *Engine) convertOperators(expr string) string {
-	// Replace Jinja2 operators with Go template equivalents
-	operators := map[string]string{
-		" and ":  " && ",
-		" or ":   " || ",
-		" not ":  " ! ",
-		" == ":   " eq ",
-		" != ":   " ne ",
-		" < ":    " lt ",
-		" <= ":   " le ",
-		" > ":    " gt ",
-		" >= ":   " ge ",
-		" in ":   " | contains ",
-	}
-
-	result := expr
-	for jinja2Op, goOp := range operators {
-		result = strings.ReplaceAll(result, jinja2Op, goOp)
-	}
-
 	return result
 }
 
-// convertControlStructure converts Jinja2 control structures
-func (e *Engine) convertControlStructure(expr string) string {
-	parts := strings.Fields(expr)
-	if len(parts) == 0 {
-		return ""
-	}
-
-	command := parts[0]
-
-	switch command {
-	case "if":
-		condition := strings.Join(parts[1:], " ")
-		goCondition := e.convertExpression(condition)
-		return "{{if " + goCondition + "}}"
-
-	case "elif":
-		condition := strings.Join(parts[1:], " ")
-		goCondition := e.convertExpression(condition)
-		return "{{else if " + goCondition + "}}"
-
-	case "else":
-		return "{{else}}"
-
-	case "endif":
-		return "{{end}}"
-
-	case "for":
-		// Handle: for item in items -> {{range .Variables.items}}
-		if len(parts) >= 4 && parts[2] == "in" {
-			variable := parts[1]
-			iterable := strings.Join(parts[3:], " ")
-			iterableExpr := e.convertExpression(iterable)
-			return fmt.Sprintf("{{range $%s := %s}}", variable, iterableExpr)
-		}
-
-	case "endfor":
-		return "{{end}}"
-
-	case "set":
-		// Handle: set var = value -> {{$var := value}}
-		if len(parts) >= 4 && parts[2] == "=" {
-			variable := parts[1]
-			value := strings.Join(parts[3:], " ")
-			valueExpr := e.convertExpression(value)
-			return fmt.Sprintf("{{$%s := %s}}", variable, valueExpr)
-		}
-	}
-
-	return "{{/* Unsupported control structure: " + expr + " */}}"
-}
-
 // isSimpleVariable checks if a string is a simple variable name
 func (e *Engine) isSimpleVariable(s string) bool {
 	if len(s) == 0 {
@@ -346,12 +191,21 @@ func (e *Engine) createFuncMap(ctx *Context) template.FuncMap {
 	funcMap := template.FuncMap{
 		// Arithmetic functions
 		"add": func(a, b interface{}) interface{} {
+			if e.isInteger(a) && e.isInteger(b) {
+				return e.toInt(a) + e.toInt(b)
+			}
 			return e.add(a, b)
 		},
 		"sub": func(a, b interface{}) interface{} {
+			if e.isInteger(a) && e.isInteger(b) {
+				return e.toInt(a) - e.toInt(b)
+			}
 			return e.sub(a, b)
 		},
 		"mul": func(a, b interface{}) interface{} {
+			if e.isInteger(a) && e.isInteger(b) {
+				return e.toInt(a) * e.toInt(b)
+			}
 			return e.mul(a, b)
 		},
 		"div": func(a, b interface{}) interface{} {
@@ -375,6 +229,30 @@ func (e *Engine) createFuncMap(ctx *Context) template.FuncMap {
 			return strings.ReplaceAll(e.toString(s), e.toString(old), e.toString(new))
 		},
 
+		// Type conversion functions
+		"int": func(v interface{}) int {
+			return int(e.toFloat(v))
+		},
+		"float": func(v interface{}) float64 {
+			return e.toFloat(v)
+		},
+		"string": func(v interface{}) string {
+			return e.toString(v)
+		},
+		"bool": func(v interface{}) bool {
+			if s, ok := v.(string); ok {
+				switch strings.ToLower(strings.TrimSpace(s)) {
+				case "yes", "y", "on", "true", "1":
+					return true
+				}
+				return false
+			}
+			return e.toBool(v)
+		},
+		"list": func(v interface{}) []interface{} {
+			return e.iterable(v)
+		},
+
 		// List functions
 		"length": func(v interface{}) int {
 			return e.length(v)
@@ -385,8 +263,8 @@ func (e *Engine) createFuncMap(ctx *Context) template.FuncMap {
 		"last": func(v interface{}) interface{} {
 			return e.last(v)
 		},
-		"join": func(v interface{}, sep string) string {
-			return e.join(v, sep)
+		"join": func(v interface{}, sep ...string) string {
+			return e.join(v, strings.Join(sep, ""))
 		},
 
 		// Dict functions
@@ -397,45 +275,176 @@ func (e *Engine) createFuncMap(ctx *Context) template.FuncMap {
 			return e.values(v)
 		},
 
+		// Logic and comparison functions
+		"not": func(v interface{}) bool {
+			return !e.toBool(v)
+		},
+		"eq": func(a, b interface{}) bool {
+			return e.equal(a, b)
+		},
+		"ne": func(a, b interface{}) bool {
+			return !e.equal(a, b)
+		},
+		"lt": func(a, b interface{}) (bool, error) {
+			cmp, err := e.compare(a, b)
+			return cmp < 0, err
+		},
+		"le": func(a, b interface{}) (bool, error) {
+			cmp, err := e.compare(a, b)
+			return cmp <= 0, err
+		},
+		"gt": func(a, b interface{}) (bool, error) {
+			cmp, err := e.compare(a, b)
+			return cmp > 0, err
+		},
+		"ge": func(a, b interface{}) (bool, error) {
+			cmp, err := e.compare(a, b)
+			return cmp >= 0, err
+		},
+		"contains": func(container, item interface{}) bool {
+			return e.contains(container, item)
+		},
+		"ifElse": func(cond, then, otherwise interface{}) interface{} {
+			if e.toBool(cond) {
+				return then
+			}
+			return otherwise
+		},
+
+		// Expression support functions
+		"concat": func(a, b interface{}) string {
+			return e.toString(a) + e.toString(b)
+		},
+		"floordiv": func(a, b interface{}) (interface{}, error) {
+			return e.floordiv(a, b)
+		},
+		"mod": func(a, b interface{}) (interface{}, error) {
+			return e.mod(a, b)
+		},
+		"pow": func(a, b interface{}) interface{} {
+			return math.Pow(e.toFloat(a), e.toFloat(b))
+		},
+		"neg": func(v interface{}) interface{} {
+			return e.sub(0, v)
+		},
+		"newList": func(items ...interface{}) []interface{} {
+			return append([]interface{}{}, items...)
+		},
+		"newDict": func(pairs ...interface{}) (map[string]interface{}, error) {
+			return e.newDict(pairs...)
+		},
+		"kwarg": func(name string, value interface{}) keywordArg {
+			return keywordArg{Name: name, Value: value}
+		},
+		"getitem": func(obj, key interface{}) (interface{}, error) {
+			return e.strictItem(obj, key)
+		},
+		"tryitem": func(obj, key interface{}, name string) interface{} {
+			return e.tryItem(obj, key, name)
+		},
+		"tryvar": func(variables map[string]interface{}, name string) (interface{}, error) {
+			return e.tryVariable(variables, name)
+		},
+		"resolve": func(v interface{}) (interface{}, error) {
+			return e.resolve(v)
+		},
+		"output": func(v interface{}) (string, error) {
+			return e.output(v)
+		},
+		"getslice": func(obj, start, end interface{}) interface{} {
+			return e.getSlice(obj, start, end)
+		},
+		"callMethod": func(obj interface{}, method string, args ...interface{}) (interface{}, error) {
+			return e.callMethod(obj, method, args...)
+		},
+		"iterable": func(v interface{}) []interface{} {
+			return e.iterable(v)
+		},
+		"loopInfo": func(index int, seq []interface{}) map[string]interface{} {
+			return e.loopInfo(index, seq)
+		},
+		"rangeList": func(args ...interface{}) ([]interface{}, error) {
+			return e.rangeList(args...)
+		},
+		"lookup": func(name string, args ...interface{}) (interface{}, error) {
+			return e.lookup(ctx, name, false, args...)
+		},
+		"query": func(name string, args ...interface{}) (interface{}, error) {
+			return e.lookup(ctx, name, true, args...)
+		},
+
 		// Test functions
-		"defined": func(v interface{}) bool {
-			return v != nil
+		"is_defined": func(v interface{}) bool {
+			return !isUndefined(v)
 		},
-		"undefined": func(v interface{}) bool {
-			return v == nil
+		"is_undefined": func(v interface{}) bool {
+			return isUndefined(v)
 		},
-		"none": func(v interface{}) bool {
+		"is_none": func(v interface{}) bool {
 			return v == nil
 		},
-		"string": func(v interface{}) bool {
+		"is_string": func(v interface{}) bool {
 			_, ok := v.(string)
 			return ok
 		},
-		"number": func(v interface{}) bool {
+		"is_number": func(v interface{}) bool {
 			return e.isNumber(v)
 		},
-		"boolean": func(v interface{}) bool {
+		"is_boolean": func(v interface{}) bool {
 			_, ok := v.(bool)
 			return ok
 		},
-		"list": func(v interface{}) bool {
+		"is_list": func(v interface{}) bool {
 			return e.isList(v)
 		},
-		"dict": func(v interface{}) bool {
+		"is_dict": func(v interface{}) bool {
 			return e.isDict(v)
 		},
-
-		// Logic functions
-		"not": func(v interface{}) bool {
-			return !e.toBool(v)
+		"is_mapping": func(v interface{}) bool {
+			return e.isDict(v)
+		},
+		"is_iterable": func(v interface{}) bool {
+			_, ok := v.(string)
+			return ok || e.isList(v) || e.isDict(v)
+		},
+		"is_sequence": func(v interface{}) bool {
+			_, ok := v.(string)
+			return ok || e.isList(v)
+		},
+		"is_in": func(v, container interface{}) bool {
+			return e.contains(container, v)
+		},
+		"is_equalto": func(a, b interface{}) bool {
+			return e.equal(a, b)
+		},
+		"is_sameas": func(a, b interface{}) bool {
+			return e.equal(a, b)
+		},
+		"is_divisibleby": func(a, b interface{}) bool {
+			divisor := e.toInt(b)
+			return divisor != 0 && e.toInt(a)%divisor == 0
+		},
+		"is_even": func(v interface{}) bool {
+			return e.toInt(v)%2 == 0
+		},
+		"is_odd": func(v interface{}) bool {
+			return e.toInt(v)%2 != 0
+		},
+		"is_lower": func(v interface{}) bool {
+			s := e.toString(v)
+			return s == strings.ToLower(s)
+		},
+		"is_upper": func(v interface{}) bool {
+			s := e.toString(v)
+			return s == strings.ToUpper(s)
 		},
 
 		// Ansible-specific functions
-		"default": func(v, defaultVal interface{}) interface{} {
-			if v == nil {
-				return defaultVal
-			}
-			return v
+		"default": func(v interface{}, args ...interface{}) interface{} {
+			return e.defaultValue(v, args...)
+		},
+		"mandatory": func(v interface{}, args ...interface{}) (interface{}, error) {
+			return e.mandatory(v, args...)
 		},
 	}
 
@@ -449,9 +458,10 @@ func (e *Engine) createFuncMap(ctx *Context) template.FuncMap {
 		funcMap[name] = fn
 	}
 
-	// Add custom tests
+	// Add custom tests; tests live in their own namespace so that a test
+	// and a filter may share a name (e.g. "list")
 	for name, fn := range e.tests {
-		funcMap[name] = fn
+		funcMap["is_"+name] = fn
 	}
 
 	return funcMap
@@ -707,6 +717,13 @@ func (e *Engine) AddFilter(name string, fn interface{}) {
 	e.filters[name] = fn
 }
 
+// HasFilter reports whether the engine implements a filter, either built
+// in or added with AddFilter
+func (e *Engine) HasFilter(name string) bool {
+	_, exists := e.createFuncMap(nil)[name]
+	return exists
+}
+
 // AddTest adds a custom test to the template engine
 func (e *Engine) AddTest(name string, fn interface{}) {
 	e.tests[name] = fn
@@ -17,8 +17,13 @@ limitations under the License.
 package template
 
 import (
+	"errors"
+	"fmt"
+	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/spf13/afero"
 )
 
 func TestNewEngine(t *testing.T) {
@@ -421,6 +426,303 @@ func TestEngine_AddCustomTest(t *testing.T) {
 	}
 }
 
+func TestEngine_Render_LoopsAndCalls(t *testing.T) {
+	engine := NewEngine()
+	ctx := &Context{
+		Variables: map[string]interface{}{
+			"users":  []interface{}{"alice", "bob"},
+			"ports":  map[string]interface{}{"http": 80, "https": 443},
+			"csv":    "a,b,c",
+			"weight": 7,
+		},
+	}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"{% for u in users %}{{ loop.index }}:{{ u }}{% if not loop.last %},{% endif %}{% endfor %}", "1:alice,2:bob"},
+		{"{% for name, port in ports.items() %}{{ name }}={{ port }} {% endfor %}", "http=80 https=443 "},
+		{"{{ csv.split(',') | join('-') }}", "a-b-c"},
+		{"{{ 'heavy' if weight > 5 else 'light' }}", "heavy"},
+		{"{{ users[-1] }} {{ 'alice' in users }} {{ weight // 2 }} {{ 'w' ~ weight }}", "bob true 3 w7"},
+		{"{% set doubled = weight * 2 %}{{ doubled }}", "14"},
+		{"{{ \"}}\" }}", "}}"},
+	}
+
+	for _, test := range tests {
+		result, err := engine.Render(test.template, ctx)
+		if err != nil {
+			t.Errorf("Template '%s' failed with error: %v", test.template, err)
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("Template '%s': expected '%s', got '%s'", test.template, test.expected, result)
+		}
+	}
+}
+
+func TestEngine_Render_Lookup(t *testing.T) {
+	engine := NewEngine()
+
+	var gotTerms []interface{}
+	var gotOptions map[string]interface{}
+	engine.SetLookupFunc(func(name string, terms []interface{}, options map[string]interface{}, variables map[string]interface{}) ([]interface{}, error) {
+		gotTerms, gotOptions = terms, options
+		switch name {
+		case "items":
+			return terms, nil
+		case "single":
+			return []interface{}{"only"}, nil
+		}
+		return nil, fmt.Errorf("lookup plugin '%s' not found", name)
+	})
+
+	ctx := &Context{Variables: map[string]interface{}{"name": "b"}}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"{{ lookup('items', 'a', name) }}", "a,b"},
+		{"{{ lookup('single') }}", "only"},
+		{"{{ lookup('items', 'a', wantlist=True) | length }}", "1"},
+		{"{{ query('items', 'a', 'b') | length }}", "2"},
+		{"{{ q('single') | first }}", "only"},
+		{"{{ lookup('missing', errors='ignore') is none }}", "true"},
+		{"[{{ lookup('missing', errors='ignore') }}]", "[]"},
+		{"{{ query('missing', errors='ignore') | length }}", "0"},
+	}
+
+	for _, test := range tests {
+		result, err := engine.Render(test.template, ctx)
+		if err != nil {
+			t.Errorf("Template '%s' failed with error: %v", test.template, err)
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("Template '%s': expected '%s', got '%s'", test.template, test.expected, result)
+		}
+	}
+
+	// Keyword arguments other than wantlist and errors reach the plugin
+	if _, err := engine.Render("{{ lookup('items', 'x', rstrip=False) }}", ctx); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(gotTerms) != 1 || gotTerms[0] != "x" {
+		t.Errorf("Expected terms [x], got %v", gotTerms)
+	}
+	if gotOptions["rstrip"] != false {
+		t.Errorf("Expected rstrip option to be passed, got %v", gotOptions)
+	}
+
+	// errors='strict' is the default
+	if _, err := engine.Render("{{ lookup('missing') }}", ctx); err == nil {
+		t.Error("Expected failing lookup to fail the template")
+	}
+
+	// errors='warn' records a warning and continues
+	if _, err := engine.Render("{{ lookup('missing', errors='warn') }}", ctx); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(ctx.Warnings) != 1 {
+		t.Errorf("Expected one warning, got %v", ctx.Warnings)
+	}
+}
+
+func TestEngine_Render_Undefined(t *testing.T) {
+	engine := NewEngine()
+	ctx := &Context{
+		Variables: map[string]interface{}{
+			"server": map[string]interface{}{"name": "web"},
+			"empty":  "",
+			"ports":  []interface{}{80},
+			"null":   nil,
+		},
+	}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"{{ missing is defined }}", "false"},
+		{"[{{ null }}]", "[]"},
+		{"{{ null is none }}", "true"},
+		{"{{ missing.child.leaf is defined }}", "false"},
+		{"{{ server.port.number is undefined }}", "true"},
+		{"{{ server.name is defined }}", "true"},
+		{"{{ missing | default('x') }}", "x"},
+		{"{{ empty | default('x') }}", ""},
+		{"{{ empty | default('x', true) }}", "x"},
+		{"{{ missing.child | d(other | default(3)) }}", "3"},
+		{"{{ server.name | mandatory }}", "web"},
+		{"{{ 'a' if ports else missing }}", "a"},
+		{"{{ missing | default(omit) }}", OmitPlaceholder},
+	}
+
+	for _, test := range tests {
+		result, err := engine.Render(test.template, ctx)
+		if err != nil {
+			t.Errorf("Template '%s' failed with error: %v", test.template, err)
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("Template '%s': expected '%s', got '%s'", test.template, test.expected, result)
+		}
+	}
+
+	failures := []struct {
+		template string
+		variable string
+		line     int
+	}{
+		{"{{ missing }}", "missing", 1},
+		{"first\n{% if true %}\n{{ server.port }}\n{% endif %}", "server.port", 3},
+		{"{% for p in ports %}\n\n{{ p.proto }}{% endfor %}", "p.proto", 3},
+		{"{{ missing if ports else 'b' }}", "missing", 1},
+	}
+
+	for _, test := range failures {
+		_, err := engine.Render(test.template, ctx)
+		var tmplErr *TemplateError
+		if !errors.As(err, &tmplErr) {
+			t.Errorf("Template '%s': expected a TemplateError, got %v", test.template, err)
+			continue
+		}
+		if tmplErr.Variable != test.variable || tmplErr.Line != test.line {
+			t.Errorf("Template '%s': expected '%s' at line %d, got '%s' at line %d",
+				test.template, test.variable, test.line, tmplErr.Variable, tmplErr.Line)
+		}
+		if !contains(err.Error(), "'"+test.variable+"' is undefined") {
+			t.Errorf("Template '%s': unexpected message: %v", test.template, err)
+		}
+	}
+
+	_, err := engine.Render("{{ missing | mandatory('set missing') }}", ctx)
+	if err == nil || !contains(err.Error(), "set missing") {
+		t.Errorf("Expected mandatory to fail with its message, got %v", err)
+	}
+
+	_, err = engine.RenderNamed("motd.j2", "\n{{ server['port'] }}", ctx)
+	if err == nil || !contains(err.Error(), "'motd.j2' at line 2") {
+		t.Errorf("Expected error to name the template and line, got %v", err)
+	}
+}
+
+func TestEngine_RenderNative(t *testing.T) {
+	engine := NewEngine()
+	ctx := &Context{
+		Variables: map[string]interface{}{
+			"port":    "8080",
+			"servers": []interface{}{"a", "b"},
+			"nothing": nil,
+		},
+	}
+
+	tests := []struct {
+		template string
+		expected interface{}
+	}{
+		{"{{ [1, 2, 3] }}", []interface{}{1, 2, 3}},
+		{"{{ port | int }}", 8080},
+		{"{{ port }}", "8080"},
+		{"{{ servers }}", []interface{}{"a", "b"}},
+		{"{{ {'port': port | int} }}", map[string]interface{}{"port": 8080}},
+		{"{{ {'a': {'b': [1,2]}} }}", map[string]interface{}{"a": map[string]interface{}{"b": []interface{}{1, 2}}}},
+		{"{{ [{'x': {'y': '}}'}}] }}", []interface{}{map[string]interface{}{"x": map[string]interface{}{"y": "}}"}}}},
+		{"{{ servers | length > 1 }}", true},
+		{"{{ nothing }}", nil},
+		{"  {{ 1.5 }}\n", 1.5},
+		{"port {{ port }}", "port 8080"},
+		{"{{ port }}{{ port }}", "80808080"},
+		{"{% if true %}{{ port | int }}{% endif %}", "8080"},
+	}
+
+	for _, test := range tests {
+		result, err := engine.RenderNative(test.template, ctx)
+		if err != nil {
+			t.Errorf("Template '%s' failed with error: %v", test.template, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("Template '%s': expected %#v, got %#v", test.template, test.expected, result)
+		}
+	}
+
+	if _, err := engine.RenderNative("{{ missing }}", ctx); err == nil {
+		t.Error("Expected undefined variable to fail in native mode")
+	}
+}
+
+func TestEngine_RenderFile(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	files := map[string]string{
+		"/play/templates/macros.j2": "{% macro greet(name, greeting='Hello') %}{{ greeting }}, {{ name }}!{% endmacro %}\n" +
+			"{% macro broken() %}\n{{ nothing }}{% endmacro %}",
+		"/play/templates/base.j2":   "<{% block title %}Base{% endblock %}>{% block body %}{% endblock %}",
+		"/play/templates/child.j2":  "{% extends 'base.j2' %}{% block title %}Child/{{ super() }}{% endblock %}{% block body %}[{{ name }}]{% endblock %}",
+		"/play/templates/item.j2":   "{{ item }}@{{ port }};",
+		"/role/templates/import.j2": "{% import 'macros.j2' as m %}{{ m.greet('web') }} {{ m.greet('db', greeting='Hi') }}",
+		"/role/templates/from.j2":   "{% from 'macros.j2' import greet as hello %}{{ hello(name) }}",
+		"/role/templates/loop.j2":   "{% for item in ['a', 'b'] %}{% include 'item.j2' %}{% endfor %}{% include 'none.j2' ignore missing %}",
+		"/role/templates/set.j2":    "{% set x = 1 %}{% set body %}x={{ x }} {{ name }}{% endset %}[{{ body | upper }}]",
+		"/role/templates/raw.j2":    "{% raw %}{{ name }}{% endraw %}",
+		"/role/templates/error.j2":  "{% from 'macros.j2' import broken %}\n{{ broken() }}",
+	}
+	for name, content := range files {
+		if err := afero.WriteFile(fs, name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	engine := NewEngine()
+	engine.SetFilesystem(fs)
+	ctx := &Context{
+		Variables: map[string]interface{}{
+			"name":         "app",
+			"port":         80,
+			"playbook_dir": "/play",
+		},
+		SearchPath: []string{"/role"},
+	}
+
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"child.j2", "<Child/Base>[app]"},
+		{"import.j2", "Hello, web! Hi, db!"},
+		{"from.j2", "Hello, app!"},
+		{"loop.j2", "a@80;b@80;"},
+		{"set.j2", "[X=1 APP]"},
+		{"raw.j2", "{{ name }}"},
+	}
+
+	for _, test := range tests {
+		result, err := engine.RenderFile(test.name, ctx)
+		if err != nil {
+			t.Errorf("Template '%s' failed with error: %v", test.name, err)
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("Template '%s': expected '%s', got '%s'", test.name, test.expected, result)
+		}
+	}
+
+	_, err := engine.RenderFile("error.j2", ctx)
+	var tmplErr *TemplateError
+	if !errors.As(err, &tmplErr) {
+		t.Fatalf("Expected a TemplateError, got %v", err)
+	}
+	if tmplErr.Template != "/play/templates/macros.j2" || tmplErr.Line != 3 || tmplErr.Variable != "nothing" {
+		t.Errorf("Expected 'nothing' undefined in macros.j2 at line 3, got %v", err)
+	}
+
+	if _, err := engine.RenderFile("missing.j2", ctx); err == nil {
+		t.Error("Expected an error for a missing template")
+	}
+}
+
 // Helper function
 func contains(s, substr string) bool {
 	return strings.Contains(s, substr)
@@ -0,0 +1,298 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package template
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// OmitPlaceholder is the value of the "omit" variable. Module arguments
+// that render to it are removed before the module runs.
+const OmitPlaceholder = "__omit_place_holder__"
+
+// Undefined stands in for a variable or attribute that does not exist.
+// Templates only see it where Jinja2 evaluates lazily: the operands of
+// default and mandatory, the defined/undefined tests and the branches of
+// a conditional expression. Anywhere else a missing variable is an error.
+type Undefined struct {
+	Name string
+}
+
+// UndefinedError reports the use of an undefined variable
+type UndefinedError struct {
+	Name string
+}
+
+func (e *UndefinedError) Error() string {
+	return fmt.Sprintf("'%s' is undefined", e.Name)
+}
+
+// TemplateError reports a rendering failure together with its location
+type TemplateError struct {
+	// Template is the template name, empty for inline strings
+	Template string
+	// Source is the template text of inline strings
+	Source string
+	Line   int
+	// Variable names the undefined variable that caused the error, if any
+	Variable string
+	Message  string
+	Err      error
+
+	// nested marks errors raised inside an included template or a
+	// definition, which already carry their own location
+	nested bool
+}
+
+func (e *TemplateError) Error() string {
+	location := ""
+	if e.Line > 0 {
+		location = fmt.Sprintf(" at line %d", e.Line)
+	}
+	if e.Template != "" {
+		return fmt.Sprintf("template error in '%s'%s: %s", e.Template, location, e.Message)
+	}
+	return fmt.Sprintf("template error while templating string%s: %s. String: %s", location, e.Message, e.Source)
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+func isUndefined(v interface{}) bool {
+	_, ok := v.(Undefined)
+	return ok
+}
+
+// tryVariable returns a context variable or Undefined
+func (e *Engine) tryVariable(variables map[string]interface{}, name string) (interface{}, error) {
+	if value, ok := variables[name]; ok {
+		return e.resolveLazily(value, name)
+	}
+	return Undefined{Name: name}, nil
+}
+
+// tryItem is getItem for lazily evaluated operands: missing keys and
+// attributes of undefined parents yield Undefined instead of failing
+func (e *Engine) tryItem(obj, key interface{}, name string) interface{} {
+	if isUndefined(obj) {
+		return Undefined{Name: name}
+	}
+	if value, ok := e.lookupItem(obj, key); ok {
+		return value
+	}
+	return Undefined{Name: name}
+}
+
+// strictItem is getItem for ordinary expressions, failing on missing keys
+func (e *Engine) strictItem(obj, key interface{}) (interface{}, error) {
+	if u, ok := obj.(Undefined); ok {
+		return nil, &UndefinedError{Name: u.Name}
+	}
+	if value, ok := e.lookupItem(obj, key); ok {
+		return value, nil
+	}
+
+	switch {
+	case obj == nil:
+		return nil, fmt.Errorf("'None' has no attribute '%s'", e.toString(key))
+	case e.isList(obj):
+		return nil, fmt.Errorf("list object has no element %s", e.toString(key))
+	case e.isDict(obj):
+		return nil, fmt.Errorf("'dict object' has no attribute '%s'", e.toString(key))
+	}
+	return nil, fmt.Errorf("'%T' object has no attribute '%s'", obj, e.toString(key))
+}
+
+// output renders the value of a {{ }} tag. None renders as an empty
+// string, as Ansible's templating does, so null variables and lookups
+// that fail with errors='ignore' leave nothing behind.
+func (e *Engine) output(v interface{}) (string, error) {
+	if u, ok := v.(Undefined); ok {
+		return "", &UndefinedError{Name: u.Name}
+	}
+	if v == nil {
+		return "", nil
+	}
+	return e.toString(v), nil
+}
+
+// defaultValue implements default(default_value, boolean); the
+// fallback defaults to an empty string
+func (e *Engine) defaultValue(v interface{}, args ...interface{}) interface{} {
+	positional, keywords := splitKeywordArgs(args)
+
+	var fallback interface{} = ""
+	boolean := false
+	if len(positional) > 0 {
+		fallback = positional[0]
+	}
+	if len(positional) > 1 {
+		boolean = e.toBool(positional[1])
+	}
+	if value, ok := keywords["default_value"]; ok {
+		fallback = value
+	}
+	if value, ok := keywords["boolean"]; ok {
+		boolean = e.toBool(value)
+	}
+
+	if isUndefined(v) || (boolean && !e.truthy(v)) {
+		return fallback
+	}
+	return v
+}
+
+// mandatory implements the mandatory filter
+func (e *Engine) mandatory(v interface{}, args ...interface{}) (interface{}, error) {
+	if u, ok := v.(Undefined); ok {
+		positional, keywords := splitKeywordArgs(args)
+		if msg, ok := keywords["msg"]; ok {
+			return nil, errors.New(e.toString(msg))
+		}
+		if len(positional) > 0 && positional[0] != nil {
+			return nil, errors.New(e.toString(positional[0]))
+		}
+		return nil, fmt.Errorf("Mandatory variable '%s' not defined", u.Name)
+	}
+	return v, nil
+}
+
+// truthy implements Python truthiness, which the boolean form of default
+// relies on: empty strings, collections and zero values are false
+func (e *Engine) truthy(v interface{}) bool {
+	switch val := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return val
+	case string:
+		return val != ""
+	}
+	if e.isNumber(v) {
+		return e.toFloat(v) != 0
+	}
+	if e.isList(v) || e.isDict(v) {
+		return e.length(v) > 0
+	}
+	return true
+}
+
+var (
+	execErrorPattern  = regexp.MustCompile(`(?s)^template: (.*?):(\d+)(?::\d+)?: executing "[^"]*" at <(.*?)>: (.*)$`)
+	parseErrorPattern = regexp.MustCompile(`(?s)^template: (.*?):(\d+): (.*)$`)
+	callErrorPattern  = regexp.MustCompile(`^error calling \w+: `)
+	missingKeyPattern = regexp.MustCompile(`^map has no entry for key "(.*)"$`)
+)
+
+// templateError turns an error from text/template into a TemplateError
+// phrased in terms of the Jinja2 source. The converter preserves line
+// numbers, so positions reported by text/template point at the source;
+// errors inside macros and blocks name the file that defines them.
+func (e *Engine) templateError(name, src string, err error, locals map[string]string) *TemplateError {
+	var undefined *UndefinedError
+	var inner *TemplateError
+	if errors.As(err, &inner) && inner.nested {
+		return inner
+	}
+
+	result := &TemplateError{Template: name, Err: err, Message: err.Error()}
+	if errors.As(err, &undefined) {
+		result.Variable = undefined.Name
+	} else if inner != nil {
+		// Failure inside a template-valued variable
+		result.Variable = inner.Variable
+	}
+
+	if m := execErrorPattern.FindStringSubmatch(err.Error()); m != nil {
+		result.Template = definitionFile(m[1])
+		result.Line, _ = strconv.Atoi(m[2])
+		node, msg := m[3], m[4]
+
+		switch {
+		case missingKeyPattern.MatchString(msg):
+			key := missingKeyPattern.FindStringSubmatch(msg)[1]
+			result.Variable = jinjaName(node, key, locals)
+			msg = (&UndefinedError{Name: result.Variable}).Error()
+		case strings.HasPrefix(msg, "nil pointer evaluating"), strings.HasPrefix(msg, "can't evaluate field"):
+			result.Variable = jinjaName(node, "", locals)
+			msg = (&UndefinedError{Name: result.Variable}).Error()
+		}
+		result.Message = callErrorPattern.ReplaceAllString(msg, "")
+	} else if m := parseErrorPattern.FindStringSubmatch(err.Error()); m != nil {
+		result.Template = definitionFile(m[1])
+		result.Line, _ = strconv.Atoi(m[2])
+		result.Message = m[3]
+	}
+
+	if result.Template == "" {
+		result.Source = src
+	}
+	return result
+}
+
+// jinjaName translates a Go template field chain such as
+// "$.Variables.web.port" back into the Jinja2 name "web.port". When key
+// is given the chain is cut after the missing key.
+func jinjaName(node, key string, locals map[string]string) string {
+	parts := strings.Split(node, ".")
+	var names []string
+
+	switch {
+	case strings.HasPrefix(node, "$."):
+		root := map[string]string{
+			"Hostvars":  "hostvars",
+			"Groups":    "groups",
+			"Inventory": "inventory",
+			"Facts":     "ansible_facts",
+		}
+		if alias, ok := root[parts[1]]; ok {
+			names = append(names, alias)
+		}
+		if len(parts) > 2 {
+			names = append(names, parts[2:]...)
+		}
+	default:
+		local := parts[0]
+		if alias, ok := locals[local]; ok {
+			local = alias
+		}
+		names = append(names, strings.TrimPrefix(local, "$"))
+		names = append(names, parts[1:]...)
+	}
+
+	// For "(resolve $.Variables.web).port" text/template only reports the
+	// inner "$.Variables.web", so a key missing from the chain is appended
+	if key != "" {
+		found := false
+		for i := len(names) - 1; i >= 1; i-- {
+			if names[i] == key {
+				names = names[:i+1]
+				found = true
+				break
+			}
+		}
+		if !found && (len(names) != 1 || names[0] != key) {
+			names = append(names, key)
+		}
+	}
+	return strings.Join(names, ".")
+}
@@ -0,0 +1,176 @@
+/*
+Copyright (c) 2024 Ansible Project
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vars
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/work-obs/ansible-go/pkg/template"
+)
+
+// CycleError reports a variable whose definition refers back to itself
+type CycleError struct {
+	// Chain lists the variables involved, starting and ending with the
+	// same name
+	Chain []string
+}
+
+func (e *CycleError) Error() string {
+	return "recursive loop detected in template: " + strings.Join(e.Chain, " -> ")
+}
+
+// lazyResolver renders template-valued variables when a template first
+// uses them. Nested renders share the resolver, so the variables being
+// resolved form a stack in which a repeated name is a cycle. Results are
+// memoised on the Context for the task set with SetTask.
+type lazyResolver struct {
+	manager     *Manager
+	ctx         *Context
+	templateCtx *template.Context
+	stack       []string
+}
+
+// lazy wraps a variable value for deferred resolution
+func (r *lazyResolver) lazy(name string, value interface{}) *template.Lazy {
+	return &template.Lazy{
+		Name: name,
+		Resolve: func() (interface{}, error) {
+			return r.resolve(name, value)
+		},
+	}
+}
+
+func (r *lazyResolver) resolve(name string, value interface{}) (interface{}, error) {
+	if cached, ok := r.ctx.cachedValue(name); ok {
+		return cached, nil
+	}
+
+	for i, resolving := range r.stack {
+		if resolving == name {
+			chain := append(append([]string{}, r.stack[i:]...), name)
+			return nil, &CycleError{Chain: chain}
+		}
+	}
+
+	r.stack = append(r.stack, name)
+	defer func() { r.stack = r.stack[:len(r.stack)-1] }()
+
+	result, err := r.render(value)
+	if err != nil {
+		// A cycle is reported once, not wrapped by every variable in it
+		var cycle *CycleError
+		if errors.As(err, &cycle) {
+			return nil, cycle
+		}
+		return nil, fmt.Errorf("error while resolving variable '%s': %w", name, err)
+	}
+
+	r.ctx.cacheValue(name, result)
+	return result, nil
+}
+
+// render templates a variable value. Like Ansible, a variable holding a
+// single expression takes the type of its result.
+func (r *lazyResolver) render(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		if !containsTemplate(v) {
+			return v, nil
+		}
+		return r.manager.templateEngine.RenderNative(v, r.templateCtx)
+
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			rendered, err := r.render(val)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = rendered
+		}
+		return result, nil
+
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			rendered, err := r.render(item)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = rendered
+		}
+		return result, nil
+	}
+
+	return value, nil
+}
+
+// containsTemplate reports whether a value holds template syntax anywhere
+func containsTemplate(value interface{}) bool {
+	switch v := value.(type) {
+	case string:
+		return strings.Contains(v, "{{") || strings.Contains(v, "{%")
+	case map[string]interface{}:
+		for _, val := range v {
+			if containsTemplate(val) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if containsTemplate(item) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cachedValue returns the memoised value of a template-valued variable
+func (c *Context) cachedValue(name string) (interface{}, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	value, ok := c.resolved[name]
+	return value, ok
+}
+
+// cacheValue memoises the value of a template-valued variable
+func (c *Context) cacheValue(name string, value interface{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.resolved == nil {
+		c.resolved = make(map[string]interface{})
+	}
+	c.resolved[name] = value
+}
+
+// SetTask records the task the context is templating for. Values of
+// template-valued variables are memoised per task, so starting another
+// task forgets them and the new task sees what earlier tasks changed.
+func (c *Context) SetTask(task string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if task != c.task {
+		c.task = task
+		c.resolved = nil
+	}
+}
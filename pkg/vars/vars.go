@@ -17,13 +17,19 @@ limitations under the License.
 package vars
 
 import (
+	"context"
 	"fmt"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 
+	"github.com/work-obs/ansible-go/pkg/config"
 	"github.com/work-obs/ansible-go/pkg/inventory"
+	"github.com/work-obs/ansible-go/pkg/plugins"
+	"github.com/work-obs/ansible-go/pkg/plugins/filter"
+	"github.com/work-obs/ansible-go/pkg/plugins/lookup"
 	"github.com/work-obs/ansible-go/pkg/template"
 	"gopkg.in/yaml.v3"
 )
@@ -57,25 +63,163 @@ type Context struct {
 	Hostvars  map[string]map[string]interface{}
 	Groups    map[string][]string
 	mutex     sync.RWMutex
+
+	// resolved memoises template-valued variables for the current task;
+	// starting another task or any change to the context clears it
+	task     string
+	resolved map[string]interface{}
 }
 
 // Manager manages variable resolution and templating
 type Manager struct {
 	templateEngine *template.Engine
+	lookups        *lookup.LookupPluginRegistry
+	filters        *filter.FilterPluginRegistry
 	inventory      *inventory.Inventory
 	extraVars      map[string]interface{}
+	nativeTypes    bool
 	mutex          sync.RWMutex
+
+	// hostContexts are the contexts handed out by CreateHostContext, which
+	// RefreshHostContexts updates when the inventory changes
+	hostContexts map[string]*Context
 }
 
 // NewManager creates a new variable manager
 func NewManager(inv *inventory.Inventory) *Manager {
-	return &Manager{
+	m := &Manager{
 		templateEngine: template.NewEngine(),
+		lookups:        lookup.NewLookupPluginRegistry(),
+		filters:        filter.NewFilterPluginRegistry(),
 		inventory:      inv,
 		extraVars:      make(map[string]interface{}),
+		hostContexts:   make(map[string]*Context),
+	}
+	m.templateEngine.SetLookupFunc(m.runLookup)
+	m.registerFilters()
+	// The template lookup renders with this manager's filters and lookups
+	m.lookups.Register("template", func() lookup.LookupPlugin { return lookup.NewTemplateLookupPlugin(m.templateEngine) })
+	return m
+}
+
+// TemplateEngine returns the template engine of the manager, configured
+// with its filters, tests and lookups
+func (m *Manager) TemplateEngine() *template.Engine {
+	return m.templateEngine
+}
+
+// SetConfig makes cfg the configuration the config lookup reports and
+// applies its templating settings, such as jinja2_native
+func (m *Manager) SetConfig(cfg *config.Config) {
+	m.lookups.Register("config", func() lookup.LookupPlugin { return lookup.NewConfigLookupPlugin(cfg) })
+	m.SetNativeTypes(cfg.JinjaNative)
+}
+
+// registerFilters makes the filters of the filter plugins available to
+// templates. The core plugin overlaps with filters the template engine
+// implements itself; where both define a filter the engine's is kept, as
+// it handles undefined values and lazy arguments.
+func (m *Manager) registerFilters() {
+	names := m.filters.List()
+	sort.Strings(names)
+	for _, name := range names {
+		plugin, err := m.filters.Get(name)
+		if err != nil {
+			continue
+		}
+		for filterName, fn := range plugin.GetFilters() {
+			if name == "core" && m.templateEngine.HasFilter(filterName) {
+				continue
+			}
+			m.templateEngine.AddFilter(filterName, templateFilter(fn))
+		}
+	}
+}
+
+// templateFilter adapts a filter plugin function to the template engine,
+// passing keyword arguments (name=value) as a trailing filter.Keywords
+func templateFilter(fn filter.FilterFunction) func(interface{}, ...interface{}) (interface{}, error) {
+	return func(input interface{}, args ...interface{}) (interface{}, error) {
+		positional, keywords := template.SplitKeywordArgs(args)
+		if len(keywords) > 0 {
+			positional = append(positional, filter.Keywords(keywords))
+		}
+		return fn(input, positional...)
 	}
 }
 
+// LoadExternalPlugins makes the external executable plugins found by the
+// loader available to templates: filters from the filter plugin paths,
+// lookups from the lookup plugin paths and tests from the test plugin paths.
+func (m *Manager) LoadExternalPlugins(loader *plugins.Loader) error {
+	filterPlugins, err := loader.ExternalPlugins(plugins.PluginTypeFilter)
+	if err != nil {
+		return err
+	}
+	for _, external := range filterPlugins {
+		plugin := filter.NewExternalFilterPlugin(external)
+		m.filters.Register(plugin.Name(), func() filter.FilterPlugin { return plugin })
+		for filterName, fn := range plugin.GetFilters() {
+			m.templateEngine.AddFilter(filterName, templateFilter(fn))
+		}
+	}
+
+	lookupPlugins, err := loader.ExternalPlugins(plugins.PluginTypeLookup)
+	if err != nil {
+		return err
+	}
+	for _, external := range lookupPlugins {
+		for _, name := range external.Lookups {
+			plugin := lookup.NewExternalLookupPlugin(external, name)
+			m.lookups.Register(name, func() lookup.LookupPlugin { return plugin })
+		}
+	}
+
+	testPlugins, err := loader.ExternalPlugins(plugins.PluginTypeTest)
+	if err != nil {
+		return err
+	}
+	for _, external := range testPlugins {
+		for _, name := range external.Tests {
+			m.templateEngine.AddTest(name, externalTest(external, name))
+		}
+	}
+	return nil
+}
+
+// externalTest adapts a test of an external plugin to the template engine
+func externalTest(external *plugins.ExternalPlugin, name string) func(interface{}, ...interface{}) (bool, error) {
+	return func(input interface{}, args ...interface{}) (bool, error) {
+		return external.Test(context.Background(), name, input, args)
+	}
+}
+
+// runLookup runs a lookup plugin on behalf of the template engine
+func (m *Manager) runLookup(name string, terms []interface{}, options map[string]interface{}, variables map[string]interface{}) ([]interface{}, error) {
+	plugin, err := m.lookups.Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if listPlugin, ok := plugin.(lookup.ListLookupPlugin); ok {
+		return listPlugin.RunList(context.Background(), terms, variables, options)
+	}
+
+	// Other lookup plugins take string terms; list terms are flattened one level
+	var stringTerms []string
+	for _, term := range terms {
+		if list, ok := term.([]interface{}); ok {
+			for _, item := range list {
+				stringTerms = append(stringTerms, fmt.Sprintf("%v", item))
+			}
+			continue
+		}
+		stringTerms = append(stringTerms, fmt.Sprintf("%v", term))
+	}
+
+	return plugin.Run(context.Background(), stringTerms, variables, options)
+}
+
 // NewContext creates a new variable context
 func NewContext() *Context {
 	return &Context{
@@ -90,6 +234,7 @@ func NewContext() *Context {
 func (c *Context) SetVariable(name string, value interface{}, precedence int, source string) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
+	c.resolved = nil
 
 	// Only set if precedence is higher or variable doesn't exist
 	if existing, exists := c.Variables[name]; !exists || precedence >= existing.Precedence {
@@ -129,6 +274,7 @@ func (c *Context) GetVariables() map[string]interface{} {
 func (c *Context) SetFact(name string, value interface{}) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
+	c.resolved = nil
 	c.Facts[name] = value
 }
 
@@ -147,6 +293,7 @@ func (c *Context) GetFact(name string) (interface{}, bool) {
 func (c *Context) SetHostvar(host, name string, value interface{}) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
+	c.resolved = nil
 
 	if c.Hostvars[host] == nil {
 		c.Hostvars[host] = make(map[string]interface{})
@@ -191,39 +338,7 @@ func (m *Manager) CreateHostContext(hostname string) (*Context, error) {
 	ctx := NewContext()
 
 	// Add inventory variables (lowest precedence)
-	if m.inventory != nil {
-		// Add host variables
-		hostVars := m.inventory.GetHostVars(hostname)
-		for name, value := range hostVars {
-			ctx.SetVariable(name, value, PrecedenceHostVars, "inventory")
-		}
-
-		// Add group variables for all groups containing this host
-		for groupName, group := range m.inventory.Groups {
-			for _, hostName := range group.Hosts {
-				if hostName == hostname {
-					groupVars := m.inventory.GetGroupVars(groupName)
-					for name, value := range groupVars {
-						ctx.SetVariable(name, value, PrecedenceGroupVars, fmt.Sprintf("group:%s", groupName))
-					}
-					break
-				}
-			}
-		}
-
-		// Set up hostvars for all hosts
-		for hostName := range m.inventory.Hosts {
-			hostVars := m.inventory.GetHostVars(hostName)
-			for varName, varValue := range hostVars {
-				ctx.SetHostvar(hostName, varName, varValue)
-			}
-		}
-
-		// Set up groups
-		for groupName, group := range m.inventory.Groups {
-			ctx.Groups[groupName] = append([]string{}, group.Hosts...)
-		}
-	}
+	m.applyInventory(ctx, hostname)
 
 	// Add extra variables (highest precedence)
 	m.mutex.RLock()
@@ -236,11 +351,126 @@ func (m *Manager) CreateHostContext(hostname string) (*Context, error) {
 	ctx.SetFact("ansible_hostname", hostname)
 	ctx.SetFact("inventory_hostname", hostname)
 
+	m.mutex.Lock()
+	m.hostContexts[hostname] = ctx
+	m.mutex.Unlock()
+
 	return ctx, nil
 }
 
+// StartTask marks the start of a task on a host, so templates of the task
+// see the changes earlier tasks made to the host's context, such as by
+// set_fact and register. Hosts without a context are ignored.
+func (m *Manager) StartTask(hostname, task string) {
+	m.mutex.RLock()
+	ctx, exists := m.hostContexts[hostname]
+	m.mutex.RUnlock()
+
+	if exists {
+		ctx.SetTask(task)
+	}
+}
+
+// RefreshHostContexts re-reads the inventory into every host context
+// created so far, so that hosts and groups added while tasks run, as by
+// add_host and group_by, are visible to later tasks and plays. Variables
+// set above inventory precedence are kept.
+func (m *Manager) RefreshHostContexts() {
+	m.mutex.RLock()
+	contexts := make(map[string]*Context, len(m.hostContexts))
+	for hostname, ctx := range m.hostContexts {
+		contexts[hostname] = ctx
+	}
+	m.mutex.RUnlock()
+
+	for hostname, ctx := range contexts {
+		ctx.mutex.Lock()
+		for name, variable := range ctx.Variables {
+			if variable.Source == "inventory" || strings.HasPrefix(variable.Source, "group:") {
+				delete(ctx.Variables, name)
+			}
+		}
+		ctx.Hostvars = make(map[string]map[string]interface{})
+		ctx.Groups = make(map[string][]string)
+		ctx.resolved = nil
+		ctx.mutex.Unlock()
+
+		m.applyInventory(ctx, hostname)
+	}
+}
+
+// applyInventory adds a host's inventory variables, every host's hostvars
+// and the group memberships to ctx
+func (m *Manager) applyInventory(ctx *Context, hostname string) {
+	if m.inventory == nil {
+		return
+	}
+
+	// Add host variables
+	hostVars := m.inventory.GetHostVars(hostname)
+	for name, value := range hostVars {
+		ctx.SetVariable(name, value, PrecedenceHostVars, "inventory")
+	}
+
+	// Add group variables for all groups containing this host
+	groups := m.inventory.GroupHosts()
+	for groupName, hosts := range groups {
+		for _, hostName := range hosts {
+			if hostName == hostname {
+				groupVars := m.inventory.GetGroupVars(groupName)
+				for name, value := range groupVars {
+					ctx.SetVariable(name, value, PrecedenceGroupVars, fmt.Sprintf("group:%s", groupName))
+				}
+				break
+			}
+		}
+	}
+
+	// Set up hostvars for all hosts
+	for _, hostName := range m.inventory.ListHosts() {
+		hostVars := m.inventory.GetHostVars(hostName)
+		for varName, varValue := range hostVars {
+			ctx.SetHostvar(hostName, varName, varValue)
+		}
+	}
+
+	// Set up groups
+	ctx.mutex.Lock()
+	for groupName, hosts := range groups {
+		ctx.Groups[groupName] = hosts
+	}
+	ctx.mutex.Unlock()
+}
+
+// SetNativeTypes selects native rendering for TemplateValue, matching the
+// jinja2_native setting: single-expression templates keep the Go type of
+// their result instead of being rendered to a string
+func (m *Manager) SetNativeTypes(enabled bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.nativeTypes = enabled
+}
+
+// NativeTypes reports whether native rendering is enabled
+func (m *Manager) NativeTypes() bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.nativeTypes
+}
+
 // TemplateString renders a string template with the given context
 func (m *Manager) TemplateString(templateStr string, ctx *Context) (string, error) {
+	return m.templateEngine.Render(templateStr, m.templateContext(ctx))
+}
+
+// TemplateNative renders a template natively: a single expression keeps
+// its type, anything else renders to a string
+func (m *Manager) TemplateNative(templateStr string, ctx *Context) (interface{}, error) {
+	return m.templateEngine.RenderNative(templateStr, m.templateContext(ctx))
+}
+
+// templateContext builds the template engine context for ctx
+func (m *Manager) templateContext(ctx *Context) *template.Context {
 	templateCtx := &template.Context{
 		Variables: ctx.GetVariables(),
 		Hostvars:  ctx.Hostvars,
@@ -248,15 +478,32 @@ func (m *Manager) TemplateString(templateStr string, ctx *Context) (string, erro
 		Facts:     ctx.Facts,
 	}
 
+	// Facts are visible as top-level variables too, above other variables
+	for name, value := range ctx.Facts {
+		templateCtx.Variables[name] = value
+	}
+
 	// Add extra variables with highest precedence
 	for name, value := range m.GetExtraVars() {
 		templateCtx.Variables[name] = value
 	}
 
-	return m.templateEngine.Render(templateStr, templateCtx)
+	// Variables defined by templates are rendered on first use
+	resolver := &lazyResolver{manager: m, ctx: ctx, templateCtx: templateCtx}
+	for name, value := range templateCtx.Variables {
+		if containsTemplate(value) {
+			templateCtx.Variables[name] = resolver.lazy(name, value)
+		}
+	}
+
+	return templateCtx
 }
 
-// TemplateValue recursively templates any string values in a complex data structure
+// TemplateValue recursively templates any string values in a complex data
+// structure. Map entries and list items that render to omit are removed,
+// which is how "{{ x | default(omit) }}" drops a module argument. With
+// native types enabled, strings holding a single expression take the type
+// of its result.
 func (m *Manager) TemplateValue(value interface{}, ctx *Context) (interface{}, error) {
 	return m.templateValueRecursive(value, ctx, 0)
 }
@@ -272,6 +519,9 @@ func (m *Manager) templateValueRecursive(value interface{}, ctx *Context, depth
 	case string:
 		// Only template strings that contain template syntax
 		if strings.Contains(v, "{{") || strings.Contains(v, "{%") {
+			if m.NativeTypes() {
+				return m.TemplateNative(v, ctx)
+			}
 			return m.TemplateString(v, ctx)
 		}
 		return v, nil
@@ -289,6 +539,10 @@ func (m *Manager) templateValueRecursive(value interface{}, ctx *Context, depth
 				return nil, fmt.Errorf("failed to template value for key '%s': %w", key, err)
 			}
 
+			if templatedVal == template.OmitPlaceholder {
+				continue
+			}
+
 			keyStr, ok := templatedKey.(string)
 			if !ok {
 				keyStr = fmt.Sprintf("%v", templatedKey)
@@ -298,13 +552,16 @@ func (m *Manager) templateValueRecursive(value interface{}, ctx *Context, depth
 		return result, nil
 
 	case []interface{}:
-		result := make([]interface{}, len(v))
+		result := make([]interface{}, 0, len(v))
 		for i, item := range v {
 			templatedItem, err := m.templateValueRecursive(item, ctx, depth+1)
 			if err != nil {
 				return nil, fmt.Errorf("failed to template array item %d: %w", i, err)
 			}
-			result[i] = templatedItem
+			if templatedItem == template.OmitPlaceholder {
+				continue
+			}
+			result = append(result, templatedItem)
 		}
 		return result, nil
 
@@ -320,6 +577,7 @@ func (c *Context) MergeContext(other *Context) {
 	other.mutex.RLock()
 	defer c.mutex.Unlock()
 	defer other.mutex.RUnlock()
+	c.resolved = nil
 
 	// Merge variables respecting precedence
 	for name, variable := range other.Variables {
@@ -471,6 +729,7 @@ func (c *Context) DotNotationSet(path string, value interface{}, precedence int,
 
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
+	c.resolved = nil
 
 	// Navigate to the parent and create intermediate maps if necessary
 	rootName := parts[0]
@@ -17,9 +17,16 @@ limitations under the License.
 package vars
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/work-obs/ansible-go/pkg/config"
 	"github.com/work-obs/ansible-go/pkg/inventory"
+	"github.com/work-obs/ansible-go/pkg/plugins"
 	"github.com/spf13/afero"
 )
 
@@ -332,6 +339,19 @@ func TestManager_TemplateValue(t *testing.T) {
 				"Port: 8080",
 			},
 		},
+		{
+			name: "omitted arguments",
+			input: map[string]interface{}{
+				"path":  "/tmp/{{ name }}",
+				"mode":  "{{ mode | default(omit) }}",
+				"owner": "{{ owner if owner is defined else omit }}",
+				"flags": []interface{}{"-v", "{{ omit }}"},
+			},
+			expected: map[string]interface{}{
+				"path":  "/tmp/world",
+				"flags": []interface{}{"-v"},
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -348,6 +368,200 @@ func TestManager_TemplateValue(t *testing.T) {
 	}
 }
 
+func TestManager_TemplateString_Undefined(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	inv := inventory.NewInventory(fs)
+	manager := NewManager(inv)
+
+	ctx := NewContext()
+	ctx.SetFact("ansible_os_family", "Debian")
+
+	result, err := manager.TemplateString("{{ ansible_os_family }}", ctx)
+	if err != nil {
+		t.Fatalf("Expected facts to be visible as variables, got: %v", err)
+	}
+	if result != "Debian" {
+		t.Errorf("Expected 'Debian', got '%s'", result)
+	}
+
+	_, err = manager.TemplateValue(map[string]interface{}{"msg": "{{ nope }}"}, ctx)
+	if err == nil {
+		t.Fatal("Expected undefined variable to fail")
+	}
+	if !strings.Contains(err.Error(), "'nope' is undefined") {
+		t.Errorf("Expected error to name the variable, got: %v", err)
+	}
+}
+
+func TestManager_TemplateValue_NativeTypes(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	inv := inventory.NewInventory(fs)
+	manager := NewManager(inv)
+
+	ctx := NewContext()
+	ctx.SetVariable("port", "8080", PrecedenceTaskVars, "test")
+
+	args := map[string]interface{}{
+		"port":    "{{ port | int }}",
+		"list":    "{{ [1, 2, 3] }}",
+		"enabled": "{{ port is defined }}",
+		"url":     "http://localhost:{{ port }}",
+	}
+
+	// Without native types everything renders to strings
+	result, err := manager.TemplateValue(args, ctx)
+	if err != nil {
+		t.Fatalf("Template failed: %v", err)
+	}
+	if result.(map[string]interface{})["port"] != "8080" {
+		t.Errorf("Expected string port, got %#v", result.(map[string]interface{})["port"])
+	}
+
+	manager.SetNativeTypes(true)
+	result, err = manager.TemplateValue(args, ctx)
+	if err != nil {
+		t.Fatalf("Template failed: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"port":    8080,
+		"list":    []interface{}{1, 2, 3},
+		"enabled": true,
+		"url":     "http://localhost:8080",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, result)
+	}
+
+	// jinja2_native in the configuration selects native rendering
+	configured := NewManager(inv)
+	configured.SetConfig(&config.Config{JinjaNative: true})
+	if !configured.NativeTypes() {
+		t.Fatal("Expected jinja2_native to enable native types")
+	}
+	if result, err := configured.TemplateValue("{{ port | int }}", ctx); err != nil || result != 8080 {
+		t.Errorf("Expected native 8080 from the configured manager, got %#v (%v)", result, err)
+	}
+	configured.SetConfig(&config.Config{})
+	if configured.NativeTypes() {
+		t.Error("Expected native types to follow jinja2_native when it is unset")
+	}
+}
+
+func TestManager_TemplateString_LazyVariables(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	inv := inventory.NewInventory(fs)
+	manager := NewManager(inv)
+
+	ctx := NewContext()
+	ctx.SetVariable("base_url", "http://{{ host }}:{{ port }}", PrecedencePlayVars, "play")
+	ctx.SetVariable("host", "example.com", PrecedenceTaskVars, "test")
+	ctx.SetVariable("port", "{{ 8000 + offset }}", PrecedenceTaskVars, "test")
+	ctx.SetVariable("offset", 80, PrecedenceTaskVars, "test")
+	ctx.SetVariable("distro", "{{ ansible_distribution }}", PrecedenceTaskVars, "test")
+	ctx.SetVariable("broken", "{{ nowhere }}", PrecedenceTaskVars, "test")
+
+	result, err := manager.TemplateString("{{ base_url }}/api", ctx)
+	if err != nil {
+		t.Fatalf("Template failed: %v", err)
+	}
+	if result != "http://example.com:8080/api" {
+		t.Errorf("Expected 'http://example.com:8080/api', got '%s'", result)
+	}
+
+	// Nested variables keep their type and are memoised on the context
+	if port, ok := ctx.cachedValue("port"); !ok || port != 8080 {
+		t.Errorf("Expected port to be memoised as 8080, got %#v", port)
+	}
+
+	// Facts gathered after the variable was defined are picked up
+	ctx.SetFact("ansible_distribution", "Debian")
+	result, err = manager.TemplateString("{{ distro }}", ctx)
+	if err != nil {
+		t.Fatalf("Template failed: %v", err)
+	}
+	if result != "Debian" {
+		t.Errorf("Expected 'Debian', got '%s'", result)
+	}
+
+	// A variable built on undefined variables is undefined itself
+	result, err = manager.TemplateString("{{ broken | default('fallback') }}", ctx)
+	if err != nil {
+		t.Fatalf("Template failed: %v", err)
+	}
+	if result != "fallback" {
+		t.Errorf("Expected 'fallback', got '%s'", result)
+	}
+	if _, err := manager.TemplateString("{{ broken }}", ctx); err == nil || !strings.Contains(err.Error(), "'nowhere' is undefined") {
+		t.Errorf("Expected error naming 'nowhere', got %v", err)
+	}
+}
+
+func TestManager_TemplateString_Cycle(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	inv := inventory.NewInventory(fs)
+	manager := NewManager(inv)
+
+	ctx := NewContext()
+	ctx.SetVariable("a", "{{ b }}", PrecedenceTaskVars, "test")
+	ctx.SetVariable("b", "prefix-{{ c | upper }}", PrecedenceTaskVars, "test")
+	ctx.SetVariable("c", "{{ a }}", PrecedenceTaskVars, "test")
+
+	_, err := manager.TemplateString("{{ a }}", ctx)
+	if err == nil {
+		t.Fatal("Expected recursive definition to fail")
+	}
+
+	var cycle *CycleError
+	if !errors.As(err, &cycle) {
+		t.Fatalf("Expected a CycleError, got %v", err)
+	}
+	if strings.Join(cycle.Chain, " -> ") != "a -> b -> c -> a" {
+		t.Errorf("Expected chain a -> b -> c -> a, got %v", cycle.Chain)
+	}
+	if !strings.Contains(err.Error(), "a -> b -> c -> a") {
+		t.Errorf("Expected error to show the chain, got: %v", err)
+	}
+}
+
+func TestManager_StartTask(t *testing.T) {
+	manager := NewManager(inventory.NewInventory(afero.NewMemMapFs()))
+	ctx, err := manager.CreateHostContext("localhost")
+	if err != nil {
+		t.Fatalf("Failed to create host context: %v", err)
+	}
+	ctx.SetVariable("greeting", "hello {{ name }}", PrecedencePlayVars, "play")
+	ctx.SetVariable("name", "alice", PrecedenceTaskVars, "test")
+
+	render := func() string {
+		t.Helper()
+		result, err := manager.TemplateString("{{ greeting }}", ctx)
+		if err != nil {
+			t.Fatalf("Template failed: %v", err)
+		}
+		return result
+	}
+
+	manager.StartTask("localhost", "task-1")
+	if result := render(); result != "hello alice" {
+		t.Errorf("Expected 'hello alice', got '%s'", result)
+	}
+
+	// A change made in place is not seen until the next task
+	ctx.Variables["name"].Value = "bob"
+	manager.StartTask("localhost", "task-1")
+	if result := render(); result != "hello alice" {
+		t.Errorf("Expected the value memoised for the task, got '%s'", result)
+	}
+	manager.StartTask("localhost", "task-2")
+	if result := render(); result != "hello bob" {
+		t.Errorf("Expected 'hello bob', got '%s'", result)
+	}
+
+	// Hosts without a context are ignored
+	manager.StartTask("192.0.2.1", "task-2")
+}
+
 func TestContext_MergeContext(t *testing.T) {
 	ctx1 := NewContext()
 	ctx1.SetVariable("var1", "value1", PrecedenceGroupVars, "group")
@@ -634,6 +848,221 @@ func TestContext_FilterVariables(t *testing.T) {
 	}
 }
 
+func TestManager_TemplateString_Lookup(t *testing.T) {
+	roleDir := t.TempDir()
+	playbookDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(roleDir, "files"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(roleDir, "files", "motd"), []byte("role motd"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(playbookDir, "banner"), []byte("playbook banner"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	manager := NewManager(inventory.NewInventory(afero.NewMemMapFs()))
+
+	ctx := NewContext()
+	ctx.SetVariable("ansible_search_path", []interface{}{roleDir, playbookDir}, PrecedenceTaskVars, "test")
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"{{ lookup('file', 'motd') }}", "role motd"},
+		{"{{ lookup('file', 'banner') }}", "playbook banner"},
+		{"{{ query('file', 'motd', 'banner') | length }}", "2"},
+		{"{{ lookup('file', 'missing', errors='ignore') is none }}", "true"},
+	}
+
+	for _, test := range tests {
+		result, err := manager.TemplateString(test.template, ctx)
+		if err != nil {
+			t.Errorf("Template '%s' failed: %v", test.template, err)
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("Template '%s': expected '%s', got '%s'", test.template, test.expected, result)
+		}
+	}
+
+	if _, err := manager.TemplateString("{{ lookup('file', 'missing') }}", ctx); err == nil {
+		t.Error("Expected missing file to fail the lookup")
+	}
+}
+
+func TestManager_TemplateString_LookupLazyVariables(t *testing.T) {
+	manager := NewManager(inventory.NewInventory(afero.NewMemMapFs()))
+
+	ctx := NewContext()
+	ctx.SetVariable("port", "{{ 8000 + offset }}", PrecedenceTaskVars, "test")
+	ctx.SetVariable("offset", 80, PrecedenceTaskVars, "test")
+	ctx.SetVariable("next_offset", "{{ offset + 1 }}", PrecedenceTaskVars, "test")
+	ctx.SetVariable("broken", "{{ nowhere }}", PrecedenceTaskVars, "test")
+
+	// Lookups resolve only the variables they read, so an unrelated
+	// variable that cannot be resolved does not affect them
+	result, err := manager.TemplateString("{{ lookup('vars', 'port') }}", ctx)
+	if err != nil {
+		t.Fatalf("Template failed: %v", err)
+	}
+	if result != "8080" {
+		t.Errorf("Expected '8080', got '%s'", result)
+	}
+	if _, ok := ctx.cachedValue("next_offset"); ok {
+		t.Error("Expected the unused variable not to be resolved")
+	}
+
+	if _, err := manager.TemplateString("{{ lookup('vars', 'broken') }}", ctx); err == nil || !strings.Contains(err.Error(), "nowhere") {
+		t.Errorf("Expected error naming 'nowhere', got %v", err)
+	}
+}
+
+func TestManager_TemplateString_StructuredLookups(t *testing.T) {
+	playbookDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(playbookDir, "port.j2"), []byte("{{ ports | zip(names) | first | join('/') }}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	manager := NewManager(inventory.NewInventory(afero.NewMemMapFs()))
+	manager.SetConfig(&config.Config{BecomeUser: "admin"})
+
+	ctx := NewContext()
+	ctx.SetVariable("playbook_dir", playbookDir, PrecedenceTaskVars, "test")
+	ctx.SetVariable("ports", []interface{}{80, 443}, PrecedenceTaskVars, "test")
+	ctx.SetVariable("names", []interface{}{"http", "https"}, PrecedenceTaskVars, "test")
+	ctx.SetVariable("users", []interface{}{
+		map[string]interface{}{"name": "alice", "groups": []interface{}{"wheel", "docker"}},
+		map[string]interface{}{"name": "bob"},
+	}, PrecedenceTaskVars, "test")
+	ctx.Groups["web"] = []string{"web1", "web2"}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"{{ (query('dict', {'b': 2, 'a': 1}) | first).key }}", "a"},
+		{"{{ query('nested', ports, names) | length }}", "4"},
+		{"{{ query('together', ports, names) | last | join('=') }}", "443=https"},
+		{"{{ query('subelements', users, 'groups', {'skip_missing': true}) | length }}", "2"},
+		{"{{ query('indexed_items', names) | last | first }}", "1"},
+		{"{{ lookup('template', 'port.j2') }}", "80/http"},
+		{"{{ lookup('vars', 'ports') | length }}", "2"},
+		{"{{ query('inventory_hostnames', 'web') | join(',') }}", "web1,web2"},
+		{"{{ lookup('config', 'DEFAULT_BECOME_USER') }}", "admin"},
+	}
+
+	for _, test := range tests {
+		result, err := manager.TemplateString(test.template, ctx)
+		if err != nil {
+			t.Errorf("Template '%s' failed: %v", test.template, err)
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("Template '%s': expected '%s', got '%s'", test.template, test.expected, result)
+		}
+	}
+}
+
+func TestManager_TemplateString_FilterPlugins(t *testing.T) {
+	manager := NewManager(inventory.NewInventory(afero.NewMemMapFs()))
+
+	ctx := NewContext()
+	ctx.SetVariable("lan", "192.168.10.0/24", PrecedenceTaskVars, "test")
+	ctx.SetVariable("domain", map[string]interface{}{
+		"servers": []interface{}{
+			map[string]interface{}{"name": "web1", "port": 80},
+			map[string]interface{}{"name": "db1", "port": 5432},
+		},
+	}, PrecedenceTaskVars, "test")
+	ctx.SetVariable("names", []interface{}{"a", "b", "c"}, PrecedenceTaskVars, "test")
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"{{ lan | ipaddr('netmask') }}", "255.255.255.0"},
+		{"{{ lan | nthhost(1) }}", "192.168.10.1"},
+		{"{{ lan | ipsubnet(26, -1) }}", "192.168.10.192/26"},
+		{"{{ 'not an address' | ipaddr }}", "false"},
+		{"{{ domain | json_query('servers[?port > `100`].name') | join(',') }}", "db1"},
+		{"{{ names | zip_longest([1, 2], fillvalue='-') | last | join('=') }}", "c=-"},
+		{"{{ dict(names | zip([1, 2, 3])).b }}", "2"},
+		{"{{ names | batch(2, fill_with='x') | last | join }}", "cx"},
+		{"{{ 'password' | password_hash('md5', 'saltsalt') }}", "$1$saltsalt$qjXMvbEw8oaL.CzflDtaK/"},
+		{"{{ 'Version 2.14.1' | regex_search('version ([0-9.]+)', '\\\\1', ignorecase=true) | first }}", "2.14.1"},
+		{"{{ '/etc/ssh/sshd_config' | basename }}", "sshd_config"},
+		{"{{ 'hello' | vault('pw') | unvault('pw') }}", "hello"},
+	}
+
+	for _, test := range tests {
+		result, err := manager.TemplateString(test.template, ctx)
+		if err != nil {
+			t.Errorf("Template '%s' failed: %v", test.template, err)
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("Template '%s': expected '%s', got '%s'", test.template, test.expected, result)
+		}
+	}
+}
+
+func TestManager_LoadExternalPlugins(t *testing.T) {
+	// A one-shot plugin providing a filter, a lookup and a test
+	dir := t.TempDir()
+	script := `#!/bin/sh
+if [ "$1" = describe ]; then
+  echo '{"name": "shell", "filters": ["shout"], "lookups": ["words"], "tests": ["short"]}'
+  exit 0
+fi
+read -r request
+input=$(echo "$request" | sed -n 's/.*"input":"\([^"]*\)".*/\1/p')
+case "$request" in
+*'"method":"filter"'*) printf '{"result": "%s!"}\n' "$(echo "$input" | tr a-z A-Z)" ;;
+*'"method":"lookup"'*) echo '{"result": ["one", "two"]}' ;;
+*'"method":"test"'*) if [ ${#input} -lt 4 ]; then echo '{"result": true}'; else echo '{"result": false}'; fi ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(dir, "shell.sh"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{
+		FilterPluginPath: []string{dir},
+		LookupPluginPath: []string{dir},
+		TestPluginPath:   []string{dir},
+	}
+	loader := plugins.NewLoader(cfg, afero.NewOsFs())
+	defer loader.Close()
+
+	manager := NewManager(inventory.NewInventory(afero.NewMemMapFs()))
+	if err := manager.LoadExternalPlugins(loader); err != nil {
+		t.Fatalf("LoadExternalPlugins() error = %v", err)
+	}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"{{ 'hello' | shout }}", "HELLO!"},
+		{"{{ lookup('words') }}", "one,two"},
+		{"{{ query('words') | last }}", "two"},
+		{"{{ 'abc' is short }}", "true"},
+		{"{{ 'abcdef' is short }}", "false"},
+	}
+	for _, test := range tests {
+		result, err := manager.TemplateString(test.template, NewContext())
+		if err != nil {
+			t.Errorf("Template '%s' failed: %v", test.template, err)
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("Template '%s': expected '%s', got '%s'", test.template, test.expected, result)
+		}
+	}
+}
+
 // Helper function to compare complex data structures
 func deepEqual(a, b interface{}) bool {
 	switch va := a.(type) {
@@ -663,4 +1092,103 @@ func deepEqual(a, b interface{}) bool {
 	default:
 		return a == b
 	}
-}
\ No newline at end of file
+}
+func TestManager_RefreshHostContexts(t *testing.T) {
+	inv := inventory.NewInventory(afero.NewMemMapFs())
+	inv.AddHost("localhost", nil, map[string]interface{}{"role": "old"})
+
+	manager := NewManager(inv)
+	ctx, err := manager.CreateHostContext("localhost")
+	if err != nil {
+		t.Fatalf("Failed to create host context: %v", err)
+	}
+	ctx.SetVariable("play_var", "kept", PrecedencePlayVars, "play")
+
+	inv.AddHost("192.0.2.10", []string{"new_hosts"}, map[string]interface{}{"ansible_port": 2222})
+	if _, err := inv.AddHostToGroup("localhost", "managed", []string{"all"}); err != nil {
+		t.Fatalf("Failed to add host to group: %v", err)
+	}
+	inv.GetOrCreateGroup("managed").Variables["managed_var"] = "yes"
+	inv.AddHost("localhost", nil, map[string]interface{}{"role": "new"})
+	manager.RefreshHostContexts()
+
+	if value, _ := ctx.GetVariable("role"); value != "new" {
+		t.Errorf("Expected the refreshed host variable, got %v", value)
+	}
+	if value, _ := ctx.GetVariable("managed_var"); value != "yes" {
+		t.Errorf("Expected the new group's variable, got %v", value)
+	}
+	if value, _ := ctx.GetVariable("play_var"); value != "kept" {
+		t.Errorf("Expected play variables to be kept, got %v", value)
+	}
+	if value, exists := ctx.GetHostvar("192.0.2.10", "ansible_port"); !exists || value != 2222 {
+		t.Errorf("Expected hostvars for the added host, got %v", value)
+	}
+	if hosts := ctx.Groups["new_hosts"]; len(hosts) != 1 || hosts[0] != "192.0.2.10" {
+		t.Errorf("Expected the new group in groups, got %v", hosts)
+	}
+}
+
+func TestManager_TemplateString_CoreFilters(t *testing.T) {
+	manager := NewManager(inventory.NewInventory(afero.NewMemMapFs()))
+
+	ctx := NewContext()
+	ctx.SetVariable("users", []interface{}{
+		map[string]interface{}{"name": "bob", "uid": 1002, "admin": false},
+		map[string]interface{}{"name": "alice", "uid": 1001, "admin": true},
+	}, PrecedenceTaskVars, "test")
+	ctx.SetVariable("ports", []interface{}{443, 80, 443, 22}, PrecedenceTaskVars, "test")
+	ctx.SetVariable("base", map[string]interface{}{"a": 1, "b": 2}, PrecedenceTaskVars, "test")
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"{{ ports | unique | sort | join(',') }}", "22,80,443"},
+		{"{{ ports | sum }}", "988"},
+		{"{{ users | map(attribute='name') | join(',') }}", "bob,alice"},
+		{"{{ users | selectattr('admin') | map(attribute='name') | first }}", "alice"},
+		{"{{ base | combine({'b': 3}) | to_json }}", `{"a":1,"b":3}`},
+		{"{{ base | combine({'b': {'c': 1}}) | to_json }}", `{"a":1,"b":{"c":1}}`},
+		{"{{ base | dict2items | map(attribute='key') | join }}", "ab"},
+		{"{{ 'web-01' | regex_replace('-(\\\\d+)$', '_\\\\1') }}", "web_01"},
+		{"{{ 'ansible' | b64encode }}", "YW5zaWJsZQ=="},
+		// map applies filters of any plugin, not only the core ones
+		{"{{ ['/etc/hosts', '/tmp/a.txt'] | map('basename') | join(',') }}", "hosts,a.txt"},
+		{"{{ ['192.0.2.1', 'nonsense'] | map('ipaddr') | select | join(',') }}", "192.0.2.1"},
+		// Engine built-ins keep precedence over core filters of the same name
+		{"{{ missing | default('fallback') | upper }}", "FALLBACK"},
+	}
+
+	for _, test := range tests {
+		result, err := manager.TemplateString(test.template, ctx)
+		if err != nil {
+			t.Errorf("Template '%s' failed: %v", test.template, err)
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("Template '%s': expected '%s', got '%s'", test.template, test.expected, result)
+		}
+	}
+}
+
+func TestManager_TemplateString_MapExtract(t *testing.T) {
+	inv := inventory.NewInventory(afero.NewMemMapFs())
+	inv.AddHost("web1", []string{"web"}, map[string]interface{}{"ansible_host": "192.0.2.11"})
+	inv.AddHost("web2", []string{"web"}, map[string]interface{}{"ansible_host": "192.0.2.12"})
+
+	manager := NewManager(inv)
+	ctx, err := manager.CreateHostContext("web1")
+	if err != nil {
+		t.Fatalf("Failed to create host context: %v", err)
+	}
+
+	template := "{{ groups['web'] | map('extract', hostvars, 'ansible_host') | sort | join(',') }}"
+	result, err := manager.TemplateString(template, ctx)
+	if err != nil {
+		t.Fatalf("Template '%s' failed: %v", template, err)
+	}
+	if result != "192.0.2.11,192.0.2.12" {
+		t.Errorf("Template '%s': expected '192.0.2.11,192.0.2.12', got '%s'", template, result)
+	}
+}